@@ -2,14 +2,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 
+	"graph"
+	"logging"
+
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -20,6 +22,7 @@ func main() {
 	if err := godotenv.Load(".env"); err != nil {
 		log.Fatal("Error loading .env file")
 	}
+	logging.SetupFromEnv("client-manager-sync")
 
 	var err error
 	DB, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
@@ -41,29 +44,17 @@ type FacebookPage struct {
 	Name string `json:"name"`
 }
 
-func getPageInfo(pageToken string) (*FacebookPage, error) {
+func getPageInfo(ctx context.Context, pageToken string) (*FacebookPage, error) {
 	// Get page info using the token
-	url := fmt.Sprintf("https://graph.facebook.com/v23.0/me?access_token=%s", pageToken)
 	log.Printf("Fetching page info from Facebook...")
 
-	resp, err := http.Get(url)
-	if err != nil {
+	var body json.RawMessage
+	if err := graph.New(graph.ConfigFromEnv()).Get(ctx, "me", pageToken, nil, &body); err != nil {
 		return nil, fmt.Errorf("error fetching page: %w", err)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
-	}
-
-	log.Printf("Response status: %s", resp.Status)
 	log.Printf("Response body: %s", string(body))
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Facebook API returned status: %s", resp.Status)
-	}
-
 	var page FacebookPage
 	if err := json.Unmarshal(body, &page); err != nil {
 		return nil, fmt.Errorf("error parsing page info: %w", err)
@@ -79,7 +70,7 @@ func syncPage() error {
 	}
 
 	// Get page info
-	page, err := getPageInfo(pageToken)
+	page, err := getPageInfo(context.Background(), pageToken)
 	if err != nil {
 		return err
 	}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
+
+	"graph"
 )
 
 // FacebookDebugger helps diagnose Facebook API issues
@@ -16,41 +17,40 @@ type FacebookDebugger struct {
 }
 
 // DebugUserToken analyzes a user's token and permissions
-func (fd *FacebookDebugger) DebugUserToken(userToken string) {
+func (fd *FacebookDebugger) DebugUserToken(ctx context.Context, userToken string) {
 	log.Printf("🔍 Starting Facebook API diagnostics...")
 
 	// 1. Check token validity and permissions
-	fd.checkTokenInfo(userToken)
+	fd.checkTokenInfo(ctx, userToken)
 
 	// 2. Check user's granted permissions
-	fd.checkUserPermissions(userToken)
+	fd.checkUserPermissions(ctx, userToken)
 
 	// 3. Check user's basic info
-	fd.checkUserInfo(userToken)
+	fd.checkUserInfo(ctx, userToken)
 
 	// 4. Check user's pages (raw)
-	fd.checkUserPages(userToken)
+	fd.checkUserPages(ctx, userToken)
 
 	// 5. Check app info
-	fd.checkAppInfo()
+	fd.checkAppInfo(ctx)
 }
 
-func (fd *FacebookDebugger) checkTokenInfo(token string) {
-	log.Printf("📋 Checking token information...")
-
-	url := fmt.Sprintf("https://graph.facebook.com/v23.0/debug_token?input_token=%s&access_token=%s|%s",
-		token, fd.AppID, fd.AppSecret)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("❌ Error checking token info: %v", err)
-		return
+// get fetches path and returns the raw response body
+func (fd *FacebookDebugger) get(ctx context.Context, what, path, token string, params url.Values) (json.RawMessage, bool) {
+	var body json.RawMessage
+	if err := graphClient.Get(ctx, path, token, params, &body); err != nil {
+		log.Printf("❌ Error checking %s: %v", what, err)
+		return nil, false
 	}
-	defer resp.Body.Close()
+	return body, true
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("❌ Error reading token info response: %v", err)
+func (fd *FacebookDebugger) checkTokenInfo(ctx context.Context, token string) {
+	log.Printf("📋 Checking token information...")
+
+	body, ok := fd.get(ctx, "token info", "debug_token", graph.AppToken(fd.AppID, fd.AppSecret), url.Values{"input_token": {token}})
+	if !ok {
 		return
 	}
 
@@ -83,21 +83,11 @@ func (fd *FacebookDebugger) checkTokenInfo(token string) {
 	}
 }
 
-func (fd *FacebookDebugger) checkUserPermissions(token string) {
+func (fd *FacebookDebugger) checkUserPermissions(ctx context.Context, token string) {
 	log.Printf("📋 Checking user permissions...")
 
-	url := fmt.Sprintf("https://graph.facebook.com/v23.0/me/permissions?access_token=%s", token)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("❌ Error checking permissions: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("❌ Error reading permissions response: %v", err)
+	body, ok := fd.get(ctx, "permissions", "me/permissions", token, nil)
+	if !ok {
 		return
 	}
 
@@ -150,42 +140,22 @@ func (fd *FacebookDebugger) checkUserPermissions(token string) {
 	}
 }
 
-func (fd *FacebookDebugger) checkUserInfo(token string) {
+func (fd *FacebookDebugger) checkUserInfo(ctx context.Context, token string) {
 	log.Printf("📋 Checking user info...")
 
-	url := fmt.Sprintf("https://graph.facebook.com/v23.0/me?fields=id,name,email&access_token=%s", token)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("❌ Error checking user info: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("❌ Error reading user info response: %v", err)
+	body, ok := fd.get(ctx, "user info", "me", token, url.Values{"fields": {"id,name,email"}})
+	if !ok {
 		return
 	}
 
 	log.Printf("👤 User info response: %s", string(body))
 }
 
-func (fd *FacebookDebugger) checkUserPages(token string) {
+func (fd *FacebookDebugger) checkUserPages(ctx context.Context, token string) {
 	log.Printf("📋 Checking user pages...")
 
-	url := fmt.Sprintf("https://graph.facebook.com/v23.0/me/accounts?access_token=%s", token)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("❌ Error checking pages: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("❌ Error reading pages response: %v", err)
+	body, ok := fd.get(ctx, "pages", "me/accounts", token, nil)
+	if !ok {
 		return
 	}
 
@@ -218,22 +188,11 @@ func (fd *FacebookDebugger) checkUserPages(token string) {
 	}
 }
 
-func (fd *FacebookDebugger) checkAppInfo() {
+func (fd *FacebookDebugger) checkAppInfo(ctx context.Context) {
 	log.Printf("📋 Checking app info...")
 
-	url := fmt.Sprintf("https://graph.facebook.com/v23.0/%s?access_token=%s|%s",
-		fd.AppID, fd.AppID, fd.AppSecret)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("❌ Error checking app info: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("❌ Error reading app info response: %v", err)
+	body, ok := fd.get(ctx, "app info", fd.AppID, graph.AppToken(fd.AppID, fd.AppSecret), nil)
+	if !ok {
 		return
 	}
 
@@ -6,3 +6,13 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.2
 )
+
+require (
+	graph v0.0.0
+	logging v0.0.0
+)
+
+replace (
+	graph => ../graph
+	logging => ../logging
+)
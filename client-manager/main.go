@@ -2,21 +2,24 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"graph"
+	"logging"
+
 	"github.com/joho/godotenv"
 )
 
@@ -35,20 +38,50 @@ var (
 	sessionMutex = sync.RWMutex{}
 )
 
+// Graph API clients, configured in init from GRAPH_API_* and INSTAGRAM_*_BASE_URL
+var (
+	graphClient          *graph.Client // graph.facebook.com
+	instagramClient      *graph.Client // graph.instagram.com (Instagram Login tokens)
+	instagramLoginClient *graph.Client // api.instagram.com code exchange (unversioned)
+)
+
 func init() {
 	tmpl = template.Must(template.ParseGlob("templates/*.html"))
 
 	// Load .env file if it exists
-	if err := godotenv.Load(); err != nil {
+	envErr := godotenv.Load()
+
+	// Structured logging (LOG_LEVEL, LOG_FORMAT, LOG_REDACT); log.Printf goes through it
+	logging.SetupFromEnv("client-manager")
+	if envErr != nil {
 		log.Printf("No .env file found - using environment variables")
 	}
 
 	// Print all environment variables (careful with sensitive info!)
 	log.Printf("Environment variables loaded. DATABASE_URL exists: %v", os.Getenv("DATABASE_URL") != "")
 
+	setupGraphClients()
 	initDB()
 }
 
+// setupGraphClients builds the Graph API clients from the environment
+func setupGraphClients() {
+	graphClient = graph.New(graph.ConfigFromEnv())
+
+	instagramBaseURL := os.Getenv("INSTAGRAM_GRAPH_BASE_URL")
+	if instagramBaseURL == "" {
+		instagramBaseURL = graph.InstagramBaseURL
+	}
+	instagramOAuthBaseURL := os.Getenv("INSTAGRAM_OAUTH_BASE_URL")
+	if instagramOAuthBaseURL == "" {
+		instagramOAuthBaseURL = graph.InstagramOAuthBaseURL
+	}
+	instagramClient = graphClient.WithBaseURL(instagramBaseURL, false)
+	instagramLoginClient = graphClient.WithBaseURL(instagramOAuthBaseURL, true)
+
+	log.Printf("Graph API client: %s (%s)", graphClient.BaseURL(), graphClient.Version())
+}
+
 func main() {
 	// Wrap ALL routes with CORS middleware
 	router := http.NewServeMux()
@@ -360,7 +393,7 @@ func handleFacebookToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 1. Get user details from Facebook
-	fbUser, err := getFacebookUser(data.UserToken)
+	fbUser, err := getFacebookUser(r.Context(), data.UserToken)
 	if err != nil {
 		log.Printf("❌ Error getting Facebook user details: %v", err)
 		http.Error(w, fmt.Sprintf("Could not verify Facebook user: %v", err), http.StatusInternalServerError)
@@ -368,7 +401,7 @@ func handleFacebookToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. Get connected pages
-	pages, err := getConnectedPages(data.UserToken)
+	pages, err := getConnectedPages(r.Context(), data.UserToken)
 	if err != nil {
 		log.Printf("❌ Error getting pages: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -544,7 +577,7 @@ func handleFacebookToken(w http.ResponseWriter, r *http.Request) {
 		log.Printf("📝 Setting up webhooks for page: %s (%s)", page.Name, page.Platform)
 		
 		// Set up webhook subscriptions automatically
-		if err := setupWebhookSubscriptions(page.ID, page.AccessToken, page.Name, page.Platform); err != nil {
+		if err := setupWebhookSubscriptions(r.Context(), page.ID, page.AccessToken, page.Name, page.Platform); err != nil {
 			log.Printf("⚠️ Webhook setup failed for %s: %v", page.Name, err)
 			// Don't fail the entire request - webhook setup is best effort
 			// Client can still use the service, but might need manual webhook setup
@@ -604,135 +637,62 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 }
 
 // Enhanced getFacebookUser function
-func getFacebookUser(token string) (*FacebookUser, error) {
-	url := fmt.Sprintf("https://graph.facebook.com/v23.0/me?fields=id,name&access_token=%s", token)
-	log.Printf("Attempting to get Facebook user details from: %s", url)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Error making HTTP request to Facebook: %v", err)
-		return nil, fmt.Errorf("error fetching user info from Facebook: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("Error reading response body from Facebook: %v", readErr)
-		return nil, fmt.Errorf("error reading Facebook response body: %w", readErr)
-	}
-	// Log the raw response body for debugging, regardless of status code.
-	log.Printf("Facebook get user response status: %s, body: %s", resp.Status, string(bodyBytes))
-
-	if resp.StatusCode != http.StatusOK {
-		var fbError struct {
-			Error struct {
-				Message   string `json:"message"`
-				Type      string `json:"type"`
-				Code      int    `json:"code"`
-				FbtraceID string `json:"fbtrace_id"`
-			} `json:"error"`
-		}
-		// Try to unmarshal the bodyBytes we already read
-		if unmarshalErr := json.Unmarshal(bodyBytes, &fbError); unmarshalErr == nil {
-			log.Printf("Facebook API error (parsed from body). Message: %s, Type: %s, Code: %d, Trace: %s",
-				fbError.Error.Message, fbError.Error.Type, fbError.Error.Code, fbError.Error.FbtraceID)
-		} else {
-			// Log if parsing the error structure itself failed
-			log.Printf("Facebook API error (could not parse error JSON from body). Body was: %s", string(bodyBytes))
-		}
-		// Return a generic error message to the caller, specific details are logged.
-		return nil, fmt.Errorf("Facebook API error (%s)", resp.Status)
-	}
+func getFacebookUser(ctx context.Context, token string) (*FacebookUser, error) {
+	params := url.Values{"fields": {"id,name"}}
+	log.Printf("Attempting to get Facebook user details from: %s", graphClient.URL("me", params))
 
 	var user FacebookUser
-	// Try to unmarshal the bodyBytes into the User struct
-	if err := json.Unmarshal(bodyBytes, &user); err != nil {
-		log.Printf("Error parsing Facebook user info from successful (200 OK) response body: %v. Body was: %s", err, string(bodyBytes))
-		return nil, fmt.Errorf("error parsing user info from Facebook response: %w", err)
+	if err := graphClient.Get(ctx, "me", token, params, &user); err != nil {
+		// The GraphError carries message, type, code and fbtrace_id
+		log.Printf("Facebook API error getting user: %v", err)
+		return nil, fmt.Errorf("error fetching user info from Facebook: %w", err)
 	}
 
 	// Basic validation that we got the essential fields
 	if user.ID == "" || user.Name == "" {
-		log.Printf("Facebook user details incomplete - ID: %s, Name: %s", user.ID, user.Name)
+		log.Printf("Facebook user details incomplete - ID: %s, Name: %s", user.ID, logging.Name(user.Name))
 		return nil, fmt.Errorf("incomplete user data from Facebook")
 	}
 
 	// If everything is successful, log the details.
-	log.Printf("Successfully fetched Facebook user: ID %s, Name %s", user.ID, user.Name)
+	log.Printf("Successfully fetched Facebook user: ID %s, Name %s", user.ID, logging.Name(user.Name))
 	return &user, nil
 }
 
-func getConnectedPages(userToken string) ([]FacebookPage, error) {
+// debugGraphGet GETs path and logs the raw response; it is only used to
+// diagnose missing pages and permissions
+func debugGraphGet(ctx context.Context, label, path, token string, params url.Values) (json.RawMessage, bool) {
+	log.Printf("🔍 %s: %s", label, graphClient.URL(path, params))
+
+	var body json.RawMessage
+	if err := graphClient.Get(ctx, path, token, params, &body); err != nil {
+		log.Printf("⚠️ Warning: %s failed: %v", label, err)
+		return nil, false
+	}
+	log.Printf("📋 %s response: %s", label, string(body))
+	return body, true
+}
+
+func getConnectedPages(ctx context.Context, userToken string) ([]FacebookPage, error) {
 	// Exchange user token for long-lived user token (60 days)
 	// Note: This is NOT permanent, but the page tokens we get from it ARE permanent
-	longLivedUrl := fmt.Sprintf(
-		"https://graph.facebook.com/v23.0/oauth/access_token?"+
-			"grant_type=fb_exchange_token&"+
-			"client_id=%s&"+
-			"client_secret=%s&"+
-			"fb_exchange_token=%s",
-		os.Getenv("FACEBOOK_APP_ID"),
-		os.Getenv("FACEBOOK_APP_SECRET"),
-		userToken,
-	)
-
 	log.Printf("Getting long-lived user token (60 days)")
-	longLivedResp, err := http.Get(longLivedUrl)
+	longLived, err := graphClient.ExchangeToken(ctx, os.Getenv("FACEBOOK_APP_ID"), os.Getenv("FACEBOOK_APP_SECRET"), userToken)
 	if err != nil {
+		log.Printf("❌ Facebook long-lived token error: %v", err)
 		return nil, fmt.Errorf("error getting long-lived token: %w", err)
 	}
-	defer longLivedResp.Body.Close()
-
-	// Read and log the long-lived token response
-	longLivedBodyBytes, readErr := io.ReadAll(longLivedResp.Body)
-	if readErr != nil {
-		return nil, fmt.Errorf("error reading long-lived token response body: %w", readErr)
-	}
-	log.Printf("Long-lived token response status: %s, body: %s", longLivedResp.Status, string(longLivedBodyBytes))
-
-	var longLivedResult struct {
-		AccessToken string `json:"access_token"`
-		Error       struct {
-			Message string `json:"message"`
-			Type    string `json:"type"`
-			Code    int    `json:"code"`
-		} `json:"error"`
-	}
-	if err := json.Unmarshal(longLivedBodyBytes, &longLivedResult); err != nil {
-		return nil, fmt.Errorf("error parsing long-lived token response: %w", err)
-	}
-
-	if longLivedResult.Error.Message != "" {
-		log.Printf("❌ Facebook long-lived token error: %s (Type: %s, Code: %d)",
-			longLivedResult.Error.Message, longLivedResult.Error.Type, longLivedResult.Error.Code)
-		return nil, fmt.Errorf("Facebook long-lived token error: %s", longLivedResult.Error.Message)
-	}
-
-	if longLivedResult.AccessToken == "" {
-		log.Printf("❌ No access token received in long-lived token response")
-		return nil, fmt.Errorf("no access token received from Facebook")
-	}
 
 	log.Printf("✅ Successfully obtained long-lived user token (60 days, NOT permanent)")
 
 	// DEBUG: Log the actual token values for manual debugging
 	log.Printf("🔑 TOKEN COMPARISON FOR DEBUGGING:")
-	log.Printf("   Original user token: %s", userToken)
-	log.Printf("   Long-lived user token: %s", longLivedResult.AccessToken)
+	log.Printf("   Original user token: %s", logging.Secret(userToken))
+	log.Printf("   Long-lived user token: %s", logging.Secret(longLived.AccessToken))
 	log.Printf("   📝 Copy these tokens to https://developers.facebook.com/tools/debug/accesstoken/ for detailed analysis")
 
 	// DEBUG: Check what permissions the long-lived token actually has
-	debugPermUrl := fmt.Sprintf("https://graph.facebook.com/v23.0/me/permissions?access_token=%s", longLivedResult.AccessToken)
-	log.Printf("🔍 Checking long-lived token permissions: %s", debugPermUrl)
-
-	permDebugResp, err := http.Get(debugPermUrl)
-	if err != nil {
-		log.Printf("⚠️ Warning: Could not check long-lived token permissions: %v", err)
-	} else {
-		defer permDebugResp.Body.Close()
-		permDebugBody, _ := io.ReadAll(permDebugResp.Body)
-		log.Printf("📋 Long-lived token permissions response: %s", string(permDebugBody))
-
+	if permDebugBody, ok := debugGraphGet(ctx, "Long-lived token permissions", "me/permissions", longLived.AccessToken, nil); ok {
 		var permissions struct {
 			Data []struct {
 				Permission string `json:"permission"`
@@ -762,39 +722,11 @@ func getConnectedPages(userToken string) ([]FacebookPage, error) {
 	}
 
 	// DEBUG: Also check permissions of the original user token for comparison
-	originalPermUrl := fmt.Sprintf("https://graph.facebook.com/v23.0/me/permissions?access_token=%s", userToken)
-	log.Printf("🔍 Checking original user token permissions: %s", originalPermUrl)
-
-	originalPermResp, err := http.Get(originalPermUrl)
-	if err != nil {
-		log.Printf("⚠️ Warning: Could not check original token permissions: %v", err)
-	} else {
-		defer originalPermResp.Body.Close()
-		originalPermBody, _ := io.ReadAll(originalPermResp.Body)
-		log.Printf("📋 Original token permissions response: %s", string(originalPermBody))
-	}
+	debugGraphGet(ctx, "Original token permissions", "me/permissions", userToken, nil)
 
 	// Use the long-lived user token to get pages (page tokens will be permanent)
-	fbURL := fmt.Sprintf(
-		"https://graph.facebook.com/v23.0/me/accounts?"+
-			"access_token=%s&"+
-			"fields=id,name,access_token,instagram_business_account{id,name,username}",
-		longLivedResult.AccessToken,
-	)
-
-	log.Printf("Fetching Facebook pages and connected Instagram accounts from: %s", fbURL)
-	fbResp, err := http.Get(fbURL)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching pages: %w", err)
-	}
-	defer fbResp.Body.Close()
-
-	// Read and log the pages response
-	fbBodyBytes, readErr := io.ReadAll(fbResp.Body)
-	if readErr != nil {
-		return nil, fmt.Errorf("error reading pages response body: %w", readErr)
-	}
-	log.Printf("Facebook pages response status: %s, body: %s", fbResp.Status, string(fbBodyBytes))
+	pagesParams := url.Values{"fields": {"id,name,access_token,instagram_business_account{id,name,username}"}}
+	log.Printf("Fetching Facebook pages and connected Instagram accounts from: %s", graphClient.URL("me/accounts", pagesParams))
 
 	var fbResult struct {
 		Data []struct {
@@ -807,103 +739,34 @@ func getConnectedPages(userToken string) ([]FacebookPage, error) {
 				Username string `json:"username"`
 			} `json:"instagram_business_account"`
 		} `json:"data"`
-		Error struct {
-			Message   string `json:"message"`
-			Type      string `json:"type"`
-			Code      int    `json:"code"`
-			FbtraceID string `json:"fbtrace_id"`
-		} `json:"error"`
-	}
-
-	if err := json.Unmarshal(fbBodyBytes, &fbResult); err != nil {
-		return nil, fmt.Errorf("error parsing Facebook response: %w", err)
 	}
-
-	if fbResult.Error.Message != "" {
-		log.Printf("❌ Facebook pages API error: %s (Type: %s, Code: %d, Trace: %s)",
-			fbResult.Error.Message, fbResult.Error.Type, fbResult.Error.Code, fbResult.Error.FbtraceID)
-		return nil, fmt.Errorf("Facebook pages API error: %s", fbResult.Error.Message)
+	if err := graphClient.Get(ctx, "me/accounts", longLived.AccessToken, pagesParams, &fbResult); err != nil {
+		log.Printf("❌ Facebook pages API error: %v", err)
+		return nil, fmt.Errorf("error fetching pages: %w", err)
 	}
+	log.Printf("Facebook pages response: %d pages", len(fbResult.Data))
 
 	// DEBUG: If no pages found, try additional debugging
 	if len(fbResult.Data) == 0 {
 		log.Printf("🔍 No pages found with long-lived token - performing additional debugging...")
 
 		log.Printf("🔑 REMINDER - TOKEN VALUES FOR MANUAL DEBUGGING:")
-		log.Printf("   Original user token: %s", userToken)
-		log.Printf("   Long-lived user token: %s", longLivedResult.AccessToken)
+		log.Printf("   Original user token: %s", logging.Secret(userToken))
+		log.Printf("   Long-lived user token: %s", logging.Secret(longLived.AccessToken))
 		log.Printf("   📝 Test both tokens at: https://developers.facebook.com/tools/debug/accesstoken/")
 
 		// TEST: Try the same API call with the original user token
-		originalPagesURL := fmt.Sprintf(
-			"https://graph.facebook.com/v23.0/me/accounts?"+
-				"access_token=%s&"+
-				"fields=id,name,access_token,instagram_business_account{id,name,username}",
-			userToken,
-		)
-		log.Printf("🔍 Testing pages API with original user token: %s", originalPagesURL)
-
-		originalPagesResp, err := http.Get(originalPagesURL)
-		if err != nil {
-			log.Printf("⚠️ Warning: Could not check pages with original token: %v", err)
-		} else {
-			defer originalPagesResp.Body.Close()
-			originalPagesBody, _ := io.ReadAll(originalPagesResp.Body)
-			log.Printf("📋 Original token pages response: %s", string(originalPagesBody))
-		}
+		debugGraphGet(ctx, "Pages with original user token", "me/accounts", userToken, pagesParams)
 
 		// Check if user has any pages at all (without permissions filter)
-		debugURL := fmt.Sprintf("https://graph.facebook.com/v23.0/me/accounts?access_token=%s", longLivedResult.AccessToken)
-		log.Printf("🔍 Checking all user accounts: %s", debugURL)
-
-		debugResp, err := http.Get(debugURL)
-		if err != nil {
-			log.Printf("⚠️ Warning: Could not check all accounts: %v", err)
-		} else {
-			defer debugResp.Body.Close()
-			debugBody, _ := io.ReadAll(debugResp.Body)
-			log.Printf("📋 All user accounts response: %s", string(debugBody))
-		}
+		debugGraphGet(ctx, "All user accounts", "me/accounts", longLived.AccessToken, nil)
 
 		// Also check user's basic info
-		userInfoURL := fmt.Sprintf("https://graph.facebook.com/v23.0/me?fields=id,name,email&access_token=%s", longLivedResult.AccessToken)
-		log.Printf("🔍 Checking user info: %s", userInfoURL)
-
-		userInfoResp, err := http.Get(userInfoURL)
-		if err != nil {
-			log.Printf("⚠️ Warning: Could not check user info: %v", err)
-		} else {
-			defer userInfoResp.Body.Close()
-			userInfoBody, _ := io.ReadAll(userInfoResp.Body)
-			log.Printf("👤 User info response: %s", string(userInfoBody))
-		}
+		debugGraphGet(ctx, "User info", "me", longLived.AccessToken, url.Values{"fields": {"id,name,email"}})
 
 		// TEST: Try accessing the specific page we know exists from token debugger
 		// Page ID: 269054096290372 (Happiness boutique)
-		specificPageURL := fmt.Sprintf("https://graph.facebook.com/v23.0/269054096290372?fields=id,name,access_token&access_token=%s", longLivedResult.AccessToken)
-		log.Printf("🔍 Testing direct access to known page: %s", specificPageURL)
-
-		specificPageResp, err := http.Get(specificPageURL)
-		if err != nil {
-			log.Printf("⚠️ Warning: Could not access specific page: %v", err)
-		} else {
-			defer specificPageResp.Body.Close()
-			specificPageBody, _ := io.ReadAll(specificPageResp.Body)
-			log.Printf("📄 Specific page response: %s", string(specificPageBody))
-		}
-
-		// TEST: Try the accounts endpoint with different API versions
-		debugURLv23 := fmt.Sprintf("https://graph.facebook.com/v23.0/me/accounts?access_token=%s", longLivedResult.AccessToken)
-		log.Printf("🔍 Testing with API v23.0: %s", debugURLv23)
-
-		debugRespv23, err := http.Get(debugURLv23)
-		if err != nil {
-			log.Printf("⚠️ Warning: Could not check with v23.0: %v", err)
-		} else {
-			defer debugRespv23.Body.Close()
-			debugBodyv23, _ := io.ReadAll(debugRespv23.Body)
-			log.Printf("📋 v23.0 response: %s", string(debugBodyv23))
-		}
+		debugGraphGet(ctx, "Direct access to known page", "269054096290372", longLived.AccessToken, url.Values{"fields": {"id,name,access_token"}})
 
 		log.Printf("💡 Possible reasons for no pages:")
 		log.Printf("   1. Permanent token doesn't inherit all permissions from user token")
@@ -921,19 +784,9 @@ func getConnectedPages(userToken string) ([]FacebookPage, error) {
 	// Add Facebook pages and their connected Instagram accounts
 	for _, page := range fbResult.Data {
 		// DEBUG: Verify that page tokens are actually permanent
-		pageTokenDebugURL := fmt.Sprintf("https://graph.facebook.com/v23.0/debug_token?input_token=%s&access_token=%s|%s",
-			page.AccessToken, os.Getenv("FACEBOOK_APP_ID"), os.Getenv("FACEBOOK_APP_SECRET"))
-
-		log.Printf("🔍 Verifying page token for %s: %s", page.Name, pageTokenDebugURL)
-
-		pageTokenResp, err := http.Get(pageTokenDebugURL)
-		if err != nil {
-			log.Printf("⚠️ Warning: Could not verify page token for %s: %v", page.Name, err)
-		} else {
-			defer pageTokenResp.Body.Close()
-			pageTokenBody, _ := io.ReadAll(pageTokenResp.Body)
-			log.Printf("📋 Page token info for %s: %s", page.Name, string(pageTokenBody))
-
+		appToken := graph.AppToken(os.Getenv("FACEBOOK_APP_ID"), os.Getenv("FACEBOOK_APP_SECRET"))
+		if pageTokenBody, ok := debugGraphGet(ctx, "Page token info for "+page.Name, "debug_token", appToken,
+			url.Values{"input_token": {page.AccessToken}}); ok {
 			// Parse and log key info about the token
 			var tokenInfo struct {
 				Data struct {
@@ -1011,25 +864,26 @@ func getConnectedPages(userToken string) ([]FacebookPage, error) {
 
 // subscribePageToWebhooks subscribes a Facebook page to all required webhook events
 // Platform-specific: Instagram doesn't support "messaging_handovers" field
-func subscribePageToWebhooks(pageID, pageToken, platform string) error {
+func subscribePageToWebhooks(ctx context.Context, pageID, pageToken, platform string) error {
 	appID := os.Getenv("FACEBOOK_APP_ID")
 	if appID == "" {
 		return fmt.Errorf("FACEBOOK_APP_ID environment variable not set")
 	}
 
 	// Subscribe page to the Neurocrow app for webhook events
-	subscribeURL := fmt.Sprintf("https://graph.facebook.com/v23.0/%s/subscribed_apps", pageID)
+	subscribePath := pageID + "/subscribed_apps"
 	
 	// Create platform-specific payload for subscribing to webhooks
 	var subscribedFields []string
 	
 	if platform == "instagram" {
-		// Instagram only supports basic messaging fields
+		// Instagram supports messaging fields and comments (for comment automation)
 		subscribedFields = []string{
 			"messages",
 			"messaging_postbacks",
+			"comments",
 		}
-		log.Printf("📱 Using Instagram-specific webhook fields (messages, messaging_postbacks only)")
+		log.Printf("📱 Using Instagram-specific webhook fields (messages, messaging_postbacks, comments)")
 	} else {
 		// Facebook pages support all fields including handovers and echoes
 		subscribedFields = []string{
@@ -1038,68 +892,30 @@ func subscribePageToWebhooks(pageID, pageToken, platform string) error {
 			"messaging_handovers",
 			"messaging_policy_enforcement",
 			"message_echoes",
+			"feed", // New comments, for comment automation
 		}
-		log.Printf("📘 Using Facebook-specific webhook fields (including messaging_handovers and message_echoes)")
+		log.Printf("📘 Using Facebook-specific webhook fields (including messaging_handovers, message_echoes and feed)")
 	}
 	
 	subscribePayload := map[string]interface{}{
 		"subscribed_fields": subscribedFields,
 	}
 
-	jsonData, err := json.Marshal(subscribePayload)
-	if err != nil {
-		return fmt.Errorf("error marshaling subscribe payload: %v", err)
-	}
-
-	// Make POST request to subscribe
-	req, err := http.NewRequest("POST", subscribeURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating subscribe request: %v", err)
-	}
+	log.Printf("🔗 Subscribing %s page %s to webhooks: %s", platform, pageID, graphClient.URL(subscribePath, nil))
+	log.Printf("📤 Subscribe payload: %v", subscribePayload)
 
-	req.Header.Set("Content-Type", "application/json")
-	req.URL.RawQuery = fmt.Sprintf("access_token=%s", pageToken)
-
-	log.Printf("🔗 Subscribing %s page %s to webhooks: %s", platform, pageID, subscribeURL)
-	log.Printf("📤 Subscribe payload: %s", string(jsonData))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error making subscribe request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading subscribe response: %v", err)
-	}
-
-	log.Printf("📥 Webhook subscription response: %d - %s", resp.StatusCode, string(respBody))
-
-	if resp.StatusCode != http.StatusOK {
-		var fbError struct {
-			Error struct {
-				Message   string `json:"message"`
-				Type      string `json:"type"`  
-				Code      int    `json:"code"`
-				FbtraceID string `json:"fbtrace_id"`
-			} `json:"error"`
-		}
-		
-		if json.Unmarshal(respBody, &fbError) == nil && fbError.Error.Message != "" {
-			return fmt.Errorf("Facebook webhook subscription error: %s (Code: %d, Trace: %s)", 
-				fbError.Error.Message, fbError.Error.Code, fbError.Error.FbtraceID)
-		}
-		return fmt.Errorf("webhook subscription failed with status %d: %s", resp.StatusCode, string(respBody))
+	var respBody json.RawMessage
+	if err := graphClient.Post(ctx, subscribePath, pageToken, subscribePayload, &respBody); err != nil {
+		return fmt.Errorf("Facebook webhook subscription error: %w", err)
 	}
 
+	log.Printf("📥 Webhook subscription response: %s", string(respBody))
 	log.Printf("✅ Successfully subscribed %s page %s to webhooks", platform, pageID)
 	return nil
 }
 
 // configureHandoverProtocol sets up the Facebook handover protocol for the page
-func configureHandoverProtocol(pageID, pageToken string) error {
+func configureHandoverProtocol(ctx context.Context, pageID, pageToken string) error {
 	neurocrowAppID := os.Getenv("FACEBOOK_APP_ID")
 	if neurocrowAppID == "" {
 		return fmt.Errorf("FACEBOOK_APP_ID environment variable not set")
@@ -1111,7 +927,7 @@ func configureHandoverProtocol(pageID, pageToken string) error {
 
 	// Set up handover protocol using messenger_profile endpoint
 	// Facebook requires at least one additional field besides primary_receiver_app_id
-	handoverURL := fmt.Sprintf("https://graph.facebook.com/v23.0/%s/messenger_profile", pageID)
+	handoverPath := pageID + "/messenger_profile"
 	handoverPayload := map[string]interface{}{
 		"primary_receiver_app_id": neurocrowAppID,
 		"greeting": []map[string]interface{}{
@@ -1125,55 +941,15 @@ func configureHandoverProtocol(pageID, pageToken string) error {
 		},
 	}
 
-	jsonData, err := json.Marshal(handoverPayload)
-	if err != nil {
-		return fmt.Errorf("error marshaling handover payload: %v", err)
-	}
-
-	// Make POST request to set primary receiver
-	req, err := http.NewRequest("POST", handoverURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating handover request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.URL.RawQuery = fmt.Sprintf("access_token=%s", pageToken)
+	log.Printf("🔗 Setting primary receiver for page %s: %s", pageID, graphClient.URL(handoverPath, nil))
+	log.Printf("📤 Handover payload: %v", handoverPayload)
 
-	log.Printf("🔗 Setting primary receiver for page %s: %s", pageID, handoverURL)
-	log.Printf("📤 Handover payload: %s", string(jsonData))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error making handover request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading handover response: %v", err)
-	}
-
-	log.Printf("📥 Handover protocol response: %d - %s", resp.StatusCode, string(respBody))
-
-	if resp.StatusCode != http.StatusOK {
-		var fbError struct {
-			Error struct {
-				Message   string `json:"message"`
-				Type      string `json:"type"`
-				Code      int    `json:"code"`
-				FbtraceID string `json:"fbtrace_id"`
-			} `json:"error"`
-		}
-		
-		if json.Unmarshal(respBody, &fbError) == nil && fbError.Error.Message != "" {
-			log.Printf("⚠️ Handover protocol setup warning: %s (Code: %d, Trace: %s)", 
-				fbError.Error.Message, fbError.Error.Code, fbError.Error.FbtraceID)
-			// Don't return error - handover protocol setup can fail but webhook subscription still works
-		} else {
-			log.Printf("⚠️ Handover protocol setup returned status %d: %s", resp.StatusCode, string(respBody))
-		}
+	var respBody json.RawMessage
+	if err := graphClient.Post(ctx, handoverPath, pageToken, handoverPayload, &respBody); err != nil {
+		log.Printf("⚠️ Handover protocol setup warning: %v", err)
+		// Don't return error - handover protocol setup can fail but webhook subscription still works
 	} else {
+		log.Printf("📥 Handover protocol response: %s", string(respBody))
 		log.Printf("✅ Successfully configured handover protocol for page %s", pageID)
 	}
 
@@ -1181,28 +957,18 @@ func configureHandoverProtocol(pageID, pageToken string) error {
 }
 
 // verifyWebhookSetup verifies that webhook subscriptions were set up correctly
-func verifyWebhookSetup(pageID, pageToken string) error {
+func verifyWebhookSetup(ctx context.Context, pageID, pageToken string) error {
 	// Check subscribed apps for the page
-	verifyURL := fmt.Sprintf("https://graph.facebook.com/v23.0/%s/subscribed_apps?access_token=%s", pageID, pageToken)
-	
-	log.Printf("🔍 Verifying webhook setup for page %s: %s", pageID, verifyURL)
+	verifyPath := pageID + "/subscribed_apps"
 
-	resp, err := http.Get(verifyURL)
-	if err != nil {
-		return fmt.Errorf("error verifying webhook setup: %v", err)
-	}
-	defer resp.Body.Close()
+	log.Printf("🔍 Verifying webhook setup for page %s: %s", pageID, graphClient.URL(verifyPath, nil))
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading verification response: %v", err)
+	var respBody json.RawMessage
+	if err := graphClient.Get(ctx, verifyPath, pageToken, nil, &respBody); err != nil {
+		return fmt.Errorf("webhook verification failed: %w", err)
 	}
 
-	log.Printf("📋 Webhook verification response: %d - %s", resp.StatusCode, string(respBody))
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("webhook verification failed with status %d: %s", resp.StatusCode, string(respBody))
-	}
+	log.Printf("📋 Webhook verification response: %s", string(respBody))
 
 	// Parse and log the subscribed apps
 	var verifyResult struct {
@@ -1239,7 +1005,7 @@ func verifyWebhookSetup(pageID, pageToken string) error {
 }
 
 // setupWebhookSubscriptions orchestrates the complete webhook setup for a page
-func setupWebhookSubscriptions(pageID, pageToken, pageName, platform string) error {
+func setupWebhookSubscriptions(ctx context.Context, pageID, pageToken, pageName, platform string) error {
 	log.Printf("🚀 Starting webhook setup for %s page: %s (%s)", platform, pageName, pageID)
 
 	if platform == "instagram" {
@@ -1255,19 +1021,19 @@ func setupWebhookSubscriptions(pageID, pageToken, pageName, platform string) err
 	log.Printf("📘 Facebook page requires individual API webhook subscription")
 
 	// Step 1: Subscribe page to webhooks (Facebook only)
-	if err := subscribePageToWebhooks(pageID, pageToken, platform); err != nil {
+	if err := subscribePageToWebhooks(ctx, pageID, pageToken, platform); err != nil {
 		log.Printf("❌ Webhook subscription failed for %s: %v", pageName, err)
 		return fmt.Errorf("webhook subscription failed: %v", err)
 	}
 
 	// Step 2: Configure handover protocol (Facebook only)
-	if err := configureHandoverProtocol(pageID, pageToken); err != nil {
+	if err := configureHandoverProtocol(ctx, pageID, pageToken); err != nil {
 		log.Printf("⚠️ Handover protocol setup failed for %s: %v", pageName, err)
 		// Don't return error - handover protocol is optional, webhook subscription is more important
 	}
 
 	// Step 3: Verify the setup (Facebook only)
-	if err := verifyWebhookSetup(pageID, pageToken); err != nil {
+	if err := verifyWebhookSetup(ctx, pageID, pageToken); err != nil {
 		log.Printf("⚠️ Webhook verification failed for %s: %v", pageName, err)
 		// Don't return error - verification is informational
 	}
@@ -1347,7 +1113,7 @@ func handleInsights(w http.ResponseWriter, r *http.Request) {
 	log.Printf("📄 Found page: %s (%s) - Platform: %s", pageName, pageID, platform)
 
 	// Get insights data from Facebook API
-	insights, err := getPageInsights(pageID, accessToken, period, pageName, platform)
+	insights, err := getPageInsights(r.Context(), pageID, accessToken, period, pageName, platform)
 	if err != nil {
 		log.Printf("❌ Error fetching insights: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to fetch insights: %v", err), http.StatusInternalServerError)
@@ -1358,7 +1124,7 @@ func handleInsights(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(insights)
 }
 
-func getPageInsights(pageID, accessToken, period, pageName, platform string) (*InsightsResponse, error) {
+func getPageInsights(ctx context.Context, pageID, accessToken, period, pageName, platform string) (*InsightsResponse, error) {
 	// Use simpler, more reliable metrics that are less likely to be deprecated
 	// Based on 2024 Facebook API changes, many metrics have been deprecated
 	metrics := []string{
@@ -1392,50 +1158,20 @@ func getPageInsights(pageID, accessToken, period, pageName, platform string) (*I
 	}
 
 	// Call Facebook Insights API
-	insightsURL := fmt.Sprintf(
-		"https://graph.facebook.com/v23.0/%s/insights?metric=%s&period=%s&access_token=%s",
-		pageID, metricsParam, fbPeriod, accessToken,
-	)
+	insightsPath := pageID + "/insights"
+	insightsParams := url.Values{"metric": {metricsParam}, "period": {fbPeriod}}
 
-	log.Printf("🔍 Facebook Insights API call: %s", insightsURL)
+	log.Printf("🔍 Facebook Insights API call: %s", graphClient.URL(insightsPath, insightsParams))
 	log.Printf("🔍 Requesting metrics: %s", metricsParam)
 	log.Printf("🔍 Using period: %s", fbPeriod)
 
-	resp, err := http.Get(insightsURL)
-	if err != nil {
-		return nil, fmt.Errorf("error calling Facebook API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading Facebook response: %w", err)
-	}
-
-	log.Printf("📥 Facebook Insights response: %d - %s", resp.StatusCode, string(bodyBytes))
-
-	if resp.StatusCode != http.StatusOK {
-		var fbError struct {
-			Error struct {
-				Message   string `json:"message"`
-				Type      string `json:"type"`
-				Code      int    `json:"code"`
-				FbtraceID string `json:"fbtrace_id"`
-			} `json:"error"`
-		}
-		
-		if json.Unmarshal(bodyBytes, &fbError) == nil && fbError.Error.Message != "" {
-			return nil, fmt.Errorf("Facebook API error: %s (Code: %d, Trace: %s)", 
-				fbError.Error.Message, fbError.Error.Code, fbError.Error.FbtraceID)
-		}
-		return nil, fmt.Errorf("Facebook API error: status %d - %s", resp.StatusCode, string(bodyBytes))
-	}
-
 	var fbData FacebookInsightsData
-	if err := json.Unmarshal(bodyBytes, &fbData); err != nil {
-		return nil, fmt.Errorf("error parsing Facebook insights data: %w", err)
+	if err := graphClient.Get(ctx, insightsPath, accessToken, insightsParams, &fbData); err != nil {
+		return nil, fmt.Errorf("Facebook API error: %w", err)
 	}
 
+	log.Printf("📥 Facebook Insights response: %d metrics", len(fbData.Data))
+
 	// Process and format the data
 	response := &InsightsResponse{
 		PageName:   pageName,
@@ -1451,33 +1187,21 @@ func getPageInsights(pageID, accessToken, period, pageName, platform string) (*I
 		log.Printf("⚠️ Facebook Insights returned empty data array - trying basic page info instead")
 		
 		// Try to get basic page information as fallback
-		pageInfoURL := fmt.Sprintf(
-			"https://graph.facebook.com/v23.0/%s?fields=id,name,fan_count,followers_count&access_token=%s",
-			pageID, accessToken,
-		)
-		
-		log.Printf("🔍 Trying basic page info: %s", pageInfoURL)
-		
-		pageResp, err := http.Get(pageInfoURL)
-		if err == nil {
-			defer pageResp.Body.Close()
-			pageBody, _ := io.ReadAll(pageResp.Body)
-			log.Printf("📄 Basic page info response: %d - %s", pageResp.StatusCode, string(pageBody))
-			
-			if pageResp.StatusCode == http.StatusOK {
-				var pageInfo struct {
-					ID             string `json:"id"`
-					Name           string `json:"name"`
-					FanCount       int    `json:"fan_count"`
-					FollowersCount int    `json:"followers_count"`
-				}
-				
-				if json.Unmarshal(pageBody, &pageInfo) == nil {
-					response.Metrics["page_fans"] = pageInfo.FanCount
-					response.Metrics["followers_count"] = pageInfo.FollowersCount
-					log.Printf("✅ Added basic page metrics: fans=%d, followers=%d", pageInfo.FanCount, pageInfo.FollowersCount)
-				}
-			}
+		pageInfoParams := url.Values{"fields": {"id,name,fan_count,followers_count"}}
+		log.Printf("🔍 Trying basic page info: %s", graphClient.URL(pageID, pageInfoParams))
+
+		var pageInfo struct {
+			ID             string `json:"id"`
+			Name           string `json:"name"`
+			FanCount       int    `json:"fan_count"`
+			FollowersCount int    `json:"followers_count"`
+		}
+		if err := graphClient.Get(ctx, pageID, accessToken, pageInfoParams, &pageInfo); err != nil {
+			log.Printf("⚠️ Basic page info failed: %v", err)
+		} else {
+			response.Metrics["page_fans"] = pageInfo.FanCount
+			response.Metrics["followers_count"] = pageInfo.FollowersCount
+			log.Printf("✅ Added basic page metrics: fans=%d, followers=%d", pageInfo.FanCount, pageInfo.FollowersCount)
 		}
 	} else {
 		// Process each metric normally
@@ -1668,7 +1392,7 @@ func handlePagePosts(w http.ResponseWriter, r *http.Request) {
 	log.Printf("📄 Found page: %s (%s) - Platform: %s", pageName, pageID, platform)
 
 	// Get posts data from Facebook API
-	posts, err := getPagePosts(pageID, accessToken, limit, pageName, platform)
+	posts, err := getPagePosts(r.Context(), pageID, accessToken, limit, pageName, platform)
 	if err != nil {
 		log.Printf("❌ Error fetching posts: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to fetch posts: %v", err), http.StatusInternalServerError)
@@ -1679,23 +1403,15 @@ func handlePagePosts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(posts)
 }
 
-func getPagePosts(pageID, accessToken, limit, pageName, platform string) (*PagePostsResponse, error) {
+func getPagePosts(ctx context.Context, pageID, accessToken, limit, pageName, platform string) (*PagePostsResponse, error) {
 	// First get page basic info
-	pageInfoURL := fmt.Sprintf(
-		"https://graph.facebook.com/v23.0/%s?fields=name,picture,fan_count,followers_count,about,website&access_token=%s",
-		pageID, accessToken,
-	)
+	pageInfoParams := url.Values{"fields": {"name,picture,fan_count,followers_count,about,website"}}
 
-	log.Printf("🔍 Facebook Page Info API call: %s", pageInfoURL)
+	log.Printf("🔍 Facebook Page Info API call: %s", graphClient.URL(pageID, pageInfoParams))
 
-	pageResp, err := http.Get(pageInfoURL)
-	if err != nil {
-		log.Printf("⚠️ Error calling Facebook Page Info API (continuing with posts): %v", err)
-	}
-	
 	var pageInfo struct {
-		Name          string `json:"name"`
-		Picture       struct {
+		Name    string `json:"name"`
+		Picture struct {
 			Data struct {
 				URL string `json:"url"`
 			} `json:"data"`
@@ -1705,62 +1421,30 @@ func getPagePosts(pageID, accessToken, limit, pageName, platform string) (*PageP
 		About          string `json:"about"`
 		Website        string `json:"website"`
 	}
-	
-	if pageResp != nil {
-		defer pageResp.Body.Close()
-		if pageResp.StatusCode == http.StatusOK {
-			if pageBody, err := io.ReadAll(pageResp.Body); err == nil {
-				json.Unmarshal(pageBody, &pageInfo)
-				log.Printf("✅ Page info loaded: %s, followers: %d", pageInfo.Name, pageInfo.FollowersCount)
-			}
-		}
+	if err := graphClient.Get(ctx, pageID, accessToken, pageInfoParams, &pageInfo); err != nil {
+		log.Printf("⚠️ Error calling Facebook Page Info API (continuing with posts): %v", err)
+	} else {
+		log.Printf("✅ Page info loaded: %s, followers: %d", pageInfo.Name, pageInfo.FollowersCount)
 	}
 
 	// Call Facebook Feed API with enhanced fields including images
-	postsURL := fmt.Sprintf(
-		"https://graph.facebook.com/v23.0/%s/feed?fields=id,message,story,created_time,from,likes.summary(true),comments.summary(true),shares,full_picture,attachments{media,url,title,description}&limit=%s&access_token=%s",
-		pageID, limit, accessToken,
-	)
-
-	log.Printf("🔍 Facebook Posts API call: %s", postsURL)
-
-	resp, err := http.Get(postsURL)
-	if err != nil {
-		return nil, fmt.Errorf("error calling Facebook API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading Facebook response: %w", err)
+	postsPath := pageID + "/feed"
+	postsParams := url.Values{
+		"fields": {"id,message,story,created_time,from,likes.summary(true),comments.summary(true),shares,full_picture,attachments{media,url,title,description}"},
+		"limit":  {limit},
 	}
 
-	log.Printf("📥 Facebook Posts response: %d - %s", resp.StatusCode, string(bodyBytes))
-
-	if resp.StatusCode != http.StatusOK {
-		var fbError struct {
-			Error struct {
-				Message   string `json:"message"`
-				Type      string `json:"type"`
-				Code      int    `json:"code"`
-				FbtraceID string `json:"fbtrace_id"`
-			} `json:"error"`
-		}
-		
-		if json.Unmarshal(bodyBytes, &fbError) == nil && fbError.Error.Message != "" {
-			return nil, fmt.Errorf("Facebook API error: %s (Code: %d, Trace: %s)", 
-				fbError.Error.Message, fbError.Error.Code, fbError.Error.FbtraceID)
-		}
-		return nil, fmt.Errorf("Facebook API error: status %d - %s", resp.StatusCode, string(bodyBytes))
-	}
+	log.Printf("🔍 Facebook Posts API call: %s", graphClient.URL(postsPath, postsParams))
 
 	var fbData struct {
 		Data []PagePost `json:"data"`
 	}
-	if err := json.Unmarshal(bodyBytes, &fbData); err != nil {
-		return nil, fmt.Errorf("error parsing Facebook posts data: %w", err)
+	if err := graphClient.Get(ctx, postsPath, accessToken, postsParams, &fbData); err != nil {
+		return nil, fmt.Errorf("Facebook API error: %w", err)
 	}
 
+	log.Printf("📥 Facebook Posts response: %d posts", len(fbData.Data))
+
 	// Build response
 	response := &PagePostsResponse{
 		PageName:       pageName,
@@ -1796,7 +1480,7 @@ func handleFacebookBusinessToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 1. Get user details from Facebook
-	fbUser, err := getFacebookUser(data.UserToken)
+	fbUser, err := getFacebookUser(r.Context(), data.UserToken)
 	if err != nil {
 		log.Printf("❌ Error getting Facebook user details: %v", err)
 		http.Error(w, fmt.Sprintf("Could not verify Facebook user: %v", err), http.StatusInternalServerError)
@@ -1806,7 +1490,7 @@ func handleFacebookBusinessToken(w http.ResponseWriter, r *http.Request) {
 	log.Printf("✅ Facebook Business user authenticated: %s (ID: %s)", fbUser.Name, fbUser.ID)
 
 	// 2. Get both Facebook pages and Instagram Business accounts
-	facebookPages, err := getConnectedPages(data.UserToken)
+	facebookPages, err := getConnectedPages(r.Context(), data.UserToken)
 	if err != nil {
 		log.Printf("❌ Error getting Facebook pages: %v", err)
 		http.Error(w, fmt.Sprintf("Could not get Facebook pages: %v", err), http.StatusInternalServerError)
@@ -1816,7 +1500,7 @@ func handleFacebookBusinessToken(w http.ResponseWriter, r *http.Request) {
 	log.Printf("✅ Found %d Facebook pages", len(facebookPages))
 
 	// 3. Get Instagram Business accounts via Facebook Pages
-	instagramAccounts, err := getInstagramAccountsViaFacebook(data.UserToken)
+	instagramAccounts, err := getInstagramAccountsViaFacebook(r.Context(), data.UserToken)
 	if err != nil {
 		log.Printf("⚠️ Warning: Could not get Instagram Business accounts: %v", err)
 		instagramAccounts = []InstagramAccount{} // Continue without Instagram accounts
@@ -1882,12 +1566,12 @@ func handleInstagramTokenExchange(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if data.Code == "" || data.RedirectURI == "" {
-		log.Printf("❌ Missing required fields: code=%s, redirect_uri=%s", data.Code, data.RedirectURI)
+		log.Printf("❌ Missing required fields: code present=%t, redirect_uri=%s", data.Code != "", data.RedirectURI)
 		http.Error(w, "Missing code or redirect_uri", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Instagram token exchange request: code=%s, redirect_uri=%s", data.Code, data.RedirectURI)
+	log.Printf("📥 Instagram token exchange request: redirect_uri=%s", data.RedirectURI)
 
 	// Exchange authorization code for access token using Instagram API
 	instagramAppId := os.Getenv("INSTAGRAM_APP_ID")
@@ -1899,76 +1583,25 @@ func handleInstagramTokenExchange(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build token exchange request for Instagram
-	tokenURL := "https://api.instagram.com/oauth/access_token"
-	
-	// Prepare form data for POST request
-	formData := fmt.Sprintf(
-		"client_id=%s&client_secret=%s&grant_type=authorization_code&redirect_uri=%s&code=%s",
-		instagramAppId, instagramAppSecret, data.RedirectURI, data.Code,
-	)
-
 	log.Printf("🔗 Making token exchange request to Instagram API")
 
-	// Make the token exchange request (POST with form data)
-	resp, err := http.Post(tokenURL, "application/x-www-form-urlencoded", strings.NewReader(formData))
+	// Exchange the authorization code (POST with form data)
+	var tokenResponse graph.AccessToken
+	err := instagramLoginClient.PostForm(r.Context(), "oauth/access_token", "", url.Values{
+		"client_id":     {instagramAppId},
+		"client_secret": {instagramAppSecret},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {data.RedirectURI},
+		"code":          {data.Code},
+	}, &tokenResponse)
 	if err != nil {
-		log.Printf("❌ Error making token exchange request: %v", err)
-		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Read response body
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("❌ Error reading token exchange response: %v", err)
-		http.Error(w, "Failed to read token response", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("📥 Instagram token exchange response: %d - %s", resp.StatusCode, string(bodyBytes))
-
-	if resp.StatusCode != http.StatusOK {
-		var igError struct {
-			Error struct {
-				Message string `json:"error_message"`
-				Type    string `json:"error_type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-			ErrorMessage string `json:"error_message"`
-			ErrorType    string `json:"error_type"`
-		}
-		
-		if json.Unmarshal(bodyBytes, &igError) == nil {
-			errorMsg := igError.ErrorMessage
-			if errorMsg == "" && igError.Error.Message != "" {
-				errorMsg = igError.Error.Message
-			}
-			if errorMsg != "" {
-				log.Printf("❌ Instagram token exchange error: %s (Type: %s)", errorMsg, igError.ErrorType)
-				http.Error(w, fmt.Sprintf("Instagram API error: %s", errorMsg), http.StatusBadRequest)
-			} else {
-				log.Printf("❌ Instagram token exchange failed with status %d", resp.StatusCode)
-				http.Error(w, "Token exchange failed", http.StatusBadRequest)
-			}
-		} else {
-			log.Printf("❌ Instagram token exchange failed with status %d", resp.StatusCode)
-			http.Error(w, "Token exchange failed", http.StatusBadRequest)
+		if graphErr, ok := graph.AsGraphError(err); ok && graphErr.Message != "" {
+			log.Printf("❌ Instagram token exchange error: %v", graphErr)
+			http.Error(w, fmt.Sprintf("Instagram API error: %s", graphErr.Message), http.StatusBadRequest)
+			return
 		}
-		return
-	}
-
-	// Parse successful token response
-	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
-		ExpiresIn   int    `json:"expires_in"`
-	}
-
-	if err := json.Unmarshal(bodyBytes, &tokenResponse); err != nil {
-		log.Printf("❌ Error parsing token response: %v", err)
-		http.Error(w, "Failed to parse token response", http.StatusInternalServerError)
+		log.Printf("❌ Instagram token exchange failed: %v", err)
+		http.Error(w, "Token exchange failed", http.StatusBadRequest)
 		return
 	}
 
@@ -2005,7 +1638,7 @@ func handleInstagramToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 1. Get Instagram user details
-	instagramUser, err := getInstagramUser(data.UserToken)
+	instagramUser, err := getInstagramUser(r.Context(), data.UserToken)
 	if err != nil {
 		log.Printf("❌ Error getting Instagram user details: %v", err)
 		http.Error(w, fmt.Sprintf("Could not verify Instagram user: %v", err), http.StatusInternalServerError)
@@ -2013,7 +1646,7 @@ func handleInstagramToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. Get Instagram Business accounts
-	accounts, err := getInstagramBusinessAccounts(data.UserToken)
+	accounts, err := getInstagramBusinessAccounts(r.Context(), data.UserToken)
 	if err != nil {
 		log.Printf("❌ Error getting Instagram accounts: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -2215,41 +1848,13 @@ type InstagramAccount struct {
 }
 
 // getInstagramUser gets Instagram user information
-func getInstagramUser(accessToken string) (*InstagramUser, error) {
-	url := fmt.Sprintf("https://graph.instagram.com/me?fields=id,username&access_token=%s", accessToken)
-	log.Printf("📡 Getting Instagram user info from: %s", url)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("error making request to Instagram API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading Instagram API response: %w", err)
-	}
-
-	log.Printf("📥 Instagram user API response: %d - %s", resp.StatusCode, string(bodyBytes))
-
-	if resp.StatusCode != http.StatusOK {
-		var igError struct {
-			Error struct {
-				Message string `json:"message"`
-				Type    string `json:"type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
-		
-		if json.Unmarshal(bodyBytes, &igError) == nil && igError.Error.Message != "" {
-			return nil, fmt.Errorf("Instagram API error: %s", igError.Error.Message)
-		}
-		return nil, fmt.Errorf("Instagram API error: status %d", resp.StatusCode)
-	}
+func getInstagramUser(ctx context.Context, accessToken string) (*InstagramUser, error) {
+	params := url.Values{"fields": {"id,username"}}
+	log.Printf("📡 Getting Instagram user info from: %s", instagramClient.URL("me", params))
 
 	var user InstagramUser
-	if err := json.Unmarshal(bodyBytes, &user); err != nil {
-		return nil, fmt.Errorf("error parsing Instagram user response: %w", err)
+	if err := instagramClient.Get(ctx, "me", accessToken, params, &user); err != nil {
+		return nil, fmt.Errorf("Instagram API error: %w", err)
 	}
 
 	if user.ID == "" || user.Username == "" {
@@ -2261,12 +1866,12 @@ func getInstagramUser(accessToken string) (*InstagramUser, error) {
 }
 
 // getInstagramBusinessAccounts gets Instagram Business accounts for the user (original Instagram-only version)
-func getInstagramBusinessAccounts(accessToken string) ([]InstagramAccount, error) {
+func getInstagramBusinessAccounts(ctx context.Context, accessToken string) ([]InstagramAccount, error) {
 	// Note: Instagram Business API typically requires getting accounts through 
 	// Facebook Pages that have connected Instagram Business accounts
 	// For now, we'll create a basic account entry using the user's info
 	
-	user, err := getInstagramUser(accessToken)
+	user, err := getInstagramUser(ctx, accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user info: %w", err)
 	}
@@ -2285,80 +1890,48 @@ func getInstagramBusinessAccounts(accessToken string) ([]InstagramAccount, error
 }
 
 // getInstagramAccountsViaFacebook gets Instagram Business accounts connected to Facebook Pages
-func getInstagramAccountsViaFacebook(facebookAccessToken string) ([]InstagramAccount, error) {
+func getInstagramAccountsViaFacebook(ctx context.Context, facebookAccessToken string) ([]InstagramAccount, error) {
 	log.Printf("🔍 Getting Instagram Business accounts through Facebook Pages...")
-	
+
 	// First get Facebook Pages for this user
-	pages, err := getConnectedPages(facebookAccessToken)
+	pages, err := getConnectedPages(ctx, facebookAccessToken)
 	if err != nil {
 		return nil, fmt.Errorf("error getting Facebook pages: %w", err)
 	}
-	
+
 	var instagramAccounts []InstagramAccount
-	
+
 	// For each Facebook Page, check if it has a connected Instagram Business account
 	for _, page := range pages {
 		log.Printf("🔍 Checking page %s for connected Instagram account...", page.Name)
-		
+
 		// Get Instagram Business account ID for this page
-		url := fmt.Sprintf("https://graph.facebook.com/v23.0/%s?fields=instagram_business_account&access_token=%s", page.ID, page.AccessToken)
-		
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("⚠️ Error checking Instagram connection for page %s: %v", page.Name, err)
-			continue
-		}
-		defer resp.Body.Close()
-		
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("⚠️ Error reading response for page %s: %v", page.Name, err)
-			continue
-		}
-		
 		var pageData struct {
 			InstagramBusinessAccount struct {
 				ID string `json:"id"`
 			} `json:"instagram_business_account"`
 		}
-		
-		if err := json.Unmarshal(bodyBytes, &pageData); err != nil {
-			log.Printf("⚠️ Error parsing response for page %s: %v", page.Name, err)
+		if err := graphClient.Get(ctx, page.ID, page.AccessToken, url.Values{"fields": {"instagram_business_account"}}, &pageData); err != nil {
+			log.Printf("⚠️ Error checking Instagram connection for page %s: %v", page.Name, err)
 			continue
 		}
-		
+
 		// If this page has a connected Instagram Business account
 		if pageData.InstagramBusinessAccount.ID != "" {
 			log.Printf("✅ Found Instagram Business account %s connected to page %s", pageData.InstagramBusinessAccount.ID, page.Name)
-			
+
 			// Get Instagram account details
-			igUrl := fmt.Sprintf("https://graph.facebook.com/v23.0/%s?fields=id,username,name&access_token=%s", 
-				pageData.InstagramBusinessAccount.ID, page.AccessToken)
-			
-			igResp, err := http.Get(igUrl)
-			if err != nil {
-				log.Printf("⚠️ Error getting Instagram account details: %v", err)
-				continue
-			}
-			defer igResp.Body.Close()
-			
-			igBodyBytes, err := io.ReadAll(igResp.Body)
-			if err != nil {
-				log.Printf("⚠️ Error reading Instagram account response: %v", err)
-				continue
-			}
-			
 			var igAccount struct {
 				ID       string `json:"id"`
 				Username string `json:"username"`
 				Name     string `json:"name"`
 			}
-			
-			if err := json.Unmarshal(igBodyBytes, &igAccount); err != nil {
-				log.Printf("⚠️ Error parsing Instagram account response: %v", err)
+			if err := graphClient.Get(ctx, pageData.InstagramBusinessAccount.ID, page.AccessToken,
+				url.Values{"fields": {"id,username,name"}}, &igAccount); err != nil {
+				log.Printf("⚠️ Error getting Instagram account details: %v", err)
 				continue
 			}
-			
+
 			// Create Instagram account entry
 			account := InstagramAccount{
 				ID:          igAccount.ID,
@@ -0,0 +1,81 @@
+// api.go
+package graph
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// SendResult is the Send API response
+type SendResult struct {
+	RecipientID string `json:"recipient_id"`
+	MessageID   string `json:"message_id"`
+}
+
+// TextMessage is a text message for the Send API
+type TextMessage struct {
+	PageID        string // Sending page; "" sends as "me" (the page the token belongs to)
+	RecipientID   string // Page-scoped (PSID) or Instagram-scoped (IGSID) user ID
+	CommentID     string // Sends a private reply to this comment instead of RecipientID
+	Text          string
+	MessagingType string // RESPONSE, UPDATE or MESSAGE_TAG; "" leaves it to Graph
+}
+
+// SendText sends a text message through the Messenger / Instagram Send API
+func (c *Client) SendText(ctx context.Context, token string, msg TextMessage) (*SendResult, error) {
+	sender := msg.PageID
+	if sender == "" {
+		sender = "me"
+	}
+
+	recipient := map[string]string{"id": msg.RecipientID}
+	if msg.CommentID != "" {
+		recipient = map[string]string{"comment_id": msg.CommentID}
+	}
+	payload := map[string]interface{}{
+		"recipient": recipient,
+		"message":   map[string]string{"text": msg.Text},
+	}
+	if msg.MessagingType != "" {
+		payload["messaging_type"] = msg.MessagingType
+	}
+
+	var result SendResult
+	if err := c.Post(ctx, sender+"/messages", token, payload, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AccessToken is an oauth/access_token response
+type AccessToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // Seconds; 0 for tokens that don't expire
+	UserID      int64  `json:"user_id"`    // Instagram Login only
+}
+
+// ExchangeToken exchanges a short-lived user token for a long-lived one (60
+// days). Page tokens fetched with the long-lived token don't expire.
+func (c *Client) ExchangeToken(ctx context.Context, appID, appSecret, userToken string) (*AccessToken, error) {
+	var token AccessToken
+	err := c.PostForm(ctx, "oauth/access_token", "", url.Values{
+		"grant_type":        {"fb_exchange_token"},
+		"client_id":         {appID},
+		"client_secret":     {appSecret},
+		"fb_exchange_token": {userToken},
+	}, &token)
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("graph: no access token in oauth/access_token response")
+	}
+	return &token, nil
+}
+
+// AppToken returns the app access token used for app-level calls such as debug_token
+func AppToken(appID, appSecret string) string {
+	return appID + "|" + appSecret
+}
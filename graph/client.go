@@ -0,0 +1,259 @@
+// client.go
+//
+// Package graph is the Meta Graph API client shared by message-router (including
+// its oauth package) and client-manager. It provides:
+//
+//   - one configurable base URL and API version (GRAPH_API_BASE_URL, GRAPH_API_VERSION)
+//   - access tokens sent in the Authorization header, never in the URL
+//   - GraphError with the code, subcode and fbtrace_id of every failed call
+//   - retries with jittered backoff for transient errors and rate limits
+//
+// Package graphtest has an httptest-based fake Graph server for offline tests.
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL        = "https://graph.facebook.com"
+	InstagramBaseURL      = "https://graph.instagram.com" // Instagram API with Instagram Login
+	InstagramOAuthBaseURL = "https://api.instagram.com"   // Instagram Login code exchange (unversioned)
+	DefaultVersion        = "v23.0"
+
+	maxResponseBytes = 10 << 20
+)
+
+// Config configures a Client; zero values use the defaults
+type Config struct {
+	BaseURL      string        // Default DefaultBaseURL
+	Version      string        // Default DefaultVersion
+	Unversioned  bool          // Don't prefix paths with a version (api.instagram.com)
+	HTTPClient   *http.Client  // Default a client with a 30s timeout
+	MaxRetries   int           // Retries after the first attempt; default 2, negative disables
+	RetryBackoff time.Duration // Base of the exponential backoff, default 500ms
+	MaxRetryWait time.Duration // Longer waits (e.g. a rate limit for the next hour) fail instead; default 30s
+}
+
+// ConfigFromEnv reads GRAPH_API_BASE_URL, GRAPH_API_VERSION and GRAPH_API_MAX_RETRIES
+func ConfigFromEnv() Config {
+	cfg := Config{
+		BaseURL: os.Getenv("GRAPH_API_BASE_URL"),
+		Version: os.Getenv("GRAPH_API_VERSION"),
+	}
+	if value := os.Getenv("GRAPH_API_MAX_RETRIES"); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil {
+			cfg.MaxRetries = retries
+			if retries == 0 {
+				cfg.MaxRetries = -1
+			}
+		}
+	}
+	return cfg
+}
+
+// Client calls the Graph API. It is safe for concurrent use.
+type Client struct {
+	baseURL      string
+	version      string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+	maxRetryWait time.Duration
+}
+
+// New creates a client
+func New(cfg Config) *Client {
+	c := &Client{
+		baseURL:      strings.TrimRight(cfg.BaseURL, "/"),
+		version:      cfg.Version,
+		httpClient:   cfg.HTTPClient,
+		maxRetries:   cfg.MaxRetries,
+		retryBackoff: cfg.RetryBackoff,
+		maxRetryWait: cfg.MaxRetryWait,
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+	if cfg.Unversioned {
+		c.version = ""
+	} else if c.version == "" {
+		c.version = DefaultVersion
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = 2
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	if c.retryBackoff <= 0 {
+		c.retryBackoff = 500 * time.Millisecond
+	}
+	if c.maxRetryWait <= 0 {
+		c.maxRetryWait = 30 * time.Second
+	}
+	return c
+}
+
+// WithBaseURL returns a copy of the client for another host, e.g. InstagramBaseURL
+func (c *Client) WithBaseURL(baseURL string, unversioned bool) *Client {
+	clone := *c
+	clone.baseURL = strings.TrimRight(baseURL, "/")
+	if unversioned {
+		clone.version = ""
+	} else if clone.version == "" {
+		clone.version = DefaultVersion
+	}
+	return &clone
+}
+
+// BaseURL returns the base URL the client calls
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// Version returns the API version paths are prefixed with, "" when unversioned
+func (c *Client) Version() string { return c.version }
+
+// Get calls GET path with the query params and decodes the response into out
+// (which may be nil). path is relative to the version, e.g. "me/accounts".
+func (c *Client) Get(ctx context.Context, path, token string, params url.Values, out interface{}) error {
+	return c.do(ctx, http.MethodGet, path, token, params, "", nil, out)
+}
+
+// Post sends body as JSON
+func (c *Client) Post(ctx context.Context, path, token string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("graph: encoding %s request: %w", path, err)
+	}
+	return c.do(ctx, http.MethodPost, path, token, nil, "application/json", data, out)
+}
+
+// PostForm sends form as application/x-www-form-urlencoded
+func (c *Client) PostForm(ctx context.Context, path, token string, form url.Values, out interface{}) error {
+	return c.do(ctx, http.MethodPost, path, token, nil, "application/x-www-form-urlencoded", []byte(form.Encode()), out)
+}
+
+// PostRaw sends a pre-encoded body, e.g. multipart/form-data with a photo
+func (c *Client) PostRaw(ctx context.Context, path, token, contentType string, body []byte, out interface{}) error {
+	return c.do(ctx, http.MethodPost, path, token, nil, contentType, body, out)
+}
+
+// Delete calls DELETE path
+func (c *Client) Delete(ctx context.Context, path, token string, out interface{}) error {
+	return c.do(ctx, http.MethodDelete, path, token, nil, "", nil, out)
+}
+
+// URL returns the full URL of path, without any access token
+func (c *Client) URL(path string, params url.Values) string {
+	u := c.baseURL + "/"
+	if c.version != "" {
+		u += c.version + "/"
+	}
+	u += strings.TrimPrefix(path, "/")
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	return u
+}
+
+// do sends the request and retries it while the error is temporary. Requests
+// that reached Graph are retried only when Graph said the failure is temporary;
+// connection errors are retried for GET and DELETE only, since a POST (a sent
+// message, a new comment) may have been applied before the connection broke.
+func (c *Client) do(ctx context.Context, method, path, token string, params url.Values, contentType string, body []byte, out interface{}) error {
+	requestURL := c.URL(path, params)
+	idempotent := method == http.MethodGet || method == http.MethodDelete
+
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+		if err != nil {
+			return fmt.Errorf("graph: creating %s %s request: %w", method, path, err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		var wait time.Duration
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			err = fmt.Errorf("graph: %s %s: %w", method, path, err)
+			if !idempotent || attempt >= c.maxRetries || ctx.Err() != nil {
+				return err
+			}
+			wait = c.backoff(attempt)
+		} else {
+			respBody, readErr := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+			resp.Body.Close()
+			if readErr != nil {
+				return fmt.Errorf("graph: reading %s %s response: %w", method, path, readErr)
+			}
+
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 && !hasErrorObject(respBody) {
+				if out == nil || len(respBody) == 0 {
+					return nil
+				}
+				if raw, ok := out.(*json.RawMessage); ok {
+					*raw = append((*raw)[:0], respBody...)
+					return nil
+				}
+				if err := json.Unmarshal(respBody, out); err != nil {
+					return fmt.Errorf("graph: decoding %s %s response: %w", method, path, err)
+				}
+				return nil
+			}
+
+			graphErr := parseError(resp.StatusCode, respBody, resp.Header)
+			if !graphErr.Temporary() || attempt >= c.maxRetries {
+				return graphErr
+			}
+			if !idempotent && graphErr.Code == 0 {
+				return graphErr // A bare 5xx from a proxy doesn't tell whether the POST was applied
+			}
+			wait = c.backoff(attempt)
+			if graphErr.RetryAfter > wait {
+				wait = graphErr.RetryAfter
+			}
+			if wait > c.maxRetryWait {
+				return graphErr
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("graph: %s %s: %w", method, path, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before retry number attempt+1: exponential with equal
+// jitter, a random delay between half the ceiling and the ceiling
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.retryBackoff << attempt
+	if ceiling <= 0 || ceiling > c.maxRetryWait {
+		ceiling = c.maxRetryWait
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
@@ -0,0 +1,290 @@
+// client_test.go
+package graph_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"graph"
+	"graph/graphtest"
+)
+
+// TestErrorParsing checks that code, subcode and fbtrace_id survive, and the
+// error classification used for retries and reconnect prompts
+func TestErrorParsing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"Error validating access token","type":"OAuthException","code":190,"error_subcode":463,"fbtrace_id":"AbCdEf"}}`))
+	}))
+	defer server.Close()
+
+	client := graph.New(graph.Config{BaseURL: server.URL})
+	err := client.Get(context.Background(), "me", "token", nil, nil)
+
+	graphErr, ok := graph.AsGraphError(err)
+	if !ok {
+		t.Fatalf("Get() error = %v, want a GraphError", err)
+	}
+	if graphErr.Code != 190 || graphErr.Subcode != 463 || graphErr.FBTraceID != "AbCdEf" || graphErr.StatusCode != 400 {
+		t.Errorf("GraphError = %+v", graphErr)
+	}
+	if !graph.IsTokenInvalid(err) || graph.IsRateLimited(err) || graphErr.Temporary() {
+		t.Errorf("classification of %v is wrong", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "code 190") || !strings.Contains(msg, "fbtrace_id AbCdEf") {
+		t.Errorf("Error() = %q", msg)
+	}
+}
+
+// TestRetryTransient checks that a transient error is retried and a permanent one is not
+func TestRetryTransient(t *testing.T) {
+	fake := graphtest.NewServer()
+	defer fake.Close()
+	fake.SetObject("123", map[string]interface{}{"name": "Test Page"})
+	client := fake.Client()
+
+	fake.Fail("GET", "123", graph.GraphError{StatusCode: 500, Message: "An unexpected error has occurred.", Code: 2, IsTransient: true})
+	var page struct {
+		Name string `json:"name"`
+	}
+	if err := client.Get(context.Background(), "123", "token", nil, &page); err != nil {
+		t.Fatalf("Get() after a transient error = %v", err)
+	}
+	if page.Name != "Test Page" || len(fake.Requests()) != 2 {
+		t.Errorf("page = %+v after %d requests, want Test Page after 2", page, len(fake.Requests()))
+	}
+
+	fake.Fail("GET", "123", graph.GraphError{Message: "Invalid parameter", Code: 100})
+	if err := client.Get(context.Background(), "123", "token", nil, &page); err == nil {
+		t.Fatal("Get() with a permanent error succeeded")
+	}
+	if got := len(fake.Requests()); got != 3 {
+		t.Errorf("permanent error made %d requests in total, want 3", got)
+	}
+}
+
+// TestRetryRateLimit checks that a rate limit is retried after Retry-After, but
+// fails right away when the wait is longer than MaxRetryWait
+func TestRetryRateLimit(t *testing.T) {
+	fake := graphtest.NewServer()
+	defer fake.Close()
+	fake.SetObject("123", map[string]interface{}{"name": "Test Page"})
+
+	client := graph.New(graph.Config{BaseURL: fake.URL, RetryBackoff: time.Millisecond, MaxRetryWait: 2 * time.Second})
+	fake.Fail("GET", "123", graph.GraphError{Message: "Application request limit reached", Code: 4, RetryAfter: time.Second})
+	start := time.Now()
+	if err := client.Get(context.Background(), "123", "token", nil, nil); err != nil {
+		t.Fatalf("Get() after a short rate limit = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s Retry-After", elapsed)
+	}
+
+	fake.Fail("GET", "123", graph.GraphError{Message: "Page request limit reached", Code: 32, RetryAfter: time.Hour})
+	err := client.Get(context.Background(), "123", "token", nil, nil)
+	if !graph.IsRateLimited(err) {
+		t.Fatalf("Get() with a long rate limit = %v, want a rate limit error", err)
+	}
+	if graphErr, _ := graph.AsGraphError(err); graphErr.RetryAfter != time.Hour {
+		t.Errorf("RetryAfter = %v, want 1h", graphErr.RetryAfter)
+	}
+}
+
+// TestNoRetryOnPostConnectionError checks that a POST that may have been
+// applied is not sent twice, while a GET is retried
+func TestNoRetryOnPostConnectionError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		hijacker, _ := w.(http.Hijacker)
+		conn, _, _ := hijacker.Hijack()
+		conn.Close()
+	}))
+	defer server.Close()
+
+	client := graph.New(graph.Config{BaseURL: server.URL, RetryBackoff: time.Millisecond})
+	if err := client.Post(context.Background(), "me/messages", "token", map[string]string{}, nil); err == nil {
+		t.Fatal("Post() to a broken connection succeeded")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Post() made %d calls, want 1", got)
+	}
+
+	calls.Store(0)
+	if err := client.Get(context.Background(), "me", "token", nil, nil); err == nil {
+		t.Fatal("Get() to a broken connection succeeded")
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Get() made %d calls, want 3", got)
+	}
+}
+
+// TestSendText checks the Send API call, the version prefix and that the token
+// travels in the Authorization header rather than the URL
+func TestSendText(t *testing.T) {
+	fake := graphtest.NewServer()
+	defer fake.Close()
+	client := fake.Client()
+
+	result, err := client.SendText(context.Background(), "page-token", graph.TextMessage{
+		PageID:        "123",
+		RecipientID:   "456",
+		Text:          "Hello",
+		MessagingType: "RESPONSE",
+	})
+	if err != nil {
+		t.Fatalf("SendText() error = %v", err)
+	}
+	if result.RecipientID != "456" || result.MessageID == "" {
+		t.Errorf("SendText() = %+v", result)
+	}
+
+	sent := fake.SentMessages()
+	want := graphtest.SentMessage{PageID: "123", RecipientID: "456", Text: "Hello", MessagingType: "RESPONSE", Token: "page-token"}
+	if len(sent) != 1 || sent[0] != want {
+		t.Errorf("SentMessages() = %+v, want [%+v]", sent, want)
+	}
+
+	req := fake.Requests()[0]
+	if req.Version != graph.DefaultVersion {
+		t.Errorf("request version = %q, want %q", req.Version, graph.DefaultVersion)
+	}
+	if req.Query.Get("access_token") != "" || req.Header.Get("Authorization") != "Bearer page-token" {
+		t.Errorf("token sent as query %q, header %q", req.Query.Get("access_token"), req.Header.Get("Authorization"))
+	}
+
+	if _, err := client.SendText(context.Background(), "", graph.TextMessage{RecipientID: "456", Text: "Hello"}); err == nil {
+		t.Error("SendText() without a token succeeded")
+	}
+
+	// Private replies address the comment instead of a user
+	if _, err := client.SendText(context.Background(), "page-token", graph.TextMessage{PageID: "123", CommentID: "123_789", Text: "Hi"}); err != nil {
+		t.Fatalf("SendText() private reply error = %v", err)
+	}
+	if got := fake.SentMessages()[1]; got.CommentID != "123_789" || got.RecipientID != "" {
+		t.Errorf("private reply sent as %+v, want comment 123_789", got)
+	}
+}
+
+// TestPostsAndComments checks listing posts and creating, editing and deleting comments
+func TestPostsAndComments(t *testing.T) {
+	fake := graphtest.NewServer()
+	defer fake.Close()
+	client := fake.Client()
+	ctx := context.Background()
+
+	postID := fake.AddToEdge("123", "posts", map[string]interface{}{"message": "Opening hours"})
+
+	var posts struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+		} `json:"data"`
+	}
+	if err := client.Get(ctx, "123/posts", "token", url.Values{"fields": {"id,message"}}, &posts); err != nil {
+		t.Fatalf("Get(posts) error = %v", err)
+	}
+	if len(posts.Data) != 1 || posts.Data[0].ID != postID || posts.Data[0].Message != "Opening hours" {
+		t.Fatalf("posts = %+v", posts.Data)
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := client.PostForm(ctx, postID+"/comments", "token", url.Values{"message": {"Open 9 & 5?"}}, &created); err != nil {
+		t.Fatalf("PostForm(comments) error = %v", err)
+	}
+	comments := fake.Edge(postID, "comments")
+	if len(comments) != 1 || comments[0]["message"] != "Open 9 & 5?" {
+		t.Fatalf("comments = %+v, want the form-encoded message", comments)
+	}
+
+	if err := client.PostForm(ctx, created.ID, "token", url.Values{"message": {"Edited"}}, nil); err != nil {
+		t.Fatalf("PostForm(edit) error = %v", err)
+	}
+	if comment, _ := fake.Object(created.ID); comment["message"] != "Edited" {
+		t.Errorf("edited comment = %+v", comment)
+	}
+
+	if err := client.Delete(ctx, created.ID, "token", nil); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	err := client.Get(ctx, created.ID, "token", nil, nil)
+	if graphErr, ok := graph.AsGraphError(err); !ok || graphErr.Code != 100 || graphErr.Subcode != 33 {
+		t.Errorf("Get() of a deleted comment = %v, want code 100 subcode 33", err)
+	}
+}
+
+// TestOAuthFlow checks the long-lived token exchange, listing pages with the
+// token, and the unversioned Instagram Login code exchange
+func TestOAuthFlow(t *testing.T) {
+	fake := graphtest.NewServer()
+	defer fake.Close()
+	client := fake.Client()
+	ctx := context.Background()
+
+	token, err := client.ExchangeToken(ctx, "app-id", "app-secret", "short-token")
+	if err != nil {
+		t.Fatalf("ExchangeToken() error = %v", err)
+	}
+	if token.AccessToken != "long-lived-short-token" {
+		t.Errorf("ExchangeToken() = %+v", token)
+	}
+	exchange := fake.Requests()[0]
+	if exchange.Form.Get("client_secret") != "app-secret" || exchange.Query.Get("client_secret") != "" {
+		t.Errorf("app secret sent as form %q, query %q; want it in the form only",
+			exchange.Form.Get("client_secret"), exchange.Query.Get("client_secret"))
+	}
+
+	fake.AddToEdge("me", "accounts", map[string]interface{}{"id": "123", "name": "Test Page", "access_token": "page-token"})
+	var accounts struct {
+		Data []struct {
+			ID          string `json:"id"`
+			AccessToken string `json:"access_token"`
+		} `json:"data"`
+	}
+	if err := client.Get(ctx, "me/accounts", token.AccessToken, nil, &accounts); err != nil {
+		t.Fatalf("Get(me/accounts) error = %v", err)
+	}
+	if len(accounts.Data) != 1 || accounts.Data[0].AccessToken != "page-token" {
+		t.Errorf("accounts = %+v", accounts.Data)
+	}
+
+	instagram := client.WithBaseURL(fake.URL, true)
+	var igToken graph.AccessToken
+	if err := instagram.PostForm(ctx, "oauth/access_token", "", url.Values{"code": {"ig-code"}}, &igToken); err != nil {
+		t.Fatalf("Instagram code exchange error = %v", err)
+	}
+	requests := fake.Requests()
+	if last := requests[len(requests)-1]; last.Version != "" || igToken.AccessToken != "long-lived-ig-code" {
+		t.Errorf("Instagram exchange version = %q, token = %q", last.Version, igToken.AccessToken)
+	}
+
+	var raw json.RawMessage
+	if err := client.Get(ctx, "me/accounts", token.AccessToken, nil, &raw); err != nil || !strings.Contains(string(raw), "page-token") {
+		t.Errorf("Get() into json.RawMessage = %s, %v", raw, err)
+	}
+}
+
+// TestInstagramLoginError checks the flat error format of api.instagram.com
+func TestInstagramLoginError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error_type": "OAuthException", "code": 400, "error_message": "Invalid authorization code"}`))
+	}))
+	defer server.Close()
+
+	client := graph.New(graph.Config{BaseURL: server.URL, Unversioned: true})
+	err := client.PostForm(context.Background(), "oauth/access_token", "", url.Values{"code": {"bad"}}, nil)
+	graphErr, ok := graph.AsGraphError(err)
+	if !ok || graphErr.Message != "Invalid authorization code" || graphErr.Type != "OAuthException" || graphErr.Code != 400 {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+// errors.go
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GraphError is an error response from the Graph API. The code, subcode and
+// fbtrace_id are what Meta support asks for, so they are always kept.
+type GraphError struct {
+	StatusCode  int           // HTTP status
+	Message     string        // error.message
+	Type        string        // error.type, e.g. OAuthException
+	Code        int           // error.code
+	Subcode     int           // error.error_subcode
+	FBTraceID   string        // error.fbtrace_id
+	IsTransient bool          // error.is_transient
+	UserTitle   string        // error.error_user_title, meant to be shown to the user
+	UserMessage string        // error.error_user_msg
+	RetryAfter  time.Duration // From Retry-After or the usage headers, 0 when unknown
+	Body        string        // Raw body when it wasn't a Graph error object
+}
+
+func (e *GraphError) Error() string {
+	var b strings.Builder
+	b.WriteString("graph: ")
+	if e.Message != "" {
+		b.WriteString(e.Message)
+	} else {
+		b.WriteString(http.StatusText(e.StatusCode))
+	}
+	fmt.Fprintf(&b, " (status %d", e.StatusCode)
+	if e.Type != "" {
+		fmt.Fprintf(&b, ", type %s", e.Type)
+	}
+	if e.Code != 0 {
+		fmt.Fprintf(&b, ", code %d", e.Code)
+	}
+	if e.Subcode != 0 {
+		fmt.Fprintf(&b, ", subcode %d", e.Subcode)
+	}
+	if e.FBTraceID != "" {
+		fmt.Fprintf(&b, ", fbtrace_id %s", e.FBTraceID)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// RateLimited reports whether the app, page, user or business use case hit a
+// Graph API rate limit
+func (e *GraphError) RateLimited() bool {
+	switch e.Code {
+	case 4, 17, 32, 341, 613:
+		return true
+	}
+	return (e.Code >= 80001 && e.Code <= 80014) || e.StatusCode == http.StatusTooManyRequests
+}
+
+// TokenInvalid reports whether the access token expired, was revoked or is
+// otherwise unusable; the page has to be reconnected
+func (e *GraphError) TokenInvalid() bool {
+	return e.Code == 190 || e.Code == 102
+}
+
+// Temporary reports whether the same request may succeed later: Graph marked
+// it transient, it is a known temporary failure, or a rate limit
+func (e *GraphError) Temporary() bool {
+	if e.IsTransient || e.RateLimited() {
+		return true
+	}
+	switch e.Code {
+	case 1, 2, 1200: // Unknown error, service unavailable, temporary send failure
+		return true
+	case 0:
+		return e.StatusCode >= 500 // Not a Graph error object, e.g. a proxy in front of Graph
+	}
+	return false
+}
+
+// AsGraphError returns the GraphError in err's chain, if any
+func AsGraphError(err error) (*GraphError, bool) {
+	var graphErr *GraphError
+	if errors.As(err, &graphErr) {
+		return graphErr, true
+	}
+	return nil, false
+}
+
+// IsRateLimited reports whether err is a Graph API rate limit error
+func IsRateLimited(err error) bool {
+	graphErr, ok := AsGraphError(err)
+	return ok && graphErr.RateLimited()
+}
+
+// IsTokenInvalid reports whether err means the access token can't be used anymore
+func IsTokenInvalid(err error) bool {
+	graphErr, ok := AsGraphError(err)
+	return ok && graphErr.TokenInvalid()
+}
+
+// parseError builds a GraphError from an error response. Besides the usual
+// {"error": {...}} object it understands the flat format of the Instagram
+// Login endpoints ({"error_type", "code", "error_message"}).
+func parseError(status int, body []byte, header http.Header) *GraphError {
+	graphErr := &GraphError{StatusCode: status, RetryAfter: retryAfter(header)}
+
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+		// Instagram Login
+		ErrorType    string `json:"error_type"`
+		ErrorMessage string `json:"error_message"`
+		Code         int    `json:"code"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		graphErr.Body = string(body)
+		return graphErr
+	}
+
+	var object struct {
+		Message      string `json:"message"`
+		Type         string `json:"type"`
+		Code         int    `json:"code"`
+		Subcode      int    `json:"error_subcode"`
+		FBTraceID    string `json:"fbtrace_id"`
+		IsTransient  bool   `json:"is_transient"`
+		UserTitle    string `json:"error_user_title"`
+		UserMessage  string `json:"error_user_msg"`
+		ErrorMessage string `json:"error_message"` // Instagram Login nests this one too
+		ErrorType    string `json:"error_type"`
+	}
+	switch {
+	case len(payload.Error) > 0 && json.Unmarshal(payload.Error, &object) == nil:
+		graphErr.Message = object.Message
+		graphErr.Type = object.Type
+		graphErr.Code = object.Code
+		graphErr.Subcode = object.Subcode
+		graphErr.FBTraceID = object.FBTraceID
+		graphErr.IsTransient = object.IsTransient
+		graphErr.UserTitle = object.UserTitle
+		graphErr.UserMessage = object.UserMessage
+		if graphErr.Message == "" {
+			graphErr.Message = object.ErrorMessage
+		}
+		if graphErr.Type == "" {
+			graphErr.Type = object.ErrorType
+		}
+	case len(payload.Error) > 0:
+		// OAuth style: {"error": "invalid_request", "error_description": "..."}
+		graphErr.Message = strings.Trim(string(payload.Error), `"`)
+	case payload.ErrorMessage != "":
+		graphErr.Message = payload.ErrorMessage
+		graphErr.Type = payload.ErrorType
+		graphErr.Code = payload.Code
+	default:
+		graphErr.Body = string(body)
+	}
+	return graphErr
+}
+
+// hasErrorObject reports whether a 2xx body still carries an error object, which
+// some endpoints (notably oauth/access_token) return
+func hasErrorObject(body []byte) bool {
+	var payload struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	return json.Unmarshal(body, &payload) == nil && payload.Error != nil && payload.Error.Message != ""
+}
+
+// retryAfter reads how long to wait before retrying from Retry-After or the
+// business use case usage header (estimated_time_to_regain_access, in minutes)
+func retryAfter(header http.Header) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	var usage map[string][]struct {
+		EstimatedTimeToRegainAccess int `json:"estimated_time_to_regain_access"`
+	}
+	if value := header.Get("X-Business-Use-Case-Usage"); value != "" && json.Unmarshal([]byte(value), &usage) == nil {
+		minutes := 0
+		for _, entries := range usage {
+			for _, entry := range entries {
+				if entry.EstimatedTimeToRegainAccess > minutes {
+					minutes = entry.EstimatedTimeToRegainAccess
+				}
+			}
+		}
+		return time.Duration(minutes) * time.Minute
+	}
+	return 0
+}
@@ -0,0 +1,471 @@
+// graphtest/server.go
+//
+// Package graphtest is an in-memory fake of the Graph API for offline tests.
+//
+// The server keeps a small object graph: objects by ID with their fields, and
+// edges (a page's posts, a post's comments) as lists of object IDs. Without any
+// setup it already behaves like Graph for the calls the services make:
+//
+//	GET    /{id}                 the object, or error 100/33 if unknown
+//	GET    /{id}/{edge}          {"data": [...]} with the objects on the edge
+//	POST   /{id}/messages        records a SentMessage (to a user or a comment)
+//	POST   /{id}/{edge}          creates an object from the form or JSON body
+//	POST   /{id}                 updates the object's fields
+//	DELETE /{id}                 deletes the object
+//	POST   /oauth/access_token   returns "long-lived-" + the exchanged token
+//
+// Every request needs an access token except oauth/access_token. Fail queues
+// Graph errors for a route and Handle replaces a route entirely.
+package graphtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"graph"
+)
+
+// Request is a request the server received
+type Request struct {
+	Method  string
+	Version string // e.g. v23.0, "" when unversioned
+	Path    string // Without version or leading slash, e.g. "123/messages"
+	Token   string // From the Authorization header or the access_token parameter
+	Query   url.Values
+	Form    url.Values             // URL-encoded or multipart fields
+	Files   map[string]string      // Multipart file field -> file name
+	JSON    map[string]interface{} // Decoded JSON body
+	Header  http.Header
+}
+
+// Param returns a parameter from the query, form or JSON body, in that order
+func (r Request) Param(name string) string {
+	if value := r.Query.Get(name); value != "" {
+		return value
+	}
+	if value := r.Form.Get(name); value != "" {
+		return value
+	}
+	if value, ok := r.JSON[name]; ok {
+		if s, ok := value.(string); ok {
+			return s
+		}
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+	return ""
+}
+
+// SentMessage is a message sent through the Send API
+type SentMessage struct {
+	PageID        string // "me" when sent without a page ID
+	RecipientID   string
+	CommentID     string // Set for private replies to a comment
+	Text          string
+	MessagingType string
+	Token         string
+}
+
+// HandlerFunc answers a request with a status and a body encoded as JSON
+type HandlerFunc func(Request) (status int, body interface{})
+
+// Server is a fake Graph API server
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]map[string]interface{}
+	edges    map[string][]string // "id/edge" -> object IDs
+	messages []SentMessage
+	requests []Request
+	failures map[string][]graph.GraphError // "METHOD path" -> queued errors
+	handlers map[string]HandlerFunc        // "METHOD path pattern" -> handler
+	nextID   int
+}
+
+var versionPattern = regexp.MustCompile(`^v\d+\.\d+$`)
+
+// NewServer starts a fake Graph server; Close it when done
+func NewServer() *Server {
+	s := &Server{
+		objects:  make(map[string]map[string]interface{}),
+		edges:    make(map[string][]string),
+		failures: make(map[string][]graph.GraphError),
+		handlers: make(map[string]HandlerFunc),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns a graph client for the server that retries without waiting
+func (s *Server) Client() *graph.Client {
+	return graph.New(graph.Config{
+		BaseURL:      s.URL,
+		HTTPClient:   s.Server.Client(),
+		RetryBackoff: time.Millisecond,
+	})
+}
+
+// SetObject creates or replaces an object; fields get "id" if they lack it
+func (s *Server) SetObject(id string, fields map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[id] = withID(id, fields)
+}
+
+// Object returns a copy of an object's fields
+func (s *Server) Object(id string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[id]
+	if !ok {
+		return nil, false
+	}
+	return copyFields(object), true
+}
+
+// AddToEdge adds an object to an edge, e.g. a post to "{page}/posts", and
+// returns its ID (generated as parent_N when fields has no "id")
+func (s *Server) AddToEdge(parentID, edge string, fields map[string]interface{}) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addToEdge(parentID, edge, fields)
+}
+
+func (s *Server) addToEdge(parentID, edge string, fields map[string]interface{}) string {
+	id, _ := fields["id"].(string)
+	if id == "" {
+		s.nextID++
+		id = fmt.Sprintf("%s_%d", parentID, s.nextID)
+	}
+	s.objects[id] = withID(id, fields)
+	key := parentID + "/" + edge
+	s.edges[key] = append(s.edges[key], id)
+	return id
+}
+
+// Edge returns copies of the objects on an edge, oldest first
+func (s *Server) Edge(parentID, edge string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.edge(parentID, edge)
+}
+
+func (s *Server) edge(parentID, edge string) []map[string]interface{} {
+	objects := []map[string]interface{}{}
+	for _, id := range s.edges[parentID+"/"+edge] {
+		if object, ok := s.objects[id]; ok {
+			objects = append(objects, copyFields(object))
+		}
+	}
+	return objects
+}
+
+// SentMessages returns the messages sent through the Send API
+func (s *Server) SentMessages() []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SentMessage(nil), s.messages...)
+}
+
+// Requests returns every request received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Fail makes the next calls to method and path (without version, e.g.
+// "me/messages") fail with errs, one per call. StatusCode defaults to 400.
+func (s *Server) Fail(method, path string, errs ...graph.GraphError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := method + " " + strings.Trim(path, "/")
+	s.failures[key] = append(s.failures[key], errs...)
+}
+
+// Handle answers method and pattern with fn instead of the built-in behavior.
+// In pattern, a "*" segment matches any single path segment ("*/insights").
+func (s *Server) Handle(method, pattern string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method+" "+strings.Trim(pattern, "/")] = fn
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRequest(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody(graph.GraphError{Message: err.Error(), Type: "GraphMethodException", Code: 100}))
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	key := req.Method + " " + req.Path
+	if queued := s.failures[key]; len(queued) > 0 {
+		s.failures[key] = queued[1:]
+		s.mu.Unlock()
+		failure := queued[0]
+		status := failure.StatusCode
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		if failure.RetryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(failure.RetryAfter.Seconds())))
+		}
+		writeJSON(w, status, errorBody(failure))
+		return
+	}
+	handler := s.matchHandler(req.Method, req.Path)
+	s.mu.Unlock()
+
+	if handler != nil {
+		status, body := handler(req)
+		writeJSON(w, status, body)
+		return
+	}
+
+	status, body := s.builtin(req)
+	writeJSON(w, status, body)
+}
+
+// matchHandler finds a handler registered with Handle; the caller holds s.mu
+func (s *Server) matchHandler(method, path string) HandlerFunc {
+	if fn, ok := s.handlers[method+" "+path]; ok {
+		return fn
+	}
+	segments := strings.Split(path, "/")
+	for key, fn := range s.handlers {
+		patternMethod, pattern, _ := strings.Cut(key, " ")
+		if patternMethod != method {
+			continue
+		}
+		parts := strings.Split(pattern, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		match := true
+		for i, part := range parts {
+			if part != "*" && part != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return fn
+		}
+	}
+	return nil
+}
+
+// builtin implements the default object graph behavior
+func (s *Server) builtin(req Request) (int, interface{}) {
+	if req.Path == "oauth/access_token" {
+		return s.accessToken(req)
+	}
+	if req.Token == "" {
+		return http.StatusBadRequest, errorBody(graph.GraphError{
+			Message: "An access token is required to request this resource.", Type: "OAuthException", Code: 104})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, edge, hasEdge := strings.Cut(req.Path, "/")
+	switch {
+	case req.Method == http.MethodGet && !hasEdge:
+		object, ok := s.objects[id]
+		if !ok {
+			return unknownObject(id)
+		}
+		return http.StatusOK, object
+	case req.Method == http.MethodGet:
+		return http.StatusOK, map[string]interface{}{"data": s.edge(id, edge)}
+	case req.Method == http.MethodPost && edge == "messages":
+		return s.sendMessage(id, req)
+	case req.Method == http.MethodPost && hasEdge:
+		newID := s.addToEdge(id, edge, requestFields(req))
+		return http.StatusOK, map[string]string{"id": newID}
+	case req.Method == http.MethodPost:
+		object, ok := s.objects[id]
+		if !ok {
+			return unknownObject(id)
+		}
+		for name, value := range requestFields(req) {
+			object[name] = value
+		}
+		return http.StatusOK, map[string]bool{"success": true}
+	case req.Method == http.MethodDelete && !hasEdge:
+		if _, ok := s.objects[id]; !ok {
+			return unknownObject(id)
+		}
+		delete(s.objects, id)
+		return http.StatusOK, map[string]bool{"success": true}
+	}
+	return http.StatusBadRequest, errorBody(graph.GraphError{
+		Message: "Unsupported " + strings.ToLower(req.Method) + " request.", Type: "GraphMethodException", Code: 100})
+}
+
+// sendMessage records a Send API call; the caller holds s.mu
+func (s *Server) sendMessage(pageID string, req Request) (int, interface{}) {
+	var body struct {
+		Recipient struct {
+			ID        string `json:"id"`
+			CommentID string `json:"comment_id"`
+		} `json:"recipient"`
+		Message struct {
+			Text string `json:"text"`
+		} `json:"message"`
+		MessagingType string `json:"messaging_type"`
+	}
+	data, _ := json.Marshal(req.JSON)
+	if err := json.Unmarshal(data, &body); err != nil || (body.Recipient.ID == "" && body.Recipient.CommentID == "") {
+		return http.StatusBadRequest, errorBody(graph.GraphError{
+			Message: "(#100) The parameter recipient is required", Type: "OAuthException", Code: 100})
+	}
+
+	s.messages = append(s.messages, SentMessage{
+		PageID:        pageID,
+		RecipientID:   body.Recipient.ID,
+		CommentID:     body.Recipient.CommentID,
+		Text:          body.Message.Text,
+		MessagingType: body.MessagingType,
+		Token:         req.Token,
+	})
+	return http.StatusOK, map[string]string{
+		"recipient_id": body.Recipient.ID,
+		"message_id":   fmt.Sprintf("m_%d", len(s.messages)),
+	}
+}
+
+// accessToken answers the token exchange endpoints
+func (s *Server) accessToken(req Request) (int, interface{}) {
+	token := req.Param("fb_exchange_token")
+	if token == "" {
+		token = req.Param("code")
+	}
+	if token == "" {
+		return http.StatusBadRequest, errorBody(graph.GraphError{
+			Message: "Missing token or code to exchange", Type: "OAuthException", Code: 100})
+	}
+	return http.StatusOK, map[string]interface{}{
+		"access_token": "long-lived-" + token,
+		"token_type":   "bearer",
+		"expires_in":   5184000,
+	}
+}
+
+func parseRequest(r *http.Request) (Request, error) {
+	path := strings.Trim(r.URL.Path, "/")
+	req := Request{Method: r.Method, Path: path, Query: r.URL.Query(), Form: url.Values{}, Header: r.Header}
+	if first, rest, _ := strings.Cut(path, "/"); versionPattern.MatchString(first) {
+		req.Version, req.Path = first, rest
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&req.JSON); err != nil && err != io.EOF {
+			return req, fmt.Errorf("invalid JSON body: %v", err)
+		}
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return req, err
+		}
+		req.Form = r.PostForm
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return req, err
+		}
+		req.Form = url.Values(r.MultipartForm.Value)
+		req.Files = make(map[string]string)
+		for field, headers := range r.MultipartForm.File {
+			if len(headers) > 0 {
+				req.Files[field] = headers[0].Filename
+			}
+		}
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") || strings.HasPrefix(auth, "OAuth ") {
+		_, req.Token, _ = strings.Cut(auth, " ")
+	} else {
+		req.Token = req.Param("access_token")
+	}
+	return req, nil
+}
+
+// requestFields returns the fields of a create or update request
+func requestFields(req Request) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for name, values := range req.Form {
+		if name != "access_token" && len(values) > 0 {
+			fields[name] = values[0]
+		}
+	}
+	for name, value := range req.JSON {
+		fields[name] = value
+	}
+	for field, filename := range req.Files {
+		fields[field] = filename
+	}
+	return fields
+}
+
+func unknownObject(id string) (int, interface{}) {
+	return http.StatusBadRequest, errorBody(graph.GraphError{
+		Message: fmt.Sprintf("Unsupported get request. Object with ID '%s' does not exist, cannot be loaded due to missing permissions, or does not support this operation.", id),
+		Type:    "GraphMethodException",
+		Code:    100,
+		Subcode: 33,
+	})
+}
+
+func errorBody(e graph.GraphError) map[string]interface{} {
+	object := map[string]interface{}{
+		"message":    e.Message,
+		"type":       e.Type,
+		"code":       e.Code,
+		"fbtrace_id": "fake-trace",
+	}
+	if e.Subcode != 0 {
+		object["error_subcode"] = e.Subcode
+	}
+	if e.IsTransient {
+		object["is_transient"] = true
+	}
+	if e.FBTraceID != "" {
+		object["fbtrace_id"] = e.FBTraceID
+	}
+	return map[string]interface{}{"error": object}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func withID(id string, fields map[string]interface{}) map[string]interface{} {
+	object := copyFields(fields)
+	if _, ok := object["id"]; !ok {
+		object["id"] = id
+	}
+	return object
+}
+
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+	object := make(map[string]interface{}, len(fields)+1)
+	for name, value := range fields {
+		object[name] = value
+	}
+	return object
+}
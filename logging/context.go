@@ -0,0 +1,123 @@
+// context.go
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// fields are the correlation IDs carried through context
+type fields struct {
+	RequestID string
+	PageID    string
+	ThreadID  string
+	ClientID  string
+}
+
+type fieldsKey struct{}
+
+func fromContext(ctx context.Context) fields {
+	if ctx == nil {
+		return fields{}
+	}
+	f, _ := ctx.Value(fieldsKey{}).(fields)
+	return f
+}
+
+func withFields(ctx context.Context, update func(*fields)) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	f := fromContext(ctx)
+	update(&f)
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// WithRequestID returns a context whose log records carry request_id
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return withFields(ctx, func(f *fields) { f.RequestID = requestID })
+}
+
+// WithConversation returns a context whose log records carry page_id and
+// thread_id. Empty values leave the existing ones unchanged.
+func WithConversation(ctx context.Context, pageID, threadID string) context.Context {
+	return withFields(ctx, func(f *fields) {
+		if pageID != "" {
+			f.PageID = pageID
+		}
+		if threadID != "" {
+			f.ThreadID = threadID
+		}
+	})
+}
+
+// WithClientID returns a context whose log records carry client_id
+func WithClientID(ctx context.Context, clientID string) context.Context {
+	return withFields(ctx, func(f *fields) { f.ClientID = clientID })
+}
+
+// RequestID returns the request ID stored in ctx, or ""
+func RequestID(ctx context.Context) string {
+	return fromContext(ctx).RequestID
+}
+
+// NewRequestID returns a short random ID for correlating logs
+func NewRequestID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(b)
+}
+
+// contextAttrs are the extra attribute sources registered with AddContextAttrs
+var contextAttrs []func(ctx context.Context) []slog.Attr
+
+// AddContextAttrs registers a function whose attributes are added to every
+// record logged with a context, e.g. trace and span IDs. Call it during startup,
+// before other goroutines log.
+func AddContextAttrs(fn func(ctx context.Context) []slog.Attr) {
+	contextAttrs = append(contextAttrs, fn)
+}
+
+// contextHandler adds the context fields to every record
+type contextHandler struct {
+	inner slog.Handler
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return h.inner.Enabled(ctx, lvl)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	f := fromContext(ctx)
+	for _, attr := range []slog.Attr{
+		slog.String("request_id", f.RequestID),
+		slog.String("page_id", f.PageID),
+		slog.String("thread_id", f.ThreadID),
+		slog.String("client_id", f.ClientID),
+	} {
+		if attr.Value.String() != "" {
+			record.AddAttrs(attr)
+		}
+	}
+	if ctx != nil {
+		for _, attrs := range contextAttrs {
+			record.AddAttrs(attrs(ctx)...)
+		}
+	}
+	if redaction().Tokens {
+		record.Message = ScrubSecrets(record.Message)
+	}
+	return h.inner.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{inner: h.inner.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{inner: h.inner.WithGroup(name)}
+}
@@ -0,0 +1,140 @@
+// logging.go
+//
+// Package logging is the structured logger shared by message-router (including
+// its oauth package) and client-manager. It is built on log/slog and adds:
+//
+//   - JSON (default) or text output selected with LOG_FORMAT
+//   - LOG_LEVEL filtering (ERROR, WARN, INFO, DEBUG)
+//   - request_id, page_id, thread_id and client_id carried through context,
+//     plus any attributes registered with AddContextAttrs (e.g. trace_id)
+//   - redaction of tokens, message bodies and user names (LOG_REDACT)
+//
+// Setup also routes the standard library log package through the same handler,
+// so existing log.Printf calls become structured INFO records.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Config configures the shared logger
+type Config struct {
+	Service string     // Added to every record as "service"
+	Level   slog.Level // Minimum level
+	Format  string     // "json" or "text"
+	Redact  RedactConfig
+	Output  io.Writer // Defaults to os.Stderr
+}
+
+// level is shared by every logger created with Setup so Enabled is cheap
+var level = new(slog.LevelVar)
+
+// ConfigFromEnv reads LOG_LEVEL, LOG_FORMAT and LOG_REDACT
+func ConfigFromEnv(service string) Config {
+	return Config{
+		Service: service,
+		Level:   ParseLevel(os.Getenv("LOG_LEVEL")),
+		Format:  strings.ToLower(strings.TrimSpace(os.Getenv("LOG_FORMAT"))),
+		Redact:  ParseRedact(os.Getenv("LOG_REDACT")),
+	}
+}
+
+// ParseLevel maps ERROR, WARN/WARNING, INFO and DEBUG to slog levels (default INFO)
+func ParseLevel(value string) slog.Level {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "ERROR":
+		return slog.LevelError
+	case "WARN", "WARNING":
+		return slog.LevelWarn
+	case "DEBUG":
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Setup builds the logger, makes it the slog and log package default and returns it
+func Setup(cfg Config) *slog.Logger {
+	if cfg.Output == nil {
+		cfg.Output = os.Stderr
+	}
+	level.Set(cfg.Level)
+	setRedaction(cfg.Redact)
+
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(cfg.Output, options)
+	} else {
+		handler = slog.NewJSONHandler(cfg.Output, options)
+	}
+
+	logger := slog.New(&contextHandler{inner: handler})
+	if cfg.Service != "" {
+		logger = logger.With("service", cfg.Service)
+	}
+	slog.SetDefault(logger)
+	return logger
+}
+
+// SetupFromEnv is Setup(ConfigFromEnv(service))
+func SetupFromEnv(service string) *slog.Logger {
+	return Setup(ConfigFromEnv(service))
+}
+
+// Enabled reports whether records at lvl are written, to skip building
+// expensive debug output
+func Enabled(lvl slog.Level) bool {
+	return lvl >= level.Level()
+}
+
+// =============================================================================
+// PRINTF BRIDGE - Existing format-string call sites, with context fields
+// =============================================================================
+
+// Errorf logs a formatted message at ERROR with the fields stored in ctx
+func Errorf(ctx context.Context, format string, args ...any) {
+	Logf(ctx, slog.LevelError, format, args...)
+}
+
+// Warnf logs a formatted message at WARN with the fields stored in ctx
+func Warnf(ctx context.Context, format string, args ...any) {
+	Logf(ctx, slog.LevelWarn, format, args...)
+}
+
+// Infof logs a formatted message at INFO with the fields stored in ctx
+func Infof(ctx context.Context, format string, args ...any) {
+	Logf(ctx, slog.LevelInfo, format, args...)
+}
+
+// Debugf logs a formatted message at DEBUG with the fields stored in ctx
+func Debugf(ctx context.Context, format string, args ...any) {
+	Logf(ctx, slog.LevelDebug, format, args...)
+}
+
+// Logf formats and logs a message. The legacy "[%s] " request ID prefix is
+// moved into the request_id attribute so old call sites stay correlated.
+func Logf(ctx context.Context, lvl slog.Level, format string, args ...any) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := slog.Default()
+	if !logger.Enabled(ctx, lvl) {
+		return
+	}
+
+	if rest, ok := strings.CutPrefix(format, "[%s] "); ok && len(args) > 0 {
+		if requestID, isString := args[0].(string); isString {
+			if RequestID(ctx) == "" {
+				ctx = WithRequestID(ctx, requestID)
+			}
+			format, args = rest, args[1:]
+		}
+	}
+	logger.Log(ctx, lvl, fmt.Sprintf(format, args...))
+}
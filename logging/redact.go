@@ -0,0 +1,186 @@
+// redact.go
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// =============================================================================
+// REDACTION - Tokens, message bodies and user names never reach the logs
+// =============================================================================
+
+// RedactConfig selects what is redacted. All three are on by default.
+type RedactConfig struct {
+	Tokens   bool // Access tokens, API keys, secrets, Authorization headers
+	Messages bool // Message texts and LLM answers
+	Names    bool // User and profile names
+}
+
+// ParseRedact parses LOG_REDACT: "all" (default), "none", or a comma-separated
+// subset of tokens, messages and names
+func ParseRedact(value string) RedactConfig {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "", "all":
+		return RedactConfig{Tokens: true, Messages: true, Names: true}
+	case "none":
+		return RedactConfig{}
+	}
+
+	var cfg RedactConfig
+	for _, part := range strings.Split(value, ",") {
+		switch strings.TrimSpace(part) {
+		case "tokens":
+			cfg.Tokens = true
+		case "messages":
+			cfg.Messages = true
+		case "names":
+			cfg.Names = true
+		}
+	}
+	return cfg
+}
+
+var currentRedaction atomic.Pointer[RedactConfig]
+
+func setRedaction(cfg RedactConfig) {
+	currentRedaction.Store(&cfg)
+}
+
+// redaction returns the active config; everything is redacted before Setup
+func redaction() RedactConfig {
+	if cfg := currentRedaction.Load(); cfg != nil {
+		return *cfg
+	}
+	return RedactConfig{Tokens: true, Messages: true, Names: true}
+}
+
+const redacted = "[REDACTED]"
+
+// Text returns a message body for logging: its length only when message
+// redaction is on, otherwise the text itself
+func Text(text string) string {
+	if !redaction().Messages {
+		return text
+	}
+	return fmt.Sprintf("[redacted %d chars]", utf8.RuneCountInString(text))
+}
+
+// Name returns a user name for logging: its first letter only when name
+// redaction is on
+func Name(name string) string {
+	if !redaction().Names || name == "" {
+		return name
+	}
+	first, _ := utf8.DecodeRuneInString(name)
+	return string(first) + "***"
+}
+
+// Secret returns a credential for logging: always masked unless token
+// redaction is explicitly off
+func Secret(secret string) string {
+	if !redaction().Tokens || secret == "" {
+		return secret
+	}
+	return redacted
+}
+
+// secretPatterns find credentials inside free-form text such as Graph API URLs,
+// JSON bodies and error messages
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b(access_token|input_token|fb_exchange_token|client_secret|appsecret_proof|api_key|apikey|password|verify_token|hub\.verify_token)=[^&\s"']+`), "${1}=" + redacted},
+	{regexp.MustCompile(`(^|[?&\s])code=[^&\s"',]*[^0-9&\s"',][^&\s"',]*`), "${1}code=" + redacted}, // OAuth authorization codes, not numeric status codes
+	{regexp.MustCompile(`(?i)"(access_token|token|api_key|apikey|client_secret|password|secret)"\s*:\s*"[^"]*"`), `"${1}":"` + redacted + `"`},
+	{regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`), "Bearer " + redacted},
+	{regexp.MustCompile(`\b(EAA|IGQ|IGA)[A-Za-z0-9_-]{20,}`), redacted},   // Facebook/Instagram tokens
+	{regexp.MustCompile(`\b(fw|sk|app)[-_][A-Za-z0-9_-]{16,}`), redacted}, // Fireworks, OpenAI, Dify keys
+}
+
+// ScrubSecrets masks credentials found in text
+func ScrubSecrets(text string) string {
+	for _, secret := range secretPatterns {
+		text = secret.pattern.ReplaceAllString(text, secret.replacement)
+	}
+	return text
+}
+
+// sensitiveHeaders are masked by RedactHeaders
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Client-Id":         true,
+	"X-Hub-Signature":     true,
+	"X-Hub-Signature-256": true,
+	"X-Api-Key":           true,
+}
+
+// RedactHeaders flattens headers for logging with credentials masked
+func RedactHeaders(header http.Header) map[string]string {
+	flat := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			value = Secret(value)
+		}
+		flat[name] = value
+	}
+	return flat
+}
+
+// RedactQuery flattens query parameters for logging with credentials masked
+func RedactQuery(query url.Values) map[string]string {
+	flat := make(map[string]string, len(query))
+	for name, values := range query {
+		value := strings.Join(values, ", ")
+		if isSecretKey(name) {
+			value = Secret(value)
+		}
+		flat[name] = value
+	}
+	return flat
+}
+
+// isSecretKey reports attribute and parameter names that hold credentials
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, marker := range []string{"token", "secret", "password", "api_key", "apikey", "authorization", "signature"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return key == "code" || key == "x-client-id"
+}
+
+// redactAttr is the slog ReplaceAttr hook: attributes are redacted by name,
+// and every string value is scrubbed for embedded credentials
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() != slog.KindString {
+		return attr
+	}
+	value := attr.Value.String()
+
+	switch key := strings.ToLower(attr.Key); {
+	case isSecretKey(key):
+		return slog.String(attr.Key, Secret(value))
+	case key == "text" || key == "message_text" || key == "body" || key == "answer":
+		return slog.String(attr.Key, Text(value))
+	case key == "user_name" || key == "username" || key == "name":
+		return slog.String(attr.Key, Name(value))
+	}
+
+	if redaction().Tokens {
+		return slog.String(attr.Key, ScrubSecrets(value))
+	}
+	return attr
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"crypto/subtle"
 	"net/http"
+	"os"
 	"strings"
+
+	"logging"
 )
 
 // AuthMiddleware handles authentication for content management APIs
 type AuthMiddleware struct {
-	db *sql.DB
+	pages PageStore
 }
 
 // NewAuthMiddleware creates a new authentication middleware
-func NewAuthMiddleware(db *sql.DB) *AuthMiddleware {
-	return &AuthMiddleware{db: db}
+func NewAuthMiddleware(pages PageStore) *AuthMiddleware {
+	return &AuthMiddleware{pages: pages}
 }
 
 // ClientAuthMiddleware validates client authentication and adds client ID to request headers
@@ -43,7 +47,7 @@ func (am *AuthMiddleware) ClientAuthMiddleware(next http.HandlerFunc) http.Handl
 		}
 		
 		// Validate that the client ID exists and has active pages
-		if !am.validateClientID(clientID) {
+		if !am.validateClientID(r.Context(), clientID) {
 			LogError("❌ Authentication failed: Invalid client ID %s", clientID)
 			http.Error(w, "Invalid authentication", http.StatusUnauthorized)
 			return
@@ -51,8 +55,9 @@ func (am *AuthMiddleware) ClientAuthMiddleware(next http.HandlerFunc) http.Handl
 		
 		LogDebug("✅ Client %s authenticated successfully", clientID)
 		
-		// Add client ID to request headers for use by handlers
+		// Add client ID to request headers for use by handlers, and to the log context
 		r.Header.Set("X-Client-ID", clientID)
+		r = r.WithContext(logging.WithClientID(r.Context(), clientID))
 		
 		// Continue to next handler
 		next.ServeHTTP(w, r)
@@ -60,21 +65,34 @@ func (am *AuthMiddleware) ClientAuthMiddleware(next http.HandlerFunc) http.Handl
 }
 
 // validateClientID checks if the client ID exists and has active pages
-func (am *AuthMiddleware) validateClientID(clientID string) bool {
-	query := `
-		SELECT COUNT(*) 
-		FROM social_pages 
-		WHERE client_id = $1 AND status = 'active'
-	`
-	
-	var count int
-	err := am.db.QueryRow(query, clientID).Scan(&count)
+func (am *AuthMiddleware) validateClientID(ctx context.Context, clientID string) bool {
+	pages, err := am.pages.ClientPages(ctx, clientID)
 	if err != nil {
 		LogError("Error validating client ID %s: %v", clientID, err)
 		return false
 	}
 	
-	return count > 0
+	return len(pages) > 0
+}
+
+// AdminAuthMiddleware protects operator endpoints that affect every client. It
+// requires "Authorization: Bearer <ADMIN_API_TOKEN>"; without the variable set
+// the endpoints are disabled.
+func AdminAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	token := os.Getenv("ADMIN_API_TOKEN")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "Admin API disabled", http.StatusForbidden)
+			return
+		}
+		expected := []byte("Bearer " + token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			LogError("❌ Admin authentication failed for %s", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
 }
 
 // CORSMiddleware handles CORS for content management APIs
@@ -0,0 +1,282 @@
+// changes.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"logging"
+)
+
+// =============================================================================
+// CHANGES WEBHOOKS - entry[].changes[] events (comments, mentions, ...)
+// =============================================================================
+//
+// Subscriptions other than messaging arrive as entry[].changes[] with a field
+// name and a field-specific value. Instagram also delivers direct messages in
+// this format on some API versions ("messages" field); those are folded into
+// entry.Messaging before processing, so they go through the normal pipeline.
+
+// Results recorded for each change in logs, metrics and the "change" span event
+const (
+	ChangeHandled   = "handled"   // A typed handler processed the change
+	ChangeIgnored   = "ignored"   // Parsed but nothing to do (e.g. the account's own comment)
+	ChangeUnhandled = "unhandled" // No handler for the field
+	ChangeInvalid   = "invalid"   // The value could not be parsed
+)
+
+// changeOutcome is what a handler did with a change
+type changeOutcome struct {
+	Result  string
+	Action  string // What the bot did about it, e.g. the comment action
+	Rule    string // Moderation rule that hid the comment, if any
+	ID      string // Comment the change is about, if any
+	From    string
+	MediaID string
+}
+
+// changeHandler processes one change of an entry
+type changeHandler func(p *MessageProcessor, ctx context.Context, event FacebookEvent, entry EntryData, change ChangeEntry) (changeOutcome, error)
+
+// changeHandlers dispatches changes by field
+var changeHandlers = map[string]changeHandler{
+	"comments":      (*MessageProcessor).handleCommentChange,
+	"live_comments": (*MessageProcessor).handleCommentChange,
+	"feed":          (*MessageProcessor).handleFeedChange,
+	"mentions":      (*MessageProcessor).handleMentionChange,
+}
+
+// CommentChange is a new comment on one of the account's posts or live videos
+type CommentChange struct {
+	Platform  string // facebook or instagram
+	PageID    string // Page or Instagram account the webhook entry belongs to
+	Field     string // Webhook field the comment arrived on (comments, live_comments, feed)
+	CommentID string
+	ParentID  string // Set when the comment is a reply to another comment
+	MediaID   string // Instagram media or Facebook post
+	MediaType string // media_product_type on Instagram (FEED, REELS, AD, ...)
+	Text      string
+	FromID    string
+	FromName  string // Instagram username or Facebook name
+}
+
+// MentionChange is an @mention of the account in a comment or caption
+type MentionChange struct {
+	Platform  string
+	PageID    string
+	MediaID   string
+	CommentID string // Empty when the mention is in the media caption
+}
+
+// instagramCommentValue is the value of Instagram comments and live_comments changes
+type instagramCommentValue struct {
+	ID       string        `json:"id"`
+	ParentID string        `json:"parent_id"`
+	Text     string        `json:"text"`
+	From     InstagramUser `json:"from"`
+	Media    struct {
+		ID               string `json:"id"`
+		MediaProductType string `json:"media_product_type"`
+	} `json:"media"`
+}
+
+// facebookFeedValue is the value of Facebook feed changes; comments have item "comment"
+type facebookFeedValue struct {
+	Item      string `json:"item"` // comment, status, photo, reaction, ...
+	Verb      string `json:"verb"` // add, edited, remove, hide, unhide
+	PostID    string `json:"post_id"`
+	CommentID string `json:"comment_id"`
+	ParentID  string `json:"parent_id"` // The post for top-level comments
+	Message   string `json:"message"`
+	From      struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"from"`
+}
+
+// foldMessageChanges moves "messages" changes into entry.Messaging. The value
+// is either a single messaging event or the older list of Instagram messages.
+func foldMessageChanges(event *FacebookEvent) {
+	for i := range event.Entry {
+		entry := &event.Entry[i]
+		remaining := entry.Changes[:0]
+		for _, change := range entry.Changes {
+			if change.Field != "messages" {
+				remaining = append(remaining, change)
+				continue
+			}
+
+			var value struct {
+				MessagingEntry
+				Messages []InstagramMessage `json:"messages"`
+			}
+			if err := json.Unmarshal(change.Value, &value); err != nil {
+				remaining = append(remaining, change) // Reported as invalid by processChanges
+				continue
+			}
+			if value.Message != nil || value.Delivery != nil {
+				entry.Messaging = append(entry.Messaging, value.MessagingEntry)
+			}
+			for _, im := range value.Messages {
+				if im.From == nil {
+					continue
+				}
+				msg := MessagingEntry{Message: &MessageData{Mid: im.ID, Text: im.Text}}
+				msg.Sender.ID = im.From.ID
+				msg.Recipient.ID = entry.ID
+				entry.Messaging = append(entry.Messaging, msg)
+			}
+		}
+		entry.Changes = remaining
+	}
+}
+
+// processChanges dispatches the changes of one entry to their handlers
+func (p *MessageProcessor) processChanges(ctx context.Context, event FacebookEvent, entry EntryData) {
+	for _, change := range entry.Changes {
+		outcome := changeOutcome{Result: ChangeUnhandled}
+		var err error
+		if handler, ok := changeHandlers[change.Field]; ok {
+			outcome, err = handler(p, ctx, event, entry, change)
+		} else if change.Field == "messages" {
+			outcome, err = changeOutcome{Result: ChangeInvalid}, fmt.Errorf("unrecognized messages value")
+		}
+
+		switch {
+		case err != nil:
+			LogErrorCtx(ctx, "Change %s on %s not processed: %v", change.Field, entry.ID, err)
+		case outcome.Result == ChangeUnhandled:
+			LogDebugCtx(ctx, "No handler for change field %s on %s", change.Field, entry.ID)
+		}
+		recordChange(ctx, event.Object, change.Field, outcome)
+	}
+}
+
+// parseCommentChange reads a comments or live_comments change value
+func parseCommentChange(event FacebookEvent, entry EntryData, change ChangeEntry) (CommentChange, error) {
+	var value instagramCommentValue
+	if err := json.Unmarshal(change.Value, &value); err != nil {
+		return CommentChange{}, fmt.Errorf("invalid %s value: %w", change.Field, err)
+	}
+	if value.ID == "" {
+		return CommentChange{}, fmt.Errorf("%s value without comment id", change.Field)
+	}
+	return CommentChange{
+		Platform:  platformName(event.Object),
+		PageID:    entry.ID,
+		Field:     change.Field,
+		CommentID: value.ID,
+		ParentID:  value.ParentID,
+		MediaID:   value.Media.ID,
+		MediaType: value.Media.MediaProductType,
+		Text:      value.Text,
+		FromID:    value.From.ID,
+		FromName:  value.From.Username,
+	}, nil
+}
+
+// handleCommentChange handles a new Instagram comment or live video comment
+func (p *MessageProcessor) handleCommentChange(ctx context.Context, event FacebookEvent, entry EntryData, change ChangeEntry) (changeOutcome, error) {
+	comment, err := parseCommentChange(event, entry, change)
+	if err != nil {
+		return changeOutcome{Result: ChangeInvalid}, err
+	}
+	return p.processComment(ctx, comment)
+}
+
+// parseFeedChange reads a feed change value. newComment is false for feed
+// changes other than new comments; the comment then only has its IDs.
+func parseFeedChange(event FacebookEvent, entry EntryData, change ChangeEntry) (comment CommentChange, newComment bool, err error) {
+	var value facebookFeedValue
+	if err := json.Unmarshal(change.Value, &value); err != nil {
+		return CommentChange{}, false, fmt.Errorf("invalid feed value: %w", err)
+	}
+	comment = CommentChange{
+		Platform:  platformName(event.Object),
+		PageID:    entry.ID,
+		Field:     change.Field,
+		CommentID: value.CommentID,
+		MediaID:   value.PostID,
+		Text:      value.Message,
+		FromID:    value.From.ID,
+		FromName:  value.From.Name,
+	}
+	if value.Item != "comment" || value.Verb != "add" {
+		return comment, false, nil
+	}
+	if value.CommentID == "" {
+		return CommentChange{}, false, fmt.Errorf("feed comment without comment_id")
+	}
+	// Top-level comments have the post as their parent
+	if value.ParentID != value.PostID {
+		comment.ParentID = value.ParentID
+	}
+	return comment, true, nil
+}
+
+// handleFeedChange handles Facebook page feed changes; only new comments are
+// processed, posts, reactions, edits and removals are ignored
+func (p *MessageProcessor) handleFeedChange(ctx context.Context, event FacebookEvent, entry EntryData, change ChangeEntry) (changeOutcome, error) {
+	comment, newComment, err := parseFeedChange(event, entry, change)
+	if err != nil {
+		return changeOutcome{Result: ChangeInvalid}, err
+	}
+	if !newComment {
+		LogDebugCtx(ctx, "Ignoring feed change on %s", entry.ID)
+		return changeOutcome{Result: ChangeIgnored, ID: comment.CommentID, From: comment.FromID, MediaID: comment.MediaID}, nil
+	}
+	return p.processComment(ctx, comment)
+}
+
+// processComment runs a new comment through the page's comment automation
+func (p *MessageProcessor) processComment(ctx context.Context, comment CommentChange) (changeOutcome, error) {
+	ctx = logging.WithConversation(ctx, comment.PageID, comment.FromID)
+	outcome := changeOutcome{Result: ChangeHandled, ID: comment.CommentID, From: comment.FromID, MediaID: comment.MediaID}
+
+	// Replies posted by the account itself come back as comments too
+	if comment.FromID == comment.PageID {
+		LogDebugCtx(ctx, "Ignoring own comment %s", comment.CommentID)
+		outcome.Result = ChangeIgnored
+		return outcome, nil
+	}
+
+	LogInfoCtx(ctx, "💬 New %s comment %s on media %s: %q",
+		comment.Platform, comment.CommentID, comment.MediaID, logging.Text(comment.Text))
+
+	action, rule := p.automateComment(ctx, comment)
+	outcome.Action, outcome.Rule = string(action), rule
+	return outcome, nil
+}
+
+// handleMentionChange handles an @mention of the account
+func (p *MessageProcessor) handleMentionChange(ctx context.Context, event FacebookEvent, entry EntryData, change ChangeEntry) (changeOutcome, error) {
+	var value struct {
+		MediaID   string `json:"media_id"`
+		CommentID string `json:"comment_id"`
+	}
+	if err := json.Unmarshal(change.Value, &value); err != nil {
+		return changeOutcome{Result: ChangeInvalid}, fmt.Errorf("invalid mentions value: %w", err)
+	}
+	mention := MentionChange{
+		Platform:  platformName(event.Object),
+		PageID:    entry.ID,
+		MediaID:   value.MediaID,
+		CommentID: value.CommentID,
+	}
+
+	if mention.CommentID != "" {
+		LogInfoCtx(ctx, "📣 %s mentioned in comment %s on media %s", mention.PageID, mention.CommentID, mention.MediaID)
+	} else {
+		LogInfoCtx(ctx, "📣 %s mentioned in the caption of media %s", mention.PageID, mention.MediaID)
+	}
+	return changeOutcome{Result: ChangeHandled, ID: mention.CommentID, MediaID: mention.MediaID}, nil
+}
+
+// platformName maps the webhook object to the platform stored on pages
+func platformName(object string) string {
+	if object == "page" {
+		return "facebook"
+	}
+	return object
+}
@@ -0,0 +1,230 @@
+// changes_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testInstagramID = "17841400000000001"
+
+// messagingEntry builds a MessagingEntry with a text message
+func messagingEntry(senderID, recipientID, mid, text string) MessagingEntry {
+	msg := MessagingEntry{Message: &MessageData{Mid: mid, Text: text}}
+	msg.Sender.ID = senderID
+	msg.Recipient.ID = recipientID
+	return msg
+}
+
+func TestFoldMessageChanges(t *testing.T) {
+	tests := []struct {
+		name          string
+		changes       []ChangeEntry
+		wantMessaging []MessagingEntry
+		wantChanges   []string // Fields of the changes left in the entry
+	}{
+		{
+			name: "single messaging event",
+			changes: []ChangeEntry{{Field: "messages", Value: json.RawMessage(
+				`{"sender":{"id":"u1"},"recipient":{"id":"` + testInstagramID + `"},"message":{"mid":"m1","text":"Hola"}}`)}},
+			wantMessaging: []MessagingEntry{messagingEntry("u1", testInstagramID, "m1", "Hola")},
+		},
+		{
+			name: "list of Instagram messages",
+			changes: []ChangeEntry{{Field: "messages", Value: json.RawMessage(
+				`{"messages":[{"id":"m1","from":{"id":"u1","username":"ana"},"text":"Hola"},{"id":"m2","text":"no sender"},{"id":"m3","from":{"id":"u2"},"text":"Precio?"}]}`)}},
+			wantMessaging: []MessagingEntry{
+				messagingEntry("u1", testInstagramID, "m1", "Hola"),
+				messagingEntry("u2", testInstagramID, "m3", "Precio?"),
+			},
+		},
+		{
+			name:        "invalid value is kept for processChanges",
+			changes:     []ChangeEntry{{Field: "messages", Value: json.RawMessage(`["not", "an", "object"]`)}},
+			wantChanges: []string{"messages"},
+		},
+		{
+			name: "other fields are kept",
+			changes: []ChangeEntry{
+				{Field: "comments", Value: json.RawMessage(`{"id":"c1"}`)},
+				{Field: "messages", Value: json.RawMessage(`{"sender":{"id":"u1"},"recipient":{"id":"` + testInstagramID + `"},"message":{"mid":"m1","text":"Hola"}}`)},
+				{Field: "mentions", Value: json.RawMessage(`{"media_id":"md1"}`)},
+			},
+			wantMessaging: []MessagingEntry{messagingEntry("u1", testInstagramID, "m1", "Hola")},
+			wantChanges:   []string{"comments", "mentions"},
+		},
+		{
+			name:    "value without a message or messages",
+			changes: []ChangeEntry{{Field: "messages", Value: json.RawMessage(`{"sender":{"id":"u1"}}`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := FacebookEvent{Object: "instagram", Entry: []EntryData{{ID: testInstagramID, Changes: tt.changes}}}
+			foldMessageChanges(&event)
+
+			entry := event.Entry[0]
+			if !reflect.DeepEqual(entry.Messaging, tt.wantMessaging) {
+				t.Errorf("Messaging = %+v, want %+v", entry.Messaging, tt.wantMessaging)
+			}
+			var fields []string
+			for _, change := range entry.Changes {
+				fields = append(fields, change.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantChanges) {
+				t.Errorf("remaining changes = %q, want %q", fields, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func TestParseCommentChange(t *testing.T) {
+	event := FacebookEvent{Object: "instagram"}
+	entry := EntryData{ID: testInstagramID}
+	tests := []struct {
+		name    string
+		change  ChangeEntry
+		want    CommentChange
+		wantErr bool
+	}{
+		{
+			name: "comment",
+			change: ChangeEntry{Field: "comments", Value: json.RawMessage(
+				`{"id":"c1","text":"¿Precio?","from":{"id":"u1","username":"ana"},"media":{"id":"md1","media_product_type":"REELS"}}`)},
+			want: CommentChange{Platform: "instagram", PageID: testInstagramID, Field: "comments", CommentID: "c1",
+				MediaID: "md1", MediaType: "REELS", Text: "¿Precio?", FromID: "u1", FromName: "ana"},
+		},
+		{
+			name: "reply",
+			change: ChangeEntry{Field: "comments", Value: json.RawMessage(
+				`{"id":"c2","parent_id":"c1","text":"Gracias","from":{"id":"u2"},"media":{"id":"md1"}}`)},
+			want: CommentChange{Platform: "instagram", PageID: testInstagramID, Field: "comments", CommentID: "c2",
+				ParentID: "c1", MediaID: "md1", Text: "Gracias", FromID: "u2"},
+		},
+		{
+			name: "live comment",
+			change: ChangeEntry{Field: "live_comments", Value: json.RawMessage(
+				`{"id":"c3","text":"Hola","from":{"id":"u1","username":"ana"},"media":{"id":"live1","media_product_type":"LIVE"}}`)},
+			want: CommentChange{Platform: "instagram", PageID: testInstagramID, Field: "live_comments", CommentID: "c3",
+				MediaID: "live1", MediaType: "LIVE", Text: "Hola", FromID: "u1", FromName: "ana"},
+		},
+		{name: "without id", change: ChangeEntry{Field: "comments", Value: json.RawMessage(`{"text":"Hola"}`)}, wantErr: true},
+		{name: "invalid value", change: ChangeEntry{Field: "comments", Value: json.RawMessage(`"c1"`)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCommentChange(event, entry, tt.change)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCommentChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCommentChange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedChange(t *testing.T) {
+	event := FacebookEvent{Object: "page"}
+	entry := EntryData{ID: testPageID}
+	tests := []struct {
+		name       string
+		value      string
+		want       CommentChange
+		newComment bool
+		wantErr    bool
+	}{
+		{
+			name:  "top-level comment",
+			value: `{"item":"comment","verb":"add","comment_id":"p1_c1","post_id":"p1","parent_id":"p1","message":"¿Precio?","from":{"id":"u1","name":"Ana"}}`,
+			want: CommentChange{Platform: "facebook", PageID: testPageID, Field: "feed", CommentID: "p1_c1",
+				MediaID: "p1", Text: "¿Precio?", FromID: "u1", FromName: "Ana"},
+			newComment: true,
+		},
+		{
+			name:  "reply to a comment",
+			value: `{"item":"comment","verb":"add","comment_id":"p1_c2","post_id":"p1","parent_id":"p1_c1","message":"Gracias","from":{"id":"u2","name":"Luis"}}`,
+			want: CommentChange{Platform: "facebook", PageID: testPageID, Field: "feed", CommentID: "p1_c2",
+				ParentID: "p1_c1", MediaID: "p1", Text: "Gracias", FromID: "u2", FromName: "Luis"},
+			newComment: true,
+		},
+		{
+			name:  "edited comment",
+			value: `{"item":"comment","verb":"edited","comment_id":"p1_c1","post_id":"p1","parent_id":"p1","message":"Editado","from":{"id":"u1"}}`,
+			want: CommentChange{Platform: "facebook", PageID: testPageID, Field: "feed", CommentID: "p1_c1",
+				MediaID: "p1", Text: "Editado", FromID: "u1"},
+		},
+		{
+			name:  "new post",
+			value: `{"item":"status","verb":"add","post_id":"p2","message":"Nueva colección","from":{"id":"` + testPageID + `"}}`,
+			want:  CommentChange{Platform: "facebook", PageID: testPageID, Field: "feed", MediaID: "p2", Text: "Nueva colección", FromID: testPageID},
+		},
+		{name: "reaction", value: `{"item":"reaction","verb":"add","post_id":"p1","from":{"id":"u1"}}`,
+			want: CommentChange{Platform: "facebook", PageID: testPageID, Field: "feed", MediaID: "p1", FromID: "u1"}},
+		{name: "comment without comment_id", value: `{"item":"comment","verb":"add","post_id":"p1"}`, wantErr: true},
+		{name: "invalid value", value: `[1, 2]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, newComment, err := parseFeedChange(event, entry, ChangeEntry{Field: "feed", Value: json.RawMessage(tt.value)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFeedChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || newComment != tt.newComment {
+				t.Errorf("parseFeedChange() = %+v, %v, want %+v, %v", got, newComment, tt.want, tt.newComment)
+			}
+		})
+	}
+}
+
+func TestHandleFeedChange(t *testing.T) {
+	memory := NewMemoryStore()
+	memory.AddPage(MemoryPage{PageID: testPageID, Platform: "facebook", ClientID: "client-1", AccessToken: "page-token"})
+	p := NewMessageProcessor(memory.Stores())
+	event := FacebookEvent{Object: "page"}
+	entry := EntryData{ID: testPageID}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    changeOutcome
+		wantErr bool
+	}{
+		{
+			name:  "new comment",
+			value: `{"item":"comment","verb":"add","comment_id":"p1_c1","post_id":"p1","parent_id":"p1","message":"¿Precio?","from":{"id":"u1"}}`,
+			want:  changeOutcome{Result: ChangeHandled, Action: string(CommentActionIgnore), ID: "p1_c1", From: "u1", MediaID: "p1"},
+		},
+		{
+			name:  "the page's own comment",
+			value: `{"item":"comment","verb":"add","comment_id":"p1_c2","post_id":"p1","parent_id":"p1_c1","message":"Gracias","from":{"id":"` + testPageID + `"}}`,
+			want:  changeOutcome{Result: ChangeIgnored, ID: "p1_c2", From: testPageID, MediaID: "p1"},
+		},
+		{
+			name:  "removed comment",
+			value: `{"item":"comment","verb":"remove","comment_id":"p1_c1","post_id":"p1","from":{"id":"u1"}}`,
+			want:  changeOutcome{Result: ChangeIgnored, ID: "p1_c1", From: "u1", MediaID: "p1"},
+		},
+		{
+			name:  "new post",
+			value: `{"item":"status","verb":"add","post_id":"p2","from":{"id":"` + testPageID + `"}}`,
+			want:  changeOutcome{Result: ChangeIgnored, From: testPageID, MediaID: "p2"},
+		},
+		{name: "comment without comment_id", value: `{"item":"comment","verb":"add","post_id":"p1"}`,
+			want: changeOutcome{Result: ChangeInvalid}, wantErr: true},
+		{name: "invalid value", value: `"p1_c1"`, want: changeOutcome{Result: ChangeInvalid}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.handleFeedChange(context.Background(), event, entry, ChangeEntry{Field: "feed", Value: json.RawMessage(tt.value)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handleFeedChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("handleFeedChange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
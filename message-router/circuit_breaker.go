@@ -0,0 +1,151 @@
+// circuit_breaker.go
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// CIRCUIT BREAKER - Stops hammering an upstream that is already failing
+// =============================================================================
+
+// ErrCircuitOpen is returned when a call is rejected because the breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // Calls flow normally
+	circuitOpen                         // Calls are rejected until the cooldown expires
+	circuitHalfOpen                     // One trial call is allowed through
+)
+
+// CircuitBreaker is a minimal consecutive-failure breaker.
+//
+// After `threshold` consecutive failures the breaker opens and rejects calls for
+// `cooldown`. Once the cooldown expires a single trial call is let through
+// (half-open); its outcome either closes the breaker again or re-opens it.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     circuitState
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+}
+
+// NewCircuitBreaker creates a closed breaker
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may proceed right now
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		// Cooldown expired - let a single trial call through
+		cb.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// A trial call is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+// RecordSuccess closes the breaker and resets the failure count
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state = circuitClosed
+	cb.failures = 0
+}
+
+// RecordFailure counts a failure and opens the breaker when the threshold is hit.
+// Returns true if this failure opened the breaker.
+func (cb *CircuitBreaker) RecordFailure() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.state == circuitHalfOpen || cb.failures >= cb.threshold {
+		wasOpen := cb.state == circuitOpen
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+		return !wasOpen
+	}
+	return false
+}
+
+// Abandon ends a call without an outcome, e.g. when the caller's context was
+// cancelled. A half-open breaker goes back to open with its cooldown already
+// expired, so the next call becomes the trial.
+func (cb *CircuitBreaker) Abandon() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+	}
+}
+
+// IsOpen reports whether calls are currently being rejected
+func (cb *CircuitBreaker) IsOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state == circuitOpen && time.Since(cb.openedAt) < cb.cooldown
+}
+
+// circuitBreakerRegistry hands out one breaker per key (e.g. per Dify API key)
+type circuitBreakerRegistry struct {
+	mu        sync.Mutex
+	breakers  map[string]*CircuitBreaker
+	threshold int
+	cooldown  time.Duration
+}
+
+func newCircuitBreakerRegistry(threshold int, cooldown time.Duration) *circuitBreakerRegistry {
+	return &circuitBreakerRegistry{
+		breakers:  make(map[string]*CircuitBreaker),
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Get returns the breaker for key, creating it on first use
+func (r *circuitBreakerRegistry) Get(key string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cb, ok := r.breakers[key]
+	if !ok {
+		cb = NewCircuitBreaker(r.threshold, r.cooldown)
+		r.breakers[key] = cb
+	}
+	return cb
+}
+
+// OpenCount returns how many keys currently have an open circuit
+func (r *circuitBreakerRegistry) OpenCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	open := 0
+	for _, cb := range r.breakers {
+		if cb.IsOpen() {
+			open++
+		}
+	}
+	return open
+}
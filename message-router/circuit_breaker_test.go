@@ -0,0 +1,74 @@
+// circuit_breaker_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCircuitBreaker walks the breaker through its states. "expire" moves the
+// open time back past the cooldown instead of sleeping.
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string // allow, deny, success, failure, opens, abandon, expire
+		open  bool
+	}{
+		{"closed allows calls", []string{"allow", "allow"}, false},
+		{"failures below threshold", []string{"failure", "failure", "allow"}, false},
+		{"threshold opens", []string{"failure", "failure", "opens", "deny"}, true},
+		{"success resets the count", []string{"failure", "failure", "success", "failure", "failure", "allow"}, false},
+		{"cooldown lets one trial through", []string{"failure", "failure", "opens", "expire", "allow", "deny"}, false},
+		{"successful trial closes", []string{"failure", "failure", "opens", "expire", "allow", "success", "allow", "allow"}, false},
+		{"failed trial reopens", []string{"failure", "failure", "opens", "expire", "allow", "opens", "deny"}, true},
+		{"abandoned trial allows the next one", []string{"failure", "failure", "opens", "expire", "allow", "abandon", "allow", "deny"}, false},
+		{"abandon keeps a closed breaker closed", []string{"failure", "abandon", "failure", "allow"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewCircuitBreaker(3, time.Minute)
+			for i, step := range tt.steps {
+				switch step {
+				case "allow", "deny":
+					if got := cb.Allow(); got != (step == "allow") {
+						t.Fatalf("step %d: Allow() = %v, want %v", i, got, step == "allow")
+					}
+				case "success":
+					cb.RecordSuccess()
+				case "failure":
+					if cb.RecordFailure() {
+						t.Fatalf("step %d: RecordFailure() opened the breaker too early", i)
+					}
+				case "opens":
+					if !cb.RecordFailure() {
+						t.Fatalf("step %d: RecordFailure() did not open the breaker", i)
+					}
+				case "abandon":
+					cb.Abandon()
+				case "expire":
+					cb.mu.Lock()
+					cb.openedAt = time.Now().Add(-time.Minute)
+					cb.mu.Unlock()
+				}
+			}
+			if got := cb.IsOpen(); got != tt.open {
+				t.Errorf("IsOpen() = %v, want %v", got, tt.open)
+			}
+		})
+	}
+}
+
+// TestCircuitBreakerRegistry checks that keys get their own breakers
+func TestCircuitBreakerRegistry(t *testing.T) {
+	registry := newCircuitBreakerRegistry(1, time.Minute)
+	if registry.Get("a") != registry.Get("a") {
+		t.Fatal("Get() returned a new breaker for the same key")
+	}
+	registry.Get("a").RecordFailure()
+	if !registry.Get("b").Allow() {
+		t.Error("a failure on key a opened key b")
+	}
+	if got := registry.OpenCount(); got != 1 {
+		t.Errorf("OpenCount() = %d, want 1", got)
+	}
+}
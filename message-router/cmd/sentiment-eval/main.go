@@ -0,0 +1,321 @@
+// cmd/sentiment-eval/main.go
+//
+// sentiment-eval runs a labeled dataset through one or two sentiment classifier
+// configurations and reports accuracy, a confusion matrix, per-class
+// precision/recall and cost.
+//
+//	go run ./cmd/sentiment-eval -dataset sentiment/testdata/eval.jsonl -a lexicon
+//	go run ./cmd/sentiment-eval -dataset cases.csv \
+//	    -a "fireworks,name=v1" \
+//	    -b "fireworks,name=v2,prompt=prompts/v2.txt" -record recordings
+//	go run ./cmd/sentiment-eval -dataset cases.csv -a "fireworks,name=v1" -replay recordings
+//
+// A variant is "provider[,key=value...]" with provider fireworks, openai or
+// lexicon and keys name, model, prompt (file), intents (a|b|c), base_url,
+// prompt_price and completion_price (USD per million tokens).
+// -record saves every response to <dir>/<name>.jsonl; -replay serves them back
+// without network access, so CI can run the evaluation.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"message-router/sentiment"
+
+	"github.com/joho/godotenv"
+)
+
+// variant is one classifier configuration under evaluation
+type variant struct {
+	Name                      string
+	Provider                  string
+	Model                     string
+	BaseURL                   string
+	Options                   sentiment.Options
+	PromptPricePerMillion     float64
+	CompletionPricePerMillion float64
+}
+
+func main() {
+	datasetPath := flag.String("dataset", "", "Labeled dataset (.jsonl or .csv)")
+	specA := flag.String("a", "fireworks", "First classifier variant")
+	specB := flag.String("b", "", "Optional second variant to compare side by side")
+	recordDir := flag.String("record", "", "Directory to record responses to")
+	replayDir := flag.String("replay", "", "Directory to replay recorded responses from (no network)")
+	timeout := flag.Duration("timeout", 15*time.Second, "Timeout per classification")
+	delay := flag.Duration("delay", 0, "Pause between calls, e.g. 500ms to respect rate limits")
+	showMistakes := flag.Int("mistakes", 10, "Misclassified cases to print per variant")
+	jsonOutput := flag.Bool("json", false, "Print the reports as JSON")
+	minAccuracy := flag.Float64("min-accuracy", 0, "Exit with status 1 if a variant's status accuracy is below this")
+	flag.Parse()
+
+	if *datasetPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *recordDir != "" && *replayDir != "" {
+		log.Fatal("❌ -record and -replay can't be used together")
+	}
+	_ = godotenv.Load(".env") // API keys, as for the service
+
+	cases, err := sentiment.LoadEvalDataset(*datasetPath)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	log.Printf("📊 Loaded %d labeled cases from %s", len(cases), *datasetPath)
+
+	specs := []string{*specA}
+	if *specB != "" {
+		specs = append(specs, *specB)
+	}
+
+	var reports []*sentiment.EvalReport
+	for i, spec := range specs {
+		v, err := parseVariant(spec, string(rune('a'+i)))
+		if err != nil {
+			log.Fatalf("❌ Invalid variant %q: %v", spec, err)
+		}
+
+		classifier, closeFn, err := buildClassifier(v, *recordDir, *replayDir)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		log.Printf("🔄 Evaluating %s (%s)", v.Name, classifier.Name())
+
+		report := sentiment.RunEval(context.Background(), v.Name, sentiment.NewChain(*timeout, classifier), cases,
+			sentiment.EvalOptions{
+				Options:                   v.Options,
+				PromptPricePerMillion:     v.PromptPricePerMillion,
+				CompletionPricePerMillion: v.CompletionPricePerMillion,
+				Delay:                     *delay,
+			})
+		closeFn()
+		reports = append(reports, report)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(reports)
+	} else {
+		for _, report := range reports {
+			printReport(report, *showMistakes)
+		}
+		if len(reports) == 2 {
+			printComparison(reports[0], reports[1])
+		}
+	}
+
+	for _, report := range reports {
+		if report.StatusAccuracy < *minAccuracy {
+			log.Printf("❌ %s status accuracy %.3f is below %.3f", report.Name, report.StatusAccuracy, *minAccuracy)
+			os.Exit(1)
+		}
+	}
+}
+
+// parseVariant parses "provider[,key=value...]"
+func parseVariant(spec, defaultName string) (variant, error) {
+	parts := strings.Split(spec, ",")
+	v := variant{Name: defaultName, Provider: strings.TrimSpace(parts[0])}
+	if v.Provider == "fireworks" {
+		v.PromptPricePerMillion, v.CompletionPricePerMillion = 0.20, 0.20
+	}
+
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return v, fmt.Errorf("expected key=value, got %q", part)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "name":
+			v.Name = value
+		case "model":
+			v.Model = value
+		case "base_url":
+			v.BaseURL = value
+		case "prompt":
+			prompt, err := os.ReadFile(value)
+			if err != nil {
+				return v, fmt.Errorf("error reading prompt: %v", err)
+			}
+			v.Options.Prompt = strings.TrimSpace(string(prompt))
+		case "intents":
+			v.Options.Intents = strings.Split(value, "|")
+		case "prompt_price", "completion_price":
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return v, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			if key == "prompt_price" {
+				v.PromptPricePerMillion = price
+			} else {
+				v.CompletionPricePerMillion = price
+			}
+		default:
+			return v, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return v, nil
+}
+
+// buildClassifier creates the variant's classifier, wrapped for recording or
+// replaced by its recordings when replaying
+func buildClassifier(v variant, recordDir, replayDir string) (sentiment.Classifier, func(), error) {
+	noop := func() {}
+	if replayDir != "" {
+		replay, err := sentiment.NewReplayClassifier(recordingPath(replayDir, v.Name))
+		return replay, noop, err
+	}
+
+	var classifier sentiment.Classifier
+	switch v.Provider {
+	case "fireworks":
+		config := sentiment.DefaultConfig()
+		config.FireworksKey = os.Getenv("FIREWORKS_API_KEY")
+		if v.Model != "" {
+			config.Model = v.Model
+		}
+		classifier = sentiment.New(config)
+	case "openai":
+		baseURL := v.BaseURL
+		if baseURL == "" {
+			baseURL = os.Getenv("SENTIMENT_OPENAI_BASE_URL")
+		}
+		if baseURL == "" {
+			baseURL = "https://api.openai.com/v1"
+		}
+		model := v.Model
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		classifier = sentiment.NewOpenAICompatible(sentiment.OpenAIConfig{
+			BaseURL: baseURL,
+			APIKey:  os.Getenv("SENTIMENT_OPENAI_API_KEY"),
+			Model:   model,
+		})
+	case "lexicon":
+		classifier = sentiment.NewLexicon()
+	default:
+		return nil, noop, fmt.Errorf("unknown provider %q", v.Provider)
+	}
+
+	if recordDir == "" {
+		return classifier, noop, nil
+	}
+	recorder, err := sentiment.NewRecordingClassifier(classifier, recordingPath(recordDir, v.Name))
+	if err != nil {
+		return nil, noop, err
+	}
+	return recorder, func() { recorder.Close() }, nil
+}
+
+func recordingPath(dir, name string) string {
+	return strings.TrimRight(dir, "/") + "/" + name + ".jsonl"
+}
+
+func printReport(report *sentiment.EvalReport, showMistakes int) {
+	fmt.Printf("\n=== %s ===\n", report.Name)
+	fmt.Printf("Cases: %d  Errors: %d  Status accuracy: %.3f", report.Cases, report.Errors, report.StatusAccuracy)
+	if report.IntentCases > 0 {
+		fmt.Printf("  Intent accuracy: %.3f (%d labeled)", report.IntentAccuracy, report.IntentCases)
+	}
+	fmt.Printf("\nTokens: %d prompt / %d completion  Cost: $%.5f  Avg latency: %v\n",
+		report.PromptTokens, report.CompletionTokens, report.CostUSD, report.AvgLatency.Round(time.Millisecond))
+
+	fmt.Println("\nStatus confusion matrix (rows: expected, columns: predicted)")
+	printConfusion(report.StatusConfusion)
+
+	fmt.Println("\nStatus per class")
+	printClasses(report.StatusClasses)
+	if len(report.IntentClasses) > 0 {
+		fmt.Println("\nIntent per class")
+		printClasses(report.IntentClasses)
+	}
+
+	if showMistakes > 0 && len(report.Mistakes) > 0 {
+		fmt.Printf("\nMistakes (%d, showing up to %d)\n", len(report.Mistakes), showMistakes)
+		for i, mistake := range report.Mistakes {
+			if i == showMistakes {
+				break
+			}
+			fmt.Printf("  %-10s → %-10s %q\n", mistake.Expected, mistake.Predicted, mistake.Message)
+		}
+	}
+}
+
+func printConfusion(confusion map[string]map[string]int) {
+	labelSet := map[string]bool{}
+	for expected, row := range confusion {
+		labelSet[expected] = true
+		for predicted := range row {
+			labelSet[predicted] = true
+		}
+	}
+	labels := make([]string, 0, len(labelSet))
+	for label := range labelSet {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	fmt.Printf("  %-12s", "")
+	for _, label := range labels {
+		fmt.Printf(" %12s", label)
+	}
+	fmt.Println()
+	for _, expected := range labels {
+		if confusion[expected] == nil {
+			continue
+		}
+		fmt.Printf("  %-12s", expected)
+		for _, predicted := range labels {
+			fmt.Printf(" %12d", confusion[expected][predicted])
+		}
+		fmt.Println()
+	}
+}
+
+func printClasses(classes []sentiment.ClassReport) {
+	fmt.Printf("  %-12s %9s %9s %9s %9s\n", "class", "support", "precision", "recall", "f1")
+	for _, class := range classes {
+		fmt.Printf("  %-12s %9d %9.3f %9.3f %9.3f\n", class.Label, class.Support, class.Precision, class.Recall, class.F1)
+	}
+}
+
+func printComparison(a, b *sentiment.EvalReport) {
+	fmt.Printf("\n=== %s vs %s ===\n", a.Name, b.Name)
+	fmt.Printf("  %-22s %10s %10s %10s\n", "metric", a.Name, b.Name, "delta")
+	row := func(metric string, va, vb float64, format string) {
+		fmt.Printf("  %-22s %10s %10s %10s\n", metric,
+			fmt.Sprintf(format, va), fmt.Sprintf(format, vb), fmt.Sprintf("%+"+format[1:], vb-va))
+	}
+	row("status accuracy", a.StatusAccuracy, b.StatusAccuracy, "%.3f")
+	if a.IntentCases > 0 || b.IntentCases > 0 {
+		row("intent accuracy", a.IntentAccuracy, b.IntentAccuracy, "%.3f")
+	}
+	recallA, recallB := classRecall(a.StatusClasses), classRecall(b.StatusClasses)
+	for _, label := range []string{"general", "frustrated", "need_human"} {
+		row(label+" recall", recallA[label], recallB[label], "%.3f")
+	}
+	row("errors", float64(a.Errors), float64(b.Errors), "%.0f")
+	row("cost (USD)", a.CostUSD, b.CostUSD, "%.5f")
+	row("avg latency (ms)", float64(a.AvgLatency.Milliseconds()), float64(b.AvgLatency.Milliseconds()), "%.0f")
+}
+
+func classRecall(classes []sentiment.ClassReport) map[string]float64 {
+	recall := map[string]float64{}
+	for _, class := range classes {
+		recall[class.Label] = class.Recall
+	}
+	return recall
+}
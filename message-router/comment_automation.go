@@ -0,0 +1,298 @@
+// comment_automation.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"logging"
+	"message-router/sentiment"
+)
+
+// =============================================================================
+// COMMENT AUTOMATION - What the bot does with new comments on posts
+// =============================================================================
+//
+// New Facebook feed comments and Instagram comments are classified with the
+// sentiment classifier and handled according to the page's comment policy:
+// a public reply generated by Dify, a private reply that opens a DM thread,
+// hiding the comment, or flagging it in message_flags for a human. The page's
+// moderation rules (moderation.go) run first and can hide a comment outright.
+
+// CommentAction is what happens to a new comment
+type CommentAction string
+
+const (
+	CommentActionIgnore       CommentAction = "ignore"        // Leave the comment alone
+	CommentActionReply        CommentAction = "reply"         // Public reply with the Dify answer
+	CommentActionPrivateReply CommentAction = "private_reply" // Private message to the author, opening a DM thread
+	CommentActionHide         CommentAction = "hide"          // Hide the comment from everyone but its author
+	CommentActionFlag         CommentAction = "flag"          // Record it in message_flags for review
+)
+
+// parseCommentAction converts a configured action, defaulting to fallback for unknown values
+func parseCommentAction(value string, fallback CommentAction) CommentAction {
+	switch action := CommentAction(strings.ToLower(strings.TrimSpace(value))); action {
+	case CommentActionIgnore, CommentActionReply, CommentActionPrivateReply, CommentActionHide, CommentActionFlag:
+		return action
+	default:
+		return fallback
+	}
+}
+
+// CommentPolicy maps the classification of a comment to an action. The config
+// holds the defaults used for pages without a page_comment_policies row.
+type CommentPolicy struct {
+	Enabled          bool
+	GeneralAction    CommentAction
+	FrustratedAction CommentAction
+	NeedHumanAction  CommentAction // Used when need_human is above SentimentMinConfidence
+	SpamAction       CommentAction // Used when the spam intent is above SentimentMinConfidence
+	PrivateReply     string        // Text of private replies
+}
+
+// actionFor picks the action for a classified comment
+func (p CommentPolicy) actionFor(analysis *sentiment.Analysis) CommentAction {
+	confident := confidentAnalysis(analysis)
+
+	switch {
+	case analysis.Intent == "spam" && confident:
+		return p.SpamAction
+	case analysis.Status == "need_human" && confident:
+		return p.NeedHumanAction
+	case analysis.Status == "frustrated":
+		return p.FrustratedAction
+	default:
+		return p.GeneralAction
+	}
+}
+
+// confidentAnalysis reports whether a classification is above SentimentMinConfidence.
+// Confidence is 0 when the model returned no logprobs - trust the label then.
+func confidentAnalysis(analysis *sentiment.Analysis) bool {
+	return analysis.Confidence == 0 || analysis.Confidence >= config.SentimentMinConfidence
+}
+
+// loadCommentPolicy returns the page's comment policy, falling back to the configured defaults
+func (p *MessageProcessor) loadCommentPolicy(ctx context.Context, pageID, platform string) CommentPolicy {
+	policy, err := p.stores.Policies.CommentPolicy(ctx, pageID, platform, config.Comments)
+	if err != nil {
+		LogWarn("Could not load comment policy for page %s, using defaults: %v", pageID, err)
+		return config.Comments
+	}
+	return policy
+}
+
+// automateComment applies the page's moderation rules and comment policy to a
+// new comment. It returns the action taken and, for hidden comments, the rule
+// that hid it; failures fall back to flagging the comment.
+func (p *MessageProcessor) automateComment(ctx context.Context, comment CommentChange) (CommentAction, string) {
+	if strings.TrimSpace(comment.Text) == "" {
+		return CommentActionIgnore, "" // Stickers, photos and GIFs
+	}
+	policy := p.loadCommentPolicy(ctx, comment.PageID, comment.Platform)
+	rules := p.loadModerationRules(ctx, comment.PageID, comment.Platform)
+	if !policy.Enabled && !rules.Enabled {
+		LogDebugCtx(ctx, "Comment automation disabled for page %s", comment.PageID)
+		return CommentActionIgnore, ""
+	}
+
+	pageInfo, err := getPageInfo(ctx, p.stores.Pages, comment.PageID, comment.Platform)
+	if err != nil {
+		LogErrorCtx(ctx, "Cannot handle comment %s: %v", comment.CommentID, err)
+		return CommentActionIgnore, ""
+	}
+
+	// Keyword, link and profanity rules don't need the classifier
+	if rules.Enabled {
+		if hit := rules.checkText(comment.Text); hit != nil {
+			return p.hideComment(ctx, pageInfo, comment, *hit), hit.Rule
+		}
+	}
+	if !policy.Enabled && len(rules.SentimentLabels) == 0 {
+		return CommentActionIgnore, ""
+	}
+
+	analysis, err := p.classifyComment(ctx, comment)
+	if err != nil {
+		if !policy.Enabled {
+			LogWarnCtx(ctx, "Comment classification failed, not moderating comment %s: %v", comment.CommentID, err)
+			return CommentActionIgnore, ""
+		}
+		LogErrorCtx(ctx, "Comment classification failed, flagging comment %s: %v", comment.CommentID, err)
+		p.flagComment(ctx, comment, "comment_unclassified", err.Error(), CommentActionFlag)
+		return CommentActionFlag, ""
+	}
+	if rules.Enabled {
+		if hit := rules.checkSentiment(analysis); hit != nil {
+			return p.hideComment(ctx, pageInfo, comment, *hit), hit.Rule
+		}
+	}
+	if !policy.Enabled {
+		return CommentActionIgnore, ""
+	}
+
+	action := policy.actionFor(analysis)
+	LogInfoCtx(ctx, "💬 Comment %s classified %s/%s (%.2f) - action: %s",
+		comment.CommentID, analysis.Status, analysis.Intent, analysis.Confidence, action)
+
+	switch action {
+	case CommentActionReply:
+		err := p.replyToCommentWithDify(ctx, pageInfo, comment)
+		var blocked *commentBlockedError
+		if errors.As(err, &blocked) {
+			LogWarnCtx(ctx, "Not replying to comment %s (%s: %s), flagging it", comment.CommentID, blocked.Check, blocked.Reason)
+			p.flagComment(ctx, comment, "comment_"+blocked.Check, blocked.Reason, CommentActionFlag)
+			return CommentActionFlag, ""
+		}
+		if err != nil {
+			LogErrorCtx(ctx, "Auto-reply to comment %s failed, flagging it: %v", comment.CommentID, err)
+			p.flagComment(ctx, comment, "comment_reply_failed", err.Error(), CommentActionFlag)
+			return CommentActionFlag, ""
+		}
+	case CommentActionPrivateReply:
+		if err := sendCommentPrivateReply(ctx, pageInfo, comment.CommentID, policy.PrivateReply); err != nil {
+			LogErrorCtx(ctx, "Private reply to comment %s failed, flagging it: %v", comment.CommentID, err)
+			p.flagComment(ctx, comment, "comment_reply_failed", err.Error(), CommentActionFlag)
+			return CommentActionFlag, ""
+		}
+	case CommentActionHide:
+		hit := moderationHit{Rule: ModerationRulePolicy,
+			Reason: fmt.Sprintf("classified %s/%s (%.2f)", analysis.Status, analysis.Intent, analysis.Confidence)}
+		return p.hideComment(ctx, pageInfo, comment, hit), hit.Rule
+	case CommentActionFlag:
+		p.flagComment(ctx, comment, "comment_"+analysis.Status, analysis.Reasoning, CommentActionFlag)
+	}
+	return action, ""
+}
+
+// classifyComment runs the page's sentiment classifier on a comment
+func (p *MessageProcessor) classifyComment(ctx context.Context, comment CommentChange) (*sentiment.Analysis, error) {
+	start := time.Now()
+	opts := p.loadSentimentOptions(ctx, comment.PageID, comment.Platform)
+
+	ctx, span := startSpan(ctx, "sentiment.classify", attribute.String("comment.id", comment.CommentID))
+	analysis, err := sentimentClassifier.Classify(ctx, comment.Text, opts)
+	if err != nil {
+		endSpan(span, err)
+		sentimentDuration.WithLabelValues("failed", "false").Observe(time.Since(start).Seconds())
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.String("sentiment.provider", analysis.Provider),
+		attribute.Bool("sentiment.cached", analysis.Cached),
+		attribute.String("sentiment.status", analysis.Status),
+		attribute.String("sentiment.intent", analysis.Intent),
+		attribute.Float64("sentiment.confidence", analysis.Confidence),
+	)
+	span.End()
+	sentimentDuration.WithLabelValues(analysis.Provider, strconv.FormatBool(analysis.Cached)).Observe(time.Since(start).Seconds())
+
+	if analysis.PromptTokens+analysis.CompletionTokens > 0 {
+		p.recordLLMUsage(ctx, LLMUsage{
+			PageID:           comment.PageID,
+			Platform:         comment.Platform,
+			ThreadID:         comment.FromID,
+			Provider:         analysis.Provider,
+			Model:            analysis.Model,
+			Purpose:          "comment_sentiment",
+			PromptTokens:     analysis.PromptTokens,
+			CompletionTokens: analysis.CompletionTokens,
+		})
+	}
+	return analysis, nil
+}
+
+// commentBlockedError is returned when the guard or a quota stops a reply to a comment
+type commentBlockedError struct {
+	Check  string // A guard check or "quota_" and the exceeded limit
+	Reason string
+}
+
+func (e *commentBlockedError) Error() string {
+	return fmt.Sprintf("reply blocked by %s: %s", e.Check, e.Reason)
+}
+
+// replyToCommentWithDify asks the page's primary Dify app for an answer and
+// posts it as a public reply. The page's guard and quotas apply like for DMs,
+// and answers that fail the safety policy are withheld.
+func (p *MessageProcessor) replyToCommentWithDify(ctx context.Context, pageInfo *PageInfo, comment CommentChange) error {
+	requestID := logging.RequestID(ctx)
+	msgContext := &MessageContext{
+		Message:   MessagingEntry{Message: &MessageData{Mid: comment.CommentID, Text: comment.Text}},
+		PageInfo:  pageInfo,
+		UserName:  comment.FromName,
+		Platform:  comment.Platform,
+		RequestID: requestID,
+	}
+	msgContext.Message.Sender.ID = comment.FromID
+	msgContext.Message.Recipient.ID = comment.PageID
+
+	if verdict := p.checkMessageGuard(ctx, msgContext, requestID); verdict != nil {
+		return &commentBlockedError{Check: verdict.Check, Reason: verdict.Reason}
+	}
+	if decision := p.checkQuotas(ctx, msgContext, requestID); decision != nil {
+		return &commentBlockedError{Check: "quota_" + decision.Limit, Reason: "quota exceeded"}
+	}
+	defer p.releaseDailyReply(ctx, msgContext)
+
+	backend, _, err := p.getDifyBackends(ctx, comment.PageID, comment.Platform)
+	if err != nil {
+		return err
+	}
+
+	// Every comment starts a new Dify conversation; DMs keep their own context
+	response, err := sendToDifyWithRetry(ctx, backend, DifyRequest{
+		Inputs:       map[string]interface{}{},
+		Query:        comment.Text,
+		ResponseMode: "blocking",
+		User:         fmt.Sprintf("%s-%s", comment.PageID, comment.FromID),
+		Files:        []interface{}{},
+	})
+	if err != nil {
+		return err
+	}
+	p.recordLLMUsage(ctx, difyUsage(comment.PageID, comment.Platform, comment.FromID, backend, response))
+
+	answer := strings.TrimSpace(response.Answer)
+	if answer == "" {
+		return fmt.Errorf("empty Dify answer")
+	}
+	if policy := p.loadSafetyPolicy(ctx, comment.PageID, comment.Platform); policy.Enabled {
+		if violation := p.checkAnswerSafety(ctx, policy, comment.PageID, comment.Platform, comment.FromID, answer); violation != nil {
+			return fmt.Errorf("answer failed the safety check %s: %s", violation.Check, violation.Reason)
+		}
+	}
+
+	LogInfoCtx(ctx, "↩️ Replying to comment %s: %q", comment.CommentID, logging.Text(answer))
+	if _, err := postCommentReply(ctx, comment.Platform, comment.CommentID, pageInfo.AccessToken, answer); err != nil {
+		return err
+	}
+	p.countBotReply(msgContext)
+	return nil
+}
+
+// flagComment records a comment in message_flags for review. The media ID is
+// stored as the thread and the comment ID as the message mid.
+func (p *MessageProcessor) flagComment(ctx context.Context, comment CommentChange, check, reason string, action CommentAction) {
+	err := p.stores.Guard.FlagMessage(ctx, MessageFlag{
+		PageID:     comment.PageID,
+		Platform:   comment.Platform,
+		ThreadID:   comment.MediaID,
+		SenderID:   comment.FromID,
+		MessageMid: comment.CommentID,
+		Content:    comment.Text,
+		Check:      check,
+		Reason:     reason,
+		Action:     string(action),
+	})
+	if err != nil {
+		LogWarnCtx(ctx, "Could not flag comment: %v", err)
+	}
+}
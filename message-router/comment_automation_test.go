@@ -0,0 +1,188 @@
+// comment_automation_test.go
+package main
+
+import (
+	"context"
+	"testing"
+
+	"message-router/sentiment"
+)
+
+// staticClassifier returns the same analysis for every message
+type staticClassifier struct {
+	analysis sentiment.Analysis
+}
+
+func (c staticClassifier) Name() string { return "static" }
+
+func (c staticClassifier) Classify(ctx context.Context, message string, opts sentiment.Options) (*sentiment.Analysis, error) {
+	analysis := c.analysis
+	return &analysis, nil
+}
+
+func TestCommentPolicyActionFor(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+	config.SentimentMinConfidence = 0.7
+
+	policy := CommentPolicy{
+		Enabled:          true,
+		GeneralAction:    CommentActionReply,
+		FrustratedAction: CommentActionPrivateReply,
+		NeedHumanAction:  CommentActionFlag,
+		SpamAction:       CommentActionHide,
+	}
+	tests := []struct {
+		name     string
+		analysis sentiment.Analysis
+		want     CommentAction
+	}{
+		{"general", sentiment.Analysis{Status: "general", Intent: "pricing", Confidence: 0.9}, CommentActionReply},
+		{"frustrated", sentiment.Analysis{Status: "frustrated", Intent: "complaint", Confidence: 0.9}, CommentActionPrivateReply},
+		{"frustrated below confidence", sentiment.Analysis{Status: "frustrated", Intent: "complaint", Confidence: 0.4}, CommentActionPrivateReply},
+		{"need human", sentiment.Analysis{Status: "need_human", Intent: "support", Confidence: 0.8}, CommentActionFlag},
+		{"need human below confidence", sentiment.Analysis{Status: "need_human", Intent: "support", Confidence: 0.5}, CommentActionReply},
+		{"spam", sentiment.Analysis{Status: "general", Intent: "spam", Confidence: 0.95}, CommentActionHide},
+		{"spam below confidence", sentiment.Analysis{Status: "general", Intent: "spam", Confidence: 0.6}, CommentActionReply},
+		{"spam wins over need human", sentiment.Analysis{Status: "need_human", Intent: "spam", Confidence: 0.9}, CommentActionHide},
+		{"no logprobs", sentiment.Analysis{Status: "need_human", Intent: "support"}, CommentActionFlag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.actionFor(&tt.analysis); got != tt.want {
+				t.Errorf("actionFor(%s/%s %.2f) = %s, want %s",
+					tt.analysis.Status, tt.analysis.Intent, tt.analysis.Confidence, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutomateComment(t *testing.T) {
+	const commentID = "400000000000004_500000000000005"
+	replyPolicy := &CommentPolicy{Enabled: true, GeneralAction: CommentActionReply, FrustratedAction: CommentActionFlag}
+	enabled := true
+
+	tests := []struct {
+		name     string
+		text     string
+		page     MemoryPage
+		status   string // Classification returned for the comment
+		quotas   QuotaPolicy
+		want     CommentAction
+		wantRule string
+		wantFlag string // Check of the flag recorded for the comment, if any
+		replied  bool   // Whether a Dify answer was posted as a reply
+	}{
+		{name: "no text", text: " ", page: MemoryPage{Comments: replyPolicy}, want: CommentActionIgnore},
+		{name: "automation disabled", text: "¿Tienen envíos?", page: MemoryPage{}, want: CommentActionIgnore},
+		{
+			name: "moderation keyword",
+			text: "Esto es una estafa",
+			page: MemoryPage{Comments: replyPolicy, Moderation: &ModerationRules{Enabled: true, Keywords: []string{"estafa"}}},
+			want: CommentActionHide, wantRule: ModerationRuleKeyword,
+		},
+		{
+			name:   "moderation sentiment label",
+			text:   "Nunca me respondieron",
+			page:   MemoryPage{Moderation: &ModerationRules{Enabled: true, SentimentLabels: []string{"frustrated"}}},
+			status: "frustrated", want: CommentActionHide, wantRule: ModerationRuleSentiment,
+		},
+		{
+			name: "rules only, no label matched",
+			text: "¿Tienen envíos?",
+			page: MemoryPage{Moderation: &ModerationRules{Enabled: true, SentimentLabels: []string{"frustrated"}}},
+			want: CommentActionIgnore,
+		},
+		{name: "reply", text: "¿Tienen envíos?", page: MemoryPage{Comments: replyPolicy}, want: CommentActionReply, replied: true},
+		{
+			name: "flag",
+			text: "Nunca me respondieron",
+			page: MemoryPage{Comments: replyPolicy}, status: "frustrated",
+			want: CommentActionFlag, wantFlag: "comment_frustrated",
+		},
+		{
+			name: "reply blocked by the guard",
+			text: "Mira http://a.example y http://b.example",
+			page: MemoryPage{Comments: replyPolicy, Guard: PageGuardSettings{Enabled: &enabled}},
+			want: CommentActionFlag, wantFlag: "comment_url_spam",
+		},
+		{
+			name: "reply over the daily quota",
+			text: "¿Tienen envíos?",
+			page: MemoryPage{Comments: replyPolicy}, quotas: QuotaPolicy{PageDailyReplies: 1},
+			want: CommentActionFlag, wantFlag: "comment_quota_page_daily_replies",
+		},
+		{
+			name: "reply failing the safety policy",
+			text: "¿A qué hora abren?",
+			page: MemoryPage{Comments: replyPolicy, Safety: &SafetyPolicy{Enabled: true, ForbiddenTerms: []string{"abrimos"}}},
+			want: CommentActionFlag, wantFlag: "comment_reply_failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory, upstreams := setupFlowTest(t)
+			config.Guard = GuardConfig{MaxURLs: 1}
+			config.QuotaDefaults = tt.quotas
+			page := tt.page
+			page.PageID, page.Platform, page.ClientID = testPageID, "facebook", "client-1"
+			page.AccessToken, page.DifyAPIKey = "page-token", testDifyKey
+			memory.AddPage(page)
+			status := tt.status
+			if status == "" {
+				status = "general"
+			}
+			sentimentClassifier = staticClassifier{sentiment.Analysis{Status: status, Intent: "other", Provider: "static"}}
+			upstreams.graph.SetObject(commentID, map[string]interface{}{"message": tt.text})
+
+			p := NewMessageProcessor(memory.Stores())
+			if tt.quotas.PageDailyReplies > 0 {
+				// Use up the day's replies
+				comment := CommentChange{Platform: "facebook", PageID: testPageID, CommentID: "earlier", MediaID: "400000000000004",
+					Text: "Hola", FromID: "300000000000003"}
+				upstreams.graph.SetObject("earlier", map[string]interface{}{})
+				if action, _ := p.automateComment(context.Background(), comment); action != CommentActionReply {
+					t.Fatalf("first comment action = %s, want reply", action)
+				}
+			}
+			replies := len(upstreams.graph.Edge(commentID, "comments"))
+
+			action, rule := p.automateComment(context.Background(), CommentChange{
+				Platform:  "facebook",
+				PageID:    testPageID,
+				Field:     "feed",
+				CommentID: commentID,
+				MediaID:   "400000000000004",
+				Text:      tt.text,
+				FromID:    testUserID,
+				FromName:  "Ana",
+			})
+			if action != tt.want || rule != tt.wantRule {
+				t.Errorf("automateComment = %s, %q, want %s, %q", action, rule, tt.want, tt.wantRule)
+			}
+
+			object, _ := upstreams.graph.Object(commentID)
+			if hidden := object["is_hidden"] == "true"; hidden != (tt.want == CommentActionHide) {
+				t.Errorf("comment hidden = %v, want %v", hidden, tt.want == CommentActionHide)
+			}
+			posted := upstreams.graph.Edge(commentID, "comments")[replies:]
+			if tt.replied {
+				if len(posted) != 1 || posted[0]["message"] != "Abrimos de 9 a 18 h." {
+					t.Errorf("replies = %+v, want the Dify answer", posted)
+				}
+			} else if len(posted) != 0 {
+				t.Errorf("replies = %+v, want none", posted)
+			}
+
+			var check string
+			for _, flag := range memory.Flags() {
+				if flag.MessageMid == commentID {
+					check = flag.Check
+				}
+			}
+			if check != tt.wantFlag {
+				t.Errorf("flag = %q, want %q", check, tt.wantFlag)
+			}
+		})
+	}
+}
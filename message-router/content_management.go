@@ -2,21 +2,27 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"graph"
+	"logging"
 )
 
 // ContentManagement handles Facebook and Instagram content management operations
 type ContentManagement struct {
-	db *sql.DB
+	pages         PageStore
+	moderationLog ModerationLogStore
 }
 
 // Page represents a connected Facebook or Instagram page
@@ -68,8 +74,8 @@ type CommentReply struct {
 }
 
 // NewContentManagement creates a new content management instance
-func NewContentManagement(db *sql.DB) *ContentManagement {
-	return &ContentManagement{db: db}
+func NewContentManagement(pages PageStore, moderationLog ModerationLogStore) *ContentManagement {
+	return &ContentManagement{pages: pages, moderationLog: moderationLog}
 }
 
 // GetUserPages retrieves all connected pages for a user
@@ -82,29 +88,15 @@ func (cm *ContentManagement) GetUserPages(w http.ResponseWriter, r *http.Request
 
 	LogInfo("🔍 Getting pages for client: %s", clientID)
 
-	query := `
-		SELECT id, page_id, page_name, platform, access_token, client_id 
-		FROM social_pages 
-		WHERE client_id = $1 AND status = 'active'
-		ORDER BY platform, page_name
-	`
-
-	rows, err := cm.db.Query(query, clientID)
+	clientPages, err := cm.pages.ClientPages(r.Context(), clientID)
 	if err != nil {
 		LogError("Error querying pages: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
 	var pages []Page
-	for rows.Next() {
-		var page Page
-		err := rows.Scan(&page.ID, &page.PageID, &page.Name, &page.Platform, &page.AccessToken, &page.ClientID)
-		if err != nil {
-			LogError("Error scanning page: %v", err)
-			continue
-		}
+	for _, page := range clientPages {
 		// Don't expose access token in API response
 		page.AccessToken = ""
 		pages = append(pages, page)
@@ -148,14 +140,14 @@ func (cm *ContentManagement) GetPagePosts(w http.ResponseWriter, r *http.Request
 	LogInfo("📱 Getting posts for page %s, limit %s, offset %s", pageID, limit, offset)
 
 	// Get page access token
-	accessToken, platform, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, platform, err := cm.getPageAccessToken(r.Context(), pageID, clientID)
 	if err != nil {
 		LogError("Error getting page access token: %v", err)
 		http.Error(w, "Page not found or access denied", http.StatusForbidden)
 		return
 	}
 
-	posts, err := cm.fetchPostsFromAPI(pageID, platform, accessToken, limit, offset)
+	posts, err := cm.fetchPostsFromAPI(r.Context(), pageID, platform, accessToken, limit, offset)
 	if err != nil {
 		LogError("Error fetching posts from API: %v", err)
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
@@ -216,14 +208,14 @@ func (cm *ContentManagement) CreatePost(w http.ResponseWriter, r *http.Request)
 	LogInfo("📝 Creating post for page %s with %d media files", pageID, len(r.MultipartForm.File))
 
 	// Get page access token
-	accessToken, platform, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, platform, err := cm.getPageAccessToken(r.Context(), pageID, clientID)
 	if err != nil {
 		LogError("Error getting page access token: %v", err)
 		http.Error(w, "Page not found or access denied", http.StatusForbidden)
 		return
 	}
 
-	postID, err := cm.createPostOnAPI(pageID, platform, accessToken, message, r.MultipartForm)
+	postID, err := cm.createPostOnAPI(r.Context(), pageID, platform, accessToken, message, r.MultipartForm)
 	if err != nil {
 		LogError("Error creating post on API: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to create post: %v", err), http.StatusInternalServerError)
@@ -260,7 +252,7 @@ func (cm *ContentManagement) GetPostComments(w http.ResponseWriter, r *http.Requ
 
 	LogInfo("💬 Getting comments for post %s (page %s)", postID, pageID)
 
-	comments, err := cm.fetchCommentsFromAPI(postID, pageID, clientID)
+	comments, err := cm.fetchCommentsFromAPI(r.Context(), postID, pageID, clientID)
 	if err != nil {
 		LogError("Error fetching comments: %v", err)
 		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
@@ -309,7 +301,7 @@ func (cm *ContentManagement) AddCommentToPost(w http.ResponseWriter, r *http.Req
 
 	LogInfo("💬 Adding comment to post %s (page %s): %s", postID, pageID, commentRequest.Message[:min(50, len(commentRequest.Message))])
 
-	commentID, err := cm.addCommentToPostOnAPI(postID, pageID, commentRequest.Message, clientID)
+	commentID, err := cm.addCommentToPostOnAPI(r.Context(), postID, pageID, commentRequest.Message, clientID)
 	if err != nil {
 		LogError("Error adding comment to post: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to add comment: %v", err), http.StatusInternalServerError)
@@ -354,9 +346,9 @@ func (cm *ContentManagement) ReplyToComment(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	LogInfo("↩️ Replying to comment %s: %s", commentID, reply.Message[:min(50, len(reply.Message))])
+	LogInfo("↩️ Replying to comment %s: %s", commentID, logging.Text(reply.Message))
 
-	replyID, err := cm.replyToCommentOnAPI(commentID, reply.Message, clientID)
+	replyID, err := cm.replyToCommentOnAPI(r.Context(), commentID, reply.Message, clientID)
 	if err != nil {
 		LogError("Error replying to comment: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to reply: %v", err), http.StatusInternalServerError)
@@ -405,7 +397,7 @@ func (cm *ContentManagement) EditComment(w http.ResponseWriter, r *http.Request)
 
 	LogInfo("✏️ Editing comment %s", commentID)
 
-	err := cm.editCommentOnAPI(commentID, editRequest.Message, clientID)
+	err := cm.editCommentOnAPI(r.Context(), commentID, editRequest.Message, clientID)
 	if err != nil {
 		LogError("Error editing comment: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to edit comment: %v", err), http.StatusInternalServerError)
@@ -440,7 +432,7 @@ func (cm *ContentManagement) DeleteComment(w http.ResponseWriter, r *http.Reques
 
 	LogInfo("🗑️ Deleting comment %s", commentID)
 
-	err := cm.deleteCommentOnAPI(commentID, clientID)
+	err := cm.deleteCommentOnAPI(r.Context(), commentID, clientID)
 	if err != nil {
 		LogError("Error deleting comment: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to delete comment: %v", err), http.StatusInternalServerError)
@@ -456,6 +448,56 @@ func (cm *ContentManagement) DeleteComment(w http.ResponseWriter, r *http.Reques
 	})
 }
 
+// SetCommentHidden hides or unhides a specific comment. The optional body
+// selects the page ({"page_id": "..."}, required for Instagram comments) and
+// gives the reason stored in the moderation log.
+func (cm *ContentManagement) SetCommentHidden(w http.ResponseWriter, r *http.Request) {
+	// Extract commentID and action from URL path
+	path := strings.TrimPrefix(r.URL.Path, "/api/comments/")
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] == "" || (parts[1] != "hide" && parts[1] != "unhide") {
+		http.Error(w, "Comment ID required", http.StatusBadRequest)
+		return
+	}
+	commentID := parts[0]
+	hidden := parts[1] == "hide"
+
+	clientID := r.Header.Get("X-Client-ID")
+	if clientID == "" {
+		http.Error(w, "Client ID required", http.StatusUnauthorized)
+		return
+	}
+
+	var moderationRequest struct {
+		PageID string `json:"page_id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&moderationRequest); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	LogInfo("🙈 Setting comment %s hidden=%v", commentID, hidden)
+
+	err := cm.setCommentHiddenOnAPI(r.Context(), commentID, moderationRequest.PageID, clientID, moderationRequest.Reason, hidden)
+	if err != nil {
+		LogError("Error moderating comment: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to %s comment: %v", parts[1], err), http.StatusInternalServerError)
+		return
+	}
+
+	message := "Comment hidden successfully"
+	if !hidden {
+		message = "Comment unhidden successfully"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"hidden":  hidden,
+		"message": message,
+	})
+}
+
 // DeletePost deletes a specific post
 func (cm *ContentManagement) DeletePost(w http.ResponseWriter, r *http.Request) {
 	// Extract postID from URL path
@@ -475,7 +517,7 @@ func (cm *ContentManagement) DeletePost(w http.ResponseWriter, r *http.Request)
 
 	LogInfo("🗑️ Deleting post %s", postID)
 
-	err := cm.deletePostOnAPI(postID, clientID)
+	err := cm.deletePostOnAPI(r.Context(), postID, clientID)
 	if err != nil {
 		LogError("Error deleting post: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to delete post: %v", err), http.StatusInternalServerError)
@@ -492,68 +534,57 @@ func (cm *ContentManagement) DeletePost(w http.ResponseWriter, r *http.Request)
 }
 
 // Helper function to get page access token
-func (cm *ContentManagement) getPageAccessToken(pageID, clientID string) (string, string, error) {
-	query := `
-		SELECT access_token, platform 
-		FROM social_pages 
-		WHERE page_id = $1 AND client_id = $2 AND status = 'active'
-	`
-	
-	var accessToken, platform string
-	err := cm.db.QueryRow(query, pageID, clientID).Scan(&accessToken, &platform)
+func (cm *ContentManagement) getPageAccessToken(ctx context.Context, pageID, clientID string) (string, string, error) {
+	page, err := cm.pages.ClientPage(ctx, pageID, clientID)
 	if err != nil {
 		return "", "", fmt.Errorf("page not found: %v", err)
 	}
 
-	LogDebug("🔍 Found page %s with platform: %s", pageID, platform)
-	return accessToken, platform, nil
+	LogDebug("🔍 Found page %s with platform: %s", pageID, page.Platform)
+	return page.AccessToken, page.Platform, nil
+}
+
+// clientFacebookPage returns the client's first active Facebook page, used by
+// the comment endpoints that don't name a page
+func (cm *ContentManagement) clientFacebookPage(ctx context.Context, clientID string) (string, error) {
+	pages, err := cm.pages.ClientPages(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+	for _, page := range pages {
+		if page.Platform == "facebook" {
+			return page.PageID, nil
+		}
+	}
+	return "", ErrNotFound
 }
 
 // Helper function to fetch posts from Facebook/Instagram API
-func (cm *ContentManagement) fetchPostsFromAPI(pageID, platform, accessToken, limit, offset string) ([]Post, error) {
-	var apiURL string
-	var fields string
+func (cm *ContentManagement) fetchPostsFromAPI(ctx context.Context, pageID, platform, accessToken, limit, offset string) ([]Post, error) {
+	var path string
+	params := url.Values{"limit": {limit}, "offset": {offset}}
 
 	if platform == "facebook" {
-		fields = "id,message,created_time,likes.summary(total_count),comments.summary(total_count),shares,picture,full_picture"
-		apiURL = fmt.Sprintf("https://graph.facebook.com/v18.0/%s/posts?fields=%s&limit=%s&offset=%s&order=reverse_chronological&access_token=%s",
-			pageID, fields, limit, offset, accessToken)
+		path = pageID + "/posts"
+		params.Set("fields", "id,message,created_time,likes.summary(total_count),comments.summary(total_count),shares,picture,full_picture")
+		params.Set("order", "reverse_chronological")
 	} else if platform == "instagram" {
-		fields = "id,caption,media_type,media_url,thumbnail_url,timestamp,like_count,comments_count"
-		apiURL = fmt.Sprintf("https://graph.facebook.com/v18.0/%s/media?fields=%s&limit=%s&offset=%s&access_token=%s",
-			pageID, fields, limit, offset, accessToken)
+		path = pageID + "/media"
+		params.Set("fields", "id,caption,media_type,media_url,thumbnail_url,timestamp,like_count,comments_count")
 	} else {
 		return nil, fmt.Errorf("unsupported platform: %s", platform)
 	}
 
 	LogInfo("🔗 Fetching posts from API for page %s (%s)", pageID, platform)
-	LogDebug("🔗 API URL: %s", strings.ReplaceAll(apiURL, accessToken, "***TOKEN***"))
-
-	resp, err := http.Get(apiURL)
-	if err != nil {
-		return nil, fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
-
-	if resp.StatusCode != 200 {
-		LogError("❌ Facebook API error %d: %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-	}
-
-	LogDebug("✅ Facebook API response: %s", string(body))
+	LogDebug("🔗 API URL: %s", graphClient.URL(path, params))
 
 	var apiResponse struct {
 		Data []json.RawMessage `json:"data"`
 	}
-
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		LogError("❌ Failed to parse API response: %v", err)
-		return nil, fmt.Errorf("failed to parse API response: %v", err)
+	if err := graphClient.Get(ctx, path, accessToken, params, &apiResponse); err != nil {
+		observeGraphAPIError(platform, "posts", err)
+		LogError("❌ Facebook API error: %v", err)
+		return nil, fmt.Errorf("API error: %w", err)
 	}
 
 	LogInfo("📊 Found %d posts in API response for page %s", len(apiResponse.Data), pageID)
@@ -655,43 +686,42 @@ func (cm *ContentManagement) parsePost(rawPost json.RawMessage, platform, pageID
 }
 
 // Helper function to create post on Facebook/Instagram API
-func (cm *ContentManagement) createPostOnAPI(pageID, platform, accessToken, message string, form *multipart.Form) (string, error) {
+func (cm *ContentManagement) createPostOnAPI(ctx context.Context, pageID, platform, accessToken, message string, form *multipart.Form) (string, error) {
 	if platform == "facebook" {
-		return cm.createFacebookPost(pageID, accessToken, message, form)
+		return cm.createFacebookPost(ctx, pageID, accessToken, message, form)
 	} else if platform == "instagram" {
 		// Instagram posting requires a two-step process: create media object, then publish
-		return cm.createInstagramPost(pageID, accessToken, message, form)
+		return cm.createInstagramPost(ctx, pageID, accessToken, message, form)
 	} else {
 		return "", fmt.Errorf("unsupported platform: %s", platform)
 	}
 }
 
 // Helper function to create Facebook post
-func (cm *ContentManagement) createFacebookPost(pageID, accessToken, message string, form *multipart.Form) (string, error) {
+func (cm *ContentManagement) createFacebookPost(ctx context.Context, pageID, accessToken, message string, form *multipart.Form) (string, error) {
 	// Check if we have media files
 	hasMedia := len(form.File) > 0
 
-	var apiURL string
+	var path string
 	if hasMedia {
 		// Use photos endpoint for media posts
-		apiURL = fmt.Sprintf("https://graph.facebook.com/v18.0/%s/photos", pageID)
+		path = pageID + "/photos"
 	} else {
 		// Use feed endpoint for text-only posts
-		apiURL = fmt.Sprintf("https://graph.facebook.com/v18.0/%s/feed", pageID)
+		path = pageID + "/feed"
 	}
-	
+
 	// Prepare form data
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
-	
+
 	// Add message (caption for photos, message for text posts)
 	if hasMedia {
 		writer.WriteField("caption", message)
 	} else {
 		writer.WriteField("message", message)
 	}
-	writer.WriteField("access_token", accessToken)
-	
+
 	// Add first media file if present (Facebook photos endpoint handles one image at a time)
 	if hasMedia {
 		for key, fileHeaders := range form.File {
@@ -716,76 +746,23 @@ func (cm *ContentManagement) createFacebookPost(pageID, accessToken, message str
 			}
 		}
 	}
-	
+
 	writer.Close()
 
-	LogDebug("🔗 Creating Facebook post: %s", apiURL)
+	LogDebug("🔗 Creating Facebook post: %s", graphClient.URL(path, nil))
 	if message != "" {
 		LogDebug("📤 Post message: %s", message[:min(100, len(message))])
 	} else {
 		LogDebug("📤 Media-only post")
 	}
 
-	req, err := http.NewRequest("POST", apiURL, &buf)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-
-	LogDebug("📥 Facebook post response: %d - %s", resp.StatusCode, string(body))
-
-	if resp.StatusCode != 200 {
-		// Try to parse error response
-		var errorResp struct {
-			Error struct {
-				Message string `json:"message"`
-				Type    string `json:"type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
-		
-		if json.Unmarshal(body, &errorResp) == nil && errorResp.Error.Message != "" {
-			// Check for specific error types and provide better guidance
-			switch errorResp.Error.Code {
-			case 4, 17, 341, 613:
-				// Rate limiting errors
-				return "", fmt.Errorf("Rate limit exceeded. Please wait a few minutes before posting again. (Error: %s)", errorResp.Error.Message)
-			case 100:
-				// Permission/parameter errors
-				if strings.Contains(strings.ToLower(errorResp.Error.Message), "rate") {
-					return "", fmt.Errorf("Rate limit exceeded. Please wait before posting again. (Error: %s)", errorResp.Error.Message)
-				}
-				return "", fmt.Errorf("Permission or parameter error: %s", errorResp.Error.Message)
-			case 190:
-				// Token errors
-				return "", fmt.Errorf("Authentication error. Please reconnect your account. (Error: %s)", errorResp.Error.Message)
-			default:
-				return "", fmt.Errorf("Facebook API error: %s (Type: %s, Code: %d)",
-					errorResp.Error.Message, errorResp.Error.Type, errorResp.Error.Code)
-			}
-		}
-		
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-	}
-
 	var response struct {
 		ID string `json:"id"`
 	}
-
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
+	if err := graphClient.PostRaw(ctx, path, accessToken, writer.FormDataContentType(), buf.Bytes(), &response); err != nil {
+		observeGraphAPIError("facebook", "create_post", err)
+		LogError("❌ Facebook post failed: %v", err)
+		return "", postingError("Facebook", err)
 	}
 
 	if response.ID == "" {
@@ -795,8 +772,30 @@ func (cm *ContentManagement) createFacebookPost(pageID, accessToken, message str
 	return response.ID, nil
 }
 
+// postingError turns a failed posting call into a message the dashboard can
+// show, with specific guidance for rate limits, permissions and expired tokens
+func postingError(action string, err error) error {
+	graphErr, ok := graph.AsGraphError(err)
+	if !ok {
+		return fmt.Errorf("API request failed: %v", err)
+	}
+
+	rateLimited := graphErr.RateLimited() ||
+		(graphErr.Code == 100 && strings.Contains(strings.ToLower(graphErr.Message), "rate"))
+	switch {
+	case rateLimited:
+		return fmt.Errorf("%s rate limit exceeded. Please wait a few minutes before posting again. (Error: %s)", action, graphErr.Message)
+	case graphErr.Code == 100:
+		return fmt.Errorf("%s permission or parameter error: %s", action, graphErr.Message)
+	case graphErr.TokenInvalid():
+		return fmt.Errorf("%s authentication error. Please reconnect your account. (Error: %s)", action, graphErr.Message)
+	default:
+		return fmt.Errorf("%s API error: %s (Type: %s, Code: %d)", action, graphErr.Message, graphErr.Type, graphErr.Code)
+	}
+}
+
 // Helper function to create Instagram post (simplified version)
-func (cm *ContentManagement) createInstagramPost(pageID, accessToken, message string, form *multipart.Form) (string, error) {
+func (cm *ContentManagement) createInstagramPost(ctx context.Context, pageID, accessToken, message string, form *multipart.Form) (string, error) {
 	// Instagram posting requires media for posts (cannot be text-only)
 	if len(form.File) == 0 {
 		return "", fmt.Errorf("Instagram posts require at least one image")
@@ -816,7 +815,7 @@ func (cm *ContentManagement) createInstagramPost(pageID, accessToken, message st
 			}
 
 			// Create Instagram media container
-			containerID, err := cm.createInstagramMediaContainer(pageID, accessToken, mediaURL, message)
+			containerID, err := cm.createInstagramMediaContainer(ctx, pageID, accessToken, mediaURL, message)
 			if err != nil {
 				return "", fmt.Errorf("failed to create media container: %v", err)
 			}
@@ -830,7 +829,7 @@ func (cm *ContentManagement) createInstagramPost(pageID, accessToken, message st
 	}
 
 	// Step 2: Publish the media container
-	postID, err := cm.publishInstagramMediaContainer(pageID, accessToken, mediaContainerIDs[0])
+	postID, err := cm.publishInstagramMediaContainer(ctx, pageID, accessToken, mediaContainerIDs[0])
 	if err != nil {
 		return "", fmt.Errorf("failed to publish Instagram post: %v", err)
 	}
@@ -879,183 +878,80 @@ func (cm *ContentManagement) uploadMediaToTempURL(fileHeader *multipart.FileHead
 }
 
 // Helper function to create Instagram media container
-func (cm *ContentManagement) createInstagramMediaContainer(pageID, accessToken, mediaURL, caption string) (string, error) {
-	apiURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s/media", pageID)
-	
-	// Prepare form data for media container creation
-	formData := fmt.Sprintf("image_url=%s&caption=%s&access_token=%s", 
-		mediaURL, caption, accessToken)
-	
-	LogDebug("🔗 Creating Instagram media container: %s", apiURL)
-	
-	resp, err := http.Post(apiURL, "application/x-www-form-urlencoded", strings.NewReader(formData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create media container: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	if resp.StatusCode != 200 {
-		LogError("❌ Instagram media container creation failed: %d - %s", resp.StatusCode, string(body))
-
-		// Try to parse error response for better user feedback
-		var errorResp struct {
-			Error struct {
-				Message string `json:"message"`
-				Type    string `json:"type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
+func (cm *ContentManagement) createInstagramMediaContainer(ctx context.Context, pageID, accessToken, mediaURL, caption string) (string, error) {
+	path := pageID + "/media"
 
-		if json.Unmarshal(body, &errorResp) == nil && errorResp.Error.Message != "" {
-			// Check for specific Instagram error types
-			switch errorResp.Error.Code {
-			case 4, 17, 341, 613:
-				// Rate limiting errors
-				return "", fmt.Errorf("Rate limit exceeded. Please wait a few minutes before posting to Instagram again. (Error: %s)", errorResp.Error.Message)
-			case 100:
-				// Permission/parameter errors
-				if strings.Contains(strings.ToLower(errorResp.Error.Message), "rate") {
-					return "", fmt.Errorf("Rate limit exceeded. Please wait before posting to Instagram again. (Error: %s)", errorResp.Error.Message)
-				}
-				return "", fmt.Errorf("Instagram permission or parameter error: %s", errorResp.Error.Message)
-			case 190:
-				// Token errors
-				return "", fmt.Errorf("Instagram authentication error. Please reconnect your account. (Error: %s)", errorResp.Error.Message)
-			default:
-				return "", fmt.Errorf("Instagram API error: %s (Code: %d)", errorResp.Error.Message, errorResp.Error.Code)
-			}
-		}
+	LogDebug("🔗 Creating Instagram media container: %s", graphClient.URL(path, nil))
 
-		return "", fmt.Errorf("Instagram API error %d: %s", resp.StatusCode, string(body))
-	}
-	
 	var containerResponse struct {
 		ID string `json:"id"`
 	}
-	
-	if err := json.Unmarshal(body, &containerResponse); err != nil {
-		return "", fmt.Errorf("failed to parse container response: %v", err)
+	err := graphClient.PostForm(ctx, path, accessToken, url.Values{
+		"image_url": {mediaURL},
+		"caption":   {caption},
+	}, &containerResponse)
+	if err != nil {
+		observeGraphAPIError("instagram", "create_post", err)
+		LogError("❌ Instagram media container creation failed: %v", err)
+		return "", postingError("Instagram", err)
 	}
-	
+
 	LogInfo("✅ Created Instagram media container: %s", containerResponse.ID)
 	return containerResponse.ID, nil
 }
 
 // Helper function to publish Instagram media container
-func (cm *ContentManagement) publishInstagramMediaContainer(pageID, accessToken, containerID string) (string, error) {
-	apiURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s/media_publish", pageID)
-	
-	// Prepare form data for publishing
-	formData := fmt.Sprintf("creation_id=%s&access_token=%s", containerID, accessToken)
-	
-	LogDebug("🔗 Publishing Instagram media container: %s", apiURL)
-	
-	resp, err := http.Post(apiURL, "application/x-www-form-urlencoded", strings.NewReader(formData))
-	if err != nil {
-		return "", fmt.Errorf("failed to publish media: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	if resp.StatusCode != 200 {
-		LogError("❌ Instagram media publish failed: %d - %s", resp.StatusCode, string(body))
-
-		// Try to parse error response for better user feedback
-		var errorResp struct {
-			Error struct {
-				Message string `json:"message"`
-				Type    string `json:"type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
+func (cm *ContentManagement) publishInstagramMediaContainer(ctx context.Context, pageID, accessToken, containerID string) (string, error) {
+	path := pageID + "/media_publish"
 
-		if json.Unmarshal(body, &errorResp) == nil && errorResp.Error.Message != "" {
-			// Check for specific Instagram publish error types
-			switch errorResp.Error.Code {
-			case 4, 17, 341, 613:
-				// Rate limiting errors
-				return "", fmt.Errorf("Instagram publish rate limit exceeded. Please wait a few minutes before posting again. (Error: %s)", errorResp.Error.Message)
-			case 100:
-				// Permission/parameter errors
-				if strings.Contains(strings.ToLower(errorResp.Error.Message), "rate") {
-					return "", fmt.Errorf("Instagram publish rate limit exceeded. Please wait before posting again. (Error: %s)", errorResp.Error.Message)
-				}
-				return "", fmt.Errorf("Instagram publish error: %s", errorResp.Error.Message)
-			case 190:
-				// Token errors
-				return "", fmt.Errorf("Instagram authentication error during publish. Please reconnect your account. (Error: %s)", errorResp.Error.Message)
-			default:
-				return "", fmt.Errorf("Instagram publish API error: %s (Code: %d)", errorResp.Error.Message, errorResp.Error.Code)
-			}
-		}
+	LogDebug("🔗 Publishing Instagram media container: %s", graphClient.URL(path, nil))
 
-		return "", fmt.Errorf("Instagram publish API error %d: %s", resp.StatusCode, string(body))
-	}
-	
 	var publishResponse struct {
 		ID string `json:"id"`
 	}
-	
-	if err := json.Unmarshal(body, &publishResponse); err != nil {
-		return "", fmt.Errorf("failed to parse publish response: %v", err)
+	if err := graphClient.PostForm(ctx, path, accessToken, url.Values{"creation_id": {containerID}}, &publishResponse); err != nil {
+		observeGraphAPIError("instagram", "publish", err)
+		LogError("❌ Instagram media publish failed: %v", err)
+		return "", postingError("Instagram publish", err)
 	}
-	
+
 	LogInfo("✅ Published Instagram post: %s", publishResponse.ID)
 	return publishResponse.ID, nil
 }
 
 // Helper function to fetch comments from Facebook/Instagram API
-func (cm *ContentManagement) fetchCommentsFromAPI(postID, pageID, clientID string) ([]Comment, error) {
+func (cm *ContentManagement) fetchCommentsFromAPI(ctx context.Context, postID, pageID, clientID string) ([]Comment, error) {
 	LogDebug("🔍 Fetching comments for post: %s (page: %s)", postID, pageID)
 
 	// Get the correct access token for this specific page
-	accessToken, platform, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, platform, err := cm.getPageAccessToken(ctx, pageID, clientID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token for page %s: %v", pageID, err)
 	}
-	
+
 	// Use different fields based on platform
 	var fields string
-	var apiURL string
-	
+
 	if platform == "instagram" {
 		// Instagram comments use different field structure
 		// Instagram doesn't have 'name' field, use 'username' instead
 		fields = "id,text,timestamp,username,replies{id,text,timestamp,username}"
-		apiURL = fmt.Sprintf("https://graph.facebook.com/v18.0/%s/comments?fields=%s&access_token=%s", postID, fields, accessToken)
 	} else {
 		// Facebook comments use the original structure
 		fields = "id,message,created_time,from{name,id},comments{id,message,created_time,from{name,id}}"
-		apiURL = fmt.Sprintf("https://graph.facebook.com/v18.0/%s/comments?fields=%s&access_token=%s", postID, fields, accessToken)
 	}
-	
-	LogDebug("🔗 Comments API URL: %s", strings.ReplaceAll(apiURL, accessToken, "***TOKEN***"))
-	
-	resp, err := http.Get(apiURL)
-	if err != nil {
-		return nil, fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	if resp.StatusCode != 200 {
-		LogError("❌ Facebook API error %d: %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+
+	path := postID + "/comments"
+	params := url.Values{"fields": {fields}}
+	LogDebug("🔗 Comments API URL: %s", graphClient.URL(path, params))
+
+	var body json.RawMessage
+	if err := graphClient.Get(ctx, path, accessToken, params, &body); err != nil {
+		observeGraphAPIError(platform, "comments", err)
+		LogError("❌ Facebook API error: %v", err)
+		return nil, fmt.Errorf("API error: %w", err)
 	}
-	
+
 	var comments []Comment
 	
 	if platform == "instagram" {
@@ -1195,8 +1091,8 @@ func (cm *ContentManagement) fetchCommentsFromAPI(postID, pageID, clientID strin
 }
 
 // Helper function to reply to comment
-func (cm *ContentManagement) replyToCommentOnAPI(commentID, message, clientID string) (string, error) {
-	LogDebug("↩️ Replying to comment %s: %s", commentID, message)
+func (cm *ContentManagement) replyToCommentOnAPI(ctx context.Context, commentID, message, clientID string) (string, error) {
+	LogDebug("↩️ Replying to comment %s: %s", commentID, logging.Text(message))
 	
 	// Validate comment ID format (Facebook comment IDs contain underscores)
 	if !strings.Contains(commentID, "_") {
@@ -1205,37 +1101,15 @@ func (cm *ContentManagement) replyToCommentOnAPI(commentID, message, clientID st
 
 	// Find the page that has a post containing this postReference
 	// Facebook post IDs are in format: pageId_postReference
-	pageQuery := `
-		SELECT DISTINCT page_id
-		FROM social_pages
-		WHERE client_id = $1 AND platform = 'facebook' AND status = 'active'
-	`
-	rows, err := cm.db.Query(pageQuery, clientID)
+	// Since we don't store posts, we'll assume the first Facebook page owns it
+	// In a real implementation, you'd check against stored posts
+	pageID, err := cm.clientFacebookPage(ctx, clientID)
 	if err != nil {
-		return "", fmt.Errorf("failed to query pages: %v", err)
-	}
-	defer rows.Close()
-
-	var pageID string
-	for rows.Next() {
-		var candidatePageID string
-		if err := rows.Scan(&candidatePageID); err != nil {
-			continue
-		}
-
-		// Check if this page could own the post (by checking if pageId_postReference exists)
-		// Since we don't store posts, we'll assume the first Facebook page owns it
-		// In a real implementation, you'd check against stored posts
-		pageID = candidatePageID
-		break
-	}
-
-	if pageID == "" {
-		return "", fmt.Errorf("no Facebook page found for client %s", clientID)
+		return "", fmt.Errorf("no Facebook page found for client %s: %v", clientID, err)
 	}
 
 	// Get access token for the identified page
-	accessToken, platform, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, platform, err := cm.getPageAccessToken(ctx, pageID, clientID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get access token for page %s: %v", pageID, err)
 	}
@@ -1247,42 +1121,96 @@ func (cm *ContentManagement) replyToCommentOnAPI(commentID, message, clientID st
 	}
 	
 	// Facebook Graph API call to reply to comment
-	apiURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s/comments", commentID)
-	
-	formData := fmt.Sprintf("message=%s&access_token=%s", message, accessToken)
-	
-	LogDebug("🔗 Reply API URL: %s", apiURL)
-	
-	resp, err := http.Post(apiURL, "application/x-www-form-urlencoded", strings.NewReader(formData))
-	if err != nil {
-		return "", fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	if resp.StatusCode != 200 {
-		LogError("❌ Facebook API error %d: %s", resp.StatusCode, string(body))
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+	return postCommentReply(ctx, platform, commentID, accessToken, message)
+}
+
+// postCommentReply publishes a public reply to a comment with the page token.
+// Facebook nests replies under {comment}/comments, Instagram under {comment}/replies.
+func postCommentReply(ctx context.Context, platform, commentID, accessToken, message string) (string, error) {
+	path := commentID + "/comments"
+	if platform == "instagram" {
+		path = commentID + "/replies"
 	}
-	
+
+	LogDebug("🔗 Reply API URL: %s", graphClient.URL(path, nil))
+
 	var replyResponse struct {
 		ID string `json:"id"`
 	}
-	
-	if err := json.Unmarshal(body, &replyResponse); err != nil {
-		return "", fmt.Errorf("failed to parse reply response: %v", err)
+	if err := graphClient.PostForm(ctx, path, accessToken, url.Values{"message": {message}}, &replyResponse); err != nil {
+		observeGraphAPIError(platform, "comments", err)
+		LogError("❌ Facebook API error: %v", err)
+		return "", fmt.Errorf("API error: %w", err)
 	}
-	
+
 	LogInfo("✅ Created reply %s to comment %s", replyResponse.ID, commentID)
 	return replyResponse.ID, nil
 }
 
+// setCommentHidden hides or unhides a comment. Hidden comments stay visible to
+// their author and friends but not to anyone else.
+func setCommentHidden(ctx context.Context, platform, commentID, accessToken string, hidden bool) error {
+	field := "is_hidden"
+	if platform == "instagram" {
+		field = "hide"
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := graphClient.PostForm(ctx, commentID, accessToken, url.Values{field: {strconv.FormatBool(hidden)}}, &result); err != nil {
+		observeGraphAPIError(platform, "comments", err)
+		return fmt.Errorf("API error: %w", err)
+	}
+
+	LogInfo("✅ Set comment %s hidden=%v", commentID, hidden)
+	return nil
+}
+
+// Helper function to hide or unhide a comment and record it in the moderation log
+func (cm *ContentManagement) setCommentHiddenOnAPI(ctx context.Context, commentID, pageID, clientID, reason string, hidden bool) error {
+	// Without a page, use the client's Facebook page like the other comment endpoints
+	if pageID == "" {
+		var err error
+		if pageID, err = cm.clientFacebookPage(ctx, clientID); err != nil {
+			return fmt.Errorf("no Facebook page found for client %s: %v", clientID, err)
+		}
+	}
+
+	accessToken, platform, err := cm.getPageAccessToken(ctx, pageID, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to get access token for page %s: %v", pageID, err)
+	}
+
+	// Validate comment ID format (Facebook comment IDs contain underscores)
+	if platform == "facebook" && !strings.Contains(commentID, "_") {
+		return fmt.Errorf("invalid comment ID format: %s", commentID)
+	}
+
+	if err := setCommentHidden(ctx, platform, commentID, accessToken, hidden); err != nil {
+		LogError("❌ %s API error: %v", platform, err)
+		return err
+	}
+
+	action := "hide"
+	if !hidden {
+		action = "unhide"
+	}
+	commentModerations.WithLabelValues(platform, action, ModerationRuleManual).Inc()
+	recordModeration(ctx, cm.moderationLog, ModerationEntry{
+		PageID:    pageID,
+		Platform:  platform,
+		CommentID: commentID,
+		Action:    action,
+		Rule:      ModerationRuleManual,
+		Reason:    reason,
+		Source:    "api",
+	})
+	return nil
+}
+
 // Helper function to delete comment
-func (cm *ContentManagement) deleteCommentOnAPI(commentID, clientID string) error {
+func (cm *ContentManagement) deleteCommentOnAPI(ctx context.Context, commentID, clientID string) error {
 	LogDebug("🗑️ Deleting comment %s", commentID)
 
 	// Validate comment ID format (Facebook comment IDs contain underscores)
@@ -1291,55 +1219,31 @@ func (cm *ContentManagement) deleteCommentOnAPI(commentID, clientID string) erro
 	}
 
 	// Find the Facebook page for this client
-	pageQuery := `
-		SELECT DISTINCT page_id
-		FROM social_pages
-		WHERE client_id = $1 AND platform = 'facebook' AND status = 'active'
-		LIMIT 1
-	`
-	var pageID string
-	err := cm.db.QueryRow(pageQuery, clientID).Scan(&pageID)
+	pageID, err := cm.clientFacebookPage(ctx, clientID)
 	if err != nil {
 		return fmt.Errorf("no Facebook page found for client %s: %v", clientID, err)
 	}
 
 	// Get access token for the identified page
-	accessToken, _, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, _, err := cm.getPageAccessToken(ctx, pageID, clientID)
 	if err != nil {
 		return fmt.Errorf("failed to get access token for page %s: %v", pageID, err)
 	}
 	
 	// Facebook Graph API call to delete comment
-	apiURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s?access_token=%s", commentID, accessToken)
-	
-	req, err := http.NewRequest("DELETE", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create delete request: %v", err)
-	}
-	
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	if resp.StatusCode != 200 {
-		LogError("❌ Facebook API error %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+	if err := graphClient.Delete(ctx, commentID, accessToken, nil); err != nil {
+		observeGraphAPIError("facebook", "comments", err)
+		LogError("❌ Facebook API error: %v", err)
+		return fmt.Errorf("API error: %w", err)
 	}
-	
+
 	LogInfo("✅ Deleted comment %s", commentID)
 	return nil
 }
 
 // Helper function to edit comment
-func (cm *ContentManagement) editCommentOnAPI(commentID, message, clientID string) error {
-	LogDebug("✏️ Editing comment %s: %s", commentID, message)
+func (cm *ContentManagement) editCommentOnAPI(ctx context.Context, commentID, message, clientID string) error {
+	LogDebug("✏️ Editing comment %s: %s", commentID, logging.Text(message))
 
 	// Validate comment ID format (Facebook comment IDs contain underscores)
 	if !strings.Contains(commentID, "_") {
@@ -1347,53 +1251,32 @@ func (cm *ContentManagement) editCommentOnAPI(commentID, message, clientID strin
 	}
 
 	// Find the Facebook page for this client
-	pageQuery := `
-		SELECT DISTINCT page_id
-		FROM social_pages
-		WHERE client_id = $1 AND platform = 'facebook' AND status = 'active'
-		LIMIT 1
-	`
-	var pageID string
-	err := cm.db.QueryRow(pageQuery, clientID).Scan(&pageID)
+	pageID, err := cm.clientFacebookPage(ctx, clientID)
 	if err != nil {
 		return fmt.Errorf("no Facebook page found for client %s: %v", clientID, err)
 	}
 
 	// Get access token for the identified page
-	accessToken, _, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, _, err := cm.getPageAccessToken(ctx, pageID, clientID)
 	if err != nil {
 		return fmt.Errorf("failed to get access token for page %s: %v", pageID, err)
 	}
 	
 	// Facebook Graph API call to edit comment
-	apiURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s", commentID)
-	
-	formData := fmt.Sprintf("message=%s&access_token=%s", message, accessToken)
-	
-	LogDebug("🔗 Edit API URL: %s", apiURL)
-	
-	resp, err := http.Post(apiURL, "application/x-www-form-urlencoded", strings.NewReader(formData))
-	if err != nil {
-		return fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	if resp.StatusCode != 200 {
-		LogError("❌ Facebook API error %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+	LogDebug("🔗 Edit API URL: %s", graphClient.URL(commentID, nil))
+
+	if err := graphClient.PostForm(ctx, commentID, accessToken, url.Values{"message": {message}}, nil); err != nil {
+		observeGraphAPIError("facebook", "comments", err)
+		LogError("❌ Facebook API error: %v", err)
+		return fmt.Errorf("API error: %w", err)
 	}
-	
+
 	LogInfo("✅ Edited comment %s", commentID)
 	return nil
 }
 
 // Helper function to delete post on Facebook/Instagram API
-func (cm *ContentManagement) deletePostOnAPI(postID, clientID string) error {
+func (cm *ContentManagement) deletePostOnAPI(ctx context.Context, postID, clientID string) error {
 	LogDebug("🗑️ Deleting post %s", postID)
 
 	// Extract page ID from post ID to get access token and platform
@@ -1410,7 +1293,7 @@ func (cm *ContentManagement) deletePostOnAPI(postID, clientID string) error {
 	}
 
 	// Get access token and platform for this post's page
-	accessToken, platform, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, platform, err := cm.getPageAccessToken(ctx, pageID, clientID)
 	if err != nil {
 		return fmt.Errorf("failed to get access token for page %s: %v", pageID, err)
 	}
@@ -1418,42 +1301,14 @@ func (cm *ContentManagement) deletePostOnAPI(postID, clientID string) error {
 	LogDebug("🔗 Deleting %s post: %s", platform, postID)
 
 	// Facebook Graph API call to delete post
-	apiURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s?access_token=%s", postID, accessToken)
-
-	req, err := http.NewRequest("DELETE", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create delete request: %v", err)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
-	}
-
-	if resp.StatusCode != 200 {
-		LogError("❌ %s API error %d: %s", platform, resp.StatusCode, string(body))
-
-		// Parse error response for better user feedback
-		var errorResp struct {
-			Error struct {
-				Message string `json:"message"`
-				Type    string `json:"type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
-
-		if json.Unmarshal(body, &errorResp) == nil && errorResp.Error.Message != "" {
+	if err := graphClient.Delete(ctx, postID, accessToken, nil); err != nil {
+		observeGraphAPIError(platform, "delete_post", err)
+		LogError("❌ %s API error: %v", platform, err)
+		if graphErr, ok := graph.AsGraphError(err); ok && graphErr.Message != "" {
 			return fmt.Errorf("%s API error: %s (Type: %s, Code: %d)",
-				platform, errorResp.Error.Message, errorResp.Error.Type, errorResp.Error.Code)
+				platform, graphErr.Message, graphErr.Type, graphErr.Code)
 		}
-
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("API error: %w", err)
 	}
 
 	LogInfo("✅ Deleted %s post %s", platform, postID)
@@ -1461,11 +1316,11 @@ func (cm *ContentManagement) deletePostOnAPI(postID, clientID string) error {
 }
 
 // Helper function to add a comment to a post on Facebook/Instagram API
-func (cm *ContentManagement) addCommentToPostOnAPI(postID, pageID, message, clientID string) (string, error) {
+func (cm *ContentManagement) addCommentToPostOnAPI(ctx context.Context, postID, pageID, message, clientID string) (string, error) {
 	LogDebug("💬 Adding comment to post %s: %s", postID, message)
 
 	// Get access token and platform for this page
-	accessToken, platform, err := cm.getPageAccessToken(pageID, clientID)
+	accessToken, platform, err := cm.getPageAccessToken(ctx, pageID, clientID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get access token for page %s: %v", pageID, err)
 	}
@@ -1478,45 +1333,16 @@ func (cm *ContentManagement) addCommentToPostOnAPI(postID, pageID, message, clie
 	LogDebug("🔗 Adding comment to %s post: %s", platform, postID)
 
 	// Facebook Graph API call to add comment to post
-	apiURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s/comments", postID)
-	formData := fmt.Sprintf("message=%s&access_token=%s", message, accessToken)
-
-	resp, err := http.Post(apiURL, "application/x-www-form-urlencoded", strings.NewReader(formData))
-	if err != nil {
-		return "", fmt.Errorf("API request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-
-	if resp.StatusCode != 200 {
-		LogError("❌ %s API error %d: %s", platform, resp.StatusCode, string(body))
-
-		// Parse error response for better user feedback
-		var errorResp struct {
-			Error struct {
-				Message string `json:"message"`
-				Type    string `json:"type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
-
-		if json.Unmarshal(body, &errorResp) == nil && errorResp.Error.Message != "" {
-			return "", fmt.Errorf("%s API error: %s (Code: %d)", platform, errorResp.Error.Message, errorResp.Error.Code)
-		}
-
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-	}
-
 	var commentResponse struct {
 		ID string `json:"id"`
 	}
-
-	if err := json.Unmarshal(body, &commentResponse); err != nil {
-		return "", fmt.Errorf("failed to parse comment response: %v", err)
+	if err := graphClient.PostForm(ctx, postID+"/comments", accessToken, url.Values{"message": {message}}, &commentResponse); err != nil {
+		observeGraphAPIError(platform, "comments", err)
+		LogError("❌ %s API error: %v", platform, err)
+		if graphErr, ok := graph.AsGraphError(err); ok && graphErr.Message != "" {
+			return "", fmt.Errorf("%s API error: %s (Code: %d)", platform, graphErr.Message, graphErr.Code)
+		}
+		return "", fmt.Errorf("API error: %w", err)
 	}
 
 	LogInfo("✅ Added comment %s to %s post %s", commentResponse.ID, platform, postID)
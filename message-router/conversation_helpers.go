@@ -3,85 +3,24 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"logging"
+	"message-router/sentiment"
+	"strings"
 )
 
 // =============================================================================
 // CONVERSATION STATE MANAGEMENT - Core database operations for conversation tracking
 // =============================================================================
 
-// getOrCreateConversation retrieves or creates a conversation record in the database
-func getOrCreateConversation(ctx context.Context, pageID, threadID, platform string) (*ConversationState, error) {
-	// Get UUID for database operations - FIXED: Include platform to avoid conflicts
-	var pageUUID string
-	err := db.QueryRowContext(ctx, `
-        SELECT id 
-        FROM social_pages 
-        WHERE page_id = $1 AND platform = $2
-    `, pageID, platform).Scan(&pageUUID)
-	if err != nil {
-		return nil, fmt.Errorf("error finding page: %v", err)
-	}
-
-	conv := &ConversationState{}
-	err = db.QueryRowContext(ctx, `
-        SELECT c.thread_id, sp.page_id, c.platform, c.bot_enabled, 
-               COALESCE(c.last_bot_message_at, '1970-01-01'::timestamp),
-               COALESCE(c.last_human_message_at, '1970-01-01'::timestamp),
-               COALESCE(c.last_user_message_at, '1970-01-01'::timestamp),
-               c.message_count,
-               COALESCE(c.dify_conversation_id, '')
-        FROM conversations c
-        JOIN social_pages sp ON sp.id = c.page_id
-        WHERE c.thread_id = $1 AND c.page_id = $2
-    `, threadID, pageUUID).Scan(
-		&conv.ThreadID,
-		&conv.PageID, // Now will get the original page_id from social_pages
-		&conv.Platform,
-		&conv.BotEnabled,
-		&conv.LastBotMessage,
-		&conv.LastHumanMessage,
-		&conv.LastUserMessage,
-		&conv.MessageCount,
-		&conv.DifyConversationID,
-	)
-
-	if err == sql.ErrNoRows {
-		// Create new conversation
-		conv = &ConversationState{
-			ThreadID:   threadID,
-			PageID:     pageID, // Use original pageID
-			Platform:   platform,
-			BotEnabled: true,
-		}
-
-		err = db.QueryRowContext(ctx, `
-            INSERT INTO conversations (
-                thread_id, page_id, platform, bot_enabled, 
-                first_message_at, latest_message_at, message_count
-            ) VALUES ($1, $2, $3, $4, NOW(), NOW(), 1)
-            RETURNING thread_id
-        `, conv.ThreadID, pageUUID, conv.Platform, conv.BotEnabled).Scan(&conv.ThreadID)
-
-		if err != nil {
-			return nil, fmt.Errorf("error creating conversation: %v", err)
-		}
-
-		log.Printf("✨ Created new conversation: %s", conv.ThreadID)
-		return conv, nil
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("error fetching conversation: %v", err)
-	}
-
-	log.Printf("📝 Retrieved conversation: %s (bot enabled: %v)", conv.ThreadID, conv.BotEnabled)
-	return conv, nil
+// getOrCreateConversation retrieves or creates a conversation record
+func (p *MessageProcessor) getOrCreateConversation(ctx context.Context, pageID, threadID, platform string) (*ConversationState, error) {
+	return p.stores.Conversations.GetOrCreate(ctx, pageID, threadID, platform)
 }
 
-// updateConversationState updates the conversation state in the database.
+// updateConversationState updates the conversation state in the conversation store.
 //
 // This function manages the bot enable/disable state for conversations and logs
 // all state transitions as system messages for auditing and debugging purposes.
@@ -141,42 +80,13 @@ func getOrCreateConversation(ctx context.Context, pageID, threadID, platform str
 //
 // The function ensures conversation state consistency and provides comprehensive
 // logging for debugging bot behavior and human agent interventions.
-func updateConversationState(ctx context.Context, conv *ConversationState, botEnabled bool, reason string) error {
+func (p *MessageProcessor) updateConversationState(ctx context.Context, conv *ConversationState, botEnabled bool, reason string) error {
 	// Add debug logging at the start
 	log.Printf("🔍 Updating conversation state: pageID=%s, platform=%s, threadID=%s, botEnabled=%v",
 		conv.PageID, conv.Platform, conv.ThreadID, botEnabled)
 
-	// Start a transaction with row-level locking to prevent race conditions
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("error starting transaction: %v", err)
-	}
-	defer tx.Rollback()
-
-	// Lock the conversation row to prevent concurrent modifications
-	var currentBotState bool
-	err = tx.QueryRowContext(ctx, `
-        SELECT bot_enabled FROM conversations 
-        WHERE thread_id = $1
-        FOR UPDATE
-    `, conv.ThreadID).Scan(&currentBotState)
-	if err != nil {
-		return fmt.Errorf("error locking conversation: %v", err)
-	}
-
-	log.Printf("🔒 Conversation state locked - current: %v, target: %v", currentBotState, botEnabled)
-
-	// Update conversations table
-	if _, err := tx.ExecContext(ctx, `
-		UPDATE conversations 
-		SET bot_enabled = $1,
-			bot_disabled_at = CASE WHEN $1 = false THEN NOW() ELSE NULL END,
-			latest_message_at = NOW(),
-			message_count = message_count + 1,
-			updated_at = NOW()
-		WHERE thread_id = $2
-	`, botEnabled, conv.ThreadID); err != nil {
-		return fmt.Errorf("error updating conversation: %v", err)
+	if err := p.stores.Conversations.SetBotEnabled(ctx, conv.ThreadID, botEnabled); err != nil {
+		return err
 	}
 
 	// Log state change if bot state changed
@@ -185,22 +95,7 @@ func updateConversationState(ctx context.Context, conv *ConversationState, botEn
 			map[bool]string{true: "enabled", false: "disabled"}[botEnabled],
 			reason,
 		)
-
 		log.Printf("🔧 %s", stateMsg)
-
-		// Update the bot_enabled state in the conversation
-		if _, err := tx.ExecContext(ctx, `
-			UPDATE conversations
-			SET bot_enabled = $1
-			WHERE thread_id = $2
-		`, botEnabled, conv.ThreadID); err != nil {
-			return fmt.Errorf("error updating bot state: %v", err)
-		}
-	}
-
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %v", err)
 	}
 
 	log.Printf("✅ Successfully updated conversation state")
@@ -210,94 +105,91 @@ func updateConversationState(ctx context.Context, conv *ConversationState, botEn
 // updateConversationForHumanMessage updates the conversation when a human agent sends a message.
 // This disables the bot to prevent conflicts between human agents and automated responses.
 // The bot will be automatically re-enabled after 12 hours of human agent inactivity.
-func updateConversationForHumanMessage(ctx context.Context, pageID, threadID, platform string) error {
+func (p *MessageProcessor) updateConversationForHumanMessage(ctx context.Context, pageID, threadID, platform string) error {
 	log.Printf("🔍 Updating conversation for human agent message: pageID=%s, threadID=%s, platform=%s", pageID, threadID, platform)
 
-	// Get UUID for database operations - FIXED: Include platform to avoid conflicts
-	var pageUUID string
-	err := db.QueryRowContext(ctx, `
-        SELECT id 
-        FROM social_pages 
-        WHERE page_id = $1 AND platform = $2
-    `, pageID, platform).Scan(&pageUUID)
-	if err != nil {
-		return fmt.Errorf("error finding page: %v", err)
-	}
-
-	// Start a transaction with row-level locking to prevent race conditions
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("error starting transaction: %v", err)
-	}
-	defer tx.Rollback()
-
-	// Lock the conversation row to prevent concurrent modifications
-	var currentBotState bool
-	err = tx.QueryRowContext(ctx, `
-        SELECT bot_enabled FROM conversations 
-        WHERE thread_id = $1 AND page_id = $2
-        FOR UPDATE
-    `, threadID, pageUUID).Scan(&currentBotState)
-	if err != nil {
-		return fmt.Errorf("error locking conversation: %v", err)
-	}
-
-	log.Printf("🔒 Conversation locked - current bot state: %v", currentBotState)
-
-	// Update the conversation to record human message and disable bot
-	// The bot will be automatically reactivated after 12 hours of inactivity
-	_, err = tx.ExecContext(ctx, `
-        UPDATE conversations 
-        SET last_human_message_at = NOW(),
-            bot_enabled = false,
-            bot_disabled_at = NOW(),
-            latest_message_at = NOW(),
-            message_count = message_count + 1,
-            updated_at = NOW()
-        WHERE thread_id = $1 AND page_id = $2
-    `, threadID, pageUUID)
-	if err != nil {
-		return fmt.Errorf("error updating conversation for human message: %v", err)
+	if err := p.stores.Conversations.RecordHumanMessage(ctx, pageID, threadID, platform); err != nil {
+		return err
 	}
 
 	// Log the bot disable
 	stateMsg := "Bot disabled due to human agent intervention (will reactivate after 12 hours of inactivity)"
 	log.Printf("🔧 %s", stateMsg)
 
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %v", err)
-	}
-
 	log.Printf("✅ Successfully updated conversation for human agent message")
 	return nil
 }
 
 // updateConversationUsername updates the user's social media name in the conversation record
-func updateConversationUsername(ctx context.Context, threadID string, userName string) error {
-	var pageUUID string
-	err := db.QueryRowContext(ctx, `
-        SELECT page_id 
-        FROM conversations 
-        WHERE thread_id = $1
-    `, threadID).Scan(&pageUUID)
-	if err != nil {
-		return fmt.Errorf("error finding conversation: %v", err)
+func (p *MessageProcessor) updateConversationUsername(ctx context.Context, threadID string, userName string) error {
+	if err := p.stores.Conversations.SetUserName(ctx, threadID, userName); err != nil {
+		return err
 	}
 
-	_, err = db.ExecContext(ctx, `
-        UPDATE conversations 
-        SET social_user_name = $1,
-            updated_at = NOW()
-        WHERE thread_id = $2 AND page_id = $3
-    `, userName, threadID, pageUUID)
+	log.Printf("✅ Updated conversation user name to: %s", logging.Name(userName))
+	return nil
+}
+
+// storeBotMessage records an answer the bot sent (from Dify or the FAQ) as a
+// `bot` message, and bumps the conversation's bot activity timestamps
+func (p *MessageProcessor) storeBotMessage(ctx context.Context, pageID, platform, threadID, content string) (err error) {
+	ctx, span := startSpan(ctx, "db.store_bot_message")
+	defer func() { endSpan(span, err) }()
+
+	return p.stores.Messages.StoreBotMessage(ctx, pageID, platform, threadID, content)
+}
 
+// storeConversationMessage records a user message or a human agent reply so it
+// is part of the history sent to the classifier. Messages without text
+// (attachments, stickers) are skipped; failures are only logged.
+func (p *MessageProcessor) storeConversationMessage(ctx context.Context, pageID, platform, threadID, source, content string) {
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	if err := p.stores.Messages.StoreMessage(ctx, pageID, platform, threadID, source, content); err != nil {
+		LogWarnCtx(ctx, "Could not store %s message: %v", source, err)
+	}
+}
+
+// loadConversationHistory returns up to limit stored messages of a thread, oldest
+// first, as context for sentiment analysis. `currentText` is dropped from the end
+// if the incoming message was already stored.
+func (p *MessageProcessor) loadConversationHistory(ctx context.Context, pageID, platform, threadID, currentText string, limit int) ([]sentiment.Turn, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	newestFirst, err := p.stores.Messages.RecentMessages(ctx, pageID, platform, threadID, limit+1)
 	if err != nil {
-		return fmt.Errorf("error updating conversation user name: %v", err)
+		return nil, err
 	}
 
-	log.Printf("✅ Updated conversation user name to: %s", userName)
-	return nil
+	if len(newestFirst) > 0 && newestFirst[0].Role == "user" &&
+		strings.TrimSpace(newestFirst[0].Content) == strings.TrimSpace(currentText) {
+		newestFirst = newestFirst[1:]
+	}
+	if len(newestFirst) > limit {
+		newestFirst = newestFirst[:limit]
+	}
+
+	history := make([]sentiment.Turn, 0, len(newestFirst))
+	for i := len(newestFirst) - 1; i >= 0; i-- {
+		history = append(history, newestFirst[i])
+	}
+	return history, nil
+}
+
+// updateFrustrationScore folds one analysis into the conversation's running
+// frustration score (an exponential moving average between 0 and 1) and returns it.
+// A frustrated message counts as 1 weighted by its confidence, anything else as 0.
+func (p *MessageProcessor) updateFrustrationScore(ctx context.Context, threadID string, frustration, smoothing float64) (float64, error) {
+	return p.stores.Conversations.UpdateFrustrationScore(ctx, threadID, frustration, smoothing)
+}
+
+// resetFrustrationScore clears the score once the conversation has been escalated,
+// so a reactivated bot starts from a calm conversation
+func (p *MessageProcessor) resetFrustrationScore(ctx context.Context, threadID string) error {
+	return p.stores.Conversations.ResetFrustrationScore(ctx, threadID)
 }
 
 // =============================================================================
@@ -306,13 +198,10 @@ func updateConversationUsername(ctx context.Context, threadID string, userName s
 
 // shouldBotProcessMessage determines if bot should process message based on bot_enabled flag.
 // This is the actual function used for bot control - returns true if bot should respond.
-func shouldBotProcessMessage(ctx context.Context, threadID string) (bool, error) {
-	var botEnabled bool
-	query := "SELECT COALESCE(bot_enabled, true) FROM conversations WHERE thread_id = $1"
-
-	err := db.QueryRowContext(ctx, query, threadID).Scan(&botEnabled)
+func (p *MessageProcessor) shouldBotProcessMessage(ctx context.Context, threadID string) (bool, error) {
+	botEnabled, err := p.stores.Conversations.BotEnabled(ctx, threadID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, ErrNotFound) {
 			LogDebug("No conversation found for thread_id: %s, defaulting to enabled", threadID)
 			return true, nil // New conversations default to bot enabled
 		}
@@ -326,19 +215,17 @@ func shouldBotProcessMessage(ctx context.Context, threadID string) (bool, error)
 
 // checkAndReactivateBots calls the database function to reactivate eligible bots (12-hour rule)
 // Returns the number of bots reactivated, used for logging
-func checkAndReactivateBots(ctx context.Context, requestID string) {
-	var reactivatedCount int
-	err := db.QueryRowContext(ctx, "SELECT reenable_disabled_bots()").Scan(&reactivatedCount)
-
+func (p *MessageProcessor) checkAndReactivateBots(ctx context.Context, requestID string) {
+	reactivatedCount, err := p.stores.Conversations.ReenableDisabledBots(ctx)
 	if err != nil {
-		LogError("[%s] Bot reactivation check failed: %v", requestID, err)
+		LogErrorCtx(ctx, "Bot reactivation check failed: %v", err)
 		return
 	}
 
 	// Only log when bots are actually reactivated to avoid spam
 	if reactivatedCount > 0 {
-		LogInfo("[%s] 🔄 Reactivated %d bots (12-hour rule)", requestID, reactivatedCount)
+		LogInfoCtx(ctx, "🔄 Reactivated %d bots (12-hour rule)", reactivatedCount)
 	} else {
-		LogDebug("[%s] No bots eligible for reactivation", requestID)
+		LogDebugCtx(ctx, "No bots eligible for reactivation")
 	}
 }
@@ -4,109 +4,325 @@ package main
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	mathrand "math/rand"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"logging"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// getDifyApiKey retrieves the Dify API key for a specific page from database
-// Each client/page has their own Dify app with unique API key (multi-tenant)
-func getDifyApiKey(ctx context.Context, pageID string, platform string) (string, error) {
+// getDifyBackends retrieves the Dify backends configured for a specific page from the page store
+// Each client/page has their own Dify app with unique API key (multi-tenant). A page can
+// optionally configure a secondary app key (and base URL, e.g. a self-hosted Dify) that
+// the fallback chain uses when the primary app fails.
+func (p *MessageProcessor) getDifyBackends(ctx context.Context, pageID string, platform string) (primary DifyBackend, secondary *DifyBackend, err error) {
 	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var difyAPIKey string
-	err := db.QueryRowContext(queryCtx,
-		"SELECT dify_api_key FROM social_pages WHERE page_id = $1 AND platform = $2 AND status = 'active'",
-		pageID, platform,
-	).Scan(&difyAPIKey)
-
+	keys, err := p.stores.Pages.GetDifyKeys(queryCtx, pageID, platform)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, ErrNotFound) {
 			log.Printf("❌ No active Dify API key found for page %s", pageID)
-			return "", fmt.Errorf("no active Dify API key found for page %s", pageID)
+			return primary, nil, fmt.Errorf("no active Dify API key found for page %s", pageID)
 		}
 		log.Printf("❌ Database error querying Dify API key: %v", err)
-		return "", fmt.Errorf("database error: %v", err)
+		return primary, nil, err
+	}
+	difyAPIKey, fallbackKey, fallbackURL := keys.APIKey, keys.FallbackAPIKey, keys.FallbackBaseURL
+
+	if fallbackKey != "" {
+		if fallbackURL == "" {
+			fallbackURL = config.DifyBaseURL
+		}
+		secondary = &DifyBackend{Name: "secondary", APIKey: fallbackKey, BaseURL: fallbackURL}
 	}
 
 	if difyAPIKey == "" {
 		log.Printf("❌ Empty Dify API key for page %s", pageID)
-		return "", fmt.Errorf("empty Dify API key for page %s", pageID)
+		return primary, secondary, fmt.Errorf("empty Dify API key for page %s", pageID)
 	}
 
-	log.Printf("✅ Found Dify API key for page %s (key: app-...%s)", pageID, difyAPIKey[len(difyAPIKey)-8:])
-	return difyAPIKey, nil
+	log.Printf("✅ Found Dify API key for page %s (key: app-...%s, fallback configured: %v)",
+		pageID, difyKeySuffix(difyAPIKey), secondary != nil)
+	return DifyBackend{Name: "primary", APIKey: difyAPIKey, BaseURL: config.DifyBaseURL}, secondary, nil
 }
 
 // forwardToDify sends a message to Dify API (replaces forwardToBotpress)
-func forwardToDify(ctx context.Context, pageID string, msg MessagingEntry, platform string) error {
+//
+// With keepContext the stored Dify conversation ID is reused and updated, which is
+// what the primary app does. Secondary backends are separate Dify apps that don't
+// know the primary's conversation IDs, so they are called without context.
+func (p *MessageProcessor) forwardToDify(ctx context.Context, pageID string, msg MessagingEntry, platform string, backend DifyBackend, keepContext bool) (err error) {
+	ctx, span := startSpan(ctx, "dify.forward",
+		attribute.String("dify.backend", backend.Name),
+		attribute.Bool("dify.keep_context", keepContext),
+	)
+	defer func() { endSpan(span, err) }()
+
 	// Get existing conversation state to retrieve any existing Dify conversation ID
-	conv, err := getOrCreateConversation(ctx, pageID, msg.Sender.ID, platform)
+	conv, err := p.getOrCreateConversation(ctx, pageID, msg.Sender.ID, platform)
 	if err != nil {
 		return fmt.Errorf("error getting conversation state: %v", err)
 	}
 
+	conversationID := ""
+	if keepContext {
+		conversationID = conv.DifyConversationID
+	}
+
 	// Create Dify request with existing conversation ID if available
 	difyReq := DifyRequest{
 		Inputs:         map[string]interface{}{}, // Empty for simple chat
 		Query:          msg.Message.Text,
 		ResponseMode:   "blocking",                                  // Get immediate response
 		User:           fmt.Sprintf("%s-%s", pageID, msg.Sender.ID), // Unique user ID
-		ConversationId: conv.DifyConversationID,                     // Use existing conversation ID or empty for new
+		ConversationId: conversationID,                              // Use existing conversation ID or empty for new
 		Files:          []interface{}{},                             // No files for now
 	}
 
 	// Log conversation continuation
-	if conv.DifyConversationID != "" {
-		log.Printf("🔄 Continuing existing Dify conversation: %s", conv.DifyConversationID)
+	if conversationID != "" {
+		log.Printf("🔄 Continuing existing Dify conversation: %s", conversationID)
 	} else {
-		log.Printf("🆕 Starting new Dify conversation for thread: %s", msg.Sender.ID)
+		log.Printf("🆕 Starting new %s Dify conversation for thread: %s", backend.Name, msg.Sender.ID)
 	}
 
-	// Get Dify API key
-	apiKey, err := getDifyApiKey(ctx, pageID, platform)
+	// Send to Dify with retries
+	response, err := sendToDifyWithRetry(ctx, backend, difyReq)
+	if err != nil && difyReq.ConversationId != "" && isDifyConversationNotFound(err) {
+		// The stored conversation was deleted or expired on the Dify side - drop it
+		// and start a fresh conversation instead of failing the whole message
+		log.Printf("♻️ Dify conversation %s no longer exists - starting a new one for thread: %s",
+			difyReq.ConversationId, msg.Sender.ID)
+		if clearErr := p.clearDifyConversationID(ctx, msg.Sender.ID); clearErr != nil {
+			log.Printf("⚠️ Could not clear stale Dify conversation ID: %v", clearErr)
+		}
+		difyReq.ConversationId = ""
+		response, err = sendToDifyWithRetry(ctx, backend, difyReq)
+	}
 	if err != nil {
-		return fmt.Errorf("error getting Dify API key: %v", err)
+		return err
 	}
 
-	// Send to Dify with retries
-	response, err := sendToDifyWithRetry(ctx, apiKey, difyReq)
+	// Record tokens and cost for per-client billing
+	p.recordLLMUsage(ctx, difyUsage(pageID, platform, msg.Sender.ID, backend, response))
+
+	// Check the answer against the page's safety policy before it reaches the user
+	response, err = p.enforceAnswerSafety(ctx, pageID, platform, conv, msg, backend, difyReq, response, keepContext)
 	if err != nil {
 		return err
 	}
 
+	if !keepContext {
+		response.ConversationId = "" // Don't overwrite the primary app's context
+	}
+
 	// Handle the response immediately (unlike Botpress webhooks)
-	return handleDifyResponseDirect(ctx, pageID, msg.Sender.ID, platform, response)
+	return p.handleDifyResponseDirect(ctx, pageID, msg.Sender.ID, platform, response)
 }
 
 // sendToDifyWithRetry sends request to Dify with retry logic (replaces sendToBotpressWithRetry)
-func sendToDifyWithRetry(ctx context.Context, apiKey string, payload DifyRequest) (*DifyResponse, error) {
-	maxRetries := 3
-	var lastErr error
+//
+// Errors are classified before retrying: only transient failures (timeouts,
+// connection errors, 5xx and 429) are retried, using a jittered exponential
+// backoff that honours Retry-After and stops as soon as ctx is cancelled.
+// Permanent failures such as 400 or an invalid API key return immediately.
+//
+// Each Dify API key has its own circuit breaker. While a key's circuit is open
+// the call fails fast with ErrCircuitOpen so callers can send the degraded-mode
+// reply instead of stacking more retries on a backend that is already down.
+func sendToDifyWithRetry(ctx context.Context, backend DifyBackend, payload DifyRequest) (*DifyResponse, error) {
+	apiKey := backend.APIKey
+	breaker := difyBreakers.Get(apiKey)
+	if !breaker.Allow() {
+		return nil, fmt.Errorf("dify unavailable for key app-...%s: %w", difyKeySuffix(apiKey), ErrCircuitOpen)
+	}
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if response, err := sendToDify(ctx, apiKey, payload); err != nil {
-			lastErr = err
-			log.Printf("⚠️ Dify attempt %d failed: %v", attempt+1, err)
-			time.Sleep(time.Second * time.Duration(attempt+1))
-			continue
-		} else {
+	maxRetries := config.DifyMaxRetries
+	if maxRetries < 1 {
+		maxRetries = 1
+	}
+
+	var lastErr error
+	attempts := 0
+	for attempts < maxRetries {
+		attempts++
+		response, err := sendToDify(ctx, backend, payload)
+		if err == nil {
+			breaker.RecordSuccess()
 			return response, nil
 		}
+		lastErr = err
+		if ctx.Err() != nil {
+			// The caller gave up - that says nothing about Dify's health
+			breaker.Abandon()
+			return nil, err
+		}
+
+		class, retryAfter := classifyDifyError(err)
+		switch class {
+		case difyErrorConversationNotFound:
+			// Dify is healthy, the conversation is just gone - let the caller reset it
+			breaker.RecordSuccess()
+			return nil, err
+		case difyErrorPermanent:
+			if isDifyAuthError(err) {
+				// A revoked or invalid key will never succeed - trip the breaker for it
+				recordDifyFailure(breaker, apiKey)
+			} else {
+				breaker.RecordSuccess()
+			}
+			log.Printf("❌ Dify attempt %d failed with a permanent error, not retrying: %v", attempts, err)
+			return nil, err
+		}
+
+		log.Printf("⚠️ Dify attempt %d/%d failed: %v", attempts, maxRetries, err)
+		if attempts >= maxRetries {
+			break
+		}
+		if retryAfter > maxDifyRetryAfter {
+			log.Printf("⚠️ Dify asked us to wait %v - giving up instead of blocking", retryAfter)
+			break
+		}
+
+		if err := sleepWithContext(ctx, difyBackoff(attempts, retryAfter)); err != nil {
+			breaker.Abandon()
+			return nil, fmt.Errorf("dify retry aborted after %d attempts: %w", attempts, err)
+		}
+	}
+
+	recordDifyFailure(breaker, apiKey)
+	return nil, fmt.Errorf("failed after %d attempts: %w", attempts, lastErr)
+}
+
+// difyErrorClass groups Dify failures by how the caller should react to them
+type difyErrorClass int
+
+const (
+	difyErrorRetryable            difyErrorClass = iota // Timeouts, connection errors, 5xx, 429
+	difyErrorPermanent                                  // 4xx, malformed responses, cancelled ctx
+	difyErrorConversationNotFound                       // Stored conversation_id is unknown to Dify
+)
+
+const (
+	difyBackoffBase   = 500 * time.Millisecond
+	difyBackoffMax    = 8 * time.Second
+	maxDifyRetryAfter = 30 * time.Second // Longer Retry-After values aren't worth blocking a webhook worker for
+)
+
+// classifyDifyError decides whether err is worth retrying and returns any
+// server-provided Retry-After delay
+func classifyDifyError(err error) (difyErrorClass, time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		return difyErrorPermanent, 0
+	}
+
+	var apiErr *DifyAPIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case isDifyConversationNotFound(err):
+			return difyErrorConversationNotFound, 0
+		case apiErr.StatusCode == http.StatusTooManyRequests,
+			apiErr.StatusCode == http.StatusRequestTimeout,
+			apiErr.StatusCode >= 500:
+			return difyErrorRetryable, apiErr.RetryAfter
+		default:
+			return difyErrorPermanent, 0
+		}
+	}
+
+	// Transport-level failures (timeouts, resets, DNS) are transient
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return difyErrorRetryable, 0
+	}
+
+	// Anything else (marshal/parse errors) won't fix itself on retry
+	return difyErrorPermanent, 0
+}
+
+// isDifyAuthError reports whether Dify rejected the API key itself
+func isDifyAuthError(err error) bool {
+	var apiErr *DifyAPIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// recordDifyFailure counts a failure against the key's breaker and logs when it opens
+func recordDifyFailure(breaker *CircuitBreaker, apiKey string) {
+	if breaker.RecordFailure() {
+		LogWarn("🔌 Dify circuit OPEN for key app-...%s - degraded mode for %v",
+			difyKeySuffix(apiKey), config.DifyBreakerCooldown)
+	}
+}
+
+// difyBackoff returns the delay before retry number `attempt` (1-based):
+// exponential with equal jitter, but never shorter than the server's Retry-After
+func difyBackoff(attempt int, retryAfter time.Duration) time.Duration {
+	ceiling := difyBackoffBase << (attempt - 1)
+	if ceiling > difyBackoffMax || ceiling <= 0 {
+		ceiling = difyBackoffMax
+	}
+	// Equal jitter (half the ceiling plus a random half) keeps concurrent workers
+	// from retrying in lockstep while still backing off at least ceiling/2
+	wait := ceiling/2 + time.Duration(mathrand.Int63n(int64(ceiling/2)+1))
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	return wait
+}
+
+// sleepWithContext waits for d or until ctx is done, whichever comes first
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
 	}
+	return 0
+}
 
-	return nil, fmt.Errorf("failed after %d attempts: %v", maxRetries, lastErr)
+// difyKeySuffix returns the last characters of an API key, safe to log
+func difyKeySuffix(apiKey string) string {
+	if len(apiKey) <= 8 {
+		return apiKey
+	}
+	return apiKey[len(apiKey)-8:]
 }
 
 // sendToDify sends the actual request to Dify API (replaces sendToBotpress)
-func sendToDify(ctx context.Context, apiKey string, payload DifyRequest) (*DifyResponse, error) {
+func sendToDify(ctx context.Context, backend DifyBackend, payload DifyRequest) (*DifyResponse, error) {
 	// Dify API endpoint
-	apiURL := "https://api.dify.ai/v1/chat-messages"
+	apiURL := strings.TrimRight(backend.BaseURL, "/") + "/chat-messages"
 
 	// Convert payload to JSON
 	jsonData, err := json.Marshal(payload)
@@ -122,7 +338,7 @@ func sendToDify(ctx context.Context, apiKey string, payload DifyRequest) (*DifyR
 
 	// Add required headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Authorization", "Bearer "+backend.APIKey)
 
 	// Log payload details only in debug mode
 	LogDebug("🤖 Dify request payload: %s", string(jsonData))
@@ -131,7 +347,8 @@ func sendToDify(ctx context.Context, apiKey string, payload DifyRequest) (*DifyR
 	start := time.Now()
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request to Dify: %v", err)
+		observeDifyRequest(backend.Name, 0, time.Since(start))
+		return nil, fmt.Errorf("error sending request to Dify: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -143,19 +360,21 @@ func sendToDify(ctx context.Context, apiKey string, payload DifyRequest) (*DifyR
 
 	// Log response timing and basic status
 	responseTime := time.Since(start)
+	observeDifyRequest(backend.Name, resp.StatusCode, responseTime)
 	LogDebug("📥 Dify response: %d in %dms", resp.StatusCode, responseTime.Milliseconds())
 
 	// Log full response body only in debug mode
-	LogDebug("Dify response body: %s", string(respBody))
+	LogDebug("Dify response body: %s", logging.Text(string(respBody)))
 
 	// Handle different response scenarios
 	if resp.StatusCode != http.StatusOK {
 		// Try to parse as error response
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
 		var errorResp DifyErrorResponse
 		if err := json.Unmarshal(respBody, &errorResp); err == nil {
-			return nil, fmt.Errorf("dify error: %s (code: %s)", errorResp.Message, errorResp.Code)
+			return nil, &DifyAPIError{StatusCode: resp.StatusCode, Code: errorResp.Code, Message: errorResp.Message, RetryAfter: retryAfter}
 		}
-		return nil, fmt.Errorf("unexpected status code from Dify: %d - %s", resp.StatusCode, string(respBody))
+		return nil, &DifyAPIError{StatusCode: resp.StatusCode, Message: string(respBody), RetryAfter: retryAfter}
 	}
 
 	// Parse successful response
@@ -168,8 +387,22 @@ func sendToDify(ctx context.Context, apiKey string, payload DifyRequest) (*DifyR
 	return &difyResp, nil
 }
 
+// isDifyConversationNotFound reports whether err means the conversation_id we sent
+// is unknown to Dify (deleted, expired, or created under a different app key).
+// Dify answers these with a 404 and the "not_found" code.
+func isDifyConversationNotFound(err error) bool {
+	var apiErr *DifyAPIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+	return apiErr.Code == "not_found" || strings.Contains(strings.ToLower(apiErr.Message), "conversation")
+}
+
 // handleDifyResponseDirect processes Dify response immediately (replaces webhook-based handleBotpressResponse)
-func handleDifyResponseDirect(ctx context.Context, pageID, senderID, platform string, response *DifyResponse) error {
+func (p *MessageProcessor) handleDifyResponseDirect(ctx context.Context, pageID, senderID, platform string, response *DifyResponse) error {
 	log.Printf("📥 Processing Dify response for conversation")
 
 	// Validate response
@@ -179,7 +412,7 @@ func handleDifyResponseDirect(ctx context.Context, pageID, senderID, platform st
 
 	// Store/update the Dify conversation ID for future context
 	if response.ConversationId != "" {
-		if err := updateDifyConversationID(ctx, senderID, response.ConversationId); err != nil {
+		if err := p.updateDifyConversationID(ctx, senderID, response.ConversationId); err != nil {
 			log.Printf("⚠️ Could not store Dify conversation ID: %v", err)
 		} else {
 			log.Printf("💾 Stored Dify conversation ID: %s for thread: %s", response.ConversationId, senderID)
@@ -187,32 +420,88 @@ func handleDifyResponseDirect(ctx context.Context, pageID, senderID, platform st
 	}
 
 	// Get page info to determine platform details
-	pageInfo, err := getPageInfo(ctx, pageID, platform)
+	pageInfo, err := getPageInfo(ctx, p.stores.Pages, pageID, platform)
 	if err != nil {
 		return fmt.Errorf("error getting page info: %v", err)
 	}
 
 	// Send response to the user via appropriate platform
 	if err := sendPlatformResponse(ctx, pageInfo, senderID, response.Answer); err != nil {
+		if replyMaybeSent(err) {
+			return fmt.Errorf("error sending platform response: %w: %v", ErrReplyMaybeSent, err)
+		}
 		return fmt.Errorf("error sending platform response: %v", err)
 	}
 
-	log.Printf("✅ Platform response sent successfully - no storage needed")
+	log.Printf("✅ Platform response sent successfully")
+	if err := p.storeBotMessage(ctx, pageID, platform, senderID, response.Answer); err != nil {
+		log.Printf("⚠️ Could not store Dify answer: %v", err)
+	}
 	return nil
 }
 
 // updateDifyConversationID stores the Dify conversation ID for maintaining context
-func updateDifyConversationID(ctx context.Context, threadID string, difyConversationID string) error {
-	_, err := db.ExecContext(ctx, `
-        UPDATE conversations 
-        SET dify_conversation_id = $1,
-            updated_at = NOW()
-        WHERE thread_id = $2
-    `, difyConversationID, threadID)
+func (p *MessageProcessor) updateDifyConversationID(ctx context.Context, threadID string, difyConversationID string) error {
+	return p.stores.Conversations.SetDifyConversationID(ctx, threadID, difyConversationID)
+}
 
-	if err != nil {
-		return fmt.Errorf("error updating Dify conversation ID: %v", err)
-	}
+// clearDifyConversationID forgets the stored Dify conversation so the next message
+// starts a new conversation (and therefore a fresh AI context) on the Dify side
+func (p *MessageProcessor) clearDifyConversationID(ctx context.Context, threadID string) error {
+	return p.stores.Conversations.SetDifyConversationID(ctx, threadID, "")
+}
 
-	return nil
+// handleResetConversationContext resets the AI context of a conversation on demand.
+// POST /api/conversations/{threadId}/reset-context
+//
+// The conversation must belong to one of the authenticated client's pages. The
+// stored Dify conversation ID is cleared, so the next user message is answered
+// in a brand new Dify conversation without any previous history.
+func handleResetConversationContext(conversations ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/api/conversations/")
+		parts := strings.Split(path, "/")
+		if len(parts) < 2 || parts[0] == "" || parts[1] != "reset-context" {
+			http.Error(w, "Invalid conversation endpoint", http.StatusBadRequest)
+			return
+		}
+		threadID := parts[0]
+
+		clientID := r.Header.Get("X-Client-ID")
+		if clientID == "" {
+			http.Error(w, "Client ID required", http.StatusUnauthorized)
+			return
+		}
+
+		previousID, err := conversations.ClientDifyConversationID(r.Context(), threadID, clientID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				http.Error(w, "Conversation not found or access denied", http.StatusNotFound)
+				return
+			}
+			LogError("Error looking up conversation %s: %v", threadID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := conversations.SetDifyConversationID(r.Context(), threadID, ""); err != nil {
+			LogError("Error resetting AI context for %s: %v", threadID, err)
+			http.Error(w, "Failed to reset AI context", http.StatusInternalServerError)
+			return
+		}
+
+		LogInfo("♻️ AI context reset for thread %s (previous Dify conversation: %q)", threadID, previousID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"thread_id": threadID,
+			"message":   "AI context reset successfully",
+		})
+	}
 }
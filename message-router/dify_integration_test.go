@@ -0,0 +1,115 @@
+// dify_integration_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyDifyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		class      difyErrorClass
+		retryAfter time.Duration
+	}{
+		{"cancelled", fmt.Errorf("request failed: %w", context.Canceled), difyErrorPermanent, 0},
+		{"deadline", fmt.Errorf("request failed: %w", context.DeadlineExceeded), difyErrorRetryable, 0},
+		{"connection reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, difyErrorRetryable, 0},
+		{"rate limited", &DifyAPIError{StatusCode: 429, RetryAfter: 3 * time.Second}, difyErrorRetryable, 3 * time.Second},
+		{"request timeout", &DifyAPIError{StatusCode: 408}, difyErrorRetryable, 0},
+		{"server error", fmt.Errorf("wrapped: %w", &DifyAPIError{StatusCode: 502}), difyErrorRetryable, 0},
+		{"bad request", &DifyAPIError{StatusCode: 400, Code: "invalid_param"}, difyErrorPermanent, 0},
+		{"unauthorized", &DifyAPIError{StatusCode: 401}, difyErrorPermanent, 0},
+		{"conversation gone", &DifyAPIError{StatusCode: 404, Code: "not_found", Message: "Conversation Not Exists."}, difyErrorConversationNotFound, 0},
+		{"malformed response", errors.New("error parsing response"), difyErrorPermanent, 0},
+	}
+	for _, tt := range tests {
+		class, retryAfter := classifyDifyError(tt.err)
+		if class != tt.class || retryAfter != tt.retryAfter {
+			t.Errorf("%s: classifyDifyError() = %v, %v, want %v, %v", tt.name, class, retryAfter, tt.class, tt.retryAfter)
+		}
+	}
+}
+
+func TestDifyBackoff(t *testing.T) {
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{1, 0, 250 * time.Millisecond, 500 * time.Millisecond},
+		{2, 0, 500 * time.Millisecond, time.Second},
+		{4, 0, 2 * time.Second, 4 * time.Second},
+		{5, 0, 4 * time.Second, difyBackoffMax},
+		{10, 0, difyBackoffMax / 2, difyBackoffMax},  // Capped
+		{100, 0, difyBackoffMax / 2, difyBackoffMax}, // Shift overflow
+		{1, 5 * time.Second, 5 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ { // Jitter
+			if got := difyBackoff(tt.attempt, tt.retryAfter); got < tt.min || got > tt.max {
+				t.Fatalf("difyBackoff(%d, %v) = %v, want between %v and %v", tt.attempt, tt.retryAfter, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"5", 5 * time.Second, 5 * time.Second},
+		{" 12 ", 12 * time.Second, 12 * time.Second},
+		{"0", 0, 0},
+		{"-3", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+// cancellingTransport answers every request with a 503 and then cancels the
+// caller's context, as if the webhook gave up during the backoff
+type cancellingTransport struct {
+	cancel context.CancelFunc
+}
+
+func (c cancellingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.cancel()
+	return jsonResponse(http.StatusServiceUnavailable, `{"code":"unavailable","message":"busy"}`), nil
+}
+
+// TestSendToDifyWithRetryCancelled checks that a cancelled caller never counts
+// as a Dify failure
+func TestSendToDifyWithRetryCancelled(t *testing.T) {
+	oldConfig, oldTransport, oldBreakers := config, httpClient.Transport, difyBreakers
+	t.Cleanup(func() {
+		config, httpClient.Transport, difyBreakers = oldConfig, oldTransport, oldBreakers
+	})
+	config.DifyMaxRetries = 3
+	difyBreakers = newCircuitBreakerRegistry(1, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	httpClient.Transport = cancellingTransport{cancel: cancel}
+	backend := DifyBackend{Name: "primary", APIKey: testDifyKey, BaseURL: testDifyBase}
+
+	if _, err := sendToDifyWithRetry(ctx, backend, DifyRequest{Query: "Hola"}); err == nil {
+		t.Fatal("sendToDifyWithRetry() succeeded after the context was cancelled")
+	}
+	if difyBreakers.Get(testDifyKey).IsOpen() {
+		t.Error("a cancelled request opened the circuit breaker")
+	}
+}
@@ -5,28 +5,55 @@ import (
 	"bytes"
 	"context"
 	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256" // Added missing import
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"graph"
+	"logging"
 )
 
-// validateFacebookRequest is middleware to validate webhook requests
+// WebhookSecret is an app secret accepted on webhook signatures. Name is what
+// logs and metrics report when a delivery matches it (facebook, instagram,
+// facebook_previous, ...)
+type WebhookSecret struct {
+	Name   string
+	Secret string
+}
+
+// errSignatureMismatch is returned when a well-formed signature matches none
+// of the active secrets
+var errSignatureMismatch = errors.New("signature matches no active app secret")
+
+// validateFacebookRequest is middleware to validate webhook requests. Facebook
+// sends X-Hub-Signature-256 and, on older subscriptions, only the legacy sha1
+// X-Hub-Signature; either is checked against every secret in config.WebhookSecrets
 func validateFacebookRequest(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("📥 Incoming %s request from %s", r.Method, r.RemoteAddr)
 
 		if r.Method == "POST" {
 			signature := r.Header.Get("X-Hub-Signature-256")
+			if signature == "" {
+				signature = r.Header.Get("X-Hub-Signature")
+			}
 			if signature == "" {
 				log.Printf("❌ Missing signature header")
+				webhookSignatures.WithLabelValues("missing", "none").Inc()
 				http.Error(w, "Missing signature", http.StatusUnauthorized)
 				return
 			}
-			log.Printf("✅ Signature header present: %s", signature)
 
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
@@ -36,116 +63,133 @@ func validateFacebookRequest(next http.HandlerFunc) http.HandlerFunc {
 			}
 			r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-			appSecret := []byte(config.FacebookAppSecret)
-			expectedSig := generateFacebookSignature(body, appSecret)
-
-			if !hmac.Equal([]byte(signature[7:]), []byte(expectedSig)) {
-				log.Printf("❌ Invalid signature")
+			secret, algorithm, err := verifyWebhookSignature(body, signature, config.WebhookSecrets)
+			if err != nil {
+				log.Printf("❌ Invalid signature: %v", err)
+				webhookSignatures.WithLabelValues("invalid", algorithm).Inc()
 				http.Error(w, "Invalid signature", http.StatusUnauthorized)
 				return
 			}
-			log.Printf("✅ Signature verified successfully")
+			log.Printf("✅ Signature verified (%s, %s secret)", algorithm, secret)
+			webhookSignatures.WithLabelValues(secret, algorithm).Inc()
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.String("webhook.signature.secret", secret),
+				attribute.String("webhook.signature.algorithm", algorithm),
+			)
 		}
 		next(w, r)
 	}
 }
 
-// generateFacebookSignature creates HMAC SHA256 signature for request verification
-func generateFacebookSignature(body []byte, secret []byte) string {
-	mac := hmac.New(sha256.New, secret)
-	mac.Write(body)
-	return hex.EncodeToString(mac.Sum(nil))
-}
-
-// sendFacebookMessage sends a message to a user through Facebook Messenger
-func sendFacebookMessage(ctx context.Context, pageID string, pageToken string, recipientID string, message string) error {
-	fbPayload := map[string]interface{}{
-		"recipient": map[string]string{
-			"id": recipientID,
-		},
-		"message": map[string]string{
-			"text": message,
-		},
+// verifyWebhookSignature checks a "sha256=<hex>" or "sha1=<hex>" header value
+// against each secret in order and returns the name of the one that signed body,
+// along with the algorithm ("unknown" when the header can't be parsed)
+func verifyWebhookSignature(body []byte, header string, secrets []WebhookSecret) (secret, algorithm string, err error) {
+	algorithm, digest, ok := strings.Cut(header, "=")
+	if !ok {
+		return "", "unknown", errors.New("malformed signature header")
 	}
 
-	jsonData, err := json.Marshal(fbPayload)
-	if err != nil {
-		return fmt.Errorf("error creating Facebook payload: %v", err)
+	var newHash func() hash.Hash
+	switch algorithm {
+	case "sha256":
+		newHash = sha256.New
+	case "sha1":
+		newHash = sha1.New
+	default:
+		return "", "unknown", fmt.Errorf("unsupported signature algorithm %q", algorithm)
 	}
 
-	fbURL := fmt.Sprintf("https://graph.facebook.com/v23.0/%s/messages?access_token=%s",
-		pageID, pageToken)
-
-	// Log payload details only in debug mode
-	LogDebug("📤 Facebook payload: %s", string(jsonData))
-
-	req, err := http.NewRequestWithContext(ctx, "POST", fbURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating Facebook request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("error sending to Facebook: %v", err)
+	expected, err := hex.DecodeString(digest)
+	if err != nil || len(expected) != newHash().Size() {
+		return "", algorithm, errors.New("malformed signature digest")
 	}
-	defer resp.Body.Close()
 
-	fbResp, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("facebook error (status %d): %s", resp.StatusCode, string(fbResp))
+	for _, s := range secrets {
+		if s.Secret == "" {
+			continue
+		}
+		mac := hmac.New(newHash, []byte(s.Secret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return s.Name, algorithm, nil
+		}
 	}
-
-	LogDebug("✅ Facebook response (%d): %s", resp.StatusCode, string(fbResp))
-	return nil
+	return "", algorithm, errSignatureMismatch
 }
 
-func sendInstagramMessage(ctx context.Context, pageToken string, recipientID string, message string) error {
-	// Instagram uses a different endpoint format
-	igURL := fmt.Sprintf("https://graph.facebook.com/v23.0/me/messages?access_token=%s", pageToken)
+// loadWebhookSecrets builds the list of secrets accepted on webhook signatures:
+// the Facebook and Instagram app secrets, then the previous ones while they are
+// being rotated out (FACEBOOK_APP_SECRET_PREVIOUS, INSTAGRAM_APP_SECRET_PREVIOUS)
+func loadWebhookSecrets(facebookSecret, instagramSecret string) []WebhookSecret {
+	candidates := []WebhookSecret{
+		{Name: "facebook", Secret: facebookSecret},
+		{Name: "instagram", Secret: instagramSecret},
+		{Name: "facebook_previous", Secret: os.Getenv("FACEBOOK_APP_SECRET_PREVIOUS")},
+		{Name: "instagram_previous", Secret: os.Getenv("INSTAGRAM_APP_SECRET_PREVIOUS")},
+	}
 
-	// Log message details for debugging
-	log.Printf("📤 Instagram message (length: %d chars): %q", len(message), message)
-
-	igPayload := map[string]interface{}{
-		"recipient": map[string]string{
-			"id": recipientID,
-		},
-		"message": map[string]string{
-			"text": message,
-		},
-		"messaging_type": "RESPONSE",
+	var secrets []WebhookSecret
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if c.Secret == "" || seen[c.Secret] {
+			continue
+		}
+		seen[c.Secret] = true
+		secrets = append(secrets, c)
 	}
+	return secrets
+}
 
-	jsonData, err := json.Marshal(igPayload)
-	if err != nil {
-		return fmt.Errorf("error creating Instagram payload: %v", err)
+// webhookSecretNames lists the secret names for the startup log
+func webhookSecretNames(secrets []WebhookSecret) string {
+	names := make([]string, len(secrets))
+	for i, s := range secrets {
+		names[i] = s.Name
 	}
+	return strings.Join(names, ", ")
+}
 
-	// Log payload details only in debug mode
-	LogDebug("📤 Instagram payload: %s", string(jsonData))
+// generateFacebookSignature creates HMAC SHA256 signature for request verification
+func generateFacebookSignature(body []byte, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", igURL, bytes.NewBuffer(jsonData))
+// sendFacebookMessage sends a message to a user through Facebook Messenger
+func sendFacebookMessage(ctx context.Context, pageID string, pageToken string, recipientID string, message string) error {
+	result, err := graphClient.SendText(ctx, pageToken, graph.TextMessage{
+		PageID:      pageID,
+		RecipientID: recipientID,
+		Text:        message,
+	})
 	if err != nil {
-		return fmt.Errorf("error creating Instagram request: %v", err)
+		observeGraphAPIError("facebook", "send", err)
+		return fmt.Errorf("error sending to Facebook: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	LogDebug("✅ Facebook message sent: %s", result.MessageID)
+	return nil
+}
 
-	resp, err := httpClient.Do(req)
+func sendInstagramMessage(ctx context.Context, pageToken string, recipientID string, message string) error {
+	// Log message details for debugging
+	log.Printf("📤 Instagram message (length: %d chars): %q", len(message), logging.Text(message))
+
+	// Instagram sends as "me", the account the page token belongs to
+	result, err := graphClient.SendText(ctx, pageToken, graph.TextMessage{
+		RecipientID:   recipientID,
+		Text:          message,
+		MessagingType: "RESPONSE",
+	})
 	if err != nil {
-		return fmt.Errorf("error sending to Instagram: %v", err)
-	}
-	defer resp.Body.Close()
-
-	igResp, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("❌ Instagram API error for message (length: %d): %q", len(message), message)
-		return fmt.Errorf("instagram error (status %d): %s", resp.StatusCode, string(igResp))
+		observeGraphAPIError("instagram", "send", err)
+		log.Printf("❌ Instagram API error for message (length: %d): %q", len(message), logging.Text(message))
+		return fmt.Errorf("error sending to Instagram: %w", err)
 	}
 
-	LogDebug("✅ Instagram response (%d): %s", resp.StatusCode, string(igResp))
+	LogDebug("✅ Instagram message sent: %s", result.MessageID)
 	return nil
 }
 
@@ -0,0 +1,115 @@
+// facebook_test.go
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func sha1Signature(body []byte, secret string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"object":"page","entry":[]}`)
+	secrets := []WebhookSecret{
+		{Name: "facebook", Secret: "fb-current"},
+		{Name: "instagram", Secret: "ig-current"},
+		{Name: "facebook_previous", Secret: "fb-old"},
+	}
+
+	tests := []struct {
+		name          string
+		header        string
+		wantSecret    string
+		wantAlgorithm string
+		wantErr       bool
+	}{
+		{"facebook sha256", signPayload(body, "fb-current"), "facebook", "sha256", false},
+		{"instagram sha256", signPayload(body, "ig-current"), "instagram", "sha256", false},
+		{"previous secret during rotation", signPayload(body, "fb-old"), "facebook_previous", "sha256", false},
+		{"legacy sha1", sha1Signature(body, "ig-current"), "instagram", "sha1", false},
+		{"unknown secret", signPayload(body, "someone-else"), "", "sha256", true},
+		{"signature of another body", signPayload([]byte("{}"), "fb-current"), "", "sha256", true},
+		{"empty", "", "", "unknown", true},
+		{"shorter than the prefix", "sha", "", "unknown", true},
+		{"prefix only", "sha256=", "", "sha256", true},
+		{"not hex", "sha256=zz", "", "sha256", true},
+		{"truncated digest", signPayload(body, "fb-current")[:20], "", "sha256", true},
+		{"unsupported algorithm", "md5=d41d8cd98f00b204e9800998ecf8427e", "", "unknown", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, algorithm, err := verifyWebhookSignature(body, tt.header, secrets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secret != tt.wantSecret || algorithm != tt.wantAlgorithm {
+				t.Errorf("got (%q, %q), want (%q, %q)", secret, algorithm, tt.wantSecret, tt.wantAlgorithm)
+			}
+		})
+	}
+}
+
+func TestValidateFacebookRequestHeaders(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+	config.WebhookSecrets = []WebhookSecret{{Name: "facebook", Secret: "fb-current"}}
+
+	body := []byte(`{"object":"page","entry":[]}`)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"sha256 header", map[string]string{"X-Hub-Signature-256": signPayload(body, "fb-current")}, http.StatusOK},
+		{"legacy sha1 header", map[string]string{"X-Hub-Signature": sha1Signature(body, "fb-current")}, http.StatusOK},
+		{"missing", nil, http.StatusUnauthorized},
+		{"short", map[string]string{"X-Hub-Signature-256": "sha2"}, http.StatusUnauthorized},
+		{"wrong secret", map[string]string{"X-Hub-Signature-256": signPayload(body, "fb-old")}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			handler := validateFacebookRequest(func(w http.ResponseWriter, r *http.Request) {
+				var buf bytes.Buffer
+				buf.ReadFrom(r.Body)
+				got = buf.Bytes()
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !bytes.Equal(got, body) {
+				t.Errorf("handler read body %q, want it restored to %q", got, body)
+			}
+		})
+	}
+}
+
+func TestLoadWebhookSecrets(t *testing.T) {
+	t.Setenv("FACEBOOK_APP_SECRET_PREVIOUS", "fb-old")
+	t.Setenv("INSTAGRAM_APP_SECRET_PREVIOUS", "")
+
+	// The same app secret configured twice is only tried once
+	got := webhookSecretNames(loadWebhookSecrets("fb-current", "fb-current"))
+	if want := "facebook, facebook_previous"; got != want {
+		t.Errorf("secrets = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,218 @@
+// fallback_chain.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"graph"
+)
+
+// =============================================================================
+// REPLY FALLBACK CHAIN - Who answers when the primary AI backend fails
+// =============================================================================
+
+// ReplyTier identifies which stage of the fallback chain answered a message
+type ReplyTier string
+
+const (
+	ReplyTierPrimary   ReplyTier = "dify_primary"   // The page's main Dify app
+	ReplyTierSecondary ReplyTier = "dify_secondary" // The page's fallback Dify app/backend
+	ReplyTierFAQ       ReplyTier = "faq"            // Canned answer matched by keywords
+	ReplyTierDegraded  ReplyTier = "degraded"       // Circuit open, degraded-mode reply sent
+	ReplyTierHuman     ReplyTier = "human_fallback" // Nothing could answer, handed to a human
+)
+
+// ErrReplyMaybeSent is returned when sending an answer failed in a way that
+// doesn't rule out delivery, so the fallback chain must not send another one
+var ErrReplyMaybeSent = errors.New("reply may have been sent")
+
+// replyMaybeSent reports whether a failed send may still have reached the user.
+// Only an error answer from the Graph API proves it didn't: a timeout or dropped
+// connection can come after Meta accepted the message, and so can a bare 5xx
+// from a proxy in front of Graph.
+func replyMaybeSent(err error) bool {
+	graphErr, ok := graph.AsGraphError(err)
+	if !ok {
+		return true
+	}
+	return graphErr.Code == 0 && graphErr.StatusCode >= http.StatusInternalServerError
+}
+
+// runReplyFallbackChain answers a general message with the first tier that works:
+//
+//  1. Primary Dify app (with conversation context)
+//  2. Secondary Dify app or backend, if the page configures one (no context)
+//  3. FAQ responder (with a lower match threshold than the pre-sentiment check)
+//  4. Degraded-mode reply if the primary circuit is open (bot stays enabled),
+//     otherwise the human fallback message and the bot is disabled
+//
+// A tier whose answer may have reached the user ends the chain even when its send
+// failed, so the user never gets two answers.
+//
+// It returns the tier that answered and the primary failure, if any, so callers
+// can log why the conversation degraded.
+func (p *MessageProcessor) runReplyFallbackChain(ctx context.Context, msgContext *MessageContext, requestID string) (ReplyTier, error) {
+	pageID := msgContext.PageInfo.PageID
+	senderID := msgContext.Message.Sender.ID
+
+	// Tier 1: primary Dify app
+	primary, secondary, primaryErr := p.getDifyBackends(ctx, pageID, msgContext.Platform)
+	if primaryErr == nil {
+		primaryErr = p.forwardToDify(ctx, pageID, msgContext.Message, msgContext.Platform, primary, true)
+		if primaryErr == nil {
+			return ReplyTierPrimary, nil
+		}
+		if errors.Is(primaryErr, ErrAnswerEscalated) {
+			return ReplyTierHuman, primaryErr // Unsafe answer withheld, a human takes over
+		}
+		if errors.Is(primaryErr, ErrReplyMaybeSent) {
+			LogErrorCtx(ctx, "Primary Dify answer may have been sent, not trying other tiers: %v", primaryErr)
+			return ReplyTierPrimary, primaryErr
+		}
+	}
+	LogWarnCtx(ctx, "Primary Dify app failed: %v", primaryErr)
+
+	// Tier 2: secondary backend
+	if secondary != nil {
+		err := p.forwardToDify(ctx, pageID, msgContext.Message, msgContext.Platform, *secondary, false)
+		if err == nil {
+			LogInfoCtx(ctx, "↪️ Answered by secondary Dify backend")
+			return ReplyTierSecondary, primaryErr
+		}
+		if errors.Is(err, ErrAnswerEscalated) {
+			return ReplyTierHuman, err
+		}
+		if errors.Is(err, ErrReplyMaybeSent) {
+			LogErrorCtx(ctx, "Secondary Dify answer may have been sent, not trying other tiers: %v", err)
+			return ReplyTierSecondary, primaryErr
+		}
+		LogWarnCtx(ctx, "Secondary Dify backend failed: %v", err)
+	}
+
+	// Tier 3: FAQ responder, accepting weaker matches than the pre-sentiment check
+	match, err := p.findFAQAnswer(ctx, pageID, msgContext.Platform, msgContext.Message.Message.Text, config.FAQFallbackThreshold)
+	if err != nil {
+		LogWarnCtx(ctx, "FAQ lookup failed: %v", err)
+	} else if match != nil {
+		if err := sendPlatformResponse(ctx, msgContext.PageInfo, senderID, match.Entry.Answer); err != nil {
+			LogErrorCtx(ctx, "Failed to send FAQ answer: %v", err)
+			if replyMaybeSent(err) {
+				return ReplyTierFAQ, primaryErr
+			}
+		} else {
+			LogInfoCtx(ctx, "↪️ Answered from FAQ entry %s (score %.2f)", match.Entry.ID, match.Score)
+			if err := p.storeBotMessage(ctx, pageID, msgContext.Platform, senderID, match.Entry.Answer); err != nil {
+				LogWarnCtx(ctx, "Could not store FAQ answer: %v", err)
+			}
+			return ReplyTierFAQ, primaryErr
+		}
+	}
+
+	// Tier 4a: Dify is known to be down for this page - reply with the degraded-mode
+	// message but keep the bot enabled so it resumes once the circuit closes
+	if errors.Is(primaryErr, ErrCircuitOpen) {
+		LogWarnCtx(ctx, "🔌 Dify circuit open - sending degraded-mode reply")
+		if sendErr := sendPlatformResponse(ctx, msgContext.PageInfo, senderID, config.DifyDegradedReply); sendErr != nil {
+			LogErrorCtx(ctx, "Failed to send degraded-mode reply: %v", sendErr)
+		}
+		return ReplyTierDegraded, primaryErr
+	}
+
+	// Tier 4b: hand the conversation to a human
+	fallbackMsg := "Disculpa, estoy teniendo problemas técnicos. Un agente humano te ayudará pronto."
+	if sendErr := sendPlatformResponse(ctx, msgContext.PageInfo, senderID, fallbackMsg); sendErr != nil {
+		LogErrorCtx(ctx, "Failed to send fallback message: %v", sendErr)
+	}
+
+	// Disable bot due to technical error
+	p.updateConversationState(ctx, msgContext.Conversation, false, "Error al procesar con Dify")
+	return ReplyTierHuman, primaryErr
+}
+
+// recordReplyTier stores which tier answered a message. Failures are only logged,
+// the stats are not worth failing message processing for.
+func (p *MessageProcessor) recordReplyTier(ctx context.Context, msgContext *MessageContext, tier ReplyTier, cause error, requestID string) {
+	errorMessage := ""
+	if cause != nil {
+		errorMessage = cause.Error()
+	}
+
+	err := p.stores.ReplyTiers.RecordReplyTier(ctx, msgContext.PageInfo.PageID, msgContext.Platform,
+		msgContext.Message.Sender.ID, tier, errorMessage)
+	if err != nil {
+		LogWarnCtx(ctx, "Could not record reply tier %s: %v", tier, err)
+	}
+}
+
+// ReplyTierCount is how many messages one tier answered on a page
+type ReplyTierCount struct {
+	PageID   string
+	Platform string
+	Tier     string
+	Count    int
+}
+
+// handleReplyTierStats reports how often each fallback tier answered, per page.
+// GET /api/reply-tiers?days=30
+func handleReplyTierStats(tiers ReplyTierStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		clientID := r.Header.Get("X-Client-ID")
+		if clientID == "" {
+			http.Error(w, "Client ID required", http.StatusUnauthorized)
+			return
+		}
+
+		days := 30
+		if value := r.URL.Query().Get("days"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > 365 {
+				http.Error(w, "days must be between 1 and 365", http.StatusBadRequest)
+				return
+			}
+			days = parsed
+		}
+
+		counts, err := tiers.ReplyTierCounts(r.Context(), clientID, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			LogError("Error querying reply tier stats: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		type pageStats struct {
+			PageID   string         `json:"page_id"`
+			Platform string         `json:"platform"`
+			Tiers    map[string]int `json:"tiers"`
+			Total    int            `json:"total"`
+		}
+		var pages []*pageStats
+		byKey := make(map[string]*pageStats)
+		for _, count := range counts {
+			key := count.Platform + ":" + count.PageID
+			stats, ok := byKey[key]
+			if !ok {
+				stats = &pageStats{PageID: count.PageID, Platform: count.Platform, Tiers: make(map[string]int)}
+				byKey[key] = stats
+				pages = append(pages, stats)
+			}
+			stats.Tiers[count.Tier] = count.Count
+			stats.Total += count.Count
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"days":  days,
+			"pages": pages,
+		})
+	}
+}
@@ -0,0 +1,462 @@
+// faq.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// =============================================================================
+// FAQ / CANNED ANSWERS - Per-page answers that don't need the LLM
+// =============================================================================
+
+// FAQEntry is a canned answer configured by a client for one of their pages
+type FAQEntry struct {
+	ID        string    `json:"id"`
+	Question  string    `json:"question"` // Human-readable question, shown in the dashboard
+	Triggers  []string  `json:"triggers"` // Keywords/phrases that select this answer
+	Answer    string    `json:"answer"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FAQMatch is the best FAQ entry for a message and how confident the match is
+type FAQMatch struct {
+	Entry *FAQEntry
+	Score float64 // 1.0 = a trigger phrase appears verbatim, lower = fuzzy/partial
+}
+
+// scanFAQEntries reads FAQ rows selected in the column order used by this file
+func scanFAQEntries(rows *sql.Rows) ([]FAQEntry, error) {
+	entries := []FAQEntry{}
+	for rows.Next() {
+		var entry FAQEntry
+		if err := rows.Scan(&entry.ID, &entry.Question, pq.Array(&entry.Triggers), &entry.Answer,
+			&entry.Enabled, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning FAQ entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// findFAQAnswer returns the best FAQ entry for the message if its score reaches
+// minScore, or nil when nothing matches well enough
+func (p *MessageProcessor) findFAQAnswer(ctx context.Context, pageID, platform, text string, minScore float64) (*FAQMatch, error) {
+	entries, err := p.stores.FAQ.EnabledFAQEntries(ctx, pageID, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	match := matchFAQ(entries, text)
+	if match == nil || match.Score < minScore {
+		return nil, nil
+	}
+	return match, nil
+}
+
+// tryFAQAnswer answers the message from the page's FAQ when there is a confident
+// match, so common questions never reach sentiment analysis or Dify.
+// Returns true if the message was answered.
+func (p *MessageProcessor) tryFAQAnswer(ctx context.Context, msgContext *MessageContext, requestID string) bool {
+	match, err := p.findFAQAnswer(ctx, msgContext.PageInfo.PageID, msgContext.Platform,
+		msgContext.Message.Message.Text, config.FAQMatchThreshold)
+	if err != nil {
+		LogWarnCtx(ctx, "FAQ lookup failed, continuing with AI pipeline: %v", err)
+		return false
+	}
+	if match == nil {
+		LogDebugCtx(ctx, "No confident FAQ match")
+		return false
+	}
+
+	if err := sendPlatformResponse(ctx, msgContext.PageInfo, msgContext.Message.Sender.ID, match.Entry.Answer); err != nil {
+		LogErrorCtx(ctx, "Failed to send FAQ answer, continuing with AI pipeline: %v", err)
+		return false
+	}
+
+	LogInfoCtx(ctx, "📚 Answered from FAQ entry %s (score %.2f)", match.Entry.ID, match.Score)
+	if err := p.storeBotMessage(ctx, msgContext.PageInfo.PageID, msgContext.Platform, msgContext.Message.Sender.ID, match.Entry.Answer); err != nil {
+		LogWarnCtx(ctx, "Could not store FAQ answer: %v", err)
+	}
+	return true
+}
+
+// matchFAQ scores every trigger of every entry against text and returns the best
+// match, or nil if nothing overlaps at all. Ties go to the longer trigger since
+// it is more specific ("horario de domingo" beats "horario").
+func matchFAQ(entries []FAQEntry, text string) *FAQMatch {
+	normalized := normalizeFAQText(text)
+	if normalized == "" {
+		return nil
+	}
+	words := strings.Fields(normalized)
+
+	var best *FAQMatch
+	bestLen := 0
+	for i := range entries {
+		for _, trigger := range entries[i].Triggers {
+			t := normalizeFAQText(trigger)
+			if t == "" {
+				continue
+			}
+			score := scoreFAQTrigger(normalized, words, t)
+			if score == 0 {
+				continue
+			}
+			if best == nil || score > best.Score || (score == best.Score && len(t) > bestLen) {
+				best = &FAQMatch{Entry: &entries[i], Score: score}
+				bestLen = len(t)
+			}
+		}
+	}
+	return best
+}
+
+// fuzzyMatchCeiling caps scores of non-verbatim matches, so a fuzzy match never
+// looks as certain as the exact trigger phrase
+const fuzzyMatchCeiling = 0.9
+
+// scoreFAQTrigger rates how well a normalized trigger matches a normalized message.
+//
+//   - 1.0 when the trigger phrase appears verbatim (on word boundaries)
+//   - otherwise the fraction of trigger words found in the message, allowing
+//     small typos ("horaio" ≈ "horario"), scaled by fuzzyMatchCeiling
+func scoreFAQTrigger(normalizedText string, words []string, trigger string) float64 {
+	// Match whole words only so "hora" doesn't fire on "ahora"
+	if strings.Contains(" "+normalizedText+" ", " "+trigger+" ") {
+		return 1.0
+	}
+
+	triggerWords := strings.Fields(trigger)
+	matched := 0
+	for _, tw := range triggerWords {
+		for _, w := range words {
+			if fuzzyWordMatch(tw, w) {
+				matched++
+				break
+			}
+		}
+	}
+	return float64(matched) / float64(len(triggerWords)) * fuzzyMatchCeiling
+}
+
+// fuzzyWordMatch tolerates one typo in words of 5+ letters and two in 8+ letters.
+// Short words must match exactly, otherwise "no" would match "lo".
+func fuzzyWordMatch(a, b string) bool {
+	if a == b {
+		return true
+	}
+	allowed := 0
+	switch n := len([]rune(a)); {
+	case n >= 8:
+		allowed = 2
+	case n >= 5:
+		allowed = 1
+	}
+	if allowed == 0 {
+		return false
+	}
+	return levenshtein(a, b) <= allowed
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// accentReplacer folds the accented vowels used in Spanish messages
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u",
+	"à", "a", "è", "e", "ì", "i", "ò", "o", "ù", "u",
+)
+
+// normalizeFAQText lowercases, strips accents and punctuation and collapses spaces,
+// so "¿Cuál es el HORARIO?" and "cual es el horario" compare equal
+func normalizeFAQText(text string) string {
+	folded := accentReplacer.Replace(strings.ToLower(text))
+
+	var b strings.Builder
+	for _, r := range folded {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// =============================================================================
+// FAQ MANAGEMENT API - CRUD endpoints used by the client dashboard
+// =============================================================================
+
+// FAQManager handles the FAQ CRUD endpoints
+type FAQManager struct {
+	faq FAQStore
+}
+
+// FAQEntryRequest is the body accepted when creating or updating an entry
+type FAQEntryRequest struct {
+	Question string   `json:"question"`
+	Triggers []string `json:"triggers"`
+	Answer   string   `json:"answer"`
+	Enabled  *bool    `json:"enabled,omitempty"` // Defaults to true on create
+}
+
+// NewFAQManager creates a new FAQ manager
+func NewFAQManager(faq FAQStore) *FAQManager {
+	return &FAQManager{faq: faq}
+}
+
+// parseFAQPath extracts {pageId} and the optional {entryId} from /api/faq/{pageId}/{entryId}
+func parseFAQPath(path string) (pageID, entryID string) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api/faq/"), "/"), "/")
+	if len(parts) > 0 {
+		pageID = parts[0]
+	}
+	if len(parts) > 1 {
+		entryID = parts[1]
+	}
+	return pageID, entryID
+}
+
+// getClientPageUUID resolves a page the client owns to its internal UUID. The
+// optional ?platform= query parameter picks the page when the same ID is
+// connected on both platforms.
+func (fm *FAQManager) getClientPageUUID(r *http.Request, pageID, clientID string) (string, error) {
+	return fm.faq.ClientPageUUID(r.Context(), pageID, r.URL.Query().Get("platform"), clientID)
+}
+
+// writePageLookupError answers a request whose page could not be resolved
+func writePageLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrAmbiguousPage) {
+		http.Error(w, "Page ID is connected on several platforms, add ?platform=facebook or ?platform=instagram", http.StatusBadRequest)
+		return
+	}
+	if !errors.Is(err, ErrNotFound) {
+		LogError("Error finding page: %v", err)
+	}
+	http.Error(w, "Page not found or access denied", http.StatusForbidden)
+}
+
+// faqEntryIDPattern matches the UUIDs of faq_entries rows
+var faqEntryIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validate checks an entry request and cleans up its triggers
+func (req *FAQEntryRequest) validate() error {
+	req.Question = strings.TrimSpace(req.Question)
+	req.Answer = strings.TrimSpace(req.Answer)
+	if req.Question == "" || req.Answer == "" {
+		return fmt.Errorf("question and answer are required")
+	}
+
+	triggers := make([]string, 0, len(req.Triggers))
+	for _, t := range req.Triggers {
+		if t = strings.TrimSpace(t); t != "" {
+			triggers = append(triggers, t)
+		}
+	}
+	if len(triggers) == 0 {
+		return fmt.Errorf("at least one trigger phrase is required")
+	}
+	req.Triggers = triggers
+	return nil
+}
+
+// ListEntries returns all FAQ entries of a page: GET /api/faq/{pageId}
+func (fm *FAQManager) ListEntries(w http.ResponseWriter, r *http.Request) {
+	pageID, _ := parseFAQPath(r.URL.Path)
+	clientID := r.Header.Get("X-Client-ID")
+	if pageID == "" {
+		http.Error(w, "Page ID required", http.StatusBadRequest)
+		return
+	}
+
+	pageUUID, err := fm.getClientPageUUID(r, pageID, clientID)
+	if err != nil {
+		writePageLookupError(w, err)
+		return
+	}
+
+	entries, err := fm.faq.ListFAQEntries(r.Context(), pageUUID)
+	if err != nil {
+		LogError("Error reading FAQ entries: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"page_id": pageID,
+	})
+}
+
+// CreateEntry adds an FAQ entry to a page: POST /api/faq/{pageId}
+func (fm *FAQManager) CreateEntry(w http.ResponseWriter, r *http.Request) {
+	pageID, _ := parseFAQPath(r.URL.Path)
+	clientID := r.Header.Get("X-Client-ID")
+	if pageID == "" {
+		http.Error(w, "Page ID required", http.StatusBadRequest)
+		return
+	}
+
+	var req FAQEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	pageUUID, err := fm.getClientPageUUID(r, pageID, clientID)
+	if err != nil {
+		writePageLookupError(w, err)
+		return
+	}
+
+	entryID, err := fm.faq.CreateFAQEntry(r.Context(), pageUUID, FAQEntry{
+		Question: req.Question,
+		Triggers: req.Triggers,
+		Answer:   req.Answer,
+		Enabled:  enabled,
+	})
+	if err != nil {
+		LogError("Error creating FAQ entry: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	LogInfo("📚 Created FAQ entry %s for page %s (%d triggers)", entryID, pageID, len(req.Triggers))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"entry_id": entryID,
+		"message":  "FAQ entry created successfully",
+	})
+}
+
+// UpdateEntry replaces an FAQ entry: PUT /api/faq/{pageId}/{entryId}
+func (fm *FAQManager) UpdateEntry(w http.ResponseWriter, r *http.Request) {
+	pageID, entryID := parseFAQPath(r.URL.Path)
+	clientID := r.Header.Get("X-Client-ID")
+	if pageID == "" || entryID == "" {
+		http.Error(w, "Both Page ID and Entry ID are required", http.StatusBadRequest)
+		return
+	}
+	if !faqEntryIDPattern.MatchString(entryID) {
+		http.Error(w, "FAQ entry not found", http.StatusNotFound)
+		return
+	}
+
+	var req FAQEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pageUUID, err := fm.getClientPageUUID(r, pageID, clientID)
+	if err != nil {
+		writePageLookupError(w, err)
+		return
+	}
+
+	err = fm.faq.UpdateFAQEntry(r.Context(), pageUUID, entryID, req)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "FAQ entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		LogError("Error updating FAQ entry %s: %v", entryID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	LogInfo("📚 Updated FAQ entry %s for page %s", entryID, pageID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "FAQ entry updated successfully",
+	})
+}
+
+// DeleteEntry removes an FAQ entry: DELETE /api/faq/{pageId}/{entryId}
+func (fm *FAQManager) DeleteEntry(w http.ResponseWriter, r *http.Request) {
+	pageID, entryID := parseFAQPath(r.URL.Path)
+	clientID := r.Header.Get("X-Client-ID")
+	if pageID == "" || entryID == "" {
+		http.Error(w, "Both Page ID and Entry ID are required", http.StatusBadRequest)
+		return
+	}
+	if !faqEntryIDPattern.MatchString(entryID) {
+		http.Error(w, "FAQ entry not found", http.StatusNotFound)
+		return
+	}
+
+	pageUUID, err := fm.getClientPageUUID(r, pageID, clientID)
+	if err != nil {
+		writePageLookupError(w, err)
+		return
+	}
+
+	err = fm.faq.DeleteFAQEntry(r.Context(), pageUUID, entryID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "FAQ entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		LogError("Error deleting FAQ entry %s: %v", entryID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	LogInfo("🗑️ Deleted FAQ entry %s for page %s", entryID, pageID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "FAQ entry deleted successfully",
+	})
+}
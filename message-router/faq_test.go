@@ -0,0 +1,47 @@
+// faq_test.go
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestFAQRoutePageLookup checks the platform-scoped page lookup and entry ID validation
+func TestFAQRoutePageLookup(t *testing.T) {
+	memory := NewMemoryStore()
+	for _, platform := range []string{"facebook", "instagram"} {
+		memory.AddPage(MemoryPage{PageID: "shared-id", Platform: platform, ClientID: "client-1"})
+	}
+	memory.AddPage(MemoryPage{PageID: testPageID, Platform: "facebook", ClientID: "client-1",
+		FAQ: []FAQEntry{{ID: "00000000-0000-4000-8000-000000000001", Triggers: []string{"horario"}, Answer: "9 a 18 h", Enabled: true}}})
+	memory.AddPage(MemoryPage{PageID: "other-client", Platform: "facebook", ClientID: "client-2"})
+	route := handleFAQRoute(NewFAQManager(memory))
+
+	body := `{"question":"¿Horario?","triggers":["horario"],"answer":"De 9 a 18 h"}`
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/faq/" + testPageID, http.StatusOK},
+		{http.MethodGet, "/api/faq/shared-id", http.StatusBadRequest},
+		{http.MethodGet, "/api/faq/shared-id?platform=instagram", http.StatusOK},
+		{http.MethodPost, "/api/faq/shared-id?platform=facebook", http.StatusCreated},
+		{http.MethodGet, "/api/faq/other-client", http.StatusForbidden},
+		{http.MethodPut, "/api/faq/" + testPageID + "/00000000-0000-4000-8000-000000000001", http.StatusOK},
+		{http.MethodPut, "/api/faq/" + testPageID + "/not-a-uuid", http.StatusNotFound},
+		{http.MethodDelete, "/api/faq/" + testPageID + "/42", http.StatusNotFound},
+		{http.MethodDelete, "/api/faq/" + testPageID + "/00000000-0000-4000-8000-000000000009", http.StatusNotFound},
+		{http.MethodDelete, "/api/faq/" + testPageID + "/00000000-0000-4000-8000-000000000001", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(body))
+		req.Header.Set("X-Client-ID", "client-1")
+		rec := httptest.NewRecorder()
+		route(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s = %d (%s), want %d", tt.method, tt.path, rec.Code, bytes.TrimSpace(rec.Body.Bytes()), tt.want)
+		}
+	}
+}
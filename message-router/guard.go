@@ -0,0 +1,252 @@
+// guard.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// =============================================================================
+// MESSAGE GUARD - Spam, abuse and prompt-injection checks before the bot
+// =============================================================================
+
+// GuardAction is what happens to a message the guard blocks
+type GuardAction string
+
+const (
+	GuardActionIgnore   GuardAction = "ignore"   // Drop the message without replying
+	GuardActionReply    GuardAction = "reply"    // Send the page's canned reply
+	GuardActionEscalate GuardAction = "escalate" // Disable the bot so a human reviews the conversation
+)
+
+// parseGuardAction converts a configured action, defaulting to ignore for unknown values
+func parseGuardAction(value string) GuardAction {
+	switch GuardAction(strings.ToLower(strings.TrimSpace(value))) {
+	case GuardActionReply:
+		return GuardActionReply
+	case GuardActionEscalate:
+		return GuardActionEscalate
+	default:
+		return GuardActionIgnore
+	}
+}
+
+// GuardConfig enables and tunes the individual checks. Zero disables a limit.
+type GuardConfig struct {
+	Enabled         bool          // Pages opt in with page_guard_settings.enabled when off
+	MaxURLs         int           // Links allowed in one message
+	MaxPhoneNumbers int           // Phone numbers allowed in one message
+	RepeatLimit     int           // Identical messages allowed from one sender per RepeatWindow
+	RepeatWindow    time.Duration //
+	RepeatMinLength int           // Shorter messages ("sí", "ok", "gracias") are never counted as repeats
+	InjectionRules  bool          // Rule-based prompt-injection detection
+	DefaultAction   GuardAction   // Used when the page has no page_guard_settings row
+	DefaultReply    string        // Canned reply used when the page has none
+}
+
+// guardVerdict explains why a message was blocked
+type guardVerdict struct {
+	Check  string // "blocked_sender", "url_spam", "phone_spam", "repeated_message" or "prompt_injection"
+	Reason string
+}
+
+var (
+	guardURLPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+|\b[a-z0-9-]+\.(?:com|net|org|io|ly|me|xyz|info|biz|top|click|link)(?:/\S*)?\b`)
+	// guardPhonePattern finds phone number candidates; findPhoneNumbers filters them
+	guardPhonePattern = regexp.MustCompile(`#?\+?\(?\d[\d\s().-]{7,}\d`)
+	guardDatePattern  = regexp.MustCompile(`\d{4}[-./]\d{1,2}[-./]\d{1,2}|\d{1,2}[-./]\d{1,2}[-./]\d{4}`)
+)
+
+// findPhoneNumbers returns the phone numbers in a message. A number has 10 to
+// 13 digits and is written with a "+" prefix or with separators, so order
+// numbers ("#12345678901", "pedido 4455667788"), prices and dates don't count.
+func findPhoneNumbers(text string) []string {
+	var phones []string
+	for _, candidate := range guardPhonePattern.FindAllString(text, -1) {
+		if strings.HasPrefix(candidate, "#") || guardDatePattern.MatchString(candidate) {
+			continue
+		}
+		digits := 0
+		for _, r := range candidate {
+			if r >= '0' && r <= '9' {
+				digits++
+			}
+		}
+		if digits < 10 || digits > 13 {
+			continue
+		}
+		if !strings.HasPrefix(candidate, "+") && !strings.ContainsAny(candidate, " ().-") {
+			continue
+		}
+		phones = append(phones, candidate)
+	}
+	return phones
+}
+
+// promptInjectionPatterns catch the usual "ignore your instructions" attacks in
+// English and Spanish. They are matched against normalized text (lowercase, no accents).
+var promptInjectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(ignore|disregard|forget|override)\b.{0,30}\b(instructions?|rules|prompt|guidelines|previous|above)\b`),
+	regexp.MustCompile(`\b(ignora|olvida|omite|descarta)\b.{0,30}\b(instrucciones|reglas|prompt|indicaciones|anteriores?)\b`),
+	regexp.MustCompile(`\b(system|initial|hidden) prompt\b`),
+	regexp.MustCompile(`\b(reveal|show|print|repeat)\b.{0,20}\b(your|the) (prompt|instructions|system message)\b`),
+	regexp.MustCompile(`\b(muestra|revela|repite)\b.{0,20}\b(tus|las) (instrucciones|indicaciones)\b`),
+	regexp.MustCompile(`\byou are now\b|\bact as (an?|the)\b|\bpretend (to be|you are)\b`),
+	regexp.MustCompile(`\bahora eres\b|\bactua como\b|\bfinge (ser|que eres)\b`),
+	regexp.MustCompile(`\b(developer|dev|god) mode\b|\bjailbreak\b|\bdo anything now\b`),
+}
+
+// checkMessageGuard runs the configured checks against a user message and returns
+// the first one that blocks it, or nil if the message may reach the bot.
+// Database errors never block a message (graceful degradation).
+func (p *MessageProcessor) checkMessageGuard(ctx context.Context, msgContext *MessageContext, requestID string) *guardVerdict {
+	guard := config.Guard
+	if !p.loadGuardSettings(ctx, msgContext.PageInfo.PageID, msgContext.Platform).Enabled {
+		return nil
+	}
+	senderID := msgContext.Message.Sender.ID
+	text := msgContext.Message.Message.Text
+
+	// Sender blocklist
+	blocked, err := p.stores.Guard.SenderBlocked(ctx, msgContext.PageInfo.PageID, msgContext.Platform, senderID)
+	if err != nil {
+		LogWarnCtx(ctx, "Blocklist check failed: %v", err)
+	} else if blocked {
+		return &guardVerdict{Check: "blocked_sender", Reason: "sender is on the page blocklist"}
+	}
+
+	// Link and phone number spam
+	if guard.MaxURLs > 0 {
+		if urls := len(guardURLPattern.FindAllString(text, -1)); urls > guard.MaxURLs {
+			return &guardVerdict{Check: "url_spam", Reason: fmt.Sprintf("%d links (max %d)", urls, guard.MaxURLs)}
+		}
+	}
+	if guard.MaxPhoneNumbers > 0 {
+		if phones := len(findPhoneNumbers(text)); phones > guard.MaxPhoneNumbers {
+			return &guardVerdict{Check: "phone_spam", Reason: fmt.Sprintf("%d phone numbers (max %d)", phones, guard.MaxPhoneNumbers)}
+		}
+	}
+
+	// Prompt injection
+	if guard.InjectionRules {
+		if pattern := detectPromptInjection(text); pattern != "" {
+			return &guardVerdict{Check: "prompt_injection", Reason: "matched " + pattern}
+		}
+	}
+
+	// Repeated messages, counted in the shared Postgres-backed counters. Short
+	// replies are repeated in any normal conversation.
+	normalized := normalizeFAQText(text)
+	if guard.RepeatLimit > 0 && guard.RepeatWindow > 0 && utf8.RuneCountInString(normalized) >= guard.RepeatMinLength {
+		sum := sha256.Sum256([]byte(normalized))
+		key := msgContext.PageInfo.PageID + ":" + senderID + ":" + hex.EncodeToString(sum[:8])
+		count, err := p.stores.Quotas.IncrementCounter(ctx, "repeat_message", key, time.Now().UTC().Truncate(guard.RepeatWindow))
+		if err != nil {
+			LogWarnCtx(ctx, "%v", err)
+		} else if count > int64(guard.RepeatLimit) {
+			return &guardVerdict{Check: "repeated_message",
+				Reason: fmt.Sprintf("sent %d times within %v (max %d)", count, guard.RepeatWindow, guard.RepeatLimit)}
+		}
+	}
+
+	return nil
+}
+
+// detectPromptInjection returns the pattern that matched, or "" if none did
+func detectPromptInjection(text string) string {
+	normalized := normalizeFAQText(text)
+	for _, pattern := range promptInjectionPatterns {
+		if pattern.MatchString(normalized) {
+			return pattern.String()
+		}
+	}
+	return ""
+}
+
+// guardSettings are the effective guard settings of a page
+type guardSettings struct {
+	Enabled bool
+	Action  GuardAction
+	Reply   string
+}
+
+// loadGuardSettings returns the page's guard settings, falling back to the defaults
+func (p *MessageProcessor) loadGuardSettings(ctx context.Context, pageID, platform string) guardSettings {
+	settings := guardSettings{
+		Enabled: config.Guard.Enabled,
+		Action:  config.Guard.DefaultAction,
+		Reply:   config.Guard.DefaultReply,
+	}
+
+	page, err := p.stores.Guard.GuardSettings(ctx, pageID, platform)
+	if err != nil {
+		LogWarn("Could not load guard settings for page %s: %v", pageID, err)
+		return settings
+	}
+	if page.Enabled != nil {
+		settings.Enabled = *page.Enabled
+	}
+	if page.Action != "" {
+		settings.Action = parseGuardAction(page.Action)
+	}
+	if page.Reply != "" {
+		settings.Reply = page.Reply
+	}
+	return settings
+}
+
+// enforceGuardVerdict flags a blocked message in storage and applies the page's action
+func (p *MessageProcessor) enforceGuardVerdict(ctx context.Context, msgContext *MessageContext, verdict *guardVerdict, requestID string) {
+	settings := p.loadGuardSettings(ctx, msgContext.PageInfo.PageID, msgContext.Platform)
+	action, reply := settings.Action, settings.Reply
+	LogWarnCtx(ctx, "🛡️ Message from %s blocked by %s (%s) - action: %s",
+		msgContext.Message.Sender.ID, verdict.Check, verdict.Reason, action)
+
+	p.flagGuardedMessage(ctx, msgContext, verdict, action, requestID)
+	if action == GuardActionEscalate {
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeHandoff, "guard:"+verdict.Check)
+	} else {
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeSkipped, "guard:"+verdict.Check)
+	}
+
+	switch action {
+	case GuardActionReply:
+		// Repeated and blocked senders never get a reply, or the guard would answer the spam
+		if verdict.Check == "repeated_message" || verdict.Check == "blocked_sender" {
+			return
+		}
+		if err := sendPlatformResponse(ctx, msgContext.PageInfo, msgContext.Message.Sender.ID, reply); err != nil {
+			LogErrorCtx(ctx, "Failed to send guard reply: %v", err)
+		}
+	case GuardActionEscalate:
+		if err := p.updateConversationState(ctx, msgContext.Conversation, false, "Message flagged: "+verdict.Check); err != nil {
+			LogErrorCtx(ctx, "Failed to disable bot: %v", err)
+		}
+	default:
+		// Ignore silently
+	}
+}
+
+// flagGuardedMessage records the blocked message in message_flags for review
+func (p *MessageProcessor) flagGuardedMessage(ctx context.Context, msgContext *MessageContext, verdict *guardVerdict, action GuardAction, requestID string) {
+	err := p.stores.Guard.FlagMessage(ctx, MessageFlag{
+		PageID:     msgContext.PageInfo.PageID,
+		Platform:   msgContext.Platform,
+		ThreadID:   msgContext.Conversation.ThreadID,
+		SenderID:   msgContext.Message.Sender.ID,
+		MessageMid: msgContext.Message.Message.Mid,
+		Content:    msgContext.Message.Message.Text,
+		Check:      verdict.Check,
+		Reason:     verdict.Reason,
+		Action:     string(action),
+	})
+	if err != nil {
+		LogWarnCtx(ctx, "Could not flag blocked message: %v", err)
+	}
+}
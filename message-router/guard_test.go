@@ -0,0 +1,30 @@
+// guard_test.go
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestFindPhoneNumbers checks that phone numbers are found and order numbers,
+// prices and dates are not
+func TestFindPhoneNumbers(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Llámame al +52 55 1234 5678", []string{"+52 55 1234 5678"}},
+		{"Mi número es (55) 1234-5678", []string{"(55) 1234-5678"}},
+		{"Escribe al 555.123.4567 o al +5215512345678", []string{"555.123.4567", "+5215512345678"}},
+		{"Mi pedido #12345678901 no ha llegado", nil},
+		{"Pedido 4455667788, ¿cuándo llega?", nil},
+		{"Cuesta $1,200.00 o 1.250.000 pesos", nil},
+		{"Lo pedí el 2024-05-12 10:30 y el 12/05/2024 09:15", nil},
+		{"Hola, ¿tienen envíos?", nil},
+	}
+	for _, tt := range tests {
+		if got := findPhoneNumbers(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findPhoneNumbers(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
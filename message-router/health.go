@@ -0,0 +1,291 @@
+// health.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// =============================================================================
+// HEALTH AND READINESS - /healthz for liveness, /readyz for routing traffic
+// =============================================================================
+
+// Check and overall statuses reported by /readyz
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded" // Messages are still answered, with fallbacks
+	HealthDown     = "down"     // Messages cannot be processed
+)
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	LatencyMS int64          `json:"latency_ms"`
+	Details   map[string]any `json:"details,omitempty"`
+	CheckedAt time.Time      `json:"checked_at"`
+}
+
+// ReadinessReport is the /readyz response body
+type ReadinessReport struct {
+	Status        string                 `json:"status"`
+	Checks        map[string]HealthCheck `json:"checks"`
+	AsyncInFlight int64                  `json:"async_in_flight"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+}
+
+// HealthConfig configures /readyz
+type HealthConfig struct {
+	CheckTimeout   time.Duration // Per-check timeout for DB pings
+	MaxInFlight    int64         // Async deliveries above which the instance is not ready (0 = no limit)
+	ProbeUpstreams bool          // Probe Fireworks and Dify reachability
+	ProbeInterval  time.Duration // How long an upstream probe result is reused
+}
+
+var (
+	startedAt = time.Now()
+
+	// asyncInFlight counts webhook deliveries still processing in the background
+	asyncInFlight atomic.Int64
+
+	fireworksProbe = &cachedProbe{check: probeFireworks}
+	difyProbe      = &cachedProbe{check: probeDify}
+)
+
+// beginAsyncWork and endAsyncWork bracket one background webhook delivery
+func beginAsyncWork() {
+	asyncInFlight.Add(1)
+	asyncWorkers.Inc()
+}
+
+func endAsyncWork() {
+	asyncInFlight.Add(-1)
+	asyncWorkers.Dec()
+}
+
+// handleHealthz reports liveness: the process is up and serving HTTP. It never
+// touches dependencies, so a database outage doesn't get the instance restarted.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":         HealthOK,
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+	})
+}
+
+// handleReadyz reports readiness: 200 while messages can be processed (status
+// ok or degraded), 503 when a database is unreachable or the async backlog is
+// over its limit
+func handleReadyz(health HealthStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checkReadiness(r.Context(), health)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status == HealthDown {
+			LogWarnCtx(r.Context(), "🩺 Not ready: %s", failedChecks(report))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// checkReadiness runs every readiness check
+func checkReadiness(ctx context.Context, health HealthStore) ReadinessReport {
+	report := ReadinessReport{
+		Checks:        make(map[string]HealthCheck),
+		AsyncInFlight: asyncInFlight.Load(),
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+	}
+
+	report.Checks["database"] = checkDatabase(ctx, health)
+	report.Checks["reenable_disabled_bots"] = checkReactivationFunction(ctx, health)
+	report.Checks["async_queue"] = checkAsyncQueue(report.AsyncInFlight)
+	if difyBreakers != nil {
+		report.Checks["dify_circuits"] = checkDifyCircuits()
+	}
+	if config.Health.ProbeUpstreams {
+		report.Checks["fireworks"] = fireworksProbe.Get(ctx)
+		report.Checks["dify"] = difyProbe.Get(ctx)
+	}
+
+	report.Status = HealthOK
+	for _, check := range report.Checks {
+		switch {
+		case check.Status == HealthDown:
+			report.Status = HealthDown
+		case check.Status == HealthDegraded && report.Status == HealthOK:
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+// failedChecks lists the checks that are not ok, for the log
+func failedChecks(report ReadinessReport) string {
+	var failed []string
+	for name, check := range report.Checks {
+		if check.Status != HealthOK {
+			failed = append(failed, fmt.Sprintf("%s=%s", name, check.Status))
+		}
+	}
+	return strings.Join(failed, ", ")
+}
+
+// checkDatabase pings the database and reports its connection pool usage
+func checkDatabase(ctx context.Context, health HealthStore) HealthCheck {
+	start := time.Now()
+	pingCtx, cancel := context.WithTimeout(ctx, config.Health.CheckTimeout)
+	defer cancel()
+	stats, err := health.Ping(pingCtx)
+
+	check := HealthCheck{
+		Status:    HealthOK,
+		LatencyMS: time.Since(start).Milliseconds(),
+		CheckedAt: start,
+		Details: map[string]any{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+			"idle":             stats.Idle,
+			"max_open":         stats.MaxOpenConnections,
+			"wait_count":       stats.WaitCount,
+		},
+	}
+	if err != nil {
+		check.Status = HealthDown
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// checkReactivationFunction reports whether the reenable_disabled_bots() database
+// function exists. Without it bots disabled by a human agent are never
+// re-enabled, but new messages are still processed.
+func checkReactivationFunction(ctx context.Context, health HealthStore) HealthCheck {
+	start := time.Now()
+	queryCtx, cancel := context.WithTimeout(ctx, config.Health.CheckTimeout)
+	defer cancel()
+	exists, err := health.ReenableFunctionExists(queryCtx)
+
+	check := HealthCheck{Status: HealthOK, LatencyMS: time.Since(start).Milliseconds(), CheckedAt: start,
+		Details: map[string]any{"exists": exists}}
+	switch {
+	case err != nil:
+		check.Status = HealthDegraded
+		check.Error = err.Error()
+	case !exists:
+		check.Status = HealthDegraded
+		check.Error = "function reenable_disabled_bots() is missing - bots will not auto-reactivate"
+	}
+	return check
+}
+
+// checkAsyncQueue reports the background webhook backlog against its limit
+func checkAsyncQueue(inFlight int64) HealthCheck {
+	check := HealthCheck{Status: HealthOK, CheckedAt: time.Now(),
+		Details: map[string]any{"in_flight": inFlight, "max_in_flight": config.Health.MaxInFlight}}
+	if limit := config.Health.MaxInFlight; limit > 0 && inFlight >= limit {
+		check.Status = HealthDown
+		check.Error = fmt.Sprintf("%d deliveries in flight (limit %d)", inFlight, limit)
+	}
+	return check
+}
+
+// checkDifyCircuits reports Dify API keys whose circuit breaker is open; those
+// pages get the degraded-mode reply
+func checkDifyCircuits() HealthCheck {
+	open := difyBreakers.OpenCount()
+	check := HealthCheck{Status: HealthOK, CheckedAt: time.Now(), Details: map[string]any{"open": open}}
+	if open > 0 {
+		check.Status = HealthDegraded
+		check.Error = fmt.Sprintf("%d Dify API key(s) with an open circuit", open)
+	}
+	return check
+}
+
+// =============================================================================
+// UPSTREAM PROBES - Cached so readiness polling doesn't hammer Fireworks or Dify
+// =============================================================================
+
+// cachedProbe reuses a probe result for config.Health.ProbeInterval
+type cachedProbe struct {
+	mu     sync.Mutex
+	result HealthCheck
+	check  func(ctx context.Context) HealthCheck
+}
+
+// Get returns the cached result, probing again once it is stale. Concurrent
+// callers wait for the same probe.
+func (p *cachedProbe) Get(ctx context.Context) HealthCheck {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.result.CheckedAt.IsZero() && time.Since(p.result.CheckedAt) < config.Health.ProbeInterval {
+		return p.result
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, config.Health.CheckTimeout)
+	defer cancel()
+	p.result = p.check(probeCtx)
+	return p.result
+}
+
+// probeFireworks lists models with the configured key: no tokens are spent
+func probeFireworks(ctx context.Context) HealthCheck {
+	return probeHTTP(ctx, "https://api.fireworks.ai/inference/v1/models", config.FireworksKey, false)
+}
+
+// probeDify checks that the Dify API answers. Keys are per page, so the probe
+// is unauthenticated and a 401 counts as reachable.
+func probeDify(ctx context.Context) HealthCheck {
+	return probeHTTP(ctx, strings.TrimRight(config.DifyBaseURL, "/")+"/parameters", "", true)
+}
+
+// probeHTTP GETs url; failures are reported as degraded because the fallback
+// chain still answers without the upstream
+func probeHTTP(ctx context.Context, url, apiKey string, allowUnauthorized bool) HealthCheck {
+	start := time.Now()
+	check := HealthCheck{Status: HealthOK, CheckedAt: start}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		check.Status, check.Error = HealthDegraded, err.Error()
+		return check
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	check.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Status, check.Error = HealthDegraded, err.Error()
+		return check
+	}
+	resp.Body.Close()
+
+	check.Details = map[string]any{"http_status": resp.StatusCode}
+	unauthorized := resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+	if resp.StatusCode >= 500 || (resp.StatusCode >= 400 && !(allowUnauthorized && unauthorized)) {
+		check.Status = HealthDegraded
+		check.Error = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	return check
+}
+
+// waitForAsyncWork waits until background webhook processing has finished
+func waitForAsyncWork(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for asyncInFlight.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
@@ -41,7 +41,7 @@
 //
 // Integration Points:
 //
-//   - Facebook Graph API: Message sending operations
+//   - Facebook Graph API: Message sending, profiles and content (shared graph package)
 //   - Fireworks AI API: Sentiment analysis for routing decisions
 //   - Dify AI API: Chatbot response generation (per-tenant API keys)
 //   - PostgreSQL: Multi-tenant conversation and message storage
@@ -53,18 +53,22 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
 	"database/sql"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"graph"
+	"logging"
+	"message-router/migrations"
 	"message-router/oauth"
 	"message-router/sentiment"
 
@@ -73,76 +77,47 @@ import (
 )
 
 // =============================================================================
-// LOGGING SYSTEM - Optimized for debugging experience
+// LOGGING SYSTEM - Structured JSON logs via the shared logging package
 // =============================================================================
 
-type LogLevel int
-
-const (
-	LogLevelError LogLevel = iota
-	LogLevelWarn
-	LogLevelInfo
-	LogLevelDebug
-)
-
-var (
-	currentLogLevel LogLevel = LogLevelInfo // Default to info level
-	logLevelNames            = map[LogLevel]string{
-		LogLevelError: "ERROR",
-		LogLevelWarn:  "WARN",
-		LogLevelInfo:  "INFO",
-		LogLevelDebug: "DEBUG",
-	}
-)
-
-// Log level helper functions for clean debugging experience
+// Log level helpers. Calls with a leading "[%s] " request ID keep working: the
+// ID is moved into the request_id field. Prefer the Ctx variants inside the
+// message pipeline, where the context also carries page_id and thread_id.
 func LogError(format string, args ...interface{}) {
-	if currentLogLevel >= LogLevelError {
-		log.Printf("❌ "+format, args...)
-	}
+	logging.Errorf(context.Background(), format, args...)
 }
 
 func LogWarn(format string, args ...interface{}) {
-	if currentLogLevel >= LogLevelWarn {
-		log.Printf("⚠️ "+format, args...)
-	}
+	logging.Warnf(context.Background(), format, args...)
 }
 
 func LogInfo(format string, args ...interface{}) {
-	if currentLogLevel >= LogLevelInfo {
-		log.Printf("ℹ️ "+format, args...)
-	}
+	logging.Infof(context.Background(), format, args...)
 }
 
 func LogDebug(format string, args ...interface{}) {
-	if currentLogLevel >= LogLevelDebug {
-		log.Printf("🔍 "+format, args...)
-	}
+	logging.Debugf(context.Background(), format, args...)
 }
 
-// generateRequestID creates a unique ID for correlating logs across async processing
-func generateRequestID() string {
-	b := make([]byte, 4)
-	rand.Read(b)
-	return fmt.Sprintf("req_%x", b)
+func LogErrorCtx(ctx context.Context, format string, args ...interface{}) {
+	logging.Errorf(ctx, format, args...)
 }
 
-// setLogLevelFromEnv configures log level from environment variable
-func setLogLevelFromEnv() {
-	levelStr := strings.ToUpper(os.Getenv("LOG_LEVEL"))
-	switch levelStr {
-	case "ERROR":
-		currentLogLevel = LogLevelError
-	case "WARN", "WARNING":
-		currentLogLevel = LogLevelWarn
-	case "INFO":
-		currentLogLevel = LogLevelInfo
-	case "DEBUG":
-		currentLogLevel = LogLevelDebug
-	default:
-		currentLogLevel = LogLevelInfo // Default
-	}
-	log.Printf("🔧 Log level set to: %s", logLevelNames[currentLogLevel])
+func LogWarnCtx(ctx context.Context, format string, args ...interface{}) {
+	logging.Warnf(ctx, format, args...)
+}
+
+func LogInfoCtx(ctx context.Context, format string, args ...interface{}) {
+	logging.Infof(ctx, format, args...)
+}
+
+func LogDebugCtx(ctx context.Context, format string, args ...interface{}) {
+	logging.Debugf(ctx, format, args...)
+}
+
+// generateRequestID creates a unique ID for correlating logs across async processing
+func generateRequestID() string {
+	return "req_" + logging.NewRequestID()
 }
 
 // =============================================================================
@@ -150,33 +125,40 @@ func setLogLevelFromEnv() {
 // =============================================================================
 
 var (
-	db         *sql.DB // Client Manager DB
 	httpClient = &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   10 * time.Second,
+		Transport: newTracingTransport(nil), // Client spans and traceparent for Dify and Graph API calls
 	}
-	config            Config
-	sentimentAnalyzer *sentiment.Analyzer
+	graphClient         = graph.New(graph.Config{HTTPClient: httpClient}) // Replaced in setup with GRAPH_API_* settings
+	config              Config
+	sentimentClassifier sentiment.Classifier        // Primary classifier with its fallbacks
+	sentimentCache      *sentiment.CachedClassifier // nil when SENTIMENT_CACHE_SIZE=0
+	answerModerator     *sentiment.Analyzer         // Reviews Dify answers for pages with LLM moderation
+	difyBreakers        *circuitBreakerRegistry     // One circuit breaker per Dify API key
 
 	// Instagram bot flag system - tracks which messages are bot responses
 	botFlags      = make(map[string]bool) // conversation_id -> is_bot_message
 	botFlagsMutex = sync.RWMutex{}
 )
 
-func init() {
-	// Set up logging with microsecond precision
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
-
-	// Configure log level from environment
-	setLogLevelFromEnv()
+// setup loads the configuration and connects the database, tracing and
+// classifiers, and returns the Postgres store for setupRouter. It runs at the
+// start of main rather than in init, so tests can build the package without a
+// database and use their own stores.
+func setup() *PostgresStore {
+	// Structured logging (LOG_LEVEL, LOG_FORMAT, LOG_REDACT); log.Printf goes through it too
+	logging.SetupFromEnv("message-router")
 
 	LogInfo("🚀 Starting Neurocrow Message Router...")
 
 	loadConfig()
-	setupDatabase()
-	setupSentimentAnalyzer()
-
-	// Initialize OAuth database connections
-	oauth.InitDB(config.DatabaseURL)
+	setupTracing()
+	setupGraphClient()
+	pool := setupDatabase()
+	runStartupMigrations(pool)
+	setupSentimentAnalyzer(pool)
+	setupAnswerModerator()
+	return NewPostgresStore(pool)
 }
 
 func loadConfig() {
@@ -201,8 +183,81 @@ func loadConfig() {
 		// Botpress integration (legacy - temporary during migration)
 		BotpressToken: os.Getenv("BOTPRESS_TOKEN"), // Optional during migration
 		// Note: Dify API keys are now stored per-page in database (multi-tenant)
+		DifyBaseURL:          getEnvOrDefault("DIFY_BASE_URL", "https://api.dify.ai/v1"),
+		DifyMaxRetries:       getEnvIntOrDefault("DIFY_MAX_RETRIES", 3),
+		DifyBreakerThreshold: getEnvIntOrDefault("DIFY_BREAKER_THRESHOLD", 5),
+		DifyBreakerCooldown:  getEnvDurationOrDefault("DIFY_BREAKER_COOLDOWN", 60*time.Second),
+		DifyDegradedReply: getEnvOrDefault("DIFY_DEGRADED_REPLY",
+			"Gracias por tu mensaje. En este momento nuestro asistente no está disponible, te responderemos lo antes posible."),
+		LLMPrices:            loadLLMPrices(os.Getenv("LLM_PRICES")),
+		FAQMatchThreshold:    getEnvFloatOrDefault("FAQ_MATCH_THRESHOLD", 0.85),
+		FAQFallbackThreshold: getEnvFloatOrDefault("FAQ_FALLBACK_THRESHOLD", 0.6),
+		QuotaDefaults: QuotaPolicy{
+			SenderPerMinute:     getEnvIntOrDefault("QUOTA_SENDER_PER_MINUTE", 10),
+			SenderAction:        parseQuotaAction(getEnvOrDefault("QUOTA_SENDER_ACTION", "drop")),
+			PageDailyReplies:    getEnvIntOrDefault("QUOTA_PAGE_DAILY_REPLIES", 0),
+			PageDailyAction:     parseQuotaAction(getEnvOrDefault("QUOTA_PAGE_DAILY_ACTION", "handoff")),
+			ClientMonthlyTokens: int64(getEnvIntOrDefault("QUOTA_CLIENT_MONTHLY_TOKENS", 0)),
+			ClientMonthlyAction: parseQuotaAction(getEnvOrDefault("QUOTA_CLIENT_MONTHLY_ACTION", "handoff")),
+			TemplateMessage: getEnvOrDefault("QUOTA_TEMPLATE_MESSAGE",
+				"Hemos recibido muchos mensajes tuyos en poco tiempo. Te responderemos en breve."),
+		},
+		Guard: GuardConfig{
+			Enabled:         getEnvOrDefault("GUARD_ENABLED", "false") == "true",
+			MaxURLs:         getEnvIntOrDefault("GUARD_MAX_URLS", 2),
+			MaxPhoneNumbers: getEnvIntOrDefault("GUARD_MAX_PHONE_NUMBERS", 2),
+			RepeatLimit:     getEnvIntOrDefault("GUARD_REPEAT_LIMIT", 3),
+			RepeatWindow:    getEnvDurationOrDefault("GUARD_REPEAT_WINDOW", 10*time.Minute),
+			RepeatMinLength: getEnvIntOrDefault("GUARD_REPEAT_MIN_LENGTH", 15),
+			InjectionRules:  getEnvOrDefault("GUARD_INJECTION_RULES", "true") == "true",
+			DefaultAction:   parseGuardAction(getEnvOrDefault("GUARD_ACTION", "ignore")),
+			DefaultReply: getEnvOrDefault("GUARD_REPLY",
+				"No podemos procesar este mensaje. Si necesitas ayuda, escríbenos tu consulta sin enlaces ni instrucciones para el asistente."),
+		},
+		SentimentMinConfidence: getEnvFloatOrDefault("SENTIMENT_MIN_CONFIDENCE", 0.6),
+		SentimentHistoryTurns:  getEnvIntOrDefault("SENTIMENT_HISTORY_TURNS", 6),
+		FrustrationSmoothing:   getEnvFloatOrDefault("FRUSTRATION_SMOOTHING", 0.5),
+		FrustrationThreshold:   getEnvFloatOrDefault("FRUSTRATION_THRESHOLD", 0.75),
+		Safety: SafetyConfig{
+			Enabled:           getEnvOrDefault("SAFETY_ENABLED", "false") == "true",
+			ForbiddenTerms:    splitAndTrim(os.Getenv("SAFETY_FORBIDDEN_TERMS")),
+			MaxLength:         getEnvIntOrDefault("SAFETY_MAX_LENGTH", 2000),
+			LLMModeration:     getEnvOrDefault("SAFETY_LLM_MODERATION", "false") == "true",
+			ModerationModel:   getEnvOrDefault("SAFETY_MODERATION_MODEL", sentiment.DefaultModel),
+			ModerationURL:     getEnvOrDefault("SAFETY_MODERATION_URL", sentiment.FireworksURL),
+			ModerationTimeout: getEnvDurationOrDefault("SAFETY_MODERATION_TIMEOUT", 5*time.Second),
+			OnFail:            parseSafetyAction(getEnvOrDefault("SAFETY_ACTION", "template")),
+			MaxRegenerations:  getEnvIntOrDefault("SAFETY_MAX_REGENERATIONS", 1),
+			SafeTemplate: getEnvOrDefault("SAFETY_TEMPLATE",
+				"Gracias por tu mensaje. Un miembro de nuestro equipo te dará esa información en breve."),
+		},
+		Comments: CommentPolicy{
+			Enabled:          getEnvOrDefault("COMMENT_AUTOMATION_ENABLED", "false") == "true",
+			GeneralAction:    parseCommentAction(getEnvOrDefault("COMMENT_GENERAL_ACTION", "reply"), CommentActionReply),
+			FrustratedAction: parseCommentAction(getEnvOrDefault("COMMENT_FRUSTRATED_ACTION", "private_reply"), CommentActionPrivateReply),
+			NeedHumanAction:  parseCommentAction(getEnvOrDefault("COMMENT_NEED_HUMAN_ACTION", "flag"), CommentActionFlag),
+			SpamAction:       parseCommentAction(getEnvOrDefault("COMMENT_SPAM_ACTION", "hide"), CommentActionHide),
+			PrivateReply: getEnvOrDefault("COMMENT_PRIVATE_REPLY",
+				"¡Hola! Vimos tu comentario y te escribimos por aquí para ayudarte mejor. ¿Nos cuentas qué necesitas?"),
+		},
+		Moderation: ModerationRules{
+			Enabled:         getEnvOrDefault("MODERATION_ENABLED", "false") == "true",
+			Keywords:        splitAndTrim(os.Getenv("MODERATION_KEYWORDS")),
+			HideLinks:       getEnvOrDefault("MODERATION_HIDE_LINKS", "true") == "true",
+			HideProfanity:   getEnvOrDefault("MODERATION_HIDE_PROFANITY", "true") == "true",
+			SentimentLabels: splitAndTrim(getEnvOrDefault("MODERATION_SENTIMENT_LABELS", "spam")),
+		},
+		Health: HealthConfig{
+			CheckTimeout:   getEnvDurationOrDefault("READYZ_CHECK_TIMEOUT", 2*time.Second),
+			MaxInFlight:    int64(getEnvIntOrDefault("READYZ_MAX_IN_FLIGHT", 200)),
+			ProbeUpstreams: getEnvOrDefault("READYZ_PROBE_UPSTREAMS", "false") == "true",
+			ProbeInterval:  getEnvDurationOrDefault("READYZ_PROBE_INTERVAL", time.Minute),
+		},
 	}
 
+	config.WebhookSecrets = loadWebhookSecrets(config.FacebookAppSecret, config.InstagramAppSecretKey)
+	difyBreakers = newCircuitBreakerRegistry(config.DifyBreakerThreshold, config.DifyBreakerCooldown)
+
 	// Log configuration (safely)
 	log.Printf("📝 Configuration loaded:")
 	log.Printf("   Database URL length: %d", len(config.DatabaseURL))
@@ -210,26 +265,110 @@ func loadConfig() {
 	log.Printf("   Facebook App ID length: %d", len(config.FacebookAppID))
 	log.Printf("   Instagram App ID length: %d", len(config.InstagramAppID))
 	log.Printf("   Instagram App Secret Key length: %d", len(config.InstagramAppSecretKey))
+	log.Printf("   Webhook signature secrets: %s", webhookSecretNames(config.WebhookSecrets))
 	log.Printf("   Verify Token length: %d", len(config.VerifyToken))
 	log.Printf("   Fireworks API Key length: %d", len(config.FireworksKey))
 	log.Printf("   Facebook Bot App ID: %d", config.FacebookBotAppID)
 	log.Printf("   Facebook Page Inbox App ID: %d", config.FacebookPageInboxAppID)
 	log.Printf("   Dify API keys: stored per-page in database (multi-tenant)")
+	log.Printf("   Dify base URL: %s", config.DifyBaseURL)
+	log.Printf("   Dify retries: %d, circuit breaker: %d failures / %v cooldown",
+		config.DifyMaxRetries, config.DifyBreakerThreshold, config.DifyBreakerCooldown)
 	if config.BotpressToken != "" {
 		log.Printf("   Botpress Token length: %d (legacy)", len(config.BotpressToken))
 	} else {
 		log.Printf("   Botpress Token: not set (migration mode)")
 	}
+	log.Printf("   LLM prices configured: %d", len(config.LLMPrices))
+	log.Printf("   FAQ match threshold: %.2f (fallback: %.2f)", config.FAQMatchThreshold, config.FAQFallbackThreshold)
+	log.Printf("   Default quotas: %d msgs/min per sender (%s), %d replies/day per page (%s), %d tokens/month per client (%s)",
+		config.QuotaDefaults.SenderPerMinute, config.QuotaDefaults.SenderAction,
+		config.QuotaDefaults.PageDailyReplies, config.QuotaDefaults.PageDailyAction,
+		config.QuotaDefaults.ClientMonthlyTokens, config.QuotaDefaults.ClientMonthlyAction)
+	log.Printf("   Message guard enabled: %v (default action: %s)", config.Guard.Enabled, config.Guard.DefaultAction)
+	log.Printf("   Answer safety filter enabled: %v (default action: %s, LLM moderation: %v)",
+		config.Safety.Enabled, config.Safety.OnFail, config.Safety.LLMModeration)
+	log.Printf("   Comment automation enabled: %v (general: %s, frustrated: %s, need_human: %s, spam: %s)",
+		config.Comments.Enabled, config.Comments.GeneralAction, config.Comments.FrustratedAction,
+		config.Comments.NeedHumanAction, config.Comments.SpamAction)
+	log.Printf("   Comment moderation enabled: %v (%d keywords, links: %v, profanity: %v, sentiment labels: %v)",
+		config.Moderation.Enabled, len(config.Moderation.Keywords), config.Moderation.HideLinks,
+		config.Moderation.HideProfanity, config.Moderation.SentimentLabels)
 	log.Printf("   Port: %s", config.Port)
 }
 
-func setupSentimentAnalyzer() {
-	// Initialize sentiment analyzer
-	sentimentConfig := sentiment.DefaultConfig()
-	sentimentConfig.FireworksKey = config.FireworksKey
-	sentimentAnalyzer = sentiment.New(sentimentConfig)
+func setupSentimentAnalyzer(pool *sql.DB) {
+	// Build the classifier chain in the configured order (primary first, then fallbacks)
+	var classifiers []sentiment.Classifier
+	for _, provider := range splitAndTrim(getEnvOrDefault("SENTIMENT_PROVIDERS", "fireworks,lexicon")) {
+		switch provider {
+		case "fireworks":
+			sentimentConfig := sentiment.DefaultConfig()
+			sentimentConfig.FireworksKey = config.FireworksKey
+			sentimentConfig.Model = getEnvOrDefault("SENTIMENT_MODEL", sentiment.DefaultModel)
+			sentimentConfig.Transport = newTracingTransport(nil)
+			classifiers = append(classifiers, sentiment.New(sentimentConfig))
+		case "openai":
+			classifiers = append(classifiers, sentiment.NewOpenAICompatible(sentiment.OpenAIConfig{
+				BaseURL:   getEnvOrDefault("SENTIMENT_OPENAI_BASE_URL", "https://api.openai.com/v1"),
+				APIKey:    os.Getenv("SENTIMENT_OPENAI_API_KEY"),
+				Model:     getEnvOrDefault("SENTIMENT_OPENAI_MODEL", "gpt-4o-mini"),
+				Transport: newTracingTransport(nil),
+			}))
+		case "lexicon":
+			classifiers = append(classifiers, sentiment.NewLexicon())
+		default:
+			log.Printf("⚠️ Unknown sentiment provider %q ignored", provider)
+		}
+	}
+	if len(classifiers) == 0 {
+		log.Printf("⚠️ No valid SENTIMENT_PROVIDERS, using the offline lexicon")
+		classifiers = append(classifiers, sentiment.NewLexicon())
+	}
 
-	log.Printf("✅ Sentiment analyzer initialized")
+	chain := sentiment.NewChain(getEnvDurationOrDefault("SENTIMENT_TIMEOUT", 5*time.Second), classifiers...)
+	sentimentClassifier = chain
+
+	log.Printf("✅ Sentiment analyzer initialized (%s, %v per classifier)", chain.Name(), chain.Timeout)
+
+	// Cache repeated short messages in front of the chain
+	cacheSize := getEnvIntOrDefault("SENTIMENT_CACHE_SIZE", 10000)
+	if cacheSize <= 0 {
+		log.Printf("   Sentiment cache disabled")
+		return
+	}
+	var store sentiment.CacheStore
+	if getEnvOrDefault("SENTIMENT_CACHE_PERSIST", "false") == "true" {
+		pgStore := &postgresCacheStore{db: pool}
+		pgStore.pruneExpired(context.Background())
+		store = pgStore
+	}
+	cacheTTL := getEnvDurationOrDefault("SENTIMENT_CACHE_TTL", 24*time.Hour)
+	// Model names are part of the version so switching models never reuses old answers
+	cacheVersion := strings.Join([]string{os.Getenv("SENTIMENT_CACHE_VERSION"),
+		getEnvOrDefault("SENTIMENT_MODEL", sentiment.DefaultModel),
+		getEnvOrDefault("SENTIMENT_OPENAI_MODEL", "gpt-4o-mini")}, "|")
+	sentimentCache = sentiment.NewCachedClassifier(chain, sentiment.CacheConfig{
+		Size:             cacheSize,
+		TTL:              cacheTTL,
+		MaxMessageLength: getEnvIntOrDefault("SENTIMENT_CACHE_MAX_LENGTH", 40),
+		Version:          cacheVersion,
+	}, store)
+	sentimentClassifier = sentimentCache
+	log.Printf("   Sentiment cache: %d entries, TTL %v, persistent: %v", cacheSize, cacheTTL, store != nil)
+}
+
+// setupAnswerModerator creates the client for LLM moderation of bot answers.
+// It is built even when SAFETY_LLM_MODERATION is off, since pages can turn
+// moderation on in page_safety_policies.
+func setupAnswerModerator() {
+	answerModerator = sentiment.New(sentiment.Config{
+		FireworksKey: config.FireworksKey,
+		Model:        config.Safety.ModerationModel,
+		Timeout:      config.Safety.ModerationTimeout,
+		Transport:    newTracingTransport(nil),
+		Endpoint:     config.Safety.ModerationURL,
+	})
 }
 
 func getEnvOrDie(key string) string {
@@ -248,20 +387,108 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return value
 }
 
-func setupDatabase() {
+// splitAndTrim turns a comma-separated list into its non-empty, trimmed items
+func splitAndTrim(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️ Invalid integer for %s (%q), using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("⚠️ Invalid number for %s (%q), using default %.2f", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️ Invalid duration for %s (%q), using default %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// setupGraphClient configures the Graph API client from GRAPH_API_BASE_URL,
+// GRAPH_API_VERSION and GRAPH_API_MAX_RETRIES; the oauth package shares it
+func setupGraphClient() {
+	graphConfig := graph.ConfigFromEnv()
+	graphConfig.HTTPClient = httpClient
+	graphClient = graph.New(graphConfig)
+
+	oauth.GraphClient = graphClient
+	oauth.InstagramClient = graphClient.WithBaseURL(getEnvOrDefault("INSTAGRAM_GRAPH_BASE_URL", graph.InstagramBaseURL), false)
+	oauth.InstagramLoginClient = graphClient.WithBaseURL(getEnvOrDefault("INSTAGRAM_OAUTH_BASE_URL", graph.InstagramOAuthBaseURL), true)
+	log.Printf("✅ Graph API client: %s (%s)", graphClient.BaseURL(), graphClient.Version())
+}
+
+func setupDatabase() *sql.DB {
 	log.Printf("📊 Setting up database connection...")
 
-	var err error
 	for i := 0; i < 3; i++ {
 		log.Printf("🔄 Database connection attempt %d/3...", i+1)
-		if db, err = connectDB(config.DatabaseURL, "Database"); err == nil {
+		pool, err := connectDB(config.DatabaseURL, "Database")
+		if err == nil {
 			log.Printf("✅ Successfully connected to database!")
-			return
+			return pool
 		}
 		log.Printf("❌ Connection attempt %d failed: %v", i+1, err)
 		time.Sleep(time.Second * 2)
 	}
 	log.Fatal("❌ Failed to connect to database after 3 attempts")
+	return nil
+}
+
+// runStartupMigrations applies pending schema migrations when MIGRATE_ON_START=true.
+// Otherwise the schema is managed with `go run ./cmd/migrate`.
+func runStartupMigrations(pool *sql.DB) {
+	if getEnvOrDefault("MIGRATE_ON_START", "false") != "true" {
+		return
+	}
+
+	migrator, err := migrations.New(pool)
+	if err != nil {
+		log.Fatalf("❌ Loading migrations: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	applied, err := migrator.Up(ctx)
+	for _, m := range applied {
+		log.Printf("🗄️ Applied migration %04d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatalf("❌ Schema migration failed: %v", err)
+	}
+	log.Printf("✅ Database schema up to date (%d migrations applied now)", len(applied))
 }
 
 func connectDB(dbURL string, dbName string) (*sql.DB, error) {
@@ -288,17 +515,28 @@ func logMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Log incoming request details
-		log.Printf("🔍 Request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
-		log.Printf("   Headers: %v", r.Header)
-		log.Printf("   Query Parameters: %v", r.URL.Query())
+		// Correlate every log line of this request (reuse the caller's ID when given)
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = generateRequestID()
+		}
+		ctx := logging.WithRequestID(r.Context(), requestID)
+		r = r.WithContext(ctx)
+		w.Header().Set("X-Request-ID", requestID)
+
+		// Log incoming request details (credentials in headers and query are masked)
+		slog.InfoContext(ctx, "🔍 Request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		if logging.Enabled(slog.LevelDebug) {
+			slog.DebugContext(ctx, "Request details",
+				"headers", logging.RedactHeaders(r.Header), "query", logging.RedactQuery(r.URL.Query()))
+		}
 
 		// Call the next handler
 		next(w, r)
 
 		// Log request completion
-		duration := time.Since(start)
-		log.Printf("⏱️ Request completed in %v", duration)
+		slog.InfoContext(ctx, "⏱️ Request completed", "method", r.Method, "path", r.URL.Path,
+			"duration_ms", time.Since(start).Milliseconds())
 	}
 }
 
@@ -306,8 +544,7 @@ func recoverMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				// Log the stack trace
-				log.Printf("❌ PANIC RECOVERED: %v", err)
+				LogErrorCtx(r.Context(), "❌ PANIC RECOVERED: %v", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}()
@@ -338,18 +575,22 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func setupRouter() *http.ServeMux {
+func setupRouter(s Stores) http.Handler {
 	router := http.NewServeMux()
+	processor := NewMessageProcessor(s)
 
 	// Register routes with middleware
 	router.HandleFunc("/", logMiddleware(healthCheckHandler))
+	router.HandleFunc("/healthz", handleHealthz)
+	router.HandleFunc("/readyz", handleReadyz(s.Health))
+	router.HandleFunc("/metrics", metricsHandler())
 
 	// Main webhook endpoint for Facebook/Instagram
 	router.HandleFunc("/webhook", logMiddleware(recoverMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		// If it has Facebook signature headers, treat as Facebook webhook
-		if r.Header.Get("X-Hub-Signature-256") != "" {
+		if r.Header.Get("X-Hub-Signature-256") != "" || r.Header.Get("X-Hub-Signature") != "" {
 			log.Printf("✅ Facebook/Instagram webhook request detected")
-			validateFacebookRequest(handleWebhook)(w, r)
+			validateFacebookRequest(processor.handleWebhook)(w, r)
 			return
 		}
 
@@ -360,24 +601,33 @@ func setupRouter() *http.ServeMux {
 	})))
 
 	// New endpoint for sending messages from the dashboard
-	router.HandleFunc("/send-message", logMiddleware(recoverMiddleware(handleSendMessage)))
+	router.HandleFunc("/send-message", logMiddleware(recoverMiddleware(handleSendMessage(s.Pages))))
 
 	// Instagram bot flag endpoint for Dify integration
 	router.HandleFunc("/api/mark-bot-response", logMiddleware(recoverMiddleware(handleMarkBotResponse)))
 
 	// OAuth endpoints for client onboarding
-	router.HandleFunc("/facebook-token", oauth.CorsMiddleware(logMiddleware(recoverMiddleware(oauth.HandleFacebookToken))))
-	router.HandleFunc("/facebook-business-token", oauth.CorsMiddleware(logMiddleware(recoverMiddleware(oauth.HandleFacebookBusinessToken))))
-	router.HandleFunc("/instagram-token", oauth.CorsMiddleware(logMiddleware(recoverMiddleware(oauth.HandleInstagramToken))))
+	router.HandleFunc("/facebook-token", oauth.CorsMiddleware(logMiddleware(recoverMiddleware(oauth.HandleFacebookToken(s.Clients)))))
+	router.HandleFunc("/facebook-business-token", oauth.CorsMiddleware(logMiddleware(recoverMiddleware(oauth.HandleFacebookBusinessToken(s.Clients)))))
+	router.HandleFunc("/instagram-token", oauth.CorsMiddleware(logMiddleware(recoverMiddleware(oauth.HandleInstagramToken(s.Clients)))))
 	router.HandleFunc("/instagram-token-exchange", oauth.CorsMiddleware(logMiddleware(recoverMiddleware(oauth.HandleInstagramTokenExchange))))
 
 	// Content Management API endpoints
-	contentMgmt := NewContentManagement(db)
-	authMiddleware := NewAuthMiddleware(db)
+	contentMgmt := NewContentManagement(s.Pages, s.ModerationLog)
+	authMiddleware := NewAuthMiddleware(s.Pages)
 	
 	router.HandleFunc("/api/pages", authMiddleware.ContentAuthMiddleware(contentMgmt.GetUserPages))
 	router.HandleFunc("/api/posts/", authMiddleware.ContentAuthMiddleware(handlePostsRoute(contentMgmt)))
 	router.HandleFunc("/api/comments/", authMiddleware.ContentAuthMiddleware(handleCommentsRoute(contentMgmt)))
+	router.HandleFunc("/api/conversations/", authMiddleware.ContentAuthMiddleware(handleResetConversationContext(s.Conversations)))
+	router.HandleFunc("/api/reply-tiers", authMiddleware.ContentAuthMiddleware(handleReplyTierStats(s.ReplyTiers)))
+	router.HandleFunc("/api/usage", authMiddleware.ContentAuthMiddleware(handleUsageReport(s.Usage)))
+	router.HandleFunc("/api/moderation-log", authMiddleware.ContentAuthMiddleware(handleModerationLog(s.ModerationLog)))
+	router.HandleFunc("/api/sentiment-cache", AdminAuthMiddleware(handleSentimentCache)) // Shared by every client
+
+	// FAQ / canned answers API
+	faqManager := NewFAQManager(s.FAQ)
+	router.HandleFunc("/api/faq/", authMiddleware.ContentAuthMiddleware(handleFAQRoute(faqManager)))
 	
 	// Temporary media files serving for Instagram posting
 	router.Handle("/temp-media/", http.StripPrefix("/temp-media/", http.FileServer(http.Dir("/tmp/media_uploads"))))
@@ -385,6 +635,7 @@ func setupRouter() *http.ServeMux {
 	// Log registered routes
 	log.Printf("📍 Registered routes:")
 	log.Printf("   - GET/POST/HEAD / (Health Check)")
+	log.Printf("   - GET /healthz, /readyz (Liveness, Readiness)")
 	log.Printf("   - GET/POST /webhook (Facebook/Instagram Webhook)")
 	log.Printf("   - POST /send-message (Dashboard Message Sender)")
 	log.Printf("   - POST /api/mark-bot-response (Instagram Bot Flag)")
@@ -395,6 +646,13 @@ func setupRouter() *http.ServeMux {
 	log.Printf("   - GET /api/pages (Content Management: Get Pages)")
 	log.Printf("   - GET/POST/DELETE /api/posts/{pageId} (Content Management: Posts)")
 	log.Printf("   - GET/POST /api/comments/{id} (Content Management: Comments)")
+	log.Printf("   - POST /api/comments/{commentId}/hide|unhide (Comment Moderation)")
+	log.Printf("   - POST /api/conversations/{threadId}/reset-context (Reset AI Context)")
+	log.Printf("   - GET /api/reply-tiers (Fallback Chain Stats)")
+	log.Printf("   - GET /api/usage (LLM Usage & Cost Report)")
+	log.Printf("   - GET /api/moderation-log (Hidden Comments Audit Log)")
+	log.Printf("   - GET/POST/PUT/DELETE /api/faq/{pageId}[/{entryId}] (FAQ Answers)")
+	log.Printf("   - GET/DELETE /api/sentiment-cache (Sentiment Cache, admin token)")
 	log.Printf("   - GET /temp-media/* (Temporary Media Files for Instagram)")
 	log.Printf("🤖 AI Integration: Dify (per-page API keys)")
 	log.Printf("📊 Database: Multi-tenant client support")
@@ -405,13 +663,17 @@ func setupRouter() *http.ServeMux {
 	return router
 }
 
-func cleanup() {
-	if db != nil {
-		log.Printf("🧹 Closing database connection...")
-		db.Close()
+func cleanup(store *PostgresStore) {
+	log.Printf("🧹 Closing database connection...")
+	store.db.Close()
+	// Flush spans still waiting in the exporter
+	if tracingShutdown != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracingShutdown(ctx); err != nil {
+			log.Printf("⚠️ Tracing shutdown: %v", err)
+		}
 	}
-	// Cleanup OAuth database connections
-	oauth.CleanupDB()
 }
 
 // =============================================================================
@@ -492,8 +754,11 @@ func handleCommentsRoute(cm *ContentManagement) http.HandlerFunc {
 			// Get comments for post: GET /api/comments/{pageId}/{postId}
 			cm.GetPostComments(w, r)
 		case "POST":
-			// Determine if this is a comment to post or reply to comment
-			if len(parts) >= 2 && parts[1] != "reply" {
+			// Determine if this is a comment to post, a reply to comment or moderation
+			if len(parts) >= 2 && (parts[1] == "hide" || parts[1] == "unhide") {
+				// Hide or unhide comment: POST /api/comments/{commentId}/hide|unhide
+				cm.SetCommentHidden(w, r)
+			} else if len(parts) >= 2 && parts[1] != "reply" {
 				// Add comment to post: POST /api/comments/{pageId}/{postId}
 				cm.AddCommentToPost(w, r)
 			} else if len(parts) >= 2 && parts[1] == "reply" {
@@ -514,11 +779,39 @@ func handleCommentsRoute(cm *ContentManagement) http.HandlerFunc {
 	}
 }
 
+func handleFAQRoute(fm *FAQManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			// List entries: GET /api/faq/{pageId}
+			fm.ListEntries(w, r)
+		case "POST":
+			// Create entry: POST /api/faq/{pageId}
+			fm.CreateEntry(w, r)
+		case "PUT":
+			// Update entry: PUT /api/faq/{pageId}/{entryId}
+			fm.UpdateEntry(w, r)
+		case "DELETE":
+			// Delete entry: DELETE /api/faq/{pageId}/{entryId}
+			fm.DeleteEntry(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
 // Bot reactivation system: 12-hour rule with message-triggered checks
 // System auto-disables bot when human agents respond, auto-reactivates after 12 hours of inactivity
 // No background workers needed - reactivation check runs on each message processing
 
 func main() {
+	// `message-router simulate` replays webhooks instead of serving (simulate.go)
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(runSimulate(os.Args[2:]))
+	}
+
+	store := setup()
+
 	// Create context for graceful shutdown (used in shutdown signal handling)
 	_, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -530,15 +823,16 @@ func main() {
 	// Bot reactivation now happens on message processing (no background worker needed)
 
 	// Ensure cleanup on exit
-	defer cleanup()
+	defer cleanup(store)
 
-	// Set up router
-	router := setupRouter()
+	// Set up router and metrics
+	setupMetrics(store.db)
+	router := setupRouter(store.Stores())
 
 	// Configure server
 	server := &http.Server{
 		Addr:         ":" + config.Port,
-		Handler:      router,
+		Handler:      traceMiddleware(router),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
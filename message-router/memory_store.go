@@ -0,0 +1,898 @@
+// memory_store.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"message-router/oauth"
+	"message-router/sentiment"
+)
+
+// =============================================================================
+// IN-MEMORY STORE - Conversations, pages and messages without a database
+// =============================================================================
+
+// MemoryPage is a connected page as seeded into a MemoryStore
+type MemoryPage struct {
+	PageID          string
+	Platform        string
+	ClientID        string
+	Name            string
+	AccessToken     string
+	DifyAPIKey      string
+	DifyFallbackKey string
+	DifyFallbackURL string
+	Inactive        bool // Pages that were disconnected; lookups treat them as missing
+
+	FAQ            []FAQEntry   // Disabled entries are skipped like in faq_entries
+	Quotas         *QuotaPolicy // Replaces the defaults when set (page_quotas and client_quotas)
+	BlockedSenders []string
+	Guard          PageGuardSettings
+	Safety         *SafetyPolicy      // Replaces the defaults when set (page_safety_policies)
+	Comments       *CommentPolicy     // Replaces the defaults when set (page_comment_policies)
+	Moderation     *ModerationRules   // Replaces the defaults when set (page_moderation_rules)
+	Sentiment      *sentiment.Options // page_sentiment_settings
+}
+
+// memoryConversation is a conversation row with the columns ConversationState lacks
+type memoryConversation struct {
+	state         ConversationState
+	userName      string
+	frustration   float64
+	botDisabledAt time.Time
+	updatedAt     time.Time
+}
+
+// memoryMessage is a message row
+type memoryMessage struct {
+	pageID   string
+	platform string
+	threadID string
+	turn     sentiment.Turn
+}
+
+// MemoryStore implements every store in memory with the same semantics as
+// PostgresStore. It is meant for tests and local experiments; nothing survives
+// a restart.
+type MemoryStore struct {
+	mu            sync.Mutex
+	pages         map[string]MemoryPage // pageID + "/" + platform -> page
+	conversations map[string]*memoryConversation
+	messages      []memoryMessage         // Oldest first
+	counters      map[memoryCounter]int64 // rate_limit_counters
+	tokens        map[string]int64        // LLM tokens used per client
+	flags         []MessageFlag           // message_flags, oldest first
+	usage         []memoryUsage           // llm_usage, oldest first
+	replyTiers    []memoryReplyTier       // bot_reply_events, oldest first
+	moderations   []ModerationEntry       // comment_moderation_log, oldest first
+	clients       map[string]string       // Facebook or Instagram user ID -> client ID
+	nextID        int                     // Sequence for client and FAQ entry IDs
+}
+
+// memoryUsage is an llm_usage row
+type memoryUsage struct {
+	usage     LLMUsage
+	clientID  string
+	costUSD   float64
+	requestID string
+	createdAt time.Time
+}
+
+// memoryReplyTier is a bot_reply_events row
+type memoryReplyTier struct {
+	pageID    string
+	platform  string
+	threadID  string
+	tier      ReplyTier
+	createdAt time.Time
+}
+
+// memoryCounter is the primary key of a rate_limit_counters row
+type memoryCounter struct {
+	scope  string
+	key    string
+	window time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pages:         make(map[string]MemoryPage),
+		conversations: make(map[string]*memoryConversation),
+		counters:      make(map[memoryCounter]int64),
+		tokens:        make(map[string]int64),
+		clients:       make(map[string]string),
+	}
+}
+
+// Stores returns the store behind every interface
+func (s *MemoryStore) Stores() Stores {
+	return Stores{Conversations: s, Pages: s, Messages: s, FAQ: s, Quotas: s, Guard: s,
+		Policies: s, Usage: s, ReplyTiers: s, ModerationLog: s, Clients: s, Health: s}
+}
+
+// AddPage connects a page, replacing any page with the same ID and platform
+func (s *MemoryStore) AddPage(page MemoryPage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[page.PageID+"/"+page.Platform] = page
+}
+
+// AddMessage appends a message to a thread; role is "user", "bot" or "human"
+func (s *MemoryStore) AddMessage(pageID, platform, threadID, role, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, memoryMessage{pageID, platform, threadID, sentiment.Turn{Role: role, Content: content}})
+}
+
+// AddTokenUsage counts LLM tokens a client used
+func (s *MemoryStore) AddTokenUsage(clientID string, tokens int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[clientID] += tokens
+}
+
+// Flags returns the flagged messages, oldest first
+func (s *MemoryStore) Flags() []MessageFlag {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MessageFlag(nil), s.flags...)
+}
+
+// Usage returns the recorded LLM calls, oldest first
+func (s *MemoryStore) Usage() []LLMUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := make([]LLMUsage, len(s.usage))
+	for i, row := range s.usage {
+		usage[i] = row.usage
+	}
+	return usage
+}
+
+// ReplyTiers returns the tiers that answered, oldest first
+func (s *MemoryStore) ReplyTiers() []ReplyTier {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tiers := make([]ReplyTier, len(s.replyTiers))
+	for i, row := range s.replyTiers {
+		tiers[i] = row.tier
+	}
+	return tiers
+}
+
+// Moderations returns the moderation log, oldest first
+func (s *MemoryStore) Moderations() []ModerationEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ModerationEntry(nil), s.moderations...)
+}
+
+// Conversation returns a copy of a thread's conversation state
+func (s *MemoryStore) Conversation(threadID string) (ConversationState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, ok := s.conversations[threadID]
+	if !ok {
+		return ConversationState{}, false
+	}
+	return conv.state, true
+}
+
+// activePage returns an active page; the caller holds s.mu
+func (s *MemoryStore) activePage(pageID, platform string) (MemoryPage, bool) {
+	page, ok := s.pages[pageID+"/"+platform]
+	return page, ok && !page.Inactive
+}
+
+// conversation returns a thread's conversation or an error; the caller holds s.mu
+func (s *MemoryStore) conversation(threadID string) (*memoryConversation, error) {
+	conv, ok := s.conversations[threadID]
+	if !ok {
+		return nil, fmt.Errorf("error finding conversation %s: %w", threadID, ErrNotFound)
+	}
+	return conv, nil
+}
+
+// GetOrCreate implements ConversationStore
+func (s *MemoryStore) GetOrCreate(ctx context.Context, pageID, threadID, platform string) (*ConversationState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[pageID+"/"+platform]; !ok {
+		return nil, fmt.Errorf("error finding page: %w", ErrNotFound)
+	}
+	if conv, ok := s.conversations[threadID]; ok && conv.state.PageID == pageID {
+		state := conv.state
+		return &state, nil
+	}
+
+	now := time.Now()
+	conv := &memoryConversation{
+		state: ConversationState{
+			ThreadID:     threadID,
+			PageID:       pageID,
+			Platform:     platform,
+			BotEnabled:   true,
+			MessageCount: 1,
+		},
+		updatedAt: now,
+	}
+	s.conversations[threadID] = conv
+	state := conv.state
+	return &state, nil
+}
+
+// BotEnabled implements ConversationStore
+func (s *MemoryStore) BotEnabled(ctx context.Context, threadID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[threadID]
+	if !ok {
+		return true, ErrNotFound
+	}
+	return conv.state.BotEnabled, nil
+}
+
+// SetBotEnabled implements ConversationStore
+func (s *MemoryStore) SetBotEnabled(ctx context.Context, threadID string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(threadID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !enabled && conv.state.BotEnabled {
+		conv.botDisabledAt = now
+	} else if enabled {
+		conv.botDisabledAt = time.Time{}
+	}
+	conv.state.BotEnabled = enabled
+	conv.state.MessageCount++
+	conv.updatedAt = now
+	return nil
+}
+
+// RecordHumanMessage implements ConversationStore
+func (s *MemoryStore) RecordHumanMessage(ctx context.Context, pageID, threadID, platform string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(threadID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	conv.state.LastHumanMessage = now
+	conv.state.BotEnabled = false
+	conv.state.MessageCount++
+	conv.botDisabledAt = now
+	conv.updatedAt = now
+	return nil
+}
+
+// SetUserName implements ConversationStore
+func (s *MemoryStore) SetUserName(ctx context.Context, threadID, userName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(threadID)
+	if err != nil {
+		return err
+	}
+	conv.userName = userName
+	conv.updatedAt = time.Now()
+	return nil
+}
+
+// SetDifyConversationID implements ConversationStore. Like the UPDATE it replaces,
+// an unknown thread is not an error.
+func (s *MemoryStore) SetDifyConversationID(ctx context.Context, threadID, difyConversationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if conv, ok := s.conversations[threadID]; ok {
+		conv.state.DifyConversationID = difyConversationID
+		conv.updatedAt = time.Now()
+	}
+	return nil
+}
+
+// ClientDifyConversationID implements ConversationStore
+func (s *MemoryStore) ClientDifyConversationID(ctx context.Context, threadID, clientID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[threadID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	page, ok := s.pages[conv.state.PageID+"/"+conv.state.Platform]
+	if !ok || page.ClientID != clientID {
+		return "", ErrNotFound
+	}
+	return conv.state.DifyConversationID, nil
+}
+
+// UpdateFrustrationScore implements ConversationStore
+func (s *MemoryStore) UpdateFrustrationScore(ctx context.Context, threadID string, frustration, smoothing float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(threadID)
+	if err != nil {
+		return 0, err
+	}
+	conv.frustration = smoothing*frustration + (1-smoothing)*conv.frustration
+	conv.updatedAt = time.Now()
+	return conv.frustration, nil
+}
+
+// ResetFrustrationScore implements ConversationStore
+func (s *MemoryStore) ResetFrustrationScore(ctx context.Context, threadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if conv, ok := s.conversations[threadID]; ok {
+		conv.frustration = 0
+		conv.updatedAt = time.Now()
+	}
+	return nil
+}
+
+// ReenableDisabledBots implements ConversationStore with the same 12-hour rule
+// as the reenable_disabled_bots() function
+func (s *MemoryStore) ReenableDisabledBots(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-12 * time.Hour)
+	reactivated := 0
+	for _, conv := range s.conversations {
+		if conv.state.BotEnabled {
+			continue
+		}
+		last := conv.updatedAt
+		if !conv.state.LastHumanMessage.IsZero() {
+			last = conv.state.LastHumanMessage
+		} else if !conv.botDisabledAt.IsZero() {
+			last = conv.botDisabledAt
+		}
+		if last.Before(cutoff) {
+			conv.state.BotEnabled = true
+			conv.botDisabledAt = time.Time{}
+			conv.updatedAt = time.Now()
+			reactivated++
+		}
+	}
+	return reactivated, nil
+}
+
+// GetPageInfo implements PageStore
+func (s *MemoryStore) GetPageInfo(ctx context.Context, pageID, platform string) (*PageInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.activePage(pageID, platform)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &PageInfo{Platform: page.Platform, PageID: page.PageID, AccessToken: page.AccessToken}, nil
+}
+
+// GetDifyKeys implements PageStore
+func (s *MemoryStore) GetDifyKeys(ctx context.Context, pageID, platform string) (PageDifyKeys, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.activePage(pageID, platform)
+	if !ok {
+		return PageDifyKeys{}, ErrNotFound
+	}
+	return PageDifyKeys{
+		APIKey:          page.DifyAPIKey,
+		FallbackAPIKey:  page.DifyFallbackKey,
+		FallbackBaseURL: page.DifyFallbackURL,
+	}, nil
+}
+
+// StoreMessage implements MessageStore
+func (s *MemoryStore) StoreMessage(ctx context.Context, pageID, platform, threadID, source, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[pageID+"/"+platform]; ok {
+		s.messages = append(s.messages, memoryMessage{pageID, platform, threadID, sentiment.Turn{Role: source, Content: content}})
+	}
+	return nil
+}
+
+// StoreBotMessage implements MessageStore
+func (s *MemoryStore) StoreBotMessage(ctx context.Context, pageID, platform, threadID, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[pageID+"/"+platform]; ok {
+		s.messages = append(s.messages, memoryMessage{pageID, platform, threadID, sentiment.Turn{Role: "bot", Content: content}})
+	}
+	if conv, ok := s.conversations[threadID]; ok {
+		now := time.Now()
+		conv.state.LastBotMessage = now
+		conv.state.MessageCount++
+		conv.updatedAt = now
+	}
+	return nil
+}
+
+// RecentMessages implements MessageStore
+func (s *MemoryStore) RecentMessages(ctx context.Context, pageID, platform, threadID string, limit int) ([]sentiment.Turn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var newestFirst []sentiment.Turn
+	for i := len(s.messages) - 1; i >= 0 && len(newestFirst) < limit; i-- {
+		m := s.messages[i]
+		if m.pageID == pageID && m.platform == platform && m.threadID == threadID {
+			newestFirst = append(newestFirst, m.turn)
+		}
+	}
+	return newestFirst, nil
+}
+
+// EnabledFAQEntries implements FAQStore
+func (s *MemoryStore) EnabledFAQEntries(ctx context.Context, pageID, platform string) ([]FAQEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []FAQEntry{}
+	page, _ := s.pages[pageID+"/"+platform]
+	for _, entry := range page.FAQ {
+		if entry.Enabled {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// QuotaLimits implements QuotaStore. The page key doubles as the page's internal ID.
+func (s *MemoryStore) QuotaLimits(ctx context.Context, pageID, platform string, defaults QuotaPolicy) (QuotaPolicy, string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[pageID+"/"+platform]
+	if !ok {
+		return defaults, "", "", fmt.Errorf("error loading quota policy: %w", ErrNotFound)
+	}
+	policy := defaults
+	if page.Quotas != nil {
+		policy = *page.Quotas
+	}
+	return policy, pageID + "/" + platform, page.ClientID, nil
+}
+
+// IncrementCounter implements QuotaStore
+func (s *MemoryStore) IncrementCounter(ctx context.Context, scope, key string, window time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter := memoryCounter{scope, key, window.UTC()}
+	s.counters[counter]++
+	return s.counters[counter], nil
+}
+
+// DecrementCounter implements QuotaStore
+func (s *MemoryStore) DecrementCounter(ctx context.Context, scope, key string, window time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if counter := (memoryCounter{scope, key, window.UTC()}); s.counters[counter] > 0 {
+		s.counters[counter]--
+	}
+	return nil
+}
+
+// PruneCounters implements QuotaStore
+func (s *MemoryStore) PruneCounters(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for counter := range s.counters {
+		cutoff := now.Add(-48 * time.Hour)
+		if counter.scope == "client_month_exceeded" {
+			cutoff = month
+		}
+		if counter.window.Before(cutoff) {
+			delete(s.counters, counter)
+		}
+	}
+	return nil
+}
+
+// ClientTokensSince implements QuotaStore. Usage added with AddTokenUsage counts
+// as the current month's.
+func (s *MemoryStore) ClientTokensSince(ctx context.Context, clientID string, since time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[clientID], nil
+}
+
+// SenderBlocked implements GuardStore
+func (s *MemoryStore) SenderBlocked(ctx context.Context, pageID, platform, senderID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, blocked := range s.pages[pageID+"/"+platform].BlockedSenders {
+		if blocked == senderID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GuardSettings implements GuardStore
+func (s *MemoryStore) GuardSettings(ctx context.Context, pageID, platform string) (PageGuardSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pages[pageID+"/"+platform].Guard, nil
+}
+
+// FlagMessage implements GuardStore. Flags for unknown pages are dropped, like
+// the INSERT ... SELECT from social_pages.
+func (s *MemoryStore) FlagMessage(ctx context.Context, flag MessageFlag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[flag.PageID+"/"+flag.Platform]; ok {
+		s.flags = append(s.flags, flag)
+	}
+	return nil
+}
+
+// ClientPages implements PageStore
+func (s *MemoryStore) ClientPages(ctx context.Context, clientID string) ([]Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pages []Page
+	for key, page := range s.pages {
+		if page.ClientID == clientID && !page.Inactive {
+			pages = append(pages, Page{ID: key, PageID: page.PageID, Name: page.Name, Platform: page.Platform,
+				AccessToken: page.AccessToken, ClientID: page.ClientID})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Platform != pages[j].Platform {
+			return pages[i].Platform < pages[j].Platform
+		}
+		return pages[i].Name < pages[j].Name
+	})
+	return pages, nil
+}
+
+// ClientPage implements PageStore. Like the query it replaces, it takes the
+// first of the client's pages with the ID on either platform.
+func (s *MemoryStore) ClientPage(ctx context.Context, pageID, clientID string) (*PageInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.clientPageKey(pageID, clientID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	page := s.pages[key]
+	return &PageInfo{Platform: page.Platform, PageID: page.PageID, AccessToken: page.AccessToken}, nil
+}
+
+// clientPageKey finds an active page of the client by ID; the caller holds s.mu
+func (s *MemoryStore) clientPageKey(pageID, clientID string) (string, bool) {
+	for _, platform := range []string{"facebook", "instagram"} {
+		key := pageID + "/" + platform
+		if page, ok := s.pages[key]; ok && page.ClientID == clientID && !page.Inactive {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// ConnectClient implements oauth.ClientStore
+func (s *MemoryStore) ConnectClient(ctx context.Context, name, userID string, pages []oauth.ConnectedPage) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clientID, ok := s.clients[userID]
+	if !ok {
+		s.nextID++
+		clientID = fmt.Sprintf("client-%d", s.nextID)
+		s.clients[userID] = clientID
+	}
+	for _, connected := range pages {
+		key := connected.PageID + "/" + connected.Platform
+		page := s.pages[key]
+		page.PageID, page.Platform = connected.PageID, connected.Platform
+		page.ClientID, page.Name, page.AccessToken = clientID, connected.Name, connected.AccessToken
+		s.pages[key] = page
+	}
+	return clientID, nil
+}
+
+// ClientPageUUID implements FAQStore. The page key doubles as the page's internal ID.
+func (s *MemoryStore) ClientPageUUID(ctx context.Context, pageID, platform, clientID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for _, candidate := range []string{"facebook", "instagram"} {
+		key := pageID + "/" + candidate
+		if page, ok := s.pages[key]; ok && page.ClientID == clientID && !page.Inactive && (platform == "" || platform == candidate) {
+			keys = append(keys, key)
+		}
+	}
+	switch len(keys) {
+	case 0:
+		return "", ErrNotFound
+	case 1:
+		return keys[0], nil
+	default:
+		return "", ErrAmbiguousPage
+	}
+}
+
+// ListFAQEntries implements FAQStore
+func (s *MemoryStore) ListFAQEntries(ctx context.Context, pageUUID string) ([]FAQEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]FAQEntry{}, s.pages[pageUUID].FAQ...), nil
+}
+
+// CreateFAQEntry implements FAQStore
+func (s *MemoryStore) CreateFAQEntry(ctx context.Context, pageUUID string, entry FAQEntry) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[pageUUID]
+	if !ok {
+		return "", fmt.Errorf("error creating FAQ entry: %w", ErrNotFound)
+	}
+	s.nextID++
+	entry.ID = fmt.Sprintf("00000000-0000-4000-8000-%012d", s.nextID)
+	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = entry.CreatedAt
+	page.FAQ = append(append([]FAQEntry{}, page.FAQ...), entry)
+	s.pages[pageUUID] = page
+	return entry.ID, nil
+}
+
+// UpdateFAQEntry implements FAQStore
+func (s *MemoryStore) UpdateFAQEntry(ctx context.Context, pageUUID, entryID string, req FAQEntryRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := s.pages[pageUUID]
+	for i, entry := range page.FAQ {
+		if entry.ID != entryID {
+			continue
+		}
+		entry.Question, entry.Triggers, entry.Answer = req.Question, req.Triggers, req.Answer
+		if req.Enabled != nil {
+			entry.Enabled = *req.Enabled
+		}
+		entry.UpdatedAt = time.Now()
+		page.FAQ = append([]FAQEntry{}, page.FAQ...)
+		page.FAQ[i] = entry
+		s.pages[pageUUID] = page
+		return nil
+	}
+	return ErrNotFound
+}
+
+// DeleteFAQEntry implements FAQStore
+func (s *MemoryStore) DeleteFAQEntry(ctx context.Context, pageUUID, entryID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := s.pages[pageUUID]
+	for i, entry := range page.FAQ {
+		if entry.ID == entryID {
+			page.FAQ = append(append([]FAQEntry{}, page.FAQ[:i]...), page.FAQ[i+1:]...)
+			s.pages[pageUUID] = page
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// SafetyPolicy implements PolicyStore
+func (s *MemoryStore) SafetyPolicy(ctx context.Context, pageID, platform string, defaults SafetyPolicy) (SafetyPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if policy := s.pages[pageID+"/"+platform].Safety; policy != nil {
+		return *policy, nil
+	}
+	return defaults, nil
+}
+
+// CommentPolicy implements PolicyStore
+func (s *MemoryStore) CommentPolicy(ctx context.Context, pageID, platform string, defaults CommentPolicy) (CommentPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if policy := s.pages[pageID+"/"+platform].Comments; policy != nil {
+		return *policy, nil
+	}
+	return defaults, nil
+}
+
+// ModerationRules implements PolicyStore
+func (s *MemoryStore) ModerationRules(ctx context.Context, pageID, platform string, defaults ModerationRules) (ModerationRules, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rules := s.pages[pageID+"/"+platform].Moderation; rules != nil {
+		return *rules, nil
+	}
+	return defaults, nil
+}
+
+// SentimentOptions implements PolicyStore
+func (s *MemoryStore) SentimentOptions(ctx context.Context, pageID, platform string) (sentiment.Options, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if opts := s.pages[pageID+"/"+platform].Sentiment; opts != nil {
+		return *opts, nil
+	}
+	return sentiment.Options{}, nil
+}
+
+// RecordLLMUsage implements UsageStore. The tokens count towards the client's
+// ClientTokensSince; calls for unknown pages are dropped like the INSERT ... SELECT.
+func (s *MemoryStore) RecordLLMUsage(ctx context.Context, usage LLMUsage, costUSD float64, requestID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[usage.PageID+"/"+usage.Platform]
+	if !ok {
+		return nil
+	}
+	s.usage = append(s.usage, memoryUsage{usage: usage, clientID: page.ClientID, costUSD: costUSD,
+		requestID: requestID, createdAt: time.Now().UTC()})
+	s.tokens[page.ClientID] += int64(usage.PromptTokens + usage.CompletionTokens)
+	return nil
+}
+
+// UsageReport implements UsageStore
+func (s *MemoryStore) UsageReport(ctx context.Context, clientID, period string, from, to time.Time) ([]UsageReportRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type group struct{ period, provider, model, backend string }
+	rows := make(map[group]*UsageReportRow)
+	for _, row := range s.usage {
+		if row.clientID != clientID || row.createdAt.Before(from) || !row.createdAt.Before(to) {
+			continue
+		}
+		bucket := time.Date(row.createdAt.Year(), row.createdAt.Month(), row.createdAt.Day(), 0, 0, 0, 0, time.UTC)
+		if period == "month" {
+			bucket = bucket.AddDate(0, 0, 1-bucket.Day())
+		}
+		key := group{formatUsagePeriod(bucket, period), row.usage.Provider, row.usage.Model, row.usage.Backend}
+		report, ok := rows[key]
+		if !ok {
+			report = &UsageReportRow{Period: key.period, Provider: key.provider, Model: key.model, Backend: key.backend}
+			rows[key] = report
+		}
+		report.Calls++
+		report.PromptTokens += int64(row.usage.PromptTokens)
+		report.CompletionTokens += int64(row.usage.CompletionTokens)
+		report.CostUSD += row.costUSD
+	}
+
+	report := []UsageReportRow{}
+	for _, row := range rows {
+		report = append(report, *row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.Backend < b.Backend
+	})
+	return report, nil
+}
+
+// RecordReplyTier implements ReplyTierStore
+func (s *MemoryStore) RecordReplyTier(ctx context.Context, pageID, platform, threadID string, tier ReplyTier, errorMessage string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[pageID+"/"+platform]; ok {
+		s.replyTiers = append(s.replyTiers, memoryReplyTier{pageID, platform, threadID, tier, time.Now()})
+	}
+	return nil
+}
+
+// ReplyTierCounts implements ReplyTierStore
+func (s *MemoryStore) ReplyTierCounts(ctx context.Context, clientID string, since time.Time) ([]ReplyTierCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type group struct {
+		pageID, platform string
+		tier             ReplyTier
+	}
+	counts := make(map[group]int)
+	for _, row := range s.replyTiers {
+		if s.pages[row.pageID+"/"+row.platform].ClientID == clientID && !row.createdAt.Before(since) {
+			counts[group{row.pageID, row.platform, row.tier}]++
+		}
+	}
+
+	var result []ReplyTierCount
+	for key, count := range counts {
+		result = append(result, ReplyTierCount{PageID: key.pageID, Platform: key.platform, Tier: string(key.tier), Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].PageID != result[j].PageID {
+			return result[i].PageID < result[j].PageID
+		}
+		return result[i].Tier < result[j].Tier
+	})
+	return result, nil
+}
+
+// RecordModeration implements ModerationLogStore. Entries for unknown pages are
+// dropped like the INSERT ... SELECT.
+func (s *MemoryStore) RecordModeration(ctx context.Context, entry ModerationEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[entry.PageID+"/"+entry.Platform]; ok {
+		entry.CreatedAt = time.Now()
+		s.moderations = append(s.moderations, entry)
+	}
+	return nil
+}
+
+// ModerationLog implements ModerationLogStore
+func (s *MemoryStore) ModerationLog(ctx context.Context, clientID, pageID, commentID string, limit int) ([]ModerationEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []ModerationEntry{}
+	for i := len(s.moderations) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := s.moderations[i]
+		if s.pages[entry.PageID+"/"+entry.Platform].ClientID != clientID ||
+			(pageID != "" && entry.PageID != pageID) || (commentID != "" && entry.CommentID != commentID) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Ping implements HealthStore; there is no connection pool
+func (s *MemoryStore) Ping(ctx context.Context) (sql.DBStats, error) {
+	return sql.DBStats{}, nil
+}
+
+// ReenableFunctionExists implements HealthStore; ReenableDisabledBots is built in
+func (s *MemoryStore) ReenableFunctionExists(ctx context.Context) (bool, error) {
+	return true, nil
+}
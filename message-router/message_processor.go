@@ -4,11 +4,26 @@ package main
 import (
 	"context"
 	"fmt"
+	"logging"
 	"message-router/sentiment"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// MessageProcessor runs the webhook pipeline - messages, echoes and changes -
+// on the stores it was built with
+type MessageProcessor struct {
+	stores Stores
+}
+
+// NewMessageProcessor creates a processor reading and writing s
+func NewMessageProcessor(s Stores) *MessageProcessor {
+	return &MessageProcessor{stores: s}
+}
+
 // processMessagesAsync processes Facebook webhook messages asynchronously.
 //
 // This is the core message processing pipeline that handles the complete lifecycle
@@ -33,13 +48,27 @@ import (
 //  5. Thread Control Validation: Checks current thread control status to determine
 //     if the bot should process the message or if a human has control
 //
-//  6. Sentiment Analysis: Analyzes message content using Fireworks AI to categorize
-//     user intent: 'general', 'frustrated', or 'need_human'
+//  6. Message Guard: Blocklisted senders, link/phone spam, repeated messages and
+//     prompt-injection attempts are flagged and ignored, answered or escalated
+//
+//  7. Quotas: Per-sender messages per minute, daily replies per page and the
+//     client's monthly token budget; exceeded limits drop, send a template or hand off
+//
+//  8. FAQ Answers: Confident matches against the page's canned answers are sent
+//     directly (stored as `bot` messages) without calling Fireworks or Dify
+//
+//  9. Sentiment Analysis: Analyzes message content with the configured classifier chain
+//     (Fireworks, an OpenAI-compatible API and/or the offline lexicon) to categorize
+//     status ('general', 'frustrated', 'need_human'), intent and language, with a
+//     confidence derived from logprobs. Pages can customize the prompt and intents.
+//     The last turns of the conversation are sent as context, and a running
+//     frustration score escalates conversations that stay negative.
 //
-//  7. Response Routing:
+//  10. Response Routing:
 //     - General messages: Routes to Dify AI for automated chatbot response
-//     - Frustrated users: Sends empathy message and escalates to human agents
-//     - Human requests: Immediately connects users to human support
+//     - Frustrated users: Answered normally; sustained frustration escalates with an empathy message
+//     - Human requests: Immediately connects users to human support (when confident)
+//     - Spam intent: Flagged and not answered
 //
 // Echo Message Logic:
 //
@@ -63,16 +92,22 @@ import (
 // The function processes each message entry in the webhook event and handles
 // various message types while maintaining conversation state and thread control.
 // All operations are logged with the requestID for debugging and monitoring.
-func processMessagesAsync(ctx context.Context, event FacebookEvent, requestID string) {
-	LogDebug("[%s] 🔄 Starting async message processing", requestID)
+func (p *MessageProcessor) processMessagesAsync(ctx context.Context, event FacebookEvent, requestID string) {
+	ctx, span := startSpan(ctx, "webhook.process", attribute.String("webhook.object", event.Object))
+	defer span.End()
+
+	LogDebugCtx(ctx, "🔄 Starting async message processing")
 
 	// Step 1: Check and reactivate eligible bots before processing new messages (12-hour rule)
-	checkAndReactivateBots(ctx, requestID)
+	p.checkAndReactivateBots(ctx, requestID)
 
 	// Step 2: Process each entry in the webhook event
 	for _, entry := range event.Entry {
+		// Comments, mentions and other subscription fields (see changes.go)
+		p.processChanges(ctx, event, entry)
+
 		if len(entry.Messaging) == 0 {
-			LogDebug("[%s] No messages in entry %s", requestID, entry.ID)
+			LogDebugCtx(ctx, "No messages in entry %s", entry.ID)
 			continue
 		}
 
@@ -84,9 +119,9 @@ func processMessagesAsync(ctx context.Context, event FacebookEvent, requestID st
 			}
 
 			// Step 5: Handle echo messages (bot responses, human agent interventions)
-			echoAction, err := handleEchoMessage(ctx, msg, entry, event, requestID)
+			echoAction, err := p.handleEchoMessage(ctx, msg, entry, event, requestID)
 			if err != nil {
-				LogError("[%s] Echo message handling failed: %v", requestID, err)
+				LogErrorCtx(ctx, "Echo message handling failed: %v", err)
 				continue
 			}
 
@@ -99,36 +134,75 @@ func processMessagesAsync(ctx context.Context, event FacebookEvent, requestID st
 				// Continue with normal user message processing
 			}
 
-			// Step 6: Log that we're processing a user message
-			LogInfo("[%s] 👤 User message detected - proceeding with bot processing", requestID)
+			// Steps 6-12: Process the user message in its own span
+			p.processUserMessage(ctx, msg, entry, event, requestID)
+		}
+	}
 
-			// Step 7: Gather all context needed for processing (conversation, page info, user profile)
-			msgContext, err := gatherMessageContext(ctx, msg, entry, event, requestID)
-			if err != nil {
-				// Error already logged in gatherMessageContext
-				continue
-			}
+	LogDebugCtx(ctx, "✅ Async message processing completed")
+}
 
-			// Step 8: Check if bot should process this message
-			shouldProcess, err := shouldBotProcessMessage(ctx, msg.Sender.ID)
-			if err != nil {
-				LogWarn("[%s] Thread control check failed, defaulting to bot: %v", requestID, err)
-				shouldProcess = true // Graceful degradation
-			}
+// processUserMessage runs the reply pipeline for one user message: context,
+// thread control, guard, quotas, FAQ, then sentiment routing
+func (p *MessageProcessor) processUserMessage(ctx context.Context, msg MessagingEntry, entry EntryData, event FacebookEvent, requestID string) {
+	ctx, span := startSpan(ctx, "message.process",
+		attribute.String("page.id", entry.ID),
+		attribute.String("thread.id", msg.Sender.ID),
+		attribute.String("message.mid", msg.Message.Mid),
+		attribute.String("platform", event.Object),
+	)
+	defer span.End()
+
+	// Step 6: Log that we're processing a user message (with page and thread in every line)
+	ctx = logging.WithConversation(ctx, entry.ID, msg.Sender.ID)
+	LogInfoCtx(ctx, "👤 User message detected - proceeding with bot processing")
+
+	// Step 7: Gather all context needed for processing (conversation, page info, user profile)
+	msgContext, err := p.gatherMessageContext(ctx, msg, entry, event, requestID)
+	if err != nil {
+		// Error already logged in gatherMessageContext
+		recordMessageOutcome(ctx, entry.ID, OutcomeError, "context_error")
+		return
+	}
+	p.storeConversationMessage(ctx, entry.ID, msgContext.Platform, msg.Sender.ID, "user", msg.Message.Text)
 
-			if !shouldProcess {
-				LogInfo("[%s] 🔴 Bot disabled for this conversation - skipping processing", requestID)
-				continue
-			}
+	// Step 8: Check if bot should process this message
+	shouldProcess, err := p.shouldBotProcessMessage(ctx, msg.Sender.ID)
+	if err != nil {
+		LogWarnCtx(ctx, "Thread control check failed, defaulting to bot: %v", err)
+		shouldProcess = true // Graceful degradation
+	}
 
-			// Step 9: Process sentiment and route accordingly
-			if err := processSentimentAndRoute(ctx, msgContext, requestID); err != nil {
-				LogError("[%s] Failed to process sentiment and route: %v", requestID, err)
-			}
-		}
+	if !shouldProcess {
+		LogInfoCtx(ctx, "🔴 Bot disabled for this conversation - skipping processing")
+		recordMessageOutcome(ctx, entry.ID, OutcomeSkipped, "bot_disabled")
+		return
+	}
+
+	// Step 9: Block spam, abusive senders and prompt-injection attempts
+	if verdict := p.checkMessageGuard(ctx, msgContext, requestID); verdict != nil {
+		p.enforceGuardVerdict(ctx, msgContext, verdict, requestID)
+		return
+	}
+
+	// Step 10: Enforce sender, page and client quotas before any reply is generated
+	if decision := p.checkQuotas(ctx, msgContext, requestID); decision != nil {
+		p.enforceQuotaDecision(ctx, msgContext, decision, requestID)
+		return
+	}
+	defer p.releaseDailyReply(ctx, msgContext)
+
+	// Step 11: Answer common questions straight from the page's FAQ
+	if p.tryFAQAnswer(ctx, msgContext, requestID) {
+		p.countBotReply(msgContext)
+		recordMessageOutcome(ctx, entry.ID, OutcomeBot, "faq")
+		return
 	}
 
-	LogDebug("[%s] ✅ Async message processing completed", requestID)
+	// Step 12: Process sentiment and route accordingly
+	if err := p.processSentimentAndRoute(ctx, msgContext, requestID); err != nil {
+		LogErrorCtx(ctx, "Failed to process sentiment and route: %v", err)
+	}
 }
 
 // filterAndValidateMessage filters out unwanted messages and validates content
@@ -147,7 +221,7 @@ func filterAndValidateMessage(msg MessagingEntry, requestID string, msgIndex int
 
 	// Log every message we receive for debugging
 	LogInfo("[%s] 📨 Raw message %d: sender=%s, recipient=%s, echo=%v, app_id=%d, text=%q",
-		requestID, msgIndex, msg.Sender.ID, msg.Recipient.ID, msg.Message.IsEcho, msg.Message.AppId, msg.Message.Text)
+		requestID, msgIndex, msg.Sender.ID, msg.Recipient.ID, msg.Message.IsEcho, msg.Message.AppId, logging.Text(msg.Message.Text))
 
 	// Skip non-user senders (but allow regular user messages and echo messages)
 	if !msg.Message.IsEcho && (strings.HasPrefix(msg.Sender.ID, "page-") || strings.HasPrefix(msg.Sender.ID, "bot-")) {
@@ -168,13 +242,13 @@ const (
 )
 
 // handleEchoMessage processes echo messages intelligently
-func handleEchoMessage(ctx context.Context, msg MessagingEntry, entry EntryData, event FacebookEvent, requestID string) (EchoAction, error) {
+func (p *MessageProcessor) handleEchoMessage(ctx context.Context, msg MessagingEntry, entry EntryData, event FacebookEvent, requestID string) (EchoAction, error) {
 	// Only process if this is an echo message
 	if !msg.Message.IsEcho {
 		return EchoActionContinue, nil
 	}
 
-	LogInfo("[%s] 🔍 Echo message detected - analyzing app_id and sender", requestID)
+	LogInfoCtx(ctx, "🔍 Echo message detected - analyzing app_id and sender")
 
 	// Normalize platform name
 	platform := event.Object
@@ -184,27 +258,30 @@ func handleEchoMessage(ctx context.Context, msg MessagingEntry, entry EntryData,
 
 	// Instagram-specific logic using bot flag system
 	if platform == "instagram" {
-		LogInfo("[%s] 📱 Instagram echo message - checking bot flag", requestID)
+		LogInfoCtx(ctx, "📱 Instagram echo message - checking bot flag")
 
 		// Construct conversation ID (pageID-senderID, but for echo messages sender=page, recipient=user)
 		conversationID := fmt.Sprintf("%s-%s", entry.ID, msg.Recipient.ID)
 
 		// Check if this message has a bot flag
 		if hasBotFlag(conversationID) {
-			LogInfo("[%s] 🤖 Instagram bot message confirmed by flag - skipping", requestID)
+			LogInfoCtx(ctx, "🤖 Instagram bot message confirmed by flag - skipping")
+			recordEchoClassification(ctx, platform, "bot", msg)
 			clearBotFlag(conversationID) // Clear the flag after use
 			return EchoActionSkip, nil
 		} else {
-			LogInfo("[%s] 👤 Instagram human agent message detected (no bot flag) - disabling bot", requestID)
+			LogInfoCtx(ctx, "👤 Instagram human agent message detected (no bot flag) - disabling bot")
+			recordEchoClassification(ctx, platform, "human_agent", msg)
 
 			// Auto-disable bot for human agent intervention
-			err := updateConversationForHumanMessage(ctx, entry.ID, msg.Recipient.ID, platform)
+			err := p.updateConversationForHumanMessage(ctx, entry.ID, msg.Recipient.ID, platform)
 			if err != nil {
-				LogError("[%s] ❌ Failed to disable bot for human agent: %v", requestID, err)
+				LogErrorCtx(ctx, "❌ Failed to disable bot for human agent: %v", err)
 				return EchoActionSkip, err
 			} else {
-				LogInfo("[%s] ✅ Bot successfully disabled for human agent", requestID)
+				LogInfoCtx(ctx, "✅ Bot successfully disabled for human agent")
 			}
+			p.storeConversationMessage(ctx, entry.ID, platform, msg.Recipient.ID, "human", msg.Message.Text)
 			return EchoActionDisableBot, nil
 		}
 	}
@@ -213,34 +290,39 @@ func handleEchoMessage(ctx context.Context, msg MessagingEntry, entry EntryData,
 	if platform == "facebook" {
 		// Check if this is a bot echo (our own bot responses)
 		if msg.Message.AppId == 1195277397801905 {
-			LogInfo("[%s] 🤖 Facebook bot echo message detected (app_id: %d) - skipping", requestID, msg.Message.AppId)
+			LogInfoCtx(ctx, "🤖 Facebook bot echo message detected (app_id: %d) - skipping", msg.Message.AppId)
+			recordEchoClassification(ctx, platform, "bot", msg)
 			return EchoActionSkip, nil
 		}
 
 		// Check if this is a human agent message (sender = page)
 		if msg.Sender.ID == entry.ID {
-			LogInfo("[%s] 👤 Facebook human agent message detected! sender=%s matches page=%s, app_id=%d",
-				requestID, msg.Sender.ID, entry.ID, msg.Message.AppId)
+			LogInfoCtx(ctx, "👤 Facebook human agent message detected! sender=%s matches page=%s, app_id=%d",
+				msg.Sender.ID, entry.ID, msg.Message.AppId)
+			recordEchoClassification(ctx, platform, "human_agent", msg)
 
-			LogInfo("[%s] 🔴 Auto-disabling bot due to human agent intervention", requestID)
-			err := updateConversationForHumanMessage(ctx, entry.ID, msg.Recipient.ID, platform)
+			LogInfoCtx(ctx, "🔴 Auto-disabling bot due to human agent intervention")
+			err := p.updateConversationForHumanMessage(ctx, entry.ID, msg.Recipient.ID, platform)
 			if err != nil {
-				LogError("[%s] ❌ Failed to disable bot for human agent: %v", requestID, err)
+				LogErrorCtx(ctx, "❌ Failed to disable bot for human agent: %v", err)
 				return EchoActionSkip, err
 			} else {
-				LogInfo("[%s] ✅ Bot successfully disabled for human agent", requestID)
+				LogInfoCtx(ctx, "✅ Bot successfully disabled for human agent")
 			}
+			p.storeConversationMessage(ctx, entry.ID, platform, msg.Recipient.ID, "human", msg.Message.Text)
 			return EchoActionDisableBot, nil
 		}
 
 		// Unknown Facebook echo message pattern
-		LogWarn("[%s] ⚠️ Unknown Facebook echo pattern: sender=%s, page=%s, app_id=%d",
-			requestID, msg.Sender.ID, entry.ID, msg.Message.AppId)
+		LogWarnCtx(ctx, "⚠️ Unknown Facebook echo pattern: sender=%s, page=%s, app_id=%d",
+			msg.Sender.ID, entry.ID, msg.Message.AppId)
+		recordEchoClassification(ctx, platform, "unknown", msg)
 		return EchoActionSkip, nil
 	}
 
 	// Unknown platform
-	LogWarn("[%s] ⚠️ Unknown platform echo message: platform=%s", requestID, platform)
+	LogWarnCtx(ctx, "⚠️ Unknown platform echo message: platform=%s", platform)
+	recordEchoClassification(ctx, "other", "unknown", msg)
 	return EchoActionSkip, nil
 }
 
@@ -252,10 +334,16 @@ type MessageContext struct {
 	UserName     string
 	Platform     string
 	RequestID    string
+
+	// A reply reserved against the page's daily limit by checkQuotas. The bot
+	// marks it sent with countBotReply; otherwise releaseDailyReply gives it back.
+	DailyReplyKey  string // page_day counter key, empty for pages without a daily limit
+	DailyReplyDay  time.Time
+	DailyReplySent bool
 }
 
 // gatherMessageContext collects all necessary context for message processing
-func gatherMessageContext(ctx context.Context, msg MessagingEntry, entry EntryData, event FacebookEvent, requestID string) (*MessageContext, error) {
+func (p *MessageProcessor) gatherMessageContext(ctx context.Context, msg MessagingEntry, entry EntryData, event FacebookEvent, requestID string) (*MessageContext, error) {
 	// Normalize platform name
 	platform := event.Object
 	if platform == "page" {
@@ -263,29 +351,31 @@ func gatherMessageContext(ctx context.Context, msg MessagingEntry, entry EntryDa
 	}
 
 	// Single consolidated log for message reception
-	LogInfo("[%s] 📥 Message: %s -> %s (%s) %q",
-		requestID, msg.Sender.ID, entry.ID, platform, msg.Message.Text)
+	LogInfoCtx(ctx, "📥 Message: %s -> %s (%s) %q",
+		msg.Sender.ID, entry.ID, platform, logging.Text(msg.Message.Text))
 
 	// Get conversation state and page info (consolidated error handling)
-	conv, err := getOrCreateConversation(ctx, entry.ID, msg.Sender.ID, platform)
+	ctx, span := startSpan(ctx, "conversation.load", attribute.String("platform", platform))
+	defer span.End()
+	conv, err := p.getOrCreateConversation(ctx, entry.ID, msg.Sender.ID, platform)
 	if err != nil {
-		LogError("[%s] Failed to get conversation state for %s: %v", requestID, msg.Sender.ID, err)
+		LogErrorCtx(ctx, "Failed to get conversation state for %s: %v", msg.Sender.ID, err)
 		return nil, err
 	}
 
-	pageInfo, err := getPageInfo(ctx, entry.ID, platform)
+	pageInfo, err := getPageInfo(ctx, p.stores.Pages, entry.ID, platform)
 	if err != nil {
-		LogError("[%s] Failed to get page info for %s: %v", requestID, entry.ID, err)
+		LogErrorCtx(ctx, "Failed to get page info for %s: %v", entry.ID, err)
 		return nil, err
 	}
 
 	// Get user profile (non-critical, don't log failures unless debug)
 	userName, err := getProfileInfo(ctx, msg.Sender.ID, pageInfo.AccessToken, platform)
 	if err != nil {
-		LogDebug("[%s] Could not get user name for %s: %v", requestID, msg.Sender.ID, err)
+		LogDebugCtx(ctx, "Could not get user name for %s: %v", msg.Sender.ID, err)
 		userName = "user"
 	} else {
-		updateConversationUsername(ctx, msg.Sender.ID, userName) // Fire and forget
+		p.updateConversationUsername(ctx, msg.Sender.ID, userName) // Fire and forget
 	}
 
 	return &MessageContext{
@@ -299,103 +389,203 @@ func gatherMessageContext(ctx context.Context, msg MessagingEntry, entry EntryDa
 }
 
 // processSentimentAndRoute analyzes sentiment and routes the message accordingly
-func processSentimentAndRoute(ctx context.Context, msgContext *MessageContext, requestID string) error {
+func (p *MessageProcessor) processSentimentAndRoute(ctx context.Context, msgContext *MessageContext, requestID string) error {
 	// Analyze sentiment
 	start := time.Now()
-	analysis, err := sentimentAnalyzer.Analyze(ctx, msgContext.Message.Message.Text)
+	opts := p.loadSentimentOptions(ctx, msgContext.PageInfo.PageID, msgContext.Platform)
+	history, err := p.loadConversationHistory(ctx, msgContext.PageInfo.PageID, msgContext.Platform,
+		msgContext.Message.Sender.ID, msgContext.Message.Message.Text, config.SentimentHistoryTurns)
 	if err != nil {
-		LogError("[%s] Sentiment analysis failed for %s: %v", requestID, msgContext.Message.Sender.ID, err)
-		return err
+		LogWarnCtx(ctx, "Analyzing without conversation history: %v", err)
+	}
+	opts.History = history
+	sentimentCtx, span := startSpan(ctx, "sentiment.classify", attribute.Int("sentiment.history_turns", len(history)))
+	analysis, err := sentimentClassifier.Classify(sentimentCtx, msgContext.Message.Message.Text, opts)
+	if err == nil {
+		span.SetAttributes(
+			attribute.String("sentiment.provider", analysis.Provider),
+			attribute.Bool("sentiment.cached", analysis.Cached),
+			attribute.String("sentiment.status", analysis.Status),
+			attribute.String("sentiment.intent", analysis.Intent),
+			attribute.Float64("sentiment.confidence", analysis.Confidence),
+			attribute.Int("llm.tokens", analysis.TokensUsed),
+		)
+	}
+	endSpan(span, err)
+	if err != nil {
+		// Every classifier failed - answer as a general message rather than not at all
+		LogErrorCtx(ctx, "Sentiment analysis failed for %s, routing as general: %v", msgContext.Message.Sender.ID, err)
+		sentimentDuration.WithLabelValues("failed", "false").Observe(time.Since(start).Seconds())
+		return p.handleGeneralMessage(ctx, msgContext, requestID)
 	}
 
 	// Single consolidated log for processing status
 	processingTime := time.Since(start)
-	LogInfo("[%s] 🤖 Processing: %s sentiment, %d tokens, %dms",
-		requestID, analysis.Status, analysis.TokensUsed, processingTime.Milliseconds())
+	sentimentDuration.WithLabelValues(analysis.Provider, strconv.FormatBool(analysis.Cached)).Observe(processingTime.Seconds())
+	source := analysis.Provider
+	if analysis.Cached {
+		source += " (cached)"
+	}
+	LogInfoCtx(ctx, "🤖 Processing: %s sentiment (%.2f) via %s, intent %s, language %s, %d tokens, %dms",
+		analysis.Status, analysis.Confidence, source, analysis.Intent, analysis.Language,
+		analysis.TokensUsed, processingTime.Milliseconds())
+	LogDebugCtx(ctx, "Sentiment reasoning: %s", analysis.Reasoning)
+
+	// Record tokens and cost for per-client billing (the offline lexicon and cache hits use none)
+	if analysis.PromptTokens+analysis.CompletionTokens > 0 {
+		p.recordLLMUsage(ctx, LLMUsage{
+			PageID:           msgContext.PageInfo.PageID,
+			Platform:         msgContext.Platform,
+			ThreadID:         msgContext.Message.Sender.ID,
+			Provider:         analysis.Provider,
+			Model:            analysis.Model,
+			Purpose:          "sentiment",
+			PromptTokens:     analysis.PromptTokens,
+			CompletionTokens: analysis.CompletionTokens,
+		})
+	}
 
-	// Log cost details only in debug mode
-	LogDebug("[%s] Estimated cost: $%.6f", requestID, float64(analysis.TokensUsed)*0.20/1_000_000)
+	// Escalate on sustained negativity rather than a single frustrated message
+	frustration := 0.0
+	if analysis.Status == "frustrated" {
+		frustration = 1.0
+		if analysis.Confidence > 0 {
+			frustration = analysis.Confidence
+		}
+	}
+	score, err := p.updateFrustrationScore(ctx, msgContext.Message.Sender.ID, frustration, config.FrustrationSmoothing)
+	if err != nil {
+		LogWarnCtx(ctx, "%v", err)
+	} else {
+		LogDebugCtx(ctx, "Frustration score: %.2f", score)
+		if config.FrustrationThreshold > 0 && score >= config.FrustrationThreshold && analysis.Status != "need_human" {
+			LogInfoCtx(ctx, "📉 Sustained frustration (score %.2f >= %.2f) - escalating", score, config.FrustrationThreshold)
+			if err := p.resetFrustrationScore(ctx, msgContext.Message.Sender.ID); err != nil {
+				LogWarnCtx(ctx, "%v", err)
+			}
+			return p.handleFrustratedUser(ctx, msgContext, requestID)
+		}
+	}
 
 	// Route based on sentiment analysis
-	return routeBasedOnSentiment(ctx, msgContext, analysis, requestID)
+	return p.routeBasedOnSentiment(ctx, msgContext, analysis, requestID)
 }
 
 // routeBasedOnSentiment routes messages based on sentiment analysis results
-func routeBasedOnSentiment(ctx context.Context, msgContext *MessageContext, analysis *sentiment.Analysis, requestID string) error {
+func (p *MessageProcessor) routeBasedOnSentiment(ctx context.Context, msgContext *MessageContext, analysis *sentiment.Analysis, requestID string) error {
+	confident := confidentAnalysis(analysis)
+
+	// Spam the guard's heuristics missed: flag it and don't spend a Dify call on it.
+	// Only pages that opted into the guard drop messages, and the lexicon
+	// fallback's keyword matches are too coarse to drop a customer's message.
+	if analysis.Intent == "spam" && confident {
+		if analysis.Provider != "lexicon" && p.loadGuardSettings(ctx, msgContext.PageInfo.PageID, msgContext.Platform).Enabled {
+			LogInfoCtx(ctx, "🗑️ Message classified as spam - not replying")
+			recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeSkipped, "intent_spam")
+			p.flagGuardedMessage(ctx, msgContext, &guardVerdict{Check: "intent_spam", Reason: analysis.Reasoning},
+				GuardActionIgnore, requestID)
+			return nil
+		}
+		LogInfoCtx(ctx, "Message classified as spam by %s - treating as general", analysis.Provider)
+		return p.handleGeneralMessage(ctx, msgContext, requestID)
+	}
+
 	switch analysis.Status {
 	case "need_human":
-		return handleNeedHumanRequest(ctx, msgContext, requestID)
+		if !confident {
+			LogInfoCtx(ctx, "need_human below confidence threshold (%.2f) - treating as general", analysis.Confidence)
+			return p.handleGeneralMessage(ctx, msgContext, requestID)
+		}
+		return p.handleNeedHumanRequest(ctx, msgContext, requestID)
 	case "frustrated":
-		return handleGeneralMessage(ctx, msgContext, requestID) // I don't want 'frustrated' messages to disable the bot, so I use general message handling for this
+		return p.handleGeneralMessage(ctx, msgContext, requestID) // I don't want 'frustrated' messages to disable the bot, so I use general message handling for this
 	case "general":
-		return handleGeneralMessage(ctx, msgContext, requestID)
+		return p.handleGeneralMessage(ctx, msgContext, requestID)
 	default:
-		LogWarn("[%s] Unknown sentiment status: %s", requestID, analysis.Status)
-		return handleGeneralMessage(ctx, msgContext, requestID) // Default to general
+		LogWarnCtx(ctx, "Unknown sentiment status: %s", analysis.Status)
+		return p.handleGeneralMessage(ctx, msgContext, requestID) // Default to general
+	}
+}
+
+// loadSentimentOptions returns the page's custom sentiment prompt and intent
+// categories from page_sentiment_settings; empty options use the package defaults
+func (p *MessageProcessor) loadSentimentOptions(ctx context.Context, pageID, platform string) sentiment.Options {
+	opts, err := p.stores.Policies.SentimentOptions(ctx, pageID, platform)
+	if err != nil {
+		LogWarn("Could not load sentiment settings for page %s, using defaults: %v", pageID, err)
+		return sentiment.Options{}
 	}
+	return opts
 }
 
 // handleNeedHumanRequest processes requests for human assistance
-func handleNeedHumanRequest(ctx context.Context, msgContext *MessageContext, requestID string) error {
-	LogInfo("[%s] 👤 User requested human - disabling bot", requestID)
+func (p *MessageProcessor) handleNeedHumanRequest(ctx context.Context, msgContext *MessageContext, requestID string) error {
+	LogInfoCtx(ctx, "👤 User requested human - disabling bot")
 
 	// Send handoff message and disable bot
 	handoffMsg := "Te conectaré con un agente humano en breve. Mientras tanto, puedes seguir escribiendo y un agente te responderá."
 
 	if err := sendPlatformResponse(ctx, msgContext.PageInfo, msgContext.Message.Sender.ID, handoffMsg); err != nil {
-		LogError("[%s] Failed to send handoff message: %v", requestID, err)
+		LogErrorCtx(ctx, "Failed to send handoff message: %v", err)
 	}
 
 	// Disable bot for this conversation
-	if err := updateConversationState(ctx, msgContext.Conversation, false, "User requested human assistance"); err != nil {
-		LogError("[%s] Failed to disable bot: %v", requestID, err)
+	if err := p.updateConversationState(ctx, msgContext.Conversation, false, "User requested human assistance"); err != nil {
+		LogErrorCtx(ctx, "Failed to disable bot: %v", err)
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeError, "need_human")
 		return err
 	}
+	recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeHandoff, "need_human")
 
-	LogInfo("[%s] ✅ User connected to human agent", requestID)
+	LogInfoCtx(ctx, "✅ User connected to human agent")
 	return nil
 }
 
 // handleFrustratedUser processes messages from frustrated users
-func handleFrustratedUser(ctx context.Context, msgContext *MessageContext, requestID string) error {
-	LogInfo("[%s] 😤 User frustrated - disabling bot", requestID)
+func (p *MessageProcessor) handleFrustratedUser(ctx context.Context, msgContext *MessageContext, requestID string) error {
+	LogInfoCtx(ctx, "😤 User frustrated - disabling bot")
 
 	// Send empathy message and escalate
 	empathyMsg := "Entiendo tu frustración. Te estoy conectando con un agente humano que podrá ayudarte mejor."
 
 	if err := sendPlatformResponse(ctx, msgContext.PageInfo, msgContext.Message.Sender.ID, empathyMsg); err != nil {
-		LogError("[%s] Failed to send empathy message: %v", requestID, err)
+		LogErrorCtx(ctx, "Failed to send empathy message: %v", err)
 	}
 
 	// Disable bot and escalate to human
-	if err := updateConversationState(ctx, msgContext.Conversation, false, "User appears frustrated"); err != nil {
-		LogError("[%s] Failed to disable bot: %v", requestID, err)
+	if err := p.updateConversationState(ctx, msgContext.Conversation, false, "User appears frustrated"); err != nil {
+		LogErrorCtx(ctx, "Failed to disable bot: %v", err)
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeError, "sustained_frustration")
 		return err
 	}
+	recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeHandoff, "sustained_frustration")
 
-	LogInfo("[%s] ✅ Frustrated user escalated to human agent", requestID)
+	LogInfoCtx(ctx, "✅ Frustrated user escalated to human agent")
 	return nil
 }
 
 // handleGeneralMessage processes general messages through the AI system
-func handleGeneralMessage(ctx context.Context, msgContext *MessageContext, requestID string) error {
-	LogInfo("[%s] 💬 General message - forwarding to Dify AI", requestID)
-
-	// Forward to Dify for AI response
-	if err := forwardToDify(ctx, msgContext.PageInfo.PageID, msgContext.Message, msgContext.Platform); err != nil {
-		LogError("[%s] Dify forwarding failed: %v", requestID, err)
-
-		// Send fallback message to user
-		fallbackMsg := "Disculpa, estoy teniendo problemas técnicos. Un agente humano te ayudará pronto."
-		if sendErr := sendPlatformResponse(ctx, msgContext.PageInfo, msgContext.Message.Sender.ID, fallbackMsg); sendErr != nil {
-			LogError("[%s] Failed to send fallback message: %v", requestID, sendErr)
-		}
-
-		// Disable bot due to technical error
-		updateConversationState(ctx, msgContext.Conversation, false, "Error al procesar con Dify")
+func (p *MessageProcessor) handleGeneralMessage(ctx context.Context, msgContext *MessageContext, requestID string) error {
+	LogInfoCtx(ctx, "💬 General message - forwarding to Dify AI")
+
+	// Walk the fallback chain: primary Dify → secondary → FAQ → degraded/human
+	tier, err := p.runReplyFallbackChain(ctx, msgContext, requestID)
+	p.recordReplyTier(ctx, msgContext, tier, err, requestID)
+
+	switch tier {
+	case ReplyTierPrimary:
+		LogInfoCtx(ctx, "✅ Message successfully processed by Dify AI")
+		p.countBotReply(msgContext)
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeBot, "reply:"+string(tier))
+		return nil
+	case ReplyTierHuman:
+		LogErrorCtx(ctx, "Dify forwarding failed: %v", err)
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeHandoff, "reply:"+string(tier))
 		return err
+	default:
+		p.countBotReply(msgContext)
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeBot, "reply:"+string(tier))
+		LogWarnCtx(ctx, "⚠️ Message answered in degraded mode (tier: %s)", tier)
+		return nil
 	}
-
-	LogInfo("[%s] ✅ Message successfully processed by Dify AI", requestID)
-	return nil
 }
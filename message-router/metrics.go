@@ -0,0 +1,202 @@
+// metrics.go
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"graph"
+	"message-router/sentiment"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// =============================================================================
+// PROMETHEUS METRICS - Throughput, failure rates and latency at /metrics
+// =============================================================================
+
+// Message outcomes for messagesProcessed
+const (
+	OutcomeBot     = "bot"     // Answered by Dify, the FAQ or a degraded-mode reply
+	OutcomeSkipped = "skipped" // Not answered: bot disabled, guard, quota or spam
+	OutcomeHandoff = "handoff" // Conversation handed to a human
+	OutcomeError   = "error"   // Processing failed
+)
+
+var (
+	webhooksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_webhooks_received_total",
+		Help: "Webhook deliveries received, by object type (page, instagram, invalid).",
+	}, []string{"object"})
+
+	messagesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_messages_processed_total",
+		Help: "User messages processed, by page and outcome (bot, skipped, handoff, error).",
+	}, []string{"page_id", "outcome"})
+
+	echoMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_echo_messages_total",
+		Help: "Echo messages by platform and classification (bot, human_agent, unknown).",
+	}, []string{"platform", "classification"})
+
+	webhookSignatures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_webhook_signatures_total",
+		Help: "Webhook signature checks, by matching secret (or missing, invalid) and algorithm (sha256, sha1).",
+	}, []string{"secret", "algorithm"})
+
+	webhookChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_webhook_changes_total",
+		Help: "Webhook changes (comments, mentions, ...) by object, field and result (handled, ignored, unhandled, invalid).",
+	}, []string{"object", "field", "result"})
+
+	commentModerations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_comment_moderations_total",
+		Help: "Comments hidden or unhidden, by platform, action (hide, unhide) and rule (keyword, link, profanity, sentiment, policy, manual).",
+	}, []string{"platform", "action", "rule"})
+
+	sentimentDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "message_router_sentiment_duration_seconds",
+		Help:    "Sentiment classification latency, by provider and whether it was served from the cache.",
+		Buckets: []float64{0.005, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	}, []string{"provider", "cached"})
+
+	difyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "message_router_dify_request_duration_seconds",
+		Help:    "Dify chat-messages request latency, by backend and HTTP status (network for transport errors).",
+		Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 15, 30},
+	}, []string{"backend", "status"})
+
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_llm_tokens_total",
+		Help: "LLM tokens used, by provider, model, purpose and kind (prompt, completion).",
+	}, []string{"provider", "model", "purpose", "kind"})
+
+	graphAPIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_router_graph_api_errors_total",
+		Help: "Facebook/Instagram Graph API errors, by platform, operation and Graph error code.",
+	}, []string{"platform", "operation", "code"})
+
+	asyncWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "message_router_async_workers_in_flight",
+		Help: "Webhook deliveries currently being processed in the background.",
+	})
+)
+
+// setupMetrics registers the collectors that need the database pool and
+// initialized globals
+func setupMetrics(pool *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(pool, "client_manager"))
+
+	if sentimentCache != nil {
+		cacheCounter := func(name, help string, value func(stats sentiment.CacheStats) int64) {
+			prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "message_router_sentiment_cache_" + name + "_total",
+				Help: help,
+			}, func() float64 { return float64(value(sentimentCache.Stats())) }))
+		}
+		cacheCounter("hits", "Sentiment analyses served from the in-memory cache.",
+			func(s sentiment.CacheStats) int64 { return s.Hits })
+		cacheCounter("store_hits", "Sentiment analyses served from the persistent cache.",
+			func(s sentiment.CacheStats) int64 { return s.StoreHits })
+		cacheCounter("misses", "Cacheable messages sent to the classifier.",
+			func(s sentiment.CacheStats) int64 { return s.Misses })
+		cacheCounter("evictions", "Sentiment cache entries evicted from memory.",
+			func(s sentiment.CacheStats) int64 { return s.Evictions })
+		cacheCounter("purges", "Sentiment cache purges.",
+			func(s sentiment.CacheStats) int64 { return s.Purges })
+	}
+}
+
+// metricsHandler serves /metrics. Scrapers must send METRICS_TOKEN as a bearer
+// token; without the variable set the endpoint is disabled, since the metrics
+// name every page.
+func metricsHandler() http.HandlerFunc {
+	token := os.Getenv("METRICS_TOKEN")
+	if token == "" {
+		LogWarn("METRICS_TOKEN not set - /metrics is disabled")
+	}
+	handler := promhttp.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "Metrics disabled", http.StatusForbidden)
+			return
+		}
+		expected := []byte("Bearer " + token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// recordMessageOutcome counts a processed user message for its page and records
+// the outcome and the route that decided it (e.g. "reply:dify_primary",
+// "guard:prompt_injection") on the message span
+func recordMessageOutcome(ctx context.Context, pageID, outcome, route string) {
+	messagesProcessed.WithLabelValues(pageID, outcome).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("message.outcome", outcome),
+		attribute.String("message.route", route),
+	)
+}
+
+// recordEchoClassification counts an echo message by how it was classified
+// (bot, human_agent or unknown) and adds an "echo" event to the current span
+func recordEchoClassification(ctx context.Context, platform, classification string, msg MessagingEntry) {
+	echoMessages.WithLabelValues(platform, classification).Inc()
+	trace.SpanFromContext(ctx).AddEvent("echo", trace.WithAttributes(
+		attribute.String("echo.classification", classification),
+		attribute.String("echo.sender", msg.Sender.ID),
+		attribute.String("echo.recipient", msg.Recipient.ID),
+		attribute.String("message.mid", msg.Message.Mid),
+		attribute.Int64("echo.app_id", msg.Message.AppId),
+	))
+}
+
+// recordChange counts a webhook change by result and adds a "change" event to
+// the current span
+func recordChange(ctx context.Context, object, field string, outcome changeOutcome) {
+	webhookChanges.WithLabelValues(object, field, outcome.Result).Inc()
+	trace.SpanFromContext(ctx).AddEvent("change", trace.WithAttributes(
+		attribute.String("change.field", field),
+		attribute.String("change.result", outcome.Result),
+		attribute.String("change.action", outcome.Action),
+		attribute.String("change.rule", outcome.Rule),
+		attribute.String("change.id", outcome.ID),
+		attribute.String("change.from", outcome.From),
+		attribute.String("change.media", outcome.MediaID),
+	))
+}
+
+// observeDifyRequest records the latency of one Dify request
+func observeDifyRequest(backend string, status int, elapsed time.Duration) {
+	label := "network"
+	if status > 0 {
+		label = strconv.Itoa(status)
+	}
+	difyDuration.WithLabelValues(backend, label).Observe(elapsed.Seconds())
+}
+
+// observeGraphAPIError counts a failed Graph API call, labeled with the Graph
+// error code, or http_<status> / network when the response had none
+func observeGraphAPIError(platform, operation string, err error) {
+	code := "network"
+	if graphErr, ok := graph.AsGraphError(err); ok {
+		code = "http_" + strconv.Itoa(graphErr.StatusCode)
+		if graphErr.Code != 0 {
+			code = strconv.Itoa(graphErr.Code)
+		}
+	}
+	graphAPIErrors.WithLabelValues(platform, operation, code).Inc()
+}
@@ -0,0 +1,32 @@
+// migrations_test.go
+package migrations
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestLoad checks the embedded migrations: versions are contiguous from 1 and
+// every table an up migration creates is dropped by its down migration
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Load() returned no migrations")
+	}
+
+	createTable := regexp.MustCompile(`(?i)CREATE TABLE IF NOT EXISTS (\w+)`)
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d_%s: want version %d (versions must be contiguous)", m.Version, m.Name, i+1)
+		}
+		for _, match := range createTable.FindAllStringSubmatch(m.Up, -1) {
+			if !strings.Contains(m.Down, "DROP TABLE IF EXISTS "+match[1]+";") {
+				t.Errorf("migration %04d_%s creates %s but its down migration doesn't drop it", m.Version, m.Name, match[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+-- Drops every conversation and message. For local development and tests only.
+
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS social_pages;
+DROP TABLE IF EXISTS clients;
@@ -0,0 +1,90 @@
+-- Core multi-tenant schema: clients, their pages, conversations, messages and
+-- dashboard users. IF NOT EXISTS everywhere so databases created before
+-- migrations existed can be brought under version control with `migrate up`.
+
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS clients (
+    id               uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name             text NOT NULL,
+    email            text UNIQUE,
+    facebook_user_id text UNIQUE,
+    created_at       timestamptz DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS social_pages (
+    id                     uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id              uuid NOT NULL REFERENCES clients(id),
+    platform               text NOT NULL CHECK (platform IN ('facebook', 'instagram')),
+    page_id                text NOT NULL,
+    page_name              text NOT NULL,
+    access_token           text NOT NULL,
+    status                 text,
+    dify_api_key           text,
+    dify_fallback_api_key  text,
+    dify_fallback_base_url text,
+    activated_at           timestamptz,
+    created_at             timestamptz DEFAULT now(),
+    UNIQUE (platform, page_id)
+);
+
+-- Columns added after the original schema, for databases that predate them
+ALTER TABLE social_pages ADD COLUMN IF NOT EXISTS dify_fallback_api_key text;
+ALTER TABLE social_pages ADD COLUMN IF NOT EXISTS dify_fallback_base_url text;
+
+CREATE INDEX IF NOT EXISTS idx_social_pages_page_id ON social_pages (page_id);
+
+CREATE TABLE IF NOT EXISTS conversations (
+    thread_id             text PRIMARY KEY,
+    page_id               uuid NOT NULL REFERENCES social_pages(id),
+    platform              text NOT NULL,
+    bot_enabled           boolean DEFAULT true,
+    latest_message_at     timestamptz NOT NULL DEFAULT now(),
+    message_count         integer DEFAULT 0,
+    first_message_at      timestamptz,
+    social_user_name      varchar,
+    profile_picture_url   text DEFAULT 'https://via.placeholder.com/40',
+    unread_count          integer DEFAULT 0,
+    last_message_content  text,
+    last_message_sender   text,
+    dify_conversation_id  text,
+    last_bot_message_at   timestamptz,
+    last_human_message_at timestamptz,
+    last_user_message_at  timestamptz,
+    bot_disabled_at       timestamptz,
+    frustration_score     real DEFAULT 0,
+    created_at            timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at            timestamptz DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Columns added after the original schema, for databases that predate them
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS bot_disabled_at timestamptz;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS frustration_score real DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS messages (
+    id                 uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id          uuid REFERENCES clients(id),
+    page_id            uuid REFERENCES social_pages(id),
+    thread_id          text REFERENCES conversations(thread_id),
+    platform           text NOT NULL,
+    content            text NOT NULL,
+    from_user          text NOT NULL,
+    source             text NOT NULL CHECK (source IN ('bot', 'human', 'user', 'system')),
+    requires_attention boolean DEFAULT false,
+    internal           boolean DEFAULT false,
+    read               boolean DEFAULT false,
+    timestamp          timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_thread_id ON messages (thread_id);
+CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages (timestamp);
+
+CREATE TABLE IF NOT EXISTS users (
+    id            uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    email         varchar NOT NULL UNIQUE,
+    password_hash varchar NOT NULL,
+    client_id     uuid REFERENCES clients(id),
+    role          varchar DEFAULT 'client',
+    created_at    timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at    timestamptz DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS reenable_disabled_bots();
@@ -0,0 +1,25 @@
+-- 12-hour rule: re-enable the bot in conversations where no human agent has
+-- written for 12 hours. Called on every webhook; returns how many were re-enabled.
+-- Created only when missing, so a function already deployed by hand is kept.
+
+DO $migration$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_proc WHERE proname = 'reenable_disabled_bots') THEN
+        CREATE FUNCTION reenable_disabled_bots() RETURNS integer AS $fn$
+        DECLARE
+            reactivated integer;
+        BEGIN
+            UPDATE conversations
+            SET bot_enabled = true,
+                bot_disabled_at = NULL,
+                updated_at = now()
+            WHERE bot_enabled = false
+              AND COALESCE(last_human_message_at, bot_disabled_at, updated_at) < now() - INTERVAL '12 hours';
+
+            GET DIAGNOSTICS reactivated = ROW_COUNT;
+            RETURN reactivated;
+        END;
+        $fn$ LANGUAGE plpgsql;
+    END IF;
+END
+$migration$;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bot_reply_events;
+DROP TABLE IF EXISTS faq_entries;
@@ -0,0 +1,25 @@
+-- Per-page FAQ answers and the reply fallback chain's tier log
+
+CREATE TABLE IF NOT EXISTS faq_entries (
+    id         uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    page_id    uuid NOT NULL REFERENCES social_pages(id) ON DELETE CASCADE,
+    question   text NOT NULL,
+    triggers   text[] NOT NULL DEFAULT '{}',
+    answer     text NOT NULL,
+    enabled    boolean DEFAULT true,
+    created_at timestamptz DEFAULT now(),
+    updated_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_faq_entries_page_id ON faq_entries (page_id);
+
+CREATE TABLE IF NOT EXISTS bot_reply_events (
+    id            uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    page_id       uuid NOT NULL REFERENCES social_pages(id) ON DELETE CASCADE,
+    thread_id     text NOT NULL,
+    tier          text NOT NULL CHECK (tier IN ('dify_primary', 'dify_secondary', 'faq', 'degraded', 'human_fallback')),
+    error_message text,
+    created_at    timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_bot_reply_events_page_created ON bot_reply_events (page_id, created_at);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS rate_limit_counters;
+DROP TABLE IF EXISTS client_quotas;
+DROP TABLE IF EXISTS page_quotas;
+DROP TABLE IF EXISTS llm_usage;
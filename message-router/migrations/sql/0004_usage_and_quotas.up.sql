@@ -0,0 +1,43 @@
+-- LLM usage accounting, quota overrides and the shared rate limit counters
+
+CREATE TABLE IF NOT EXISTS llm_usage (
+    id                uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id         uuid REFERENCES clients(id),
+    page_id           uuid REFERENCES social_pages(id) ON DELETE SET NULL,
+    thread_id         text,
+    provider          text NOT NULL,
+    model             text NOT NULL,
+    purpose           text NOT NULL,
+    prompt_tokens     integer NOT NULL DEFAULT 0,
+    completion_tokens integer NOT NULL DEFAULT 0,
+    total_tokens      integer NOT NULL DEFAULT 0,
+    cost_usd          numeric(12,6) NOT NULL DEFAULT 0,
+    created_at        timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_usage_client_created ON llm_usage (client_id, created_at);
+
+CREATE TABLE IF NOT EXISTS page_quotas (
+    page_id                    uuid PRIMARY KEY REFERENCES social_pages(id) ON DELETE CASCADE,
+    sender_messages_per_minute integer,
+    sender_action              text CHECK (sender_action IN ('drop', 'template', 'handoff')),
+    daily_bot_replies          integer,
+    daily_action               text CHECK (daily_action IN ('drop', 'template', 'handoff')),
+    template_message           text
+);
+
+CREATE TABLE IF NOT EXISTS client_quotas (
+    client_id            uuid PRIMARY KEY REFERENCES clients(id) ON DELETE CASCADE,
+    monthly_token_budget bigint,
+    monthly_action       text CHECK (monthly_action IN ('drop', 'template', 'handoff'))
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    scope        text NOT NULL,
+    key          text NOT NULL,
+    window_start timestamptz NOT NULL,
+    count        bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (scope, key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_window ON rate_limit_counters (window_start);
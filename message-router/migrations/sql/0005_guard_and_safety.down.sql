@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS page_safety_policies;
+DROP TABLE IF EXISTS message_flags;
+DROP TABLE IF EXISTS page_guard_settings;
+DROP TABLE IF EXISTS blocked_senders;
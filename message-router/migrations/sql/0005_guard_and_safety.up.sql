@@ -0,0 +1,42 @@
+-- Inbound message guard, outbound answer safety policies and the flags both record
+
+CREATE TABLE IF NOT EXISTS blocked_senders (
+    page_id    uuid REFERENCES social_pages(id) ON DELETE CASCADE,
+    sender_id  text NOT NULL,
+    reason     text,
+    created_at timestamptz DEFAULT now(),
+    PRIMARY KEY (page_id, sender_id)
+);
+
+CREATE TABLE IF NOT EXISTS page_guard_settings (
+    page_id      uuid PRIMARY KEY REFERENCES social_pages(id) ON DELETE CASCADE,
+    action       text CHECK (action IN ('ignore', 'reply', 'escalate')),
+    canned_reply text
+);
+
+CREATE TABLE IF NOT EXISTS message_flags (
+    id          uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id   uuid REFERENCES clients(id),
+    page_id     uuid REFERENCES social_pages(id) ON DELETE CASCADE,
+    thread_id   text,
+    sender_id   text NOT NULL,
+    message_mid text,
+    content     text,
+    check_name  text NOT NULL,
+    reason      text,
+    action      text NOT NULL,
+    created_at  timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_flags_page_created ON message_flags (page_id, created_at);
+
+CREATE TABLE IF NOT EXISTS page_safety_policies (
+    page_id              uuid PRIMARY KEY REFERENCES social_pages(id) ON DELETE CASCADE,
+    forbidden_terms      text[] DEFAULT '{}',
+    blocked_patterns     text[] DEFAULT '{}',
+    prices_from_faq_only boolean DEFAULT false,
+    max_length           integer,
+    llm_moderation       boolean,
+    on_fail              text CHECK (on_fail IN ('regenerate', 'template', 'escalate')),
+    safe_template        text
+);
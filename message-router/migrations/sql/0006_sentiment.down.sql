@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sentiment_cache;
+DROP TABLE IF EXISTS page_sentiment_settings;
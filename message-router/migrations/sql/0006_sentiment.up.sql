@@ -0,0 +1,16 @@
+-- Per-page classifier settings and the persistent sentiment cache
+
+CREATE TABLE IF NOT EXISTS page_sentiment_settings (
+    page_id uuid PRIMARY KEY REFERENCES social_pages(id) ON DELETE CASCADE,
+    prompt  text,
+    intents text[]
+);
+
+CREATE TABLE IF NOT EXISTS sentiment_cache (
+    cache_key  text PRIMARY KEY,
+    analysis   jsonb NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sentiment_cache_expires ON sentiment_cache (expires_at);
@@ -0,0 +1,11 @@
+-- Per-page policy for the bot's handling of new comments on posts
+
+CREATE TABLE IF NOT EXISTS page_comment_policies (
+    page_id            uuid PRIMARY KEY REFERENCES social_pages(id) ON DELETE CASCADE,
+    enabled            boolean,
+    general_action     text CHECK (general_action IN ('ignore', 'reply', 'private_reply', 'hide', 'flag')),
+    frustrated_action  text CHECK (frustrated_action IN ('ignore', 'reply', 'private_reply', 'hide', 'flag')),
+    need_human_action  text CHECK (need_human_action IN ('ignore', 'reply', 'private_reply', 'hide', 'flag')),
+    spam_action        text CHECK (spam_action IN ('ignore', 'reply', 'private_reply', 'hide', 'flag')),
+    private_reply_text text
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS comment_moderation_log;
+DROP TABLE IF EXISTS page_moderation_rules;
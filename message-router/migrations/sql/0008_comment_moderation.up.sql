@@ -0,0 +1,29 @@
+-- Per-page rules that hide comments automatically, and the audit log of every
+-- comment hidden or unhidden by the bot or through the API
+
+CREATE TABLE IF NOT EXISTS page_moderation_rules (
+    page_id          uuid PRIMARY KEY REFERENCES social_pages(id) ON DELETE CASCADE,
+    enabled          boolean,
+    keywords         text[] DEFAULT '{}',
+    hide_links       boolean,
+    hide_profanity   boolean,
+    sentiment_labels text[]
+);
+
+CREATE TABLE IF NOT EXISTS comment_moderation_log (
+    id         uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id  uuid REFERENCES clients(id),
+    page_id    uuid REFERENCES social_pages(id) ON DELETE CASCADE,
+    comment_id text NOT NULL,
+    media_id   text,
+    author_id  text,
+    content    text,
+    action     text NOT NULL CHECK (action IN ('hide', 'unhide')),
+    rule       text NOT NULL,
+    reason     text,
+    source     text NOT NULL CHECK (source IN ('webhook', 'api')),
+    created_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_moderation_log_client_created ON comment_moderation_log (client_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_comment_moderation_log_comment ON comment_moderation_log (comment_id);
@@ -0,0 +1 @@
+ALTER TABLE page_safety_policies DROP COLUMN IF EXISTS enabled;
@@ -0,0 +1,3 @@
+-- Pages opt in to the answer safety filter when SAFETY_ENABLED is off
+
+ALTER TABLE page_safety_policies ADD COLUMN IF NOT EXISTS enabled boolean;
@@ -0,0 +1 @@
+ALTER TABLE page_guard_settings DROP COLUMN IF EXISTS enabled;
@@ -0,0 +1,3 @@
+-- Pages opt in to the message guard when GUARD_ENABLED is off
+
+ALTER TABLE page_guard_settings ADD COLUMN IF NOT EXISTS enabled boolean;
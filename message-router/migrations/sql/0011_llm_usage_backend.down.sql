@@ -0,0 +1,5 @@
+UPDATE llm_usage SET model = backend
+WHERE provider = 'dify' AND backend IS NOT NULL;
+
+ALTER TABLE llm_usage DROP COLUMN IF EXISTS request_id;
+ALTER TABLE llm_usage DROP COLUMN IF EXISTS backend;
@@ -0,0 +1,8 @@
+-- Dify backend and request ID of each LLM call. Dify rows used to store the
+-- backend name as their model.
+
+ALTER TABLE llm_usage ADD COLUMN IF NOT EXISTS backend text;
+ALTER TABLE llm_usage ADD COLUMN IF NOT EXISTS request_id text;
+
+UPDATE llm_usage SET backend = model, model = 'app'
+WHERE provider = 'dify' AND backend IS NULL AND model IN ('primary', 'secondary');
@@ -0,0 +1,201 @@
+// moderation.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"message-router/sentiment"
+)
+
+// =============================================================================
+// COMMENT MODERATION - Hiding spam and insults under the page's posts
+// =============================================================================
+//
+// Moderation rules are checked before the comment policy: a comment with one of
+// the page's keywords, a link or profanity is hidden without classifying it, and
+// a comment whose classification matches one of the sentiment labels is hidden
+// after. Hidden comments stay visible to their author and friends, so spammers
+// don't notice. Every hide and unhide - automatic or through the API - is
+// recorded in comment_moderation_log with the rule that triggered it.
+
+// Rules recorded in comment_moderation_log
+const (
+	ModerationRuleKeyword   = "keyword"   // One of the page's keywords
+	ModerationRuleLink      = "link"      // A URL or domain
+	ModerationRuleProfanity = "profanity" // A term from defaultForbiddenTerms
+	ModerationRuleSentiment = "sentiment" // Classified with one of the sentiment labels
+	ModerationRulePolicy    = "policy"    // The comment policy's hide action
+	ModerationRuleManual    = "manual"    // Hidden or unhidden through the API
+)
+
+// ModerationRules are the automatic hide rules of a page. The config holds the
+// defaults used for pages without a page_moderation_rules row.
+type ModerationRules struct {
+	Enabled         bool
+	Keywords        []string // Matched as whole words on normalized text
+	HideLinks       bool
+	HideProfanity   bool
+	SentimentLabels []string // Statuses or intents (e.g. "spam", "frustrated") that hide a comment
+}
+
+// moderationHit is the rule a comment matched
+type moderationHit struct {
+	Rule   string
+	Reason string
+}
+
+// loadModerationRules returns the page's rules, falling back to the configured defaults
+func (p *MessageProcessor) loadModerationRules(ctx context.Context, pageID, platform string) ModerationRules {
+	rules, err := p.stores.Policies.ModerationRules(ctx, pageID, platform, config.Moderation)
+	if err != nil {
+		LogWarn("Could not load moderation rules for page %s, using defaults: %v", pageID, err)
+		return config.Moderation
+	}
+	return rules
+}
+
+// checkText runs the keyword, link and profanity rules against a comment and
+// returns the first one it matched, or nil
+func (r ModerationRules) checkText(text string) *moderationHit {
+	normalized := " " + normalizeFAQText(text) + " "
+	containsTerm := func(term string) bool {
+		normalizedTerm := normalizeFAQText(term)
+		return normalizedTerm != "" && strings.Contains(normalized, " "+normalizedTerm+" ")
+	}
+
+	for _, keyword := range r.Keywords {
+		if containsTerm(keyword) {
+			return &moderationHit{Rule: ModerationRuleKeyword, Reason: "contains " + keyword}
+		}
+	}
+	if r.HideLinks {
+		if link := guardURLPattern.FindString(text); link != "" {
+			return &moderationHit{Rule: ModerationRuleLink, Reason: "contains " + link}
+		}
+	}
+	if r.HideProfanity {
+		for _, term := range defaultForbiddenTerms {
+			if containsTerm(term) {
+				return &moderationHit{Rule: ModerationRuleProfanity, Reason: "contains " + term}
+			}
+		}
+	}
+	return nil
+}
+
+// checkSentiment returns a hit when the comment's status or intent is one of
+// the sentiment labels and the classifier was confident about it
+func (r ModerationRules) checkSentiment(analysis *sentiment.Analysis) *moderationHit {
+	if !confidentAnalysis(analysis) {
+		return nil
+	}
+	for _, label := range r.SentimentLabels {
+		if label == analysis.Status || label == analysis.Intent {
+			return &moderationHit{Rule: ModerationRuleSentiment,
+				Reason: fmt.Sprintf("classified %s/%s (%.2f)", analysis.Status, analysis.Intent, analysis.Confidence)}
+		}
+	}
+	return nil
+}
+
+// ModerationEntry is one row of comment_moderation_log
+type ModerationEntry struct {
+	PageID    string    `json:"page_id"` // Facebook page or Instagram account ID
+	Platform  string    `json:"platform"`
+	CommentID string    `json:"comment_id"`
+	MediaID   string    `json:"media_id,omitempty"`
+	AuthorID  string    `json:"author_id,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Action    string    `json:"action"` // hide or unhide
+	Rule      string    `json:"rule"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source"`     // webhook or api
+	CreatedAt time.Time `json:"created_at"` // Set by the store
+}
+
+// hideComment hides a comment that matched a rule and records it in the audit
+// log. A comment that could not be hidden is flagged for review instead.
+func (p *MessageProcessor) hideComment(ctx context.Context, pageInfo *PageInfo, comment CommentChange, hit moderationHit) CommentAction {
+	if err := setCommentHidden(ctx, comment.Platform, comment.CommentID, pageInfo.AccessToken, true); err != nil {
+		LogErrorCtx(ctx, "Hiding comment %s failed, flagging it: %v", comment.CommentID, err)
+		p.flagComment(ctx, comment, "comment_hide_failed", err.Error(), CommentActionFlag)
+		return CommentActionFlag
+	}
+
+	LogInfoCtx(ctx, "🙈 Hid comment %s (%s: %s)", comment.CommentID, hit.Rule, hit.Reason)
+	commentModerations.WithLabelValues(comment.Platform, "hide", hit.Rule).Inc()
+	recordModeration(ctx, p.stores.ModerationLog, ModerationEntry{
+		PageID:    comment.PageID,
+		Platform:  comment.Platform,
+		CommentID: comment.CommentID,
+		MediaID:   comment.MediaID,
+		AuthorID:  comment.FromID,
+		Content:   comment.Text,
+		Action:    "hide",
+		Rule:      hit.Rule,
+		Reason:    hit.Reason,
+		Source:    "webhook",
+	})
+	return CommentActionHide
+}
+
+// recordModeration writes an audit log row. Failures are logged, never fatal.
+func recordModeration(ctx context.Context, moderationLog ModerationLogStore, entry ModerationEntry) {
+	if err := moderationLog.RecordModeration(ctx, entry); err != nil {
+		LogWarnCtx(ctx, "Could not record moderation of comment %s: %v", entry.CommentID, err)
+	}
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// handleModerationLog serves GET /api/moderation-log: the client's hidden and
+// unhidden comments, newest first. Optional filters: page_id (Facebook page or
+// Instagram account ID), comment_id and limit (default 50, max 500).
+func handleModerationLog(moderationLog ModerationLogStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		clientID := r.Header.Get("X-Client-ID")
+		if clientID == "" {
+			http.Error(w, "Client ID required", http.StatusUnauthorized)
+			return
+		}
+
+		limit := 50
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = min(parsed, 500)
+		}
+
+		entries, err := moderationLog.ModerationLog(r.Context(), clientID,
+			r.URL.Query().Get("page_id"), r.URL.Query().Get("comment_id"), limit)
+		if err != nil {
+			LogError("Error querying moderation log: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": entries,
+			"count":   len(entries),
+		})
+	}
+}
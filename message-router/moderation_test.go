@@ -0,0 +1,81 @@
+// moderation_test.go
+package main
+
+import (
+	"testing"
+
+	"message-router/sentiment"
+)
+
+func TestModerationRulesCheckText(t *testing.T) {
+	rules := ModerationRules{
+		Enabled:       true,
+		Keywords:      []string{"estafa", "envío gratis"},
+		HideLinks:     true,
+		HideProfanity: true,
+	}
+	tests := []struct {
+		text string
+		rule string // Empty when no rule matches
+	}{
+		{"¿Cuánto cuesta el envío?", ""},
+		{"Esto es una ESTAFA!!", ModerationRuleKeyword},
+		{"¿Tienen envio gratis?", ModerationRuleKeyword}, // Accents and case are ignored
+		{"Me estafaron antes", ""},                       // Keywords match whole words only
+		{"Mejores precios en www.ofertas.example", ModerationRuleLink},
+		{"Compra aquí bit.ly/abc123", ModerationRuleLink},
+		{"Qué servicio de mierda", ModerationRuleProfanity},
+		{"Vivo en Computadora", ""},
+	}
+	for _, tt := range tests {
+		hit := rules.checkText(tt.text)
+		rule := ""
+		if hit != nil {
+			rule = hit.Rule
+		}
+		if rule != tt.rule {
+			t.Errorf("checkText(%q) = %+v, want rule %q", tt.text, hit, tt.rule)
+		}
+	}
+
+	// Link and profanity rules are opt-in
+	keywordsOnly := ModerationRules{Enabled: true, Keywords: []string{"estafa"}}
+	for _, text := range []string{"Mira www.ofertas.example", "Qué servicio de mierda"} {
+		if hit := keywordsOnly.checkText(text); hit != nil {
+			t.Errorf("checkText(%q) with keywords only = %+v, want no hit", text, hit)
+		}
+	}
+}
+
+func TestModerationRulesCheckSentiment(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+	config.SentimentMinConfidence = 0.7
+
+	rules := ModerationRules{Enabled: true, SentimentLabels: []string{"spam", "frustrated"}}
+	tests := []struct {
+		name     string
+		analysis sentiment.Analysis
+		hit      bool
+	}{
+		{"intent label", sentiment.Analysis{Status: "general", Intent: "spam", Confidence: 0.9}, true},
+		{"status label", sentiment.Analysis{Status: "frustrated", Intent: "complaint", Confidence: 0.8}, true},
+		{"no label", sentiment.Analysis{Status: "general", Intent: "pricing", Confidence: 0.9}, false},
+		{"below confidence", sentiment.Analysis{Status: "general", Intent: "spam", Confidence: 0.5}, false},
+		{"no logprobs", sentiment.Analysis{Status: "general", Intent: "spam"}, true},
+	}
+	for _, tt := range tests {
+		hit := rules.checkSentiment(&tt.analysis)
+		if (hit != nil) != tt.hit {
+			t.Errorf("%s: checkSentiment(%s/%s %.2f) = %+v, want hit %v",
+				tt.name, tt.analysis.Status, tt.analysis.Intent, tt.analysis.Confidence, hit, tt.hit)
+		}
+		if hit != nil && hit.Rule != ModerationRuleSentiment {
+			t.Errorf("%s: rule = %q, want %q", tt.name, hit.Rule, ModerationRuleSentiment)
+		}
+	}
+
+	if hit := (ModerationRules{Enabled: true}).checkSentiment(&sentiment.Analysis{Status: "frustrated", Intent: "spam"}); hit != nil {
+		t.Errorf("checkSentiment without labels = %+v, want no hit", hit)
+	}
+}
@@ -7,185 +7,114 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-)
-
-func HandleFacebookBusinessToken(w http.ResponseWriter, r *http.Request) {
-	LogInfo("=== Starting Facebook Business token request handling ===")
-
-	var data struct {
-		UserToken string `json:"userToken"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		LogError("Error decoding request: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// 1. Get user details from Facebook
-	fbUser, err := getFacebookUser(data.UserToken)
-	if err != nil {
-		LogError("Error getting Facebook user details: %v", err)
-		http.Error(w, fmt.Sprintf("Could not verify Facebook user: %v", err), http.StatusInternalServerError)
-		return
-	}
 
-	LogInfo("Facebook Business user authenticated: %s (ID: %s)", fbUser.Name, fbUser.ID)
+	"logging"
+)
 
-	// 2. Get both Facebook pages and Instagram Business accounts
-	facebookPages, err := getConnectedPages(data.UserToken)
-	if err != nil {
-		LogError("Error getting Facebook pages: %v", err)
-		http.Error(w, fmt.Sprintf("Could not get Facebook pages: %v", err), http.StatusInternalServerError)
-		return
-	}
+// HandleFacebookBusinessToken connects the Facebook pages and the Instagram Business
+// accounts of a Facebook user token and saves them in clients
+func HandleFacebookBusinessToken(clients ClientStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		LogInfo("=== Starting Facebook Business token request handling ===")
 
-	LogInfo("Found %d Facebook pages", len(facebookPages))
+		var data struct {
+			UserToken string `json:"userToken"`
+		}
 
-	// 3. Get Instagram Business accounts via Facebook Pages
-	instagramAccounts, err := getInstagramAccountsViaFacebook(data.UserToken)
-	if err != nil {
-		LogError("Warning: Could not get Instagram Business accounts: %v", err)
-		instagramAccounts = []InstagramAccount{} // Continue without Instagram accounts
-	}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			LogError("Error decoding request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	LogInfo("Found %d Instagram Business accounts", len(instagramAccounts))
+		// 1. Get user details from Facebook
+		fbUser, err := getFacebookUser(r.Context(), data.UserToken)
+		if err != nil {
+			LogError("Error getting Facebook user details: %v", err)
+			http.Error(w, fmt.Sprintf("Could not verify Facebook user: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-	// 4. Start transaction for single database
-	tx, err := SocialDB.Begin()
-	if err != nil {
-		LogError("Error starting database transaction: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer tx.Rollback()
-
-	// 5. Create or update client
-	var clientID string
-	err = tx.QueryRow(`
-        INSERT INTO clients (name, facebook_user_id, created_at)
-        VALUES ($1, $2, NOW())
-        ON CONFLICT (facebook_user_id) DO UPDATE
-        SET name = EXCLUDED.name
-        RETURNING id
-    `, fbUser.Name, fbUser.ID).Scan(&clientID)
-	if err != nil {
-		LogError("Error upserting client: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	LogInfo("Upserted client with ID: %s", clientID)
-
-	// 6. Insert/update Facebook pages in social_pages table
-	for _, page := range facebookPages {
-		LogInfo("Processing Facebook page %s (ID: %s)", page.Name, page.ID)
-
-		_, err := tx.Exec(`
-            INSERT INTO social_pages (
-                client_id,
-                platform,
-                page_id, 
-                page_name, 
-                access_token,
-                created_at
-            ) VALUES (
-                $1, $2, $3, $4, $5, NOW()
-            )
-            ON CONFLICT (platform, page_id) 
-            DO UPDATE SET 
-                client_id = EXCLUDED.client_id,
-                page_name = EXCLUDED.page_name,
-                access_token = EXCLUDED.access_token
-        `, clientID, page.Platform, page.ID, page.Name, page.AccessToken)
+		LogInfo("Facebook Business user authenticated: %s (ID: %s)", logging.Name(fbUser.Name), fbUser.ID)
 
+		// 2. Get both Facebook pages and Instagram Business accounts
+		facebookPages, err := getConnectedPages(r.Context(), data.UserToken)
 		if err != nil {
-			LogError("Error processing page %s: %v", page.Name, err)
-			continue
+			LogError("Error getting Facebook pages: %v", err)
+			http.Error(w, fmt.Sprintf("Could not get Facebook pages: %v", err), http.StatusInternalServerError)
+			return
 		}
 
-		LogInfo("Successfully processed page %s", page.Name)
-	}
-
-	// 7. Insert/update Instagram accounts in social_pages table
-	for _, account := range instagramAccounts {
-		LogInfo("Processing Instagram account %s (ID: %s)", account.Name, account.ID)
-
-		_, err := tx.Exec(`
-            INSERT INTO social_pages (
-                client_id,
-                platform,
-                page_id, 
-                page_name, 
-                access_token,
-                created_at
-            ) VALUES (
-                $1, $2, $3, $4, $5, NOW()
-            )
-            ON CONFLICT (platform, page_id) 
-            DO UPDATE SET 
-                client_id = EXCLUDED.client_id,
-                page_name = EXCLUDED.page_name,
-                access_token = EXCLUDED.access_token
-        `, clientID, "instagram", account.ID, account.Name, account.AccessToken)
+		LogInfo("Found %d Facebook pages", len(facebookPages))
 
+		// 3. Get Instagram Business accounts via Facebook Pages
+		instagramAccounts, err := getInstagramAccountsViaFacebook(r.Context(), data.UserToken)
 		if err != nil {
-			LogError("Error processing Instagram account %s: %v", account.Name, err)
-			continue
+			LogError("Warning: Could not get Instagram Business accounts: %v", err)
+			instagramAccounts = []InstagramAccount{} // Continue without Instagram accounts
 		}
 
-		LogInfo("Successfully processed Instagram account %s", account.Name)
-	}
+		LogInfo("Found %d Instagram Business accounts", len(instagramAccounts))
 
-	// 8. Commit transaction
-	if err = tx.Commit(); err != nil {
-		LogError("Error committing transaction: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	LogInfo("Database transaction committed successfully")
-
-	// 9. Set up webhook subscriptions for all pages/accounts (after database commit)
-	allPagesAndAccounts := len(facebookPages) + len(instagramAccounts)
-	LogInfo("Starting webhook subscription automation for %d pages/accounts", allPagesAndAccounts)
-	webhookSuccessCount := 0
-
-	// Set up Facebook page webhooks
-	for _, page := range facebookPages {
-		LogInfo("Setting up webhooks for Facebook page: %s", page.Name)
-
-		if err := setupWebhookSubscriptions(page.ID, page.AccessToken, page.Name, page.Platform); err != nil {
-			LogError("Webhook setup failed for %s: %v", page.Name, err)
-		} else {
-			webhookSuccessCount++
-			LogInfo("Webhook setup completed for %s", page.Name)
+		// 4. Create or update the client with its pages and accounts
+		connected := make([]ConnectedPage, 0, len(facebookPages)+len(instagramAccounts))
+		for _, page := range facebookPages {
+			connected = append(connected, ConnectedPage{Platform: page.Platform, PageID: page.ID, Name: page.Name, AccessToken: page.AccessToken})
+		}
+		for _, account := range instagramAccounts {
+			connected = append(connected, ConnectedPage{Platform: "instagram", PageID: account.ID, Name: account.Name, AccessToken: account.AccessToken})
+		}
+		clientID, err := clients.ConnectClient(r.Context(), fbUser.Name, fbUser.ID, connected)
+		if err != nil {
+			LogError("Error saving client: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		LogInfo("Upserted client with ID: %s", clientID)
+
+		// 5. Set up webhook subscriptions for all pages/accounts (after database commit)
+		allPagesAndAccounts := len(facebookPages) + len(instagramAccounts)
+		LogInfo("Starting webhook subscription automation for %d pages/accounts", allPagesAndAccounts)
+		webhookSuccessCount := 0
+
+		// Set up Facebook page webhooks
+		for _, page := range facebookPages {
+			LogInfo("Setting up webhooks for Facebook page: %s", page.Name)
+
+			if err := setupWebhookSubscriptions(r.Context(), page.ID, page.AccessToken, page.Name, page.Platform); err != nil {
+				LogError("Webhook setup failed for %s: %v", page.Name, err)
+			} else {
+				webhookSuccessCount++
+				LogInfo("Webhook setup completed for %s", page.Name)
+			}
 		}
-	}
 
-	// Set up Instagram account webhooks
-	for _, account := range instagramAccounts {
-		LogInfo("Setting up webhooks for Instagram account: %s", account.Name)
+		// Set up Instagram account webhooks
+		for _, account := range instagramAccounts {
+			LogInfo("Setting up webhooks for Instagram account: %s", account.Name)
 
-		if err := setupWebhookSubscriptions(account.ID, account.AccessToken, account.Name, "instagram"); err != nil {
-			LogError("Webhook setup failed for %s: %v", account.Name, err)
-		} else {
-			webhookSuccessCount++
-			LogInfo("Webhook setup completed for %s", account.Name)
+			if err := setupWebhookSubscriptions(r.Context(), account.ID, account.AccessToken, account.Name, "instagram"); err != nil {
+				LogError("Webhook setup failed for %s: %v", account.Name, err)
+			} else {
+				webhookSuccessCount++
+				LogInfo("Webhook setup completed for %s", account.Name)
+			}
 		}
-	}
 
-	LogInfo("Webhook automation summary: %d/%d pages/accounts configured successfully", webhookSuccessCount, allPagesAndAccounts)
+		LogInfo("Webhook automation summary: %d/%d pages/accounts configured successfully", webhookSuccessCount, allPagesAndAccounts)
 
-	LogInfo("Successfully completed Facebook Business authentication with webhook automation")
+		LogInfo("Successfully completed Facebook Business authentication with webhook automation")
 
-	// 10. Return success response (no session token needed)
-	response := map[string]interface{}{
-		"success":            true,
-		"client_id":          clientID,
-		"facebook_pages":     len(facebookPages),
-		"instagram_accounts": len(instagramAccounts),
-		"message":            "Facebook Business authentication successful - both Facebook and Instagram accounts connected",
-	}
+		// 6. Return success response (no session token needed)
+		response := map[string]interface{}{
+			"success":            true,
+			"client_id":          clientID,
+			"facebook_pages":     len(facebookPages),
+			"instagram_accounts": len(instagramAccounts),
+			"message":            "Facebook Business authentication successful - both Facebook and Instagram accounts connected",
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
 }
@@ -0,0 +1,14 @@
+// oauth/graph.go
+// Graph API clients used by the OAuth handlers
+
+package oauth
+
+import "graph"
+
+// Graph API clients; main replaces them with clients built from the GRAPH_API_*
+// settings so OAuth calls share the router's HTTP client, version and retries
+var (
+	GraphClient          = graph.New(graph.Config{})                                  // graph.facebook.com
+	InstagramClient      = GraphClient.WithBaseURL(graph.InstagramBaseURL, false)     // graph.instagram.com (Instagram Login tokens)
+	InstagramLoginClient = GraphClient.WithBaseURL(graph.InstagramOAuthBaseURL, true) // api.instagram.com code exchange (unversioned)
+)
@@ -4,12 +4,14 @@
 package oauth
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"strings"
+
+	"graph"
 )
 
 // =============================================================================
@@ -50,12 +52,12 @@ func HandleInstagramTokenExchange(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if data.Code == "" || data.RedirectURI == "" {
-		LogError("Missing required fields: code=%s, redirect_uri=%s", data.Code, data.RedirectURI)
+		LogError("Missing required fields: code present=%t, redirect_uri=%s", data.Code != "", data.RedirectURI)
 		http.Error(w, "Missing code or redirect_uri", http.StatusBadRequest)
 		return
 	}
 
-	LogInfo("Instagram token exchange request: code=%s, redirect_uri=%s", data.Code, data.RedirectURI)
+	LogInfo("Instagram token exchange request: redirect_uri=%s", data.RedirectURI)
 
 	// Exchange authorization code for access token using Instagram API
 	instagramAppId := os.Getenv("INSTAGRAM_APP_ID")
@@ -67,72 +69,25 @@ func HandleInstagramTokenExchange(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build token exchange request for Instagram
-	tokenURL := "https://api.instagram.com/oauth/access_token"
-
-	// Prepare form data for POST request
-	formData := fmt.Sprintf(
-		"client_id=%s&client_secret=%s&grant_type=authorization_code&redirect_uri=%s&code=%s",
-		instagramAppId, instagramAppSecret, data.RedirectURI, data.Code,
-	)
-
 	LogInfo("Making token exchange request to Instagram API")
 
-	// Make the token exchange request (POST with form data)
-	resp, err := http.Post(tokenURL, "application/x-www-form-urlencoded", strings.NewReader(formData))
+	// Exchange the authorization code (POST with form data)
+	var tokenResponse graph.AccessToken
+	err := InstagramLoginClient.PostForm(r.Context(), "oauth/access_token", "", url.Values{
+		"client_id":     {instagramAppId},
+		"client_secret": {instagramAppSecret},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {data.RedirectURI},
+		"code":          {data.Code},
+	}, &tokenResponse)
 	if err != nil {
-		LogError("Error making token exchange request: %v", err)
-		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Read response body
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		LogError("Error reading token exchange response: %v", err)
-		http.Error(w, "Failed to read token response", http.StatusInternalServerError)
-		return
-	}
-
-	LogDebug("Instagram token exchange response: %d - %s", resp.StatusCode, string(bodyBytes))
-
-	if resp.StatusCode != http.StatusOK {
-		var igError struct {
-			Error struct {
-				Message string `json:"error_message"`
-				Type    string `json:"error_type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-			ErrorMessage string `json:"error_message"`
-			ErrorType    string `json:"error_type"`
-		}
-
-		if json.Unmarshal(bodyBytes, &igError) == nil {
-			errorMsg := igError.ErrorMessage
-			if errorMsg == "" {
-				errorMsg = igError.Error.Message
-			}
-			LogError("Instagram API error: %s", errorMsg)
-			http.Error(w, fmt.Sprintf("Instagram API error: %s", errorMsg), http.StatusBadRequest)
+		if graphErr, ok := graph.AsGraphError(err); ok {
+			LogError("Instagram API error: %v", graphErr)
+			http.Error(w, fmt.Sprintf("Instagram API error: %s", graphErr.Message), http.StatusBadRequest)
 			return
 		}
-
-		LogError("Instagram token exchange failed: %s", string(bodyBytes))
-		http.Error(w, "Token exchange failed", http.StatusBadRequest)
-		return
-	}
-
-	// Parse successful response
-	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
-		ExpiresIn   int    `json:"expires_in"`
-	}
-
-	if err := json.Unmarshal(bodyBytes, &tokenResponse); err != nil {
-		LogError("Error parsing token response: %v", err)
-		http.Error(w, "Failed to parse token response", http.StatusInternalServerError)
+		LogError("Error making token exchange request: %v", err)
+		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
 		return
 	}
 
@@ -154,41 +109,13 @@ func HandleInstagramTokenExchange(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func getInstagramUser(accessToken string) (*InstagramUser, error) {
-	url := fmt.Sprintf("https://graph.instagram.com/me?fields=id,username&access_token=%s", accessToken)
-	LogDebug("Getting Instagram user info from: %s", url)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("error making request to Instagram API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading Instagram API response: %w", err)
-	}
-
-	LogDebug("Instagram user API response: %d - %s", resp.StatusCode, string(bodyBytes))
-
-	if resp.StatusCode != http.StatusOK {
-		var igError struct {
-			Error struct {
-				Message string `json:"message"`
-				Type    string `json:"type"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
-
-		if json.Unmarshal(bodyBytes, &igError) == nil && igError.Error.Message != "" {
-			return nil, fmt.Errorf("Instagram API error: %s", igError.Error.Message)
-		}
-		return nil, fmt.Errorf("Instagram API error: status %d", resp.StatusCode)
-	}
+func getInstagramUser(ctx context.Context, accessToken string) (*InstagramUser, error) {
+	params := url.Values{"fields": {"id,username"}}
+	LogDebug("Getting Instagram user info from: %s", InstagramClient.URL("me", params))
 
 	var user InstagramUser
-	if err := json.Unmarshal(bodyBytes, &user); err != nil {
-		return nil, fmt.Errorf("error parsing Instagram user response: %w", err)
+	if err := InstagramClient.Get(ctx, "me", accessToken, params, &user); err != nil {
+		return nil, fmt.Errorf("Instagram API error: %w", err)
 	}
 
 	if user.ID == "" || user.Username == "" {
@@ -199,12 +126,12 @@ func getInstagramUser(accessToken string) (*InstagramUser, error) {
 	return &user, nil
 }
 
-func getInstagramBusinessAccounts(accessToken string) ([]InstagramAccount, error) {
+func getInstagramBusinessAccounts(ctx context.Context, accessToken string) ([]InstagramAccount, error) {
 	// Note: Instagram Business API typically requires getting accounts through
 	// Facebook Pages that have connected Instagram Business accounts
 	// For now, we'll create a basic account entry using the user's info
 
-	user, err := getInstagramUser(accessToken)
+	user, err := getInstagramUser(ctx, accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user info: %w", err)
 	}
@@ -222,11 +149,11 @@ func getInstagramBusinessAccounts(accessToken string) ([]InstagramAccount, error
 	return []InstagramAccount{account}, nil
 }
 
-func getInstagramAccountsViaFacebook(facebookAccessToken string) ([]InstagramAccount, error) {
+func getInstagramAccountsViaFacebook(ctx context.Context, facebookAccessToken string) ([]InstagramAccount, error) {
 	LogInfo("Getting Instagram Business accounts through Facebook Pages...")
 
 	// First get Facebook Pages for this user
-	pages, err := getConnectedPages(facebookAccessToken)
+	pages, err := getConnectedPages(ctx, facebookAccessToken)
 	if err != nil {
 		return nil, fmt.Errorf("error getting Facebook pages: %w", err)
 	}
@@ -238,29 +165,13 @@ func getInstagramAccountsViaFacebook(facebookAccessToken string) ([]InstagramAcc
 		LogDebug("Checking page %s for connected Instagram account...", page.Name)
 
 		// Get Instagram Business account ID for this page
-		url := fmt.Sprintf("https://graph.facebook.com/v23.0/%s?fields=instagram_business_account&access_token=%s", page.ID, page.AccessToken)
-
-		resp, err := http.Get(url)
-		if err != nil {
-			LogError("Error checking Instagram connection for page %s: %v", page.Name, err)
-			continue
-		}
-		defer resp.Body.Close()
-
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			LogError("Error reading response for page %s: %v", page.Name, err)
-			continue
-		}
-
 		var pageData struct {
 			InstagramBusinessAccount struct {
 				ID string `json:"id"`
 			} `json:"instagram_business_account"`
 		}
-
-		if err := json.Unmarshal(bodyBytes, &pageData); err != nil {
-			LogError("Error parsing response for page %s: %v", page.Name, err)
+		if err := GraphClient.Get(ctx, page.ID, page.AccessToken, url.Values{"fields": {"instagram_business_account"}}, &pageData); err != nil {
+			LogError("Error checking Instagram connection for page %s: %v", page.Name, err)
 			continue
 		}
 
@@ -269,30 +180,14 @@ func getInstagramAccountsViaFacebook(facebookAccessToken string) ([]InstagramAcc
 			LogInfo("Found Instagram Business account %s connected to page %s", pageData.InstagramBusinessAccount.ID, page.Name)
 
 			// Get Instagram account details
-			igUrl := fmt.Sprintf("https://graph.facebook.com/v23.0/%s?fields=id,username,name&access_token=%s",
-				pageData.InstagramBusinessAccount.ID, page.AccessToken)
-
-			igResp, err := http.Get(igUrl)
-			if err != nil {
-				LogError("Error getting Instagram account details: %v", err)
-				continue
-			}
-			defer igResp.Body.Close()
-
-			igBodyBytes, err := io.ReadAll(igResp.Body)
-			if err != nil {
-				LogError("Error reading Instagram account response: %v", err)
-				continue
-			}
-
 			var igAccount struct {
 				ID       string `json:"id"`
 				Username string `json:"username"`
 				Name     string `json:"name"`
 			}
-
-			if err := json.Unmarshal(igBodyBytes, &igAccount); err != nil {
-				LogError("Error parsing Instagram account response: %v", err)
+			if err := GraphClient.Get(ctx, pageData.InstagramBusinessAccount.ID, page.AccessToken,
+				url.Values{"fields": {"id,username,name"}}, &igAccount); err != nil {
+				LogError("Error getting Instagram account details: %v", err)
 				continue
 			}
 
@@ -315,124 +210,78 @@ func getInstagramAccountsViaFacebook(facebookAccessToken string) ([]InstagramAcc
 	return instagramAccounts, nil
 }
 
-func HandleInstagramToken(w http.ResponseWriter, r *http.Request) {
-	LogInfo("=== Starting Instagram token request handling ===")
+// HandleInstagramToken connects the Instagram Business accounts of an Instagram user
+// token and saves them in clients
+func HandleInstagramToken(clients ClientStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		LogInfo("=== Starting Instagram token request handling ===")
 
-	var data struct {
-		UserToken string `json:"userToken"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		LogError("Error decoding request: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// 1. Get Instagram user details
-	instagramUser, err := getInstagramUser(data.UserToken)
-	if err != nil {
-		LogError("Error getting Instagram user details: %v", err)
-		http.Error(w, fmt.Sprintf("Could not verify Instagram user: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// 2. Get Instagram Business accounts
-	accounts, err := getInstagramBusinessAccounts(data.UserToken)
-	if err != nil {
-		LogError("Error getting Instagram accounts: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	LogInfo("Found %d Instagram Business accounts", len(accounts))
+		var data struct {
+			UserToken string `json:"userToken"`
+		}
 
-	// 3. Start transaction for single database
-	tx, err := SocialDB.Begin()
-	if err != nil {
-		LogError("Error starting database transaction: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer tx.Rollback()
-
-	// 4. Create or update client
-	var clientID string
-	err = tx.QueryRow(`
-        INSERT INTO clients (name, facebook_user_id, created_at)
-        VALUES ($1, $2, NOW())
-        ON CONFLICT (facebook_user_id) DO UPDATE
-        SET name = EXCLUDED.name
-        RETURNING id
-    `, instagramUser.Username, instagramUser.ID).Scan(&clientID)
-	if err != nil {
-		LogError("Error upserting client: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	LogInfo("Upserted client with ID: %s", clientID)
-
-	// 5. Insert/update Instagram accounts in social_pages table
-	for _, account := range accounts {
-		LogInfo("Processing Instagram account %s (ID: %s)", account.Name, account.ID)
-
-		_, err := tx.Exec(`
-            INSERT INTO social_pages (
-                client_id,
-                platform,
-                page_id, 
-                page_name, 
-                access_token,
-                created_at
-            ) VALUES (
-                $1, $2, $3, $4, $5, NOW()
-            )
-            ON CONFLICT (platform, page_id) 
-            DO UPDATE SET 
-                client_id = EXCLUDED.client_id,
-                page_name = EXCLUDED.page_name,
-                access_token = EXCLUDED.access_token
-        `, clientID, "instagram", account.ID, account.Name, account.AccessToken)
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			LogError("Error decoding request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
+		// 1. Get Instagram user details
+		instagramUser, err := getInstagramUser(r.Context(), data.UserToken)
 		if err != nil {
-			LogError("Error processing Instagram account %s: %v", account.Name, err)
-			continue
+			LogError("Error getting Instagram user details: %v", err)
+			http.Error(w, fmt.Sprintf("Could not verify Instagram user: %v", err), http.StatusInternalServerError)
+			return
 		}
 
-		LogInfo("Successfully processed Instagram account %s", account.Name)
-	}
+		// 2. Get Instagram Business accounts
+		accounts, err := getInstagramBusinessAccounts(r.Context(), data.UserToken)
+		if err != nil {
+			LogError("Error getting Instagram accounts: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		LogInfo("Found %d Instagram Business accounts", len(accounts))
 
-	// 6. Commit transaction
-	if err = tx.Commit(); err != nil {
-		LogError("Error committing transaction: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	LogInfo("Database transaction committed successfully")
-
-	// 7. Set up webhook subscriptions for Instagram accounts (after database commit)
-	LogInfo("Starting webhook subscription automation for %d Instagram accounts", len(accounts))
-	webhookSuccessCount := 0
-	for _, account := range accounts {
-		LogInfo("Setting up webhooks for Instagram account: %s", account.Name)
-
-		// Set up webhook subscriptions automatically
-		if err := setupWebhookSubscriptions(account.ID, account.AccessToken, account.Name, "instagram"); err != nil {
-			LogError("Webhook setup failed for %s: %v", account.Name, err)
-			// Don't fail the entire request - webhook setup is best effort
-		} else {
-			webhookSuccessCount++
-			LogInfo("Webhook setup completed for %s", account.Name)
+		// 3. Create or update the client and its accounts
+		connected := make([]ConnectedPage, 0, len(accounts))
+		for _, account := range accounts {
+			connected = append(connected, ConnectedPage{Platform: "instagram", PageID: account.ID, Name: account.Name, AccessToken: account.AccessToken})
+		}
+		clientID, err := clients.ConnectClient(r.Context(), instagramUser.Username, instagramUser.ID, connected)
+		if err != nil {
+			LogError("Error saving client: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		LogInfo("Upserted client with ID: %s", clientID)
+
+		// 4. Set up webhook subscriptions for Instagram accounts (after database commit)
+		LogInfo("Starting webhook subscription automation for %d Instagram accounts", len(accounts))
+		webhookSuccessCount := 0
+		for _, account := range accounts {
+			LogInfo("Setting up webhooks for Instagram account: %s", account.Name)
+
+			// Set up webhook subscriptions automatically
+			if err := setupWebhookSubscriptions(r.Context(), account.ID, account.AccessToken, account.Name, "instagram"); err != nil {
+				LogError("Webhook setup failed for %s: %v", account.Name, err)
+				// Don't fail the entire request - webhook setup is best effort
+			} else {
+				webhookSuccessCount++
+				LogInfo("Webhook setup completed for %s", account.Name)
+			}
 		}
-	}
 
-	LogInfo("Webhook automation summary: %d/%d Instagram accounts configured successfully", webhookSuccessCount, len(accounts))
+		LogInfo("Webhook automation summary: %d/%d Instagram accounts configured successfully", webhookSuccessCount, len(accounts))
 
-	LogInfo("Successfully completed Instagram token request with webhook automation")
+		LogInfo("Successfully completed Instagram token request with webhook automation")
 
-	// Return success response (no session token needed)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":   true,
-		"client_id": clientID,
-		"message":   "Instagram authentication successful",
-	})
+		// Return success response (no session token needed)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"client_id": clientID,
+			"message":   "Instagram authentication successful",
+		})
+	}
 }
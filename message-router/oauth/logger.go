@@ -2,20 +2,24 @@
 package oauth
 
 import (
-	"log"
+	"context"
+	"log/slog"
+
+	"logging"
 )
 
 // OAuth-specific logging functions with clear prefixes
-// This keeps OAuth logs separate from message routing logs
+// This keeps OAuth logs separate from message routing logs; records go through
+// the shared structured logger, so levels and redaction apply here too
 
 func LogError(format string, args ...interface{}) {
-	log.Printf("🔐❌ OAUTH: "+format, args...)
+	logging.Logf(context.Background(), slog.LevelError, "🔐 OAUTH: "+format, args...)
 }
 
 func LogInfo(format string, args ...interface{}) {
-	log.Printf("🔐ℹ️ OAUTH: "+format, args...)
+	logging.Logf(context.Background(), slog.LevelInfo, "🔐 OAUTH: "+format, args...)
 }
 
 func LogDebug(format string, args ...interface{}) {
-	log.Printf("🔐🔍 OAUTH: "+format, args...)
-}
\ No newline at end of file
+	logging.Logf(context.Background(), slog.LevelDebug, "🔐 OAUTH: "+format, args...)
+}
@@ -0,0 +1,22 @@
+// oauth/store.go
+
+package oauth
+
+import "context"
+
+// ConnectedPage is a Facebook page or Instagram account a client connected
+type ConnectedPage struct {
+	Platform    string
+	PageID      string
+	Name        string
+	AccessToken string
+}
+
+// ClientStore saves the clients and pages connected through OAuth. The router
+// passes its Postgres store to the handlers.
+type ClientStore interface {
+	// ConnectClient creates the client of a Facebook or Instagram user (or
+	// updates its name), creates or updates its pages and returns the client ID.
+	// Pages that cannot be saved are logged and skipped.
+	ConnectClient(ctx context.Context, name, userID string, pages []ConnectedPage) (string, error)
+}
@@ -4,11 +4,8 @@
 package oauth
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 )
 
@@ -30,7 +27,7 @@ import (
 //
 // =============================================================================
 
-func setupWebhookSubscriptions(pageID, pageToken, pageName, platform string) error {
+func setupWebhookSubscriptions(ctx context.Context, pageID, pageToken, pageName, platform string) error {
 	LogInfo("Starting webhook setup for %s page: %s (%s)", platform, pageName, pageID)
 
 	if platform == "instagram" {
@@ -46,7 +43,7 @@ func setupWebhookSubscriptions(pageID, pageToken, pageName, platform string) err
 	LogInfo("Facebook page requires individual API webhook subscription")
 
 	// Step 1: Subscribe page to webhooks (Facebook only)
-	if err := subscribePageToWebhooks(pageID, pageToken, platform); err != nil {
+	if err := subscribePageToWebhooks(ctx, pageID, pageToken, platform); err != nil {
 		LogError("Webhook subscription failed for %s: %v", pageName, err)
 		return fmt.Errorf("webhook subscription failed: %v", err)
 	}
@@ -58,88 +55,54 @@ func setupWebhookSubscriptions(pageID, pageToken, pageName, platform string) err
 	return nil
 }
 
-func subscribePageToWebhooks(pageID, pageToken, platform string) error {
+func subscribePageToWebhooks(ctx context.Context, pageID, pageToken, platform string) error {
 	appID := os.Getenv("FACEBOOK_APP_ID")
 	if appID == "" {
 		return fmt.Errorf("FACEBOOK_APP_ID environment variable not set")
 	}
 
 	// Subscribe page to the Neurocrow app for webhook events
-	subscribeURL := fmt.Sprintf("https://graph.facebook.com/v23.0/%s/subscribed_apps", pageID)
+	subscribePath := pageID + "/subscribed_apps"
 
 	// Create platform-specific payload for subscribing to webhooks
 	var subscribedFields []string
 
 	if platform == "instagram" {
-		// Instagram only supports basic messaging fields
+		// Instagram supports messaging fields and comments (for comment automation)
 		subscribedFields = []string{
 			"messages",
 			"messaging_postbacks",
+			"comments",
 		}
-		LogInfo("Using Instagram-specific webhook fields (messages, messaging_postbacks only)")
+		LogInfo("Using Instagram-specific webhook fields (messages, messaging_postbacks, comments)")
 	} else {
 		// Facebook pages support basic fields (REMOVED: messaging_handovers as requested)
+		// plus feed, which carries new comments for comment automation
 		subscribedFields = []string{
 			"messages",
 			"messaging_postbacks",
 			"messaging_policy_enforcement",
 			"message_echoes",
+			"feed",
 		}
-		LogInfo("Using Facebook-specific webhook fields (removed messaging_handovers)")
+		LogInfo("Using Facebook-specific webhook fields (removed messaging_handovers, added feed)")
 	}
 
 	subscribePayload := map[string]interface{}{
 		"subscribed_fields": subscribedFields,
 	}
 
-	jsonData, err := json.Marshal(subscribePayload)
-	if err != nil {
-		return fmt.Errorf("error marshaling subscribe payload: %v", err)
-	}
-
-	// Make POST request to subscribe
-	req, err := http.NewRequest("POST", subscribeURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating subscribe request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.URL.RawQuery = fmt.Sprintf("access_token=%s", pageToken)
-
-	LogInfo("Subscribing %s page %s to webhooks: %s", platform, pageID, subscribeURL)
-	LogDebug("Subscribe payload: %s", string(jsonData))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error making subscribe request: %v", err)
-	}
-	defer resp.Body.Close()
+	LogInfo("Subscribing %s page %s to webhooks: %s", platform, pageID, GraphClient.URL(subscribePath, nil))
+	LogDebug("Subscribe payload: %v", subscribePayload)
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading subscribe response: %v", err)
+	var result struct {
+		Success bool `json:"success"`
 	}
-
-	LogDebug("Webhook subscription response: %d - %s", resp.StatusCode, string(respBody))
-
-	if resp.StatusCode != http.StatusOK {
-		var fbError struct {
-			Error struct {
-				Message   string `json:"message"`
-				Type      string `json:"type"`
-				Code      int    `json:"code"`
-				FbtraceID string `json:"fbtrace_id"`
-			} `json:"error"`
-		}
-
-		if json.Unmarshal(respBody, &fbError) == nil && fbError.Error.Message != "" {
-			return fmt.Errorf("Facebook webhook subscription error: %s (Code: %d, Trace: %s)",
-				fbError.Error.Message, fbError.Error.Code, fbError.Error.FbtraceID)
-		}
-		return fmt.Errorf("webhook subscription failed with status %d: %s", resp.StatusCode, string(respBody))
+	if err := GraphClient.Post(ctx, subscribePath, pageToken, subscribePayload, &result); err != nil {
+		return fmt.Errorf("Facebook webhook subscription error: %w", err)
 	}
 
+	LogDebug("Webhook subscription response: success=%v", result.Success)
 	LogInfo("Successfully subscribed %s page %s to webhooks", platform, pageID)
 	return nil
 }
@@ -3,36 +3,38 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
-)
 
-// getPageInfo retrieves page information from database with platform-specific query
-func getPageInfo(ctx context.Context, pageID string, platform string) (*PageInfo, error) {
-	var info PageInfo
-	info.PageID = pageID
-	err := db.QueryRowContext(ctx,
-		"SELECT platform, access_token FROM social_pages WHERE page_id = $1 AND platform = $2 AND status = 'active'",
-		pageID, platform,
-	).Scan(&info.Platform, &info.AccessToken)
+	"graph"
+	"logging"
+
+	"go.opentelemetry.io/otel/attribute"
+)
 
+// getPageInfo retrieves the page's platform and access token from the page store
+func getPageInfo(ctx context.Context, pages PageStore, pageID string, platform string) (*PageInfo, error) {
+	info, err := pages.GetPageInfo(ctx, pageID, platform)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, ErrNotFound) {
 			return nil, fmt.Errorf("no active page found for ID %s with platform %s", pageID, platform)
 		}
-		return nil, fmt.Errorf("database error: %v", err)
+		return nil, err
 	}
 
-	return &info, nil
+	return info, nil
 }
 
 // sendPlatformResponse routes message sending to the appropriate platform API
-func sendPlatformResponse(ctx context.Context, pageInfo *PageInfo, senderID, message string) error {
+func sendPlatformResponse(ctx context.Context, pageInfo *PageInfo, senderID, message string) (err error) {
+	ctx, span := startSpan(ctx, "graph.send_message", attribute.String("platform", pageInfo.Platform))
+	defer func() { endSpan(span, err) }()
+
 	switch pageInfo.Platform {
 	case "facebook":
 		return sendFacebookMessage(ctx, pageInfo.PageID, pageInfo.AccessToken, senderID, message)
@@ -43,6 +45,29 @@ func sendPlatformResponse(ctx context.Context, pageInfo *PageInfo, senderID, mes
 	}
 }
 
+// sendCommentPrivateReply sends a private message to the author of a comment,
+// which opens a conversation with them. Each comment accepts one private reply.
+func sendCommentPrivateReply(ctx context.Context, pageInfo *PageInfo, commentID, message string) (err error) {
+	ctx, span := startSpan(ctx, "graph.send_message",
+		attribute.String("platform", pageInfo.Platform),
+		attribute.String("comment.id", commentID),
+	)
+	defer func() { endSpan(span, err) }()
+
+	msg := graph.TextMessage{CommentID: commentID, Text: message}
+	if pageInfo.Platform == "facebook" {
+		msg.PageID = pageInfo.PageID // Instagram sends as "me", the account the token belongs to
+	}
+	result, err := graphClient.SendText(ctx, pageInfo.AccessToken, msg)
+	if err != nil {
+		observeGraphAPIError(pageInfo.Platform, "send", err)
+		return fmt.Errorf("error sending private reply: %w", err)
+	}
+
+	LogDebug("✅ Private reply to comment %s sent: %s", commentID, result.MessageID)
+	return nil
+}
+
 // getProfileInfo retrieves user profile information from Facebook/Instagram Graph API
 func getProfileInfo(ctx context.Context, userID string, pageToken string, platform string) (string, error) {
 	log.Printf("🔍 Getting profile info for user %s (platform: %s)", userID, platform)
@@ -55,25 +80,23 @@ func getProfileInfo(ctx context.Context, userID string, pageToken string, platfo
 	// Different endpoints and handling for Facebook and Instagram
 	var userName string
 	if platform == "facebook" {
-		apiURL := fmt.Sprintf("https://graph.facebook.com/v23.0/%s?fields=name&access_token=%s", userID, pageToken)
 		log.Printf("📡 Making Facebook API request for user %s", userID)
 
 		var profile FacebookProfile
-		if err := makeAPIRequest(ctx, apiURL, &profile); err != nil {
+		if err := makeAPIRequest(ctx, platform, userID, pageToken, url.Values{"fields": {"name"}}, &profile); err != nil {
 			return "user", err
 		}
 		userName = profile.Name
-		log.Printf("👤 Using Facebook name: %s", userName)
+		log.Printf("👤 Using Facebook name: %s", logging.Name(userName))
 	} else {
-		apiURL := fmt.Sprintf("https://graph.facebook.com/v23.0/%s?fields=username&access_token=%s", userID, pageToken)
 		log.Printf("📡 Making Instagram API request for user %s", userID)
 
 		var profile InstagramProfile
-		if err := makeAPIRequest(ctx, apiURL, &profile); err != nil {
+		if err := makeAPIRequest(ctx, platform, userID, pageToken, url.Values{"fields": {"username"}}, &profile); err != nil {
 			return "user", err
 		}
 		userName = profile.Username
-		log.Printf("📸 Using Instagram username: %s", userName)
+		log.Printf("📸 Using Instagram username: %s", logging.Name(userName))
 	}
 
 	if userName == "" {
@@ -86,75 +109,61 @@ func getProfileInfo(ctx context.Context, userID string, pageToken string, platfo
 	return userName, nil
 }
 
-// makeAPIRequest is a helper function to make HTTP API requests with JSON decoding
-func makeAPIRequest(ctx context.Context, url string, result interface{}) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
-	}
-
+// makeAPIRequest GETs a Graph API profile object and decodes it into result
+func makeAPIRequest(ctx context.Context, platform, path, pageToken string, params url.Values, result interface{}) error {
 	start := time.Now()
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("error making request: %v", err)
-	}
-	defer resp.Body.Close()
-
+	err := graphClient.Get(ctx, path, pageToken, params, result)
 	log.Printf("⏱️ API request completed in %v", time.Since(start))
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		log.Printf("❌ API error: Status %d, Body: %s",
-			resp.StatusCode, string(respBody))
-		return fmt.Errorf("error response from API: %d", resp.StatusCode)
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
-		return fmt.Errorf("error decoding response: %v", err)
+	if err != nil {
+		observeGraphAPIError(platform, "profile", err)
+		log.Printf("❌ API error: %v", err)
+		return fmt.Errorf("error response from API: %w", err)
 	}
 
 	return nil
 }
 
 // handleSendMessage handles HTTP endpoint for sending messages directly via API
-func handleSendMessage(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func handleSendMessage(pages PageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	var req SendMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("❌ Error parsing send message request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
+		var req SendMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Printf("❌ Error parsing send message request: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
 
-	// Get page info for access token
-	pageInfo, err := getPageInfo(r.Context(), req.PageID, req.Platform)
-	if err != nil {
-		log.Printf("❌ Error getting page info: %v", err)
-		http.Error(w, "Error getting page info", http.StatusInternalServerError)
-		return
-	}
+		// Get page info for access token
+		pageInfo, err := getPageInfo(r.Context(), pages, req.PageID, req.Platform)
+		if err != nil {
+			log.Printf("❌ Error getting page info: %v", err)
+			http.Error(w, "Error getting page info", http.StatusInternalServerError)
+			return
+		}
 
-	// Send message based on platform
-	var sendErr error
-	switch req.Platform {
-	case "facebook":
-		sendErr = sendFacebookMessage(r.Context(), req.PageID, pageInfo.AccessToken, req.RecipientID, req.Message)
-	case "instagram":
-		sendErr = sendInstagramMessage(r.Context(), pageInfo.AccessToken, req.RecipientID, req.Message)
-	default:
-		sendErr = fmt.Errorf("unsupported platform: %s", req.Platform)
-	}
+		// Send message based on platform
+		var sendErr error
+		switch req.Platform {
+		case "facebook":
+			sendErr = sendFacebookMessage(r.Context(), req.PageID, pageInfo.AccessToken, req.RecipientID, req.Message)
+		case "instagram":
+			sendErr = sendInstagramMessage(r.Context(), pageInfo.AccessToken, req.RecipientID, req.Message)
+		default:
+			sendErr = fmt.Errorf("unsupported platform: %s", req.Platform)
+		}
 
-	if sendErr != nil {
-		log.Printf("❌ Error sending message: %v", sendErr)
-		http.Error(w, fmt.Sprintf("Error sending message: %v", sendErr), http.StatusInternalServerError)
-		return
-	}
+		if sendErr != nil {
+			log.Printf("❌ Error sending message: %v", sendErr)
+			http.Error(w, fmt.Sprintf("Error sending message: %v", sendErr), http.StatusInternalServerError)
+			return
+		}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
 }
@@ -0,0 +1,1063 @@
+// postgres_store.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+
+	"message-router/oauth"
+	"message-router/sentiment"
+)
+
+// =============================================================================
+// POSTGRES STORE - Every store on the main DB
+// =============================================================================
+
+// PostgresStore implements every store on the main database
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates the stores for db
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Stores returns s as every store
+func (s *PostgresStore) Stores() Stores {
+	return Stores{Conversations: s, Pages: s, Messages: s, FAQ: s, Quotas: s, Guard: s,
+		Policies: s, Usage: s, ReplyTiers: s, ModerationLog: s, Clients: s, Health: s}
+}
+
+// pageUUID resolves a platform page ID to the internal social_pages.id
+func (s *PostgresStore) pageUUID(ctx context.Context, pageID, platform string) (string, error) {
+	// Include platform: a Facebook page and its Instagram account can share an ID
+	var pageUUID string
+	err := s.db.QueryRowContext(ctx, `
+        SELECT id
+        FROM social_pages
+        WHERE page_id = $1 AND platform = $2
+    `, pageID, platform).Scan(&pageUUID)
+	if err != nil {
+		return "", fmt.Errorf("error finding page: %v", err)
+	}
+	return pageUUID, nil
+}
+
+// GetOrCreate implements ConversationStore
+func (s *PostgresStore) GetOrCreate(ctx context.Context, pageID, threadID, platform string) (*ConversationState, error) {
+	pageUUID, err := s.pageUUID(ctx, pageID, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &ConversationState{}
+	err = s.db.QueryRowContext(ctx, `
+        SELECT c.thread_id, sp.page_id, c.platform, c.bot_enabled,
+               COALESCE(c.last_bot_message_at, '1970-01-01'::timestamp),
+               COALESCE(c.last_human_message_at, '1970-01-01'::timestamp),
+               COALESCE(c.last_user_message_at, '1970-01-01'::timestamp),
+               c.message_count,
+               COALESCE(c.dify_conversation_id, '')
+        FROM conversations c
+        JOIN social_pages sp ON sp.id = c.page_id
+        WHERE c.thread_id = $1 AND c.page_id = $2
+    `, threadID, pageUUID).Scan(
+		&conv.ThreadID,
+		&conv.PageID, // Now will get the original page_id from social_pages
+		&conv.Platform,
+		&conv.BotEnabled,
+		&conv.LastBotMessage,
+		&conv.LastHumanMessage,
+		&conv.LastUserMessage,
+		&conv.MessageCount,
+		&conv.DifyConversationID,
+	)
+
+	if err == sql.ErrNoRows {
+		// Create new conversation
+		conv = &ConversationState{
+			ThreadID:   threadID,
+			PageID:     pageID, // Use original pageID
+			Platform:   platform,
+			BotEnabled: true,
+		}
+
+		err = s.db.QueryRowContext(ctx, `
+            INSERT INTO conversations (
+                thread_id, page_id, platform, bot_enabled,
+                first_message_at, latest_message_at, message_count
+            ) VALUES ($1, $2, $3, $4, NOW(), NOW(), 1)
+            RETURNING thread_id
+        `, conv.ThreadID, pageUUID, conv.Platform, conv.BotEnabled).Scan(&conv.ThreadID)
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating conversation: %v", err)
+		}
+
+		log.Printf("✨ Created new conversation: %s", conv.ThreadID)
+		return conv, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching conversation: %v", err)
+	}
+
+	log.Printf("📝 Retrieved conversation: %s (bot enabled: %v)", conv.ThreadID, conv.BotEnabled)
+	return conv, nil
+}
+
+// BotEnabled implements ConversationStore
+func (s *PostgresStore) BotEnabled(ctx context.Context, threadID string) (bool, error) {
+	var botEnabled bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COALESCE(bot_enabled, true) FROM conversations WHERE thread_id = $1", threadID).Scan(&botEnabled)
+	if err == sql.ErrNoRows {
+		return true, ErrNotFound
+	}
+	return botEnabled, err
+}
+
+// SetBotEnabled implements ConversationStore. The row is locked so concurrent
+// messages of the same thread don't interleave state changes.
+func (s *PostgresStore) SetBotEnabled(ctx context.Context, threadID string, enabled bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the conversation row to prevent concurrent modifications
+	var currentBotState bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT bot_enabled FROM conversations
+        WHERE thread_id = $1
+        FOR UPDATE
+    `, threadID).Scan(&currentBotState)
+	if err != nil {
+		return fmt.Errorf("error locking conversation: %v", err)
+	}
+
+	log.Printf("🔒 Conversation state locked - current: %v, target: %v", currentBotState, enabled)
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE conversations
+		SET bot_enabled = $1,
+			bot_disabled_at = CASE WHEN $1 = false THEN NOW() ELSE NULL END,
+			latest_message_at = NOW(),
+			message_count = message_count + 1,
+			updated_at = NOW()
+		WHERE thread_id = $2
+	`, enabled, threadID); err != nil {
+		return fmt.Errorf("error updating conversation: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// RecordHumanMessage implements ConversationStore
+func (s *PostgresStore) RecordHumanMessage(ctx context.Context, pageID, threadID, platform string) error {
+	pageUUID, err := s.pageUUID(ctx, pageID, platform)
+	if err != nil {
+		return err
+	}
+
+	// Start a transaction with row-level locking to prevent race conditions
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the conversation row to prevent concurrent modifications
+	var currentBotState bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT bot_enabled FROM conversations
+        WHERE thread_id = $1 AND page_id = $2
+        FOR UPDATE
+    `, threadID, pageUUID).Scan(&currentBotState)
+	if err != nil {
+		return fmt.Errorf("error locking conversation: %v", err)
+	}
+
+	log.Printf("🔒 Conversation locked - current bot state: %v", currentBotState)
+
+	// Record the human message and disable the bot until the 12-hour rule re-enables it
+	_, err = tx.ExecContext(ctx, `
+        UPDATE conversations
+        SET last_human_message_at = NOW(),
+            bot_enabled = false,
+            bot_disabled_at = NOW(),
+            latest_message_at = NOW(),
+            message_count = message_count + 1,
+            updated_at = NOW()
+        WHERE thread_id = $1 AND page_id = $2
+    `, threadID, pageUUID)
+	if err != nil {
+		return fmt.Errorf("error updating conversation for human message: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// SetUserName implements ConversationStore
+func (s *PostgresStore) SetUserName(ctx context.Context, threadID, userName string) error {
+	var pageUUID string
+	err := s.db.QueryRowContext(ctx, `
+        SELECT page_id
+        FROM conversations
+        WHERE thread_id = $1
+    `, threadID).Scan(&pageUUID)
+	if err != nil {
+		return fmt.Errorf("error finding conversation: %v", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+        UPDATE conversations
+        SET social_user_name = $1,
+            updated_at = NOW()
+        WHERE thread_id = $2 AND page_id = $3
+    `, userName, threadID, pageUUID)
+	if err != nil {
+		return fmt.Errorf("error updating conversation user name: %v", err)
+	}
+	return nil
+}
+
+// SetDifyConversationID implements ConversationStore
+func (s *PostgresStore) SetDifyConversationID(ctx context.Context, threadID, difyConversationID string) error {
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE conversations
+        SET dify_conversation_id = NULLIF($1, ''),
+            updated_at = NOW()
+        WHERE thread_id = $2
+    `, difyConversationID, threadID)
+	if err != nil {
+		return fmt.Errorf("error updating Dify conversation ID: %v", err)
+	}
+	return nil
+}
+
+// ClientDifyConversationID implements ConversationStore
+func (s *PostgresStore) ClientDifyConversationID(ctx context.Context, threadID, clientID string) (string, error) {
+	var difyConversationID string
+	err := s.db.QueryRowContext(ctx, `
+        SELECT COALESCE(c.dify_conversation_id, '')
+        FROM conversations c
+        JOIN social_pages sp ON sp.id = c.page_id
+        WHERE c.thread_id = $1 AND sp.client_id = $2
+    `, threadID, clientID).Scan(&difyConversationID)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return difyConversationID, err
+}
+
+// UpdateFrustrationScore implements ConversationStore with an exponential moving average
+func (s *PostgresStore) UpdateFrustrationScore(ctx context.Context, threadID string, frustration, smoothing float64) (float64, error) {
+	var score float64
+	err := s.db.QueryRowContext(ctx, `
+        UPDATE conversations
+        SET frustration_score = $1 * $2 + (1 - $1) * COALESCE(frustration_score, 0),
+            updated_at = NOW()
+        WHERE thread_id = $3
+        RETURNING frustration_score
+    `, smoothing, frustration, threadID).Scan(&score)
+	if err != nil {
+		return 0, fmt.Errorf("error updating frustration score: %v", err)
+	}
+	return score, nil
+}
+
+// ResetFrustrationScore implements ConversationStore
+func (s *PostgresStore) ResetFrustrationScore(ctx context.Context, threadID string) error {
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE conversations
+        SET frustration_score = 0,
+            updated_at = NOW()
+        WHERE thread_id = $1
+    `, threadID)
+	if err != nil {
+		return fmt.Errorf("error resetting frustration score: %v", err)
+	}
+	return nil
+}
+
+// ReenableDisabledBots implements ConversationStore with the reenable_disabled_bots() function
+func (s *PostgresStore) ReenableDisabledBots(ctx context.Context) (int, error) {
+	var reactivated int
+	err := s.db.QueryRowContext(ctx, "SELECT reenable_disabled_bots()").Scan(&reactivated)
+	return reactivated, err
+}
+
+// GetPageInfo implements PageStore
+func (s *PostgresStore) GetPageInfo(ctx context.Context, pageID, platform string) (*PageInfo, error) {
+	info := PageInfo{PageID: pageID}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT platform, access_token FROM social_pages WHERE page_id = $1 AND platform = $2 AND status = 'active'",
+		pageID, platform,
+	).Scan(&info.Platform, &info.AccessToken)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	return &info, nil
+}
+
+// GetDifyKeys implements PageStore
+func (s *PostgresStore) GetDifyKeys(ctx context.Context, pageID, platform string) (PageDifyKeys, error) {
+	var keys PageDifyKeys
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(dify_api_key, ''), COALESCE(dify_fallback_api_key, ''), COALESCE(dify_fallback_base_url, '')
+		FROM social_pages
+		WHERE page_id = $1 AND platform = $2 AND status = 'active'
+	`, pageID, platform).Scan(&keys.APIKey, &keys.FallbackAPIKey, &keys.FallbackBaseURL)
+	if err == sql.ErrNoRows {
+		return keys, ErrNotFound
+	}
+	if err != nil {
+		return keys, fmt.Errorf("database error: %v", err)
+	}
+	return keys, nil
+}
+
+// StoreMessage implements MessageStore
+func (s *PostgresStore) StoreMessage(ctx context.Context, pageID, platform, threadID, source, content string) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO messages (client_id, page_id, thread_id, platform, content, from_user, source, timestamp)
+        SELECT sp.client_id, sp.id, $3, $2, $4, $5, $5, NOW()
+        FROM social_pages sp
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform, threadID, content, source)
+	if err != nil {
+		return fmt.Errorf("error storing %s message: %v", source, err)
+	}
+	return nil
+}
+
+// StoreBotMessage implements MessageStore
+func (s *PostgresStore) StoreBotMessage(ctx context.Context, pageID, platform, threadID, content string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO messages (client_id, page_id, thread_id, platform, content, from_user, source, timestamp)
+        SELECT sp.client_id, sp.id, $3, $2, $4, 'bot', 'bot', NOW()
+        FROM social_pages sp
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform, threadID, content)
+	if err != nil {
+		return fmt.Errorf("error storing bot message: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE conversations
+        SET last_bot_message_at = NOW(),
+            latest_message_at = NOW(),
+            last_message_content = $1,
+            last_message_sender = 'bot',
+            message_count = message_count + 1,
+            updated_at = NOW()
+        WHERE thread_id = $2
+    `, content, threadID)
+	if err != nil {
+		return fmt.Errorf("error updating conversation for bot message: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// RecentMessages implements MessageStore. Internal system messages are skipped.
+func (s *PostgresStore) RecentMessages(ctx context.Context, pageID, platform, threadID string, limit int) ([]sentiment.Turn, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT m.source, m.content
+        FROM messages m
+        JOIN social_pages sp ON sp.id = m.page_id
+        WHERE sp.page_id = $1 AND sp.platform = $2 AND m.thread_id = $3
+          AND m.source IN ('user', 'bot', 'human')
+          AND COALESCE(m.internal, false) = false
+        ORDER BY m.timestamp DESC
+        LIMIT $4
+    `, pageID, platform, threadID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error loading conversation history: %v", err)
+	}
+	defer rows.Close()
+
+	var newestFirst []sentiment.Turn
+	for rows.Next() {
+		var turn sentiment.Turn
+		if err := rows.Scan(&turn.Role, &turn.Content); err != nil {
+			return nil, fmt.Errorf("error scanning conversation history: %v", err)
+		}
+		newestFirst = append(newestFirst, turn)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading conversation history: %v", err)
+	}
+	return newestFirst, nil
+}
+
+// EnabledFAQEntries implements FAQStore
+func (s *PostgresStore) EnabledFAQEntries(ctx context.Context, pageID, platform string) ([]FAQEntry, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(queryCtx, `
+        SELECT f.id, f.question, f.triggers, f.answer, f.enabled, f.created_at, f.updated_at
+        FROM faq_entries f
+        JOIN social_pages sp ON sp.id = f.page_id
+        WHERE sp.page_id = $1 AND sp.platform = $2 AND f.enabled = true
+    `, pageID, platform)
+	if err != nil {
+		return nil, fmt.Errorf("error querying FAQ entries: %v", err)
+	}
+	defer rows.Close()
+
+	return scanFAQEntries(rows)
+}
+
+// QuotaLimits implements QuotaStore
+func (s *PostgresStore) QuotaLimits(ctx context.Context, pageID, platform string, defaults QuotaPolicy) (policy QuotaPolicy, pageUUID, clientID string, err error) {
+	policy = defaults
+
+	var senderPerMinute, pageDaily, monthlyTokens sql.NullInt64
+	var senderAction, dailyAction, monthlyAction, template sql.NullString
+	err = s.db.QueryRowContext(ctx, `
+        SELECT sp.id, COALESCE(sp.client_id::text, ''),
+               pq.sender_messages_per_minute, pq.sender_action,
+               pq.daily_bot_replies, pq.daily_action, pq.template_message,
+               cq.monthly_token_budget, cq.monthly_action
+        FROM social_pages sp
+        LEFT JOIN page_quotas pq ON pq.page_id = sp.id
+        LEFT JOIN client_quotas cq ON cq.client_id = sp.client_id
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform).Scan(&pageUUID, &clientID,
+		&senderPerMinute, &senderAction, &pageDaily, &dailyAction, &template,
+		&monthlyTokens, &monthlyAction)
+	if err != nil {
+		return policy, "", "", fmt.Errorf("error loading quota policy: %v", err)
+	}
+
+	if senderPerMinute.Valid {
+		policy.SenderPerMinute = int(senderPerMinute.Int64)
+	}
+	if senderAction.Valid {
+		policy.SenderAction = parseQuotaAction(senderAction.String)
+	}
+	if pageDaily.Valid {
+		policy.PageDailyReplies = int(pageDaily.Int64)
+	}
+	if dailyAction.Valid {
+		policy.PageDailyAction = parseQuotaAction(dailyAction.String)
+	}
+	if template.Valid && template.String != "" {
+		policy.TemplateMessage = template.String
+	}
+	if monthlyTokens.Valid {
+		policy.ClientMonthlyTokens = monthlyTokens.Int64
+	}
+	if monthlyAction.Valid {
+		policy.ClientMonthlyAction = parseQuotaAction(monthlyAction.String)
+	}
+	return policy, pageUUID, clientID, nil
+}
+
+// IncrementCounter implements QuotaStore. The upsert is atomic, so limits are
+// shared between instances and survive restarts.
+func (s *PostgresStore) IncrementCounter(ctx context.Context, scope, key string, window time.Time) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO rate_limit_counters (scope, key, window_start, count)
+        VALUES ($1, $2, $3, 1)
+        ON CONFLICT (scope, key, window_start)
+        DO UPDATE SET count = rate_limit_counters.count + 1
+        RETURNING count
+    `, scope, key, window).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error incrementing %s counter: %v", scope, err)
+	}
+	return count, nil
+}
+
+// DecrementCounter implements QuotaStore
+func (s *PostgresStore) DecrementCounter(ctx context.Context, scope, key string, window time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE rate_limit_counters SET count = count - 1
+        WHERE scope = $1 AND key = $2 AND window_start = $3 AND count > 0
+    `, scope, key, window)
+	if err != nil {
+		return fmt.Errorf("error decrementing %s counter: %v", scope, err)
+	}
+	return nil
+}
+
+// PruneCounters implements QuotaStore. Monthly windows start on the 1st, so
+// they are kept until the month is over; everything else after 2 days.
+func (s *PostgresStore) PruneCounters(ctx context.Context, now time.Time) error {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	_, err := s.db.ExecContext(ctx, `
+        DELETE FROM rate_limit_counters
+        WHERE (scope = 'client_month_exceeded' AND window_start < $1)
+           OR (scope <> 'client_month_exceeded' AND window_start < $2)
+    `, month, now.Add(-48*time.Hour))
+	if err != nil {
+		return fmt.Errorf("error pruning rate limit counters: %v", err)
+	}
+	return nil
+}
+
+// ClientTokensSince implements QuotaStore
+func (s *PostgresStore) ClientTokensSince(ctx context.Context, clientID string, since time.Time) (int64, error) {
+	var used int64
+	err := s.db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(total_tokens), 0) FROM llm_usage
+        WHERE client_id = $1 AND created_at >= $2
+    `, clientID, since).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("error reading token usage: %v", err)
+	}
+	return used, nil
+}
+
+// SenderBlocked implements GuardStore
+func (s *PostgresStore) SenderBlocked(ctx context.Context, pageID, platform, senderID string) (bool, error) {
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM blocked_senders bs
+            JOIN social_pages sp ON sp.id = bs.page_id
+            WHERE sp.page_id = $1 AND sp.platform = $2 AND bs.sender_id = $3
+        )
+    `, pageID, platform, senderID).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("error checking blocked senders: %v", err)
+	}
+	return blocked, nil
+}
+
+// GuardSettings implements GuardStore
+func (s *PostgresStore) GuardSettings(ctx context.Context, pageID, platform string) (PageGuardSettings, error) {
+	var settings PageGuardSettings
+	var enabled sql.NullBool
+	var pageAction, pageReply sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+        SELECT gs.enabled, gs.action, gs.canned_reply
+        FROM page_guard_settings gs
+        JOIN social_pages sp ON sp.id = gs.page_id
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform).Scan(&enabled, &pageAction, &pageReply)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("error loading guard settings: %v", err)
+	}
+	if enabled.Valid {
+		settings.Enabled = &enabled.Bool
+	}
+	settings.Action, settings.Reply = pageAction.String, pageReply.String
+	return settings, nil
+}
+
+// FlagMessage implements GuardStore
+func (s *PostgresStore) FlagMessage(ctx context.Context, flag MessageFlag) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO message_flags (client_id, page_id, thread_id, sender_id, message_mid, content, check_name, reason, action)
+        SELECT sp.client_id, sp.id, $3, $4, $5, $6, $7, $8, $9
+        FROM social_pages sp
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, flag.PageID, flag.Platform, flag.ThreadID, flag.SenderID, flag.MessageMid, flag.Content,
+		flag.Check, flag.Reason, flag.Action)
+	if err != nil {
+		return fmt.Errorf("error flagging message: %v", err)
+	}
+	return nil
+}
+
+// ClientPages implements PageStore
+func (s *PostgresStore) ClientPages(ctx context.Context, clientID string) ([]Page, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, page_id, page_name, platform, access_token, client_id
+        FROM social_pages
+        WHERE client_id = $1 AND status = 'active'
+        ORDER BY platform, page_name
+    `, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying pages: %v", err)
+	}
+	defer rows.Close()
+
+	var pages []Page
+	for rows.Next() {
+		var page Page
+		if err := rows.Scan(&page.ID, &page.PageID, &page.Name, &page.Platform, &page.AccessToken, &page.ClientID); err != nil {
+			return nil, fmt.Errorf("error scanning page: %v", err)
+		}
+		pages = append(pages, page)
+	}
+	return pages, rows.Err()
+}
+
+// ClientPage implements PageStore
+func (s *PostgresStore) ClientPage(ctx context.Context, pageID, clientID string) (*PageInfo, error) {
+	info := PageInfo{PageID: pageID}
+	err := s.db.QueryRowContext(ctx, `
+        SELECT access_token, platform
+        FROM social_pages
+        WHERE page_id = $1 AND client_id = $2 AND status = 'active'
+    `, pageID, clientID).Scan(&info.AccessToken, &info.Platform)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	return &info, nil
+}
+
+// ConnectClient implements oauth.ClientStore in one transaction
+func (s *PostgresStore) ConnectClient(ctx context.Context, name, userID string, pages []oauth.ConnectedPage) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var clientID string
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO clients (name, facebook_user_id, created_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (facebook_user_id) DO UPDATE
+        SET name = EXCLUDED.name
+        RETURNING id
+    `, name, userID).Scan(&clientID)
+	if err != nil {
+		return "", fmt.Errorf("error upserting client: %v", err)
+	}
+
+	for _, page := range pages {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO social_pages (client_id, platform, page_id, page_name, access_token, created_at)
+            VALUES ($1, $2, $3, $4, $5, NOW())
+            ON CONFLICT (platform, page_id)
+            DO UPDATE SET
+                client_id = EXCLUDED.client_id,
+                page_name = EXCLUDED.page_name,
+                access_token = EXCLUDED.access_token
+        `, clientID, page.Platform, page.PageID, page.Name, page.AccessToken)
+		if err != nil {
+			LogError("Error processing %s page %s: %v", page.Platform, page.Name, err)
+			continue
+		}
+		LogInfo("Successfully processed %s page %s", page.Platform, page.Name)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing transaction: %v", err)
+	}
+	return clientID, nil
+}
+
+// ClientPageUUID implements FAQStore
+func (s *PostgresStore) ClientPageUUID(ctx context.Context, pageID, platform, clientID string) (string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id FROM social_pages
+        WHERE page_id = $1 AND client_id = $2 AND status = 'active'
+          AND ($3::text = '' OR platform = $3)
+        LIMIT 2
+    `, pageID, clientID, platform)
+	if err != nil {
+		return "", fmt.Errorf("error finding page: %v", err)
+	}
+	defer rows.Close()
+
+	var pageUUIDs []string
+	for rows.Next() {
+		var pageUUID string
+		if err := rows.Scan(&pageUUID); err != nil {
+			return "", fmt.Errorf("error finding page: %v", err)
+		}
+		pageUUIDs = append(pageUUIDs, pageUUID)
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error finding page: %v", err)
+	}
+	switch len(pageUUIDs) {
+	case 0:
+		return "", ErrNotFound
+	case 1:
+		return pageUUIDs[0], nil
+	default:
+		return "", ErrAmbiguousPage
+	}
+}
+
+// ListFAQEntries implements FAQStore
+func (s *PostgresStore) ListFAQEntries(ctx context.Context, pageUUID string) ([]FAQEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, question, triggers, answer, enabled, created_at, updated_at
+        FROM faq_entries
+        WHERE page_id = $1
+        ORDER BY created_at
+    `, pageUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying FAQ entries: %v", err)
+	}
+	defer rows.Close()
+
+	return scanFAQEntries(rows)
+}
+
+// CreateFAQEntry implements FAQStore
+func (s *PostgresStore) CreateFAQEntry(ctx context.Context, pageUUID string, entry FAQEntry) (string, error) {
+	var entryID string
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO faq_entries (page_id, question, triggers, answer, enabled)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id
+    `, pageUUID, entry.Question, pq.Array(entry.Triggers), entry.Answer, entry.Enabled).Scan(&entryID)
+	if err != nil {
+		return "", fmt.Errorf("error creating FAQ entry: %v", err)
+	}
+	return entryID, nil
+}
+
+// UpdateFAQEntry implements FAQStore
+func (s *PostgresStore) UpdateFAQEntry(ctx context.Context, pageUUID, entryID string, req FAQEntryRequest) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE faq_entries
+        SET question = $1, triggers = $2, answer = $3,
+            enabled = COALESCE($4, enabled), updated_at = NOW()
+        WHERE id = $5 AND page_id = $6
+    `, req.Question, pq.Array(req.Triggers), req.Answer, req.Enabled, entryID, pageUUID)
+	if err != nil {
+		return fmt.Errorf("error updating FAQ entry: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteFAQEntry implements FAQStore
+func (s *PostgresStore) DeleteFAQEntry(ctx context.Context, pageUUID, entryID string) error {
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM faq_entries WHERE id = $1 AND page_id = $2", entryID, pageUUID)
+	if err != nil {
+		return fmt.Errorf("error deleting FAQ entry: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SafetyPolicy implements PolicyStore. Page terms are added to the default
+// terms; invalid patterns are logged and skipped.
+func (s *PostgresStore) SafetyPolicy(ctx context.Context, pageID, platform string, defaults SafetyPolicy) (SafetyPolicy, error) {
+	policy := defaults
+
+	var terms, patterns []string
+	var enabled, pricesFromFAQ, llmModeration sql.NullBool
+	var maxLength sql.NullInt64
+	var onFail, template sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+        SELECT pol.enabled, pol.forbidden_terms, pol.blocked_patterns, pol.prices_from_faq_only,
+               pol.max_length, pol.llm_moderation, pol.on_fail, pol.safe_template
+        FROM page_safety_policies pol
+        JOIN social_pages sp ON sp.id = pol.page_id
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform).Scan(&enabled, pq.Array(&terms), pq.Array(&patterns), &pricesFromFAQ,
+		&maxLength, &llmModeration, &onFail, &template)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, fmt.Errorf("error loading safety policy: %v", err)
+	}
+
+	if enabled.Valid {
+		policy.Enabled = enabled.Bool
+	}
+	policy.ForbiddenTerms = append(append([]string{}, policy.ForbiddenTerms...), terms...)
+	for _, raw := range patterns {
+		pattern, err := regexp.Compile(raw)
+		if err != nil {
+			LogWarn("Ignoring invalid safety pattern %q for page %s: %v", raw, pageID, err)
+			continue
+		}
+		policy.BlockedPatterns = append(policy.BlockedPatterns, pattern)
+	}
+	if pricesFromFAQ.Valid {
+		policy.PricesFromFAQOnly = pricesFromFAQ.Bool
+	}
+	if maxLength.Valid {
+		policy.MaxLength = int(maxLength.Int64)
+	}
+	if llmModeration.Valid {
+		policy.LLMModeration = llmModeration.Bool
+	}
+	if onFail.Valid {
+		policy.OnFail = parseSafetyAction(onFail.String)
+	}
+	if template.Valid && template.String != "" {
+		policy.SafeTemplate = template.String
+	}
+	return policy, nil
+}
+
+// CommentPolicy implements PolicyStore
+func (s *PostgresStore) CommentPolicy(ctx context.Context, pageID, platform string, defaults CommentPolicy) (CommentPolicy, error) {
+	policy := defaults
+
+	var enabled sql.NullBool
+	var general, frustrated, needHuman, spam, privateReply sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+        SELECT cp.enabled, cp.general_action, cp.frustrated_action, cp.need_human_action,
+               cp.spam_action, cp.private_reply_text
+        FROM page_comment_policies cp
+        JOIN social_pages sp ON sp.id = cp.page_id
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform).Scan(&enabled, &general, &frustrated, &needHuman, &spam, &privateReply)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, fmt.Errorf("error loading comment policy: %v", err)
+	}
+
+	if enabled.Valid {
+		policy.Enabled = enabled.Bool
+	}
+	policy.GeneralAction = parseCommentAction(general.String, policy.GeneralAction)
+	policy.FrustratedAction = parseCommentAction(frustrated.String, policy.FrustratedAction)
+	policy.NeedHumanAction = parseCommentAction(needHuman.String, policy.NeedHumanAction)
+	policy.SpamAction = parseCommentAction(spam.String, policy.SpamAction)
+	if privateReply.Valid && privateReply.String != "" {
+		policy.PrivateReply = privateReply.String
+	}
+	return policy, nil
+}
+
+// ModerationRules implements PolicyStore. Page keywords are added to the
+// default keywords; page sentiment labels replace the default labels.
+func (s *PostgresStore) ModerationRules(ctx context.Context, pageID, platform string, defaults ModerationRules) (ModerationRules, error) {
+	rules := defaults
+
+	var enabled, hideLinks, hideProfanity sql.NullBool
+	var keywords, labels []string
+	err := s.db.QueryRowContext(ctx, `
+        SELECT mr.enabled, mr.keywords, mr.hide_links, mr.hide_profanity, mr.sentiment_labels
+        FROM page_moderation_rules mr
+        JOIN social_pages sp ON sp.id = mr.page_id
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform).Scan(&enabled, pq.Array(&keywords), &hideLinks, &hideProfanity, pq.Array(&labels))
+	if err == sql.ErrNoRows {
+		return rules, nil
+	}
+	if err != nil {
+		return rules, fmt.Errorf("error loading moderation rules: %v", err)
+	}
+
+	if enabled.Valid {
+		rules.Enabled = enabled.Bool
+	}
+	rules.Keywords = append(append([]string{}, rules.Keywords...), keywords...)
+	if hideLinks.Valid {
+		rules.HideLinks = hideLinks.Bool
+	}
+	if hideProfanity.Valid {
+		rules.HideProfanity = hideProfanity.Bool
+	}
+	if labels != nil {
+		rules.SentimentLabels = labels
+	}
+	return rules, nil
+}
+
+// SentimentOptions implements PolicyStore
+func (s *PostgresStore) SentimentOptions(ctx context.Context, pageID, platform string) (sentiment.Options, error) {
+	var opts sentiment.Options
+	var prompt sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+        SELECT ss.prompt, ss.intents
+        FROM page_sentiment_settings ss
+        JOIN social_pages sp ON sp.id = ss.page_id
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform).Scan(&prompt, pq.Array(&opts.Intents))
+	if err == sql.ErrNoRows {
+		return sentiment.Options{}, nil
+	}
+	if err != nil {
+		return sentiment.Options{}, fmt.Errorf("error loading sentiment settings: %v", err)
+	}
+	opts.Prompt = prompt.String
+	return opts, nil
+}
+
+// RecordLLMUsage implements UsageStore
+func (s *PostgresStore) RecordLLMUsage(ctx context.Context, usage LLMUsage, costUSD float64, requestID string) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO llm_usage (
+            client_id, page_id, thread_id, provider, model, backend, purpose,
+            prompt_tokens, completion_tokens, total_tokens, cost_usd, request_id
+        )
+        SELECT sp.client_id, sp.id, $3, $4, $5, $6, $7, $8, $9, $8 + $9, $10, $11
+        FROM social_pages sp
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, usage.PageID, usage.Platform, usage.ThreadID, usage.Provider, usage.Model, nullIfEmpty(usage.Backend), usage.Purpose,
+		usage.PromptTokens, usage.CompletionTokens, costUSD, nullIfEmpty(requestID))
+	if err != nil {
+		return fmt.Errorf("error recording usage: %v", err)
+	}
+	return nil
+}
+
+// UsageReport implements UsageStore
+func (s *PostgresStore) UsageReport(ctx context.Context, clientID, period string, from, to time.Time) ([]UsageReportRow, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT date_trunc($2, u.created_at AT TIME ZONE 'UTC') AS bucket,
+               u.provider, u.model, COALESCE(u.backend, ''),
+               COUNT(*), SUM(u.prompt_tokens), SUM(u.completion_tokens), SUM(u.cost_usd)
+        FROM llm_usage u
+        WHERE u.client_id = $1
+          AND u.created_at >= $3
+          AND u.created_at < $4
+        GROUP BY bucket, u.provider, u.model, COALESCE(u.backend, '')
+        ORDER BY bucket, u.provider, u.model, COALESCE(u.backend, '')
+    `, clientID, period, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error querying usage report: %v", err)
+	}
+	defer rows.Close()
+
+	report := []UsageReportRow{}
+	for rows.Next() {
+		var bucket time.Time
+		var row UsageReportRow
+		if err := rows.Scan(&bucket, &row.Provider, &row.Model, &row.Backend, &row.Calls,
+			&row.PromptTokens, &row.CompletionTokens, &row.CostUSD); err != nil {
+			return nil, fmt.Errorf("error scanning usage report: %v", err)
+		}
+		row.Period = formatUsagePeriod(bucket, period)
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+// RecordReplyTier implements ReplyTierStore
+func (s *PostgresStore) RecordReplyTier(ctx context.Context, pageID, platform, threadID string, tier ReplyTier, errorMessage string) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO bot_reply_events (page_id, thread_id, tier, error_message)
+        SELECT sp.id, $3, $4, NULLIF($5, '')
+        FROM social_pages sp
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, pageID, platform, threadID, string(tier), errorMessage)
+	if err != nil {
+		return fmt.Errorf("error recording reply tier: %v", err)
+	}
+	return nil
+}
+
+// ReplyTierCounts implements ReplyTierStore
+func (s *PostgresStore) ReplyTierCounts(ctx context.Context, clientID string, since time.Time) ([]ReplyTierCount, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT sp.page_id, sp.platform, e.tier, COUNT(*)
+        FROM bot_reply_events e
+        JOIN social_pages sp ON sp.id = e.page_id
+        WHERE sp.client_id = $1 AND e.created_at >= $2
+        GROUP BY sp.page_id, sp.platform, e.tier
+        ORDER BY sp.page_id, e.tier
+    `, clientID, since)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reply tier stats: %v", err)
+	}
+	defer rows.Close()
+
+	var counts []ReplyTierCount
+	for rows.Next() {
+		var count ReplyTierCount
+		if err := rows.Scan(&count.PageID, &count.Platform, &count.Tier, &count.Count); err != nil {
+			return nil, fmt.Errorf("error scanning reply tier stats: %v", err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// RecordModeration implements ModerationLogStore
+func (s *PostgresStore) RecordModeration(ctx context.Context, entry ModerationEntry) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO comment_moderation_log (client_id, page_id, comment_id, media_id, author_id, content, action, rule, reason, source)
+        SELECT sp.client_id, sp.id, $3, $4, $5, $6, $7, $8, $9, $10
+        FROM social_pages sp
+        WHERE sp.page_id = $1 AND sp.platform = $2
+    `, entry.PageID, entry.Platform, entry.CommentID, nullIfEmpty(entry.MediaID), nullIfEmpty(entry.AuthorID),
+		nullIfEmpty(entry.Content), entry.Action, entry.Rule, nullIfEmpty(entry.Reason), entry.Source)
+	if err != nil {
+		return fmt.Errorf("error recording moderation: %v", err)
+	}
+	return nil
+}
+
+// ModerationLog implements ModerationLogStore
+func (s *PostgresStore) ModerationLog(ctx context.Context, clientID, pageID, commentID string, limit int) ([]ModerationEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT sp.page_id, sp.platform, l.comment_id, COALESCE(l.media_id, ''), COALESCE(l.author_id, ''),
+               COALESCE(l.content, ''), l.action, l.rule, COALESCE(l.reason, ''), l.source, l.created_at
+        FROM comment_moderation_log l
+        JOIN social_pages sp ON sp.id = l.page_id
+        WHERE l.client_id = $1
+          AND ($2 = '' OR sp.page_id = $2)
+          AND ($3 = '' OR l.comment_id = $3)
+        ORDER BY l.created_at DESC
+        LIMIT $4
+    `, clientID, pageID, commentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying moderation log: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []ModerationEntry{}
+	for rows.Next() {
+		var e ModerationEntry
+		if err := rows.Scan(&e.PageID, &e.Platform, &e.CommentID, &e.MediaID, &e.AuthorID,
+			&e.Content, &e.Action, &e.Rule, &e.Reason, &e.Source, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning moderation log: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Ping implements HealthStore
+func (s *PostgresStore) Ping(ctx context.Context) (sql.DBStats, error) {
+	err := s.db.PingContext(ctx)
+	return s.db.Stats(), err
+}
+
+// ReenableFunctionExists implements HealthStore
+func (s *PostgresStore) ReenableFunctionExists(ctx context.Context) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_proc WHERE proname = 'reenable_disabled_bots')`).Scan(&exists)
+	return exists, err
+}
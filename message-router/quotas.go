@@ -0,0 +1,187 @@
+// quotas.go
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// QUOTAS & RATE LIMITS - Caps on what a sender, page or client can cost us
+// =============================================================================
+
+// QuotaAction is what happens to a message once a limit is exceeded
+type QuotaAction string
+
+const (
+	QuotaActionDrop     QuotaAction = "drop"     // Ignore the message silently
+	QuotaActionTemplate QuotaAction = "template" // Reply once with the page's limit template
+	QuotaActionHandoff  QuotaAction = "handoff"  // Disable the bot so a human picks it up
+)
+
+// parseQuotaAction converts a configured action, defaulting to drop for unknown values
+func parseQuotaAction(value string) QuotaAction {
+	switch QuotaAction(strings.ToLower(strings.TrimSpace(value))) {
+	case QuotaActionTemplate:
+		return QuotaActionTemplate
+	case QuotaActionHandoff:
+		return QuotaActionHandoff
+	default:
+		return QuotaActionDrop
+	}
+}
+
+// QuotaPolicy holds the limits that apply to one page. Zero means unlimited.
+type QuotaPolicy struct {
+	SenderPerMinute     int         // Messages per minute a single sender may send to the bot
+	SenderAction        QuotaAction //
+	PageDailyReplies    int         // Bot replies per page per day (UTC)
+	PageDailyAction     QuotaAction //
+	ClientMonthlyTokens int64       // LLM tokens per client per calendar month (UTC)
+	ClientMonthlyAction QuotaAction //
+	TemplateMessage     string      // Sent by the template action
+}
+
+// quotaDecision explains why a message was stopped
+type quotaDecision struct {
+	Limit       string // "sender_per_minute", "page_daily_replies" or "client_monthly_tokens"
+	Action      QuotaAction
+	Template    string // Reply used by the template action
+	FirstExceed bool   // True only for the first message over the limit in its window
+}
+
+// utcDay returns the start of t's UTC day, the window of page_day counters
+func utcDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// checkQuotas counts this message against the sender, page and client limits and
+// returns the first limit it exceeds, or nil if the bot may answer. The page's
+// daily limit counts bot replies: a reply is reserved here and released by
+// releaseDailyReply unless countBotReply marked it sent.
+// Counter errors never block a message (graceful degradation).
+func (p *MessageProcessor) checkQuotas(ctx context.Context, msgContext *MessageContext, requestID string) *quotaDecision {
+	quotas := p.stores.Quotas
+	policy, pageUUID, clientID, err := quotas.QuotaLimits(ctx, msgContext.PageInfo.PageID, msgContext.Platform, config.QuotaDefaults)
+	if err != nil {
+		LogWarnCtx(ctx, "Quota check skipped: %v", err)
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	// Per-sender messages per minute
+	if policy.SenderPerMinute > 0 {
+		count, err := quotas.IncrementCounter(ctx, "sender_minute",
+			pageUUID+":"+msgContext.Message.Sender.ID, now.Truncate(time.Minute))
+		if err != nil {
+			LogWarnCtx(ctx, "%v", err)
+		} else if count > int64(policy.SenderPerMinute) {
+			return &quotaDecision{Limit: "sender_per_minute", Action: policy.SenderAction, Template: policy.TemplateMessage,
+				FirstExceed: count == int64(policy.SenderPerMinute)+1}
+		}
+	}
+
+	// Monthly token budget per client, measured from the usage ledger
+	if policy.ClientMonthlyTokens > 0 && clientID != "" {
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		used, err := quotas.ClientTokensSince(ctx, clientID, month)
+		if err != nil {
+			LogWarnCtx(ctx, "Could not read monthly token usage: %v", err)
+		} else if used >= policy.ClientMonthlyTokens {
+			// Count this sender's messages against the exhausted budget, so each sender
+			// gets the template or handoff message once per month
+			count, err := quotas.IncrementCounter(ctx, "client_month_exceeded",
+				clientID+":"+pageUUID+":"+msgContext.Message.Sender.ID, month)
+			return &quotaDecision{Limit: "client_monthly_tokens", Action: policy.ClientMonthlyAction, Template: policy.TemplateMessage,
+				FirstExceed: err == nil && count == 1}
+		}
+	}
+
+	// Daily bot replies per page. The reply is reserved with the atomic increment,
+	// so concurrent messages can't all pass a read of the same count. Checked
+	// last, so a message stopped by another limit never holds a reservation.
+	if policy.PageDailyReplies > 0 {
+		day := utcDay(now)
+		replies, err := quotas.IncrementCounter(ctx, "page_day", pageUUID, day)
+		if err != nil {
+			LogWarnCtx(ctx, "%v", err)
+		} else if replies > int64(policy.PageDailyReplies) {
+			if err := quotas.DecrementCounter(ctx, "page_day", pageUUID, day); err != nil {
+				LogWarnCtx(ctx, "%v", err)
+			}
+			// Count the messages over the limit, so the template or handoff message
+			// goes out once per sender and day
+			count, err := quotas.IncrementCounter(ctx, "page_day_exceeded",
+				pageUUID+":"+msgContext.Message.Sender.ID, day)
+			return &quotaDecision{Limit: "page_daily_replies", Action: policy.PageDailyAction, Template: policy.TemplateMessage,
+				FirstExceed: err == nil && count == 1}
+		} else {
+			msgContext.DailyReplyKey, msgContext.DailyReplyDay = pageUUID, day
+			if replies == 1 {
+				// First reply of the day for this page - a good moment to clean up
+				if err := quotas.PruneCounters(ctx, now); err != nil {
+					LogWarnCtx(ctx, "Could not prune rate limit counters: %v", err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// countBotReply keeps the daily reply checkQuotas reserved, once the bot replied
+func (p *MessageProcessor) countBotReply(msgContext *MessageContext) {
+	msgContext.DailyReplySent = true
+}
+
+// releaseDailyReply gives back the daily reply checkQuotas reserved when the bot
+// did not reply after all (handoffs, dropped spam, failed sends). Nothing is
+// reserved for pages without a daily limit.
+func (p *MessageProcessor) releaseDailyReply(ctx context.Context, msgContext *MessageContext) {
+	if msgContext.DailyReplyKey == "" || msgContext.DailyReplySent {
+		return
+	}
+	if err := p.stores.Quotas.DecrementCounter(ctx, "page_day", msgContext.DailyReplyKey, msgContext.DailyReplyDay); err != nil {
+		LogWarnCtx(ctx, "%v", err)
+	}
+}
+
+// enforceQuotaDecision applies the configured action for an exceeded limit.
+// Templates and handoff messages are only sent on the first message over the
+// limit, so a spammer can't use them to make the page reply without limit.
+func (p *MessageProcessor) enforceQuotaDecision(ctx context.Context, msgContext *MessageContext, decision *quotaDecision, requestID string) {
+	LogWarnCtx(ctx, "🚦 Quota %s exceeded for sender %s on page %s - action: %s",
+		decision.Limit, msgContext.Message.Sender.ID, msgContext.PageInfo.PageID, decision.Action)
+	if decision.Action == QuotaActionHandoff && msgContext.Conversation.BotEnabled {
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeHandoff, "quota:"+decision.Limit)
+	} else {
+		recordMessageOutcome(ctx, msgContext.PageInfo.PageID, OutcomeSkipped, "quota:"+decision.Limit)
+	}
+
+	switch decision.Action {
+	case QuotaActionTemplate:
+		if !decision.FirstExceed {
+			return
+		}
+		if err := sendPlatformResponse(ctx, msgContext.PageInfo, msgContext.Message.Sender.ID, decision.Template); err != nil {
+			LogErrorCtx(ctx, "Failed to send quota template: %v", err)
+		}
+	case QuotaActionHandoff:
+		if !msgContext.Conversation.BotEnabled {
+			return
+		}
+		if decision.FirstExceed {
+			handoffMsg := "Te conectaré con un agente humano en breve. Mientras tanto, puedes seguir escribiendo y un agente te responderá."
+			if err := sendPlatformResponse(ctx, msgContext.PageInfo, msgContext.Message.Sender.ID, handoffMsg); err != nil {
+				LogErrorCtx(ctx, "Failed to send handoff message: %v", err)
+			}
+		}
+		if err := p.updateConversationState(ctx, msgContext.Conversation, false, "Quota exceeded: "+decision.Limit); err != nil {
+			LogErrorCtx(ctx, "Failed to disable bot: %v", err)
+		}
+	default:
+		// Drop silently
+	}
+}
@@ -0,0 +1,339 @@
+// safety_filter.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// =============================================================================
+// ANSWER SAFETY FILTER - Checks on bot answers before they reach the customer
+// =============================================================================
+
+// ErrAnswerEscalated is returned when an unsafe answer was withheld and the
+// conversation handed to a human, so the fallback chain doesn't try other tiers
+var ErrAnswerEscalated = errors.New("unsafe answer escalated to a human")
+
+// SafetyAction is what happens when a bot answer fails a safety check
+type SafetyAction string
+
+const (
+	SafetyActionRegenerate SafetyAction = "regenerate" // Ask Dify again, then fall back to the template
+	SafetyActionTemplate   SafetyAction = "template"   // Send the page's safe template instead
+	SafetyActionEscalate   SafetyAction = "escalate"   // Withhold the answer and hand off to a human
+)
+
+// parseSafetyAction converts a configured action, defaulting to template for unknown values
+func parseSafetyAction(value string) SafetyAction {
+	switch SafetyAction(strings.ToLower(strings.TrimSpace(value))) {
+	case SafetyActionRegenerate:
+		return SafetyActionRegenerate
+	case SafetyActionEscalate:
+		return SafetyActionEscalate
+	default:
+		return SafetyActionTemplate
+	}
+}
+
+// SafetyConfig holds the defaults used for pages without a page_safety_policies row
+type SafetyConfig struct {
+	Enabled           bool          // Pages opt in with page_safety_policies.enabled when off
+	ForbiddenTerms    []string      // Added to defaultForbiddenTerms and every page's own terms
+	MaxLength         int           // Characters; 0 = no limit
+	LLMModeration     bool          // Ask a Fireworks model to review answers
+	ModerationModel   string        //
+	ModerationURL     string        // Chat completions endpoint; defaults to Fireworks
+	ModerationTimeout time.Duration //
+	OnFail            SafetyAction  //
+	MaxRegenerations  int           // Dify retries for the regenerate action
+	SafeTemplate      string        // Sent instead of an answer that failed
+}
+
+// SafetyPolicy is the effective policy for one page
+type SafetyPolicy struct {
+	Enabled           bool
+	ForbiddenTerms    []string
+	BlockedPatterns   []*regexp.Regexp
+	PricesFromFAQOnly bool // Prices in answers must appear in one of the page's FAQ answers
+	MaxLength         int
+	LLMModeration     bool
+	OnFail            SafetyAction
+	SafeTemplate      string
+}
+
+// safetyViolation describes the first check an answer failed
+type safetyViolation struct {
+	Check  string // "forbidden_term", "prompt_leak", "blocked_pattern", "unverified_price", "max_length" or "llm_moderation"
+	Reason string
+}
+
+// defaultForbiddenTerms is a small profanity list applied to every page
+var defaultForbiddenTerms = []string{
+	"puta", "puto", "pendejo", "cabron", "chingada", "mierda", "verga", "culero",
+	"fuck", "shit", "bitch", "asshole",
+}
+
+// promptLeakPatterns catch answers that quote or talk about the bot's instructions.
+// They are matched against normalized text (lowercase, no accents or punctuation).
+var promptLeakPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(system|initial|hidden) prompt\b`),
+	regexp.MustCompile(`\bmy (instructions|system message|guidelines) (are|say|tell)\b`),
+	regexp.MustCompile(`\bmis (instrucciones|indicaciones) (son|dicen|indican)\b`),
+	regexp.MustCompile(`\bas an ai (language )?model\b`),
+	regexp.MustCompile(`\bcomo (un )?modelo de (lenguaje|ia)\b`),
+}
+
+// safetyPricePattern finds prices such as "$1,200", "€ 30", "450 pesos" or "99.90 USD"
+var safetyPricePattern = regexp.MustCompile(`(?i)[$€£]\s?\d[\d.,]*|\d[\d.,]*\s?(?:usd|mxn|eur|pesos|dolares|dólares|euros)\b`)
+
+// loadSafetyPolicy returns the page's policy, falling back to the configured defaults
+func (p *MessageProcessor) loadSafetyPolicy(ctx context.Context, pageID, platform string) SafetyPolicy {
+	defaults := SafetyPolicy{
+		Enabled:        config.Safety.Enabled,
+		ForbiddenTerms: append(append([]string{}, defaultForbiddenTerms...), config.Safety.ForbiddenTerms...),
+		MaxLength:      config.Safety.MaxLength,
+		LLMModeration:  config.Safety.LLMModeration,
+		OnFail:         config.Safety.OnFail,
+		SafeTemplate:   config.Safety.SafeTemplate,
+	}
+
+	policy, err := p.stores.Policies.SafetyPolicy(ctx, pageID, platform, defaults)
+	if err != nil {
+		LogWarn("Could not load safety policy for page %s, using defaults: %v", pageID, err)
+		return defaults
+	}
+	return policy
+}
+
+// checkAnswerSafety runs the policy's checks against an answer and returns the
+// first violation, or nil if the answer may be sent. The cheap local checks run
+// before the optional LLM moderation call.
+func (p *MessageProcessor) checkAnswerSafety(ctx context.Context, policy SafetyPolicy, pageID, platform, threadID, answer string) *safetyViolation {
+	normalized := " " + normalizeFAQText(answer) + " "
+
+	for _, term := range policy.ForbiddenTerms {
+		if normalizedTerm := normalizeFAQText(term); normalizedTerm != "" &&
+			strings.Contains(normalized, " "+normalizedTerm+" ") {
+			return &safetyViolation{Check: "forbidden_term", Reason: "contains " + term}
+		}
+	}
+
+	for _, pattern := range promptLeakPatterns {
+		if pattern.MatchString(normalized) {
+			return &safetyViolation{Check: "prompt_leak", Reason: "matched " + pattern.String()}
+		}
+	}
+
+	for _, pattern := range policy.BlockedPatterns {
+		if pattern.MatchString(answer) {
+			return &safetyViolation{Check: "blocked_pattern", Reason: "matched " + pattern.String()}
+		}
+	}
+
+	if policy.PricesFromFAQOnly {
+		if price := p.findUnverifiedPrice(ctx, pageID, platform, answer); price != "" {
+			return &safetyViolation{Check: "unverified_price", Reason: price + " is not in the page's FAQ"}
+		}
+	}
+
+	if policy.MaxLength > 0 {
+		if length := utf8.RuneCountInString(answer); length > policy.MaxLength {
+			return &safetyViolation{Check: "max_length", Reason: fmt.Sprintf("%d characters (max %d)", length, policy.MaxLength)}
+		}
+	}
+
+	if policy.LLMModeration {
+		if reason := p.moderateAnswer(ctx, pageID, platform, threadID, answer); reason != "" {
+			return &safetyViolation{Check: "llm_moderation", Reason: reason}
+		}
+	}
+
+	return nil
+}
+
+// parsePrice returns the value of a price in cents. The last separator is the
+// decimal point when one or two digits follow it, every other separator groups
+// thousands: "$1,200.00", "1.200,00 €" and "1200 pesos" are the same price, and
+// "$1.50" is not "$150".
+func parsePrice(price string) (int64, bool) {
+	number := strings.TrimRight(strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r == '.' || r == ',' {
+			return r
+		}
+		return -1
+	}, price), ".,") // A price can end a sentence
+
+	whole, fraction := number, ""
+	if i := strings.LastIndexAny(number, ".,"); i >= 0 {
+		if decimals := len(number) - i - 1; decimals == 1 || decimals == 2 {
+			whole, fraction = number[:i], number[i+1:]
+		}
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/100 {
+		return 0, false
+	}
+	cents := int64(0)
+	if fraction != "" {
+		cents, _ = strconv.ParseInt((fraction + "0")[:2], 10, 64)
+	}
+	return units*100 + cents, true
+}
+
+// findUnverifiedPrice returns the first price in the answer that none of the page's
+// enabled FAQ answers mention, or "" if every price is backed by the FAQ
+func (p *MessageProcessor) findUnverifiedPrice(ctx context.Context, pageID, platform, answer string) string {
+	prices := safetyPricePattern.FindAllString(answer, -1)
+	if len(prices) == 0 {
+		return ""
+	}
+
+	known := make(map[int64]bool)
+	entries, err := p.stores.FAQ.EnabledFAQEntries(ctx, pageID, platform)
+	if err != nil {
+		LogWarn("Could not load FAQ prices for page %s: %v", pageID, err)
+	}
+	for _, entry := range entries {
+		for _, price := range safetyPricePattern.FindAllString(entry.Answer, -1) {
+			if cents, ok := parsePrice(price); ok {
+				known[cents] = true
+			}
+		}
+	}
+
+	for _, price := range prices {
+		if cents, ok := parsePrice(price); !ok || !known[cents] {
+			return strings.TrimRight(price, ".,")
+		}
+	}
+	return ""
+}
+
+// moderateAnswer asks the moderation model whether an answer is fit to send and
+// returns the reason if it is not. Moderation errors let the answer through,
+// the local checks have already run.
+func (p *MessageProcessor) moderateAnswer(ctx context.Context, pageID, platform, threadID, answer string) string {
+	if answerModerator == nil {
+		return ""
+	}
+	moderation, err := answerModerator.Moderate(ctx, answer)
+	if err != nil {
+		LogWarn("Moderation skipped: %v", err)
+		return ""
+	}
+
+	p.recordLLMUsage(ctx, LLMUsage{
+		PageID:           pageID,
+		Platform:         platform,
+		ThreadID:         threadID,
+		Provider:         moderation.Provider,
+		Model:            moderation.Model,
+		Purpose:          "moderation",
+		PromptTokens:     moderation.PromptTokens,
+		CompletionTokens: moderation.CompletionTokens,
+	})
+
+	if !moderation.Unsafe {
+		return ""
+	}
+	if moderation.Reason == "" {
+		return "rejected by the moderation model"
+	}
+	return moderation.Reason
+}
+
+// enforceAnswerSafety checks a Dify answer before it is sent. Failed answers are
+// flagged and then regenerated, replaced by the safe template, or withheld with
+// the conversation escalated to a human (ErrAnswerEscalated), per the page's policy.
+func (p *MessageProcessor) enforceAnswerSafety(ctx context.Context, pageID, platform string, conv *ConversationState, msg MessagingEntry, backend DifyBackend,
+	difyReq DifyRequest, response *DifyResponse, keepContext bool) (*DifyResponse, error) {
+	policy := p.loadSafetyPolicy(ctx, pageID, platform)
+	if !policy.Enabled {
+		return response, nil
+	}
+	for attempt := 0; ; attempt++ {
+		violation := p.checkAnswerSafety(ctx, policy, pageID, platform, conv.ThreadID, response.Answer)
+		if violation == nil {
+			return response, nil
+		}
+
+		LogWarn("🧯 Dify answer for thread %s failed %s check (%s) - action: %s",
+			conv.ThreadID, violation.Check, violation.Reason, policy.OnFail)
+		p.flagUnsafeAnswer(ctx, pageID, platform, conv.ThreadID, msg, violation, policy.OnFail, response.Answer)
+
+		switch {
+		case policy.OnFail == SafetyActionRegenerate && attempt < config.Safety.MaxRegenerations:
+			// The failed answer is now part of the Dify conversation and would steer
+			// the next one, so regenerate in a fresh conversation that replaces it
+			difyReq.ConversationId = ""
+			regenerated, err := sendToDifyWithRetry(ctx, backend, difyReq)
+			if err != nil {
+				LogWarn("Regeneration failed, sending safe template: %v", err)
+				p.dropUnsafeConversation(ctx, conv, response, keepContext)
+				response.Answer = policy.SafeTemplate
+				return response, nil
+			}
+			p.recordLLMUsage(ctx, difyUsage(pageID, platform, conv.ThreadID, backend, regenerated))
+			response = regenerated
+		case policy.OnFail == SafetyActionEscalate:
+			handoffMsg := "Te conectaré con un agente humano en breve. Mientras tanto, puedes seguir escribiendo y un agente te responderá."
+			if pageInfo, err := getPageInfo(ctx, p.stores.Pages, pageID, platform); err != nil {
+				LogError("Failed to get page info for handoff: %v", err)
+			} else if err := sendPlatformResponse(ctx, pageInfo, msg.Sender.ID, handoffMsg); err != nil {
+				LogError("Failed to send handoff message: %v", err)
+			}
+			if err := p.updateConversationState(ctx, conv, false, "Unsafe bot answer: "+violation.Check); err != nil {
+				LogError("Failed to disable bot: %v", err)
+			}
+			return nil, fmt.Errorf("%s check failed: %w", violation.Check, ErrAnswerEscalated)
+		default:
+			// Template, or regeneration attempts are used up
+			p.dropUnsafeConversation(ctx, conv, response, keepContext)
+			response.Answer = policy.SafeTemplate
+			return response, nil
+		}
+	}
+}
+
+// dropUnsafeConversation forgets the Dify conversation that holds a failed
+// answer, so the user's next message starts a fresh one
+func (p *MessageProcessor) dropUnsafeConversation(ctx context.Context, conv *ConversationState, response *DifyResponse, keepContext bool) {
+	response.ConversationId = ""
+	if !keepContext {
+		return // Secondary backends never store their conversation
+	}
+	if err := p.clearDifyConversationID(ctx, conv.ThreadID); err != nil {
+		LogWarn("Could not reset the Dify conversation of thread %s: %v", conv.ThreadID, err)
+	}
+	conv.DifyConversationID = ""
+}
+
+// flagUnsafeAnswer records a withheld answer in message_flags. Outbound checks are
+// stored with an `answer_` prefix so they can be told apart from inbound guard flags.
+func (p *MessageProcessor) flagUnsafeAnswer(ctx context.Context, pageID, platform, threadID string, msg MessagingEntry, violation *safetyViolation, action SafetyAction, answer string) {
+	err := p.stores.Guard.FlagMessage(ctx, MessageFlag{
+		PageID:     pageID,
+		Platform:   platform,
+		ThreadID:   threadID,
+		SenderID:   msg.Sender.ID,
+		MessageMid: msg.Message.Mid,
+		Content:    answer,
+		Check:      "answer_" + violation.Check,
+		Reason:     violation.Reason,
+		Action:     string(action),
+	})
+	if err != nil {
+		LogWarn("Could not flag unsafe answer: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// safety_filter_test.go
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		price string
+		cents int64
+	}{
+		{"$150", 15000},
+		{"$1.50", 150},
+		{"$1,200", 120000},
+		{"$1,200.00", 120000},
+		{"1.200,00 €", 120000},
+		{"1.250.000 pesos", 125000000},
+		{"99.90 USD", 9990},
+		{"99,9 euros", 9990},
+		{"$150.", 15000},
+		{"€ 30", 3000},
+	}
+	for _, tt := range tests {
+		cents, ok := parsePrice(tt.price)
+		if !ok || cents != tt.cents {
+			t.Errorf("parsePrice(%q) = %d, %v, want %d", tt.price, cents, ok, tt.cents)
+		}
+	}
+	if _, ok := parsePrice("$99999999999999999999"); ok {
+		t.Error("parsePrice accepted a price that overflows")
+	}
+}
+
+// safetyTestProcessor returns a processor whose page has one enabled and one
+// disabled FAQ entry with prices
+func safetyTestProcessor() *MessageProcessor {
+	memory := NewMemoryStore()
+	memory.AddPage(MemoryPage{
+		PageID:   testPageID,
+		Platform: "facebook",
+		ClientID: "client-1",
+		FAQ: []FAQEntry{
+			{ID: "faq-1", Triggers: []string{"precio"}, Answer: "El corte cuesta $1,200.00 y el tinte 450 pesos.", Enabled: true},
+			{ID: "faq-2", Triggers: []string{"promo"}, Answer: "La promo cuesta $99.", Enabled: false},
+		},
+	})
+	return NewMessageProcessor(memory.Stores())
+}
+
+func TestFindUnverifiedPrice(t *testing.T) {
+	p := safetyTestProcessor()
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{"Abrimos de 9 a 18 h.", ""},
+		{"El corte cuesta 1200 pesos.", ""},
+		{"El corte cuesta $1.200,00 y el tinte $450.", ""},
+		{"El corte cuesta $12.00.", "$12.00"},
+		{"El tinte cuesta $4.50", "$4.50"},
+		{"La promo cuesta $99.", "$99"}, // Disabled entries don't count
+	}
+	for _, tt := range tests {
+		if got := p.findUnverifiedPrice(context.Background(), testPageID, "facebook", tt.answer); got != tt.want {
+			t.Errorf("findUnverifiedPrice(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAnswerSafety(t *testing.T) {
+	p := safetyTestProcessor()
+	policy := SafetyPolicy{
+		Enabled:           true,
+		ForbiddenTerms:    []string{"garantizado"},
+		BlockedPatterns:   []*regexp.Regexp{regexp.MustCompile(`(?i)whatsapp`)},
+		PricesFromFAQOnly: true,
+		MaxLength:         60,
+	}
+	tests := []struct {
+		answer string
+		check  string // Empty for safe answers
+	}{
+		{"El corte cuesta $1,200.00.", ""},
+		{"Resultado 100% GARANTIZADO.", "forbidden_term"},
+		{"Mis instrucciones dicen que no puedo ayudarte.", "prompt_leak"},
+		{"Escríbenos por WhatsApp.", "blocked_pattern"},
+		{"El corte cuesta $1.20.", "unverified_price"},
+		{"Con gusto te ayudo con tu pedido, un agente revisará el caso hoy mismo.", "max_length"},
+	}
+	for _, tt := range tests {
+		violation := p.checkAnswerSafety(context.Background(), policy, testPageID, "facebook", testUserID, tt.answer)
+		check := ""
+		if violation != nil {
+			check = violation.Check
+		}
+		if check != tt.check {
+			t.Errorf("checkAnswerSafety(%q) = %+v, want check %q", tt.answer, violation, tt.check)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// cache.go
+package sentiment
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// =============================================================================
+// CACHE - Reuse analyses of repeated short messages ("hola", "gracias", "precio?")
+// =============================================================================
+
+// CacheStore persists cached analyses beyond the in-memory LRU, e.g. in
+// Postgres so restarts and other instances share them
+type CacheStore interface {
+	Get(ctx context.Context, key string) (*Analysis, bool, error)
+	Set(ctx context.Context, key string, analysis *Analysis, ttl time.Duration) error
+	Purge(ctx context.Context) (int64, error)
+}
+
+// CacheConfig configures a CachedClassifier
+type CacheConfig struct {
+	Size             int           // Entries kept in memory (LRU)
+	TTL              time.Duration // How long an analysis stays valid
+	MaxMessageLength int           // Only messages up to this many runes are cached; 0 = any length
+	Version          string        // Part of every key; change it to invalidate all entries
+}
+
+// CacheStats are the cache counters since startup. Purge drops the entries but
+// keeps the counters, which back cumulative Prometheus counters.
+type CacheStats struct {
+	Hits        int64 `json:"hits"`         // Served from memory
+	StoreHits   int64 `json:"store_hits"`   // Served from the persistent store
+	Misses      int64 `json:"misses"`       // Classified by the inner classifier
+	Skipped     int64 `json:"skipped"`      // Not cacheable (too long, no text)
+	Evictions   int64 `json:"evictions"`    // Dropped from memory to make room
+	StoreErrors int64 `json:"store_errors"` // Failed persistent store reads/writes
+	Purges      int64 `json:"purges"`       // Calls to Purge
+	Entries     int   `json:"entries"`      // Currently in memory
+}
+
+// HitRatio is the share of cacheable lookups served without calling the classifier
+func (s CacheStats) HitRatio() float64 {
+	total := s.Hits + s.StoreHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.StoreHits) / float64(total)
+}
+
+type cacheEntry struct {
+	key       string
+	analysis  Analysis
+	expiresAt time.Time
+}
+
+// CachedClassifier serves repeated messages from a normalized-text cache in
+// front of another classifier. Keys include the inner classifier's name, the
+// prompt, the intents and Version, so a new prompt or model never reuses old
+// answers. Messages classified with conversation history also key on the last
+// turn: the same "sí" means something else after every question.
+type CachedClassifier struct {
+	Inner Classifier
+	Store CacheStore // Optional
+	cfg   CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Front = most recently used
+	stats   CacheStats
+}
+
+// NewCachedClassifier wraps inner with an LRU cache and an optional persistent store
+func NewCachedClassifier(inner Classifier, cfg CacheConfig, store CacheStore) *CachedClassifier {
+	if cfg.Size <= 0 {
+		cfg.Size = 10000
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	return &CachedClassifier{
+		Inner:   inner,
+		Store:   store,
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Name implements Classifier
+func (c *CachedClassifier) Name() string {
+	return "cache(" + c.Inner.Name() + ")"
+}
+
+// Classify implements Classifier
+func (c *CachedClassifier) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	key, ok := c.key(message, opts)
+	if !ok {
+		c.count(func(s *CacheStats) { s.Skipped++ })
+		return c.Inner.Classify(ctx, message, opts)
+	}
+
+	if analysis, ok := c.getMemory(key); ok {
+		c.count(func(s *CacheStats) { s.Hits++ })
+		return cachedCopy(analysis), nil
+	}
+
+	if c.Store != nil {
+		analysis, found, err := c.Store.Get(ctx, key)
+		if err != nil {
+			log.Printf("⚠️ Sentiment cache store read failed: %v", err)
+			c.count(func(s *CacheStats) { s.StoreErrors++ })
+		} else if found {
+			c.count(func(s *CacheStats) { s.StoreHits++ })
+			c.setMemory(key, analysis)
+			return cachedCopy(analysis), nil
+		}
+	}
+
+	c.count(func(s *CacheStats) { s.Misses++ })
+	analysis, err := c.Inner.Classify(ctx, message, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lexicon is free to rerun and usually means the primary failed - don't
+	// pin its weaker answer for the whole TTL
+	if analysis.Provider != "lexicon" {
+		c.setMemory(key, analysis)
+		if c.Store != nil {
+			if err := c.Store.Set(ctx, key, analysis, c.cfg.TTL); err != nil {
+				log.Printf("⚠️ Sentiment cache store write failed: %v", err)
+				c.count(func(s *CacheStats) { s.StoreErrors++ })
+			}
+		}
+	}
+	return analysis, nil
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *CachedClassifier) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// Purge drops every cached analysis, in memory and in the store, and counts
+// the purge in Stats. Returns the number of entries removed from the store.
+func (c *CachedClassifier) Purge(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.stats.Purges++
+	c.mu.Unlock()
+
+	if c.Store == nil {
+		return 0, nil
+	}
+	return c.Store.Purge(ctx)
+}
+
+// key builds the cache key, or reports the message as not cacheable
+func (c *CachedClassifier) key(message string, opts Options) (string, bool) {
+	normalized := normalizeLexiconText(message)
+	if normalized == "" {
+		return "", false // Emoji, stickers or punctuation only
+	}
+	if c.cfg.MaxMessageLength > 0 && utf8.RuneCountInString(normalized) > c.cfg.MaxMessageLength {
+		return "", false
+	}
+
+	shouting := "0"
+	if isShouting(message) {
+		shouting = "1" // "HOLA" and "hola" may not be classified the same
+	}
+	// The answer depends on the conversation; the last turn is what a short reply answers
+	lastTurn := ""
+	if len(opts.History) > 0 {
+		turn := opts.History[len(opts.History)-1]
+		lastTurn = turn.Role + ":" + normalizeLexiconText(turn.Content)
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		c.cfg.Version, c.Inner.Name(), opts.Prompt, strings.Join(opts.Intents, ","), shouting, lastTurn, normalized,
+	}, "\x00")))
+	return hex.EncodeToString(sum[:16]), true
+}
+
+func (c *CachedClassifier) getMemory(key string) (*Analysis, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	analysis := entry.analysis
+	return &analysis, true
+}
+
+func (c *CachedClassifier) setMemory(key string, analysis *Analysis) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, analysis: *analysis, expiresAt: time.Now().Add(c.cfg.TTL)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.cfg.Size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *CachedClassifier) count(update func(*CacheStats)) {
+	c.mu.Lock()
+	update(&c.stats)
+	c.mu.Unlock()
+}
+
+// cachedCopy marks an analysis as served from the cache. Token counts are
+// zeroed because nothing was billed for this message.
+func cachedCopy(analysis *Analysis) *Analysis {
+	copied := *analysis
+	copied.Cached = true
+	copied.TokensUsed, copied.PromptTokens, copied.CompletionTokens = 0, 0, 0
+	return &copied
+}
@@ -0,0 +1,71 @@
+// classifier.go
+package sentiment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Classifier produces an Analysis for a message. Implementations: Analyzer
+// (Fireworks or any OpenAI-compatible API) and LexiconClassifier (offline).
+type Classifier interface {
+	Name() string
+	Classify(ctx context.Context, message string, opts Options) (*Analysis, error)
+}
+
+// Chain tries classifiers in order and returns the first successful analysis,
+// e.g. Fireworks as primary with the lexicon classifier as the fallback.
+// Each classifier gets at most Timeout, so a slow one never blocks the reply.
+type Chain struct {
+	Classifiers []Classifier
+	Timeout     time.Duration // Per classifier; 0 = only the caller's deadline applies
+}
+
+// NewChain creates a Chain from a primary classifier and its fallbacks
+func NewChain(timeout time.Duration, classifiers ...Classifier) *Chain {
+	return &Chain{Classifiers: classifiers, Timeout: timeout}
+}
+
+// Name implements Classifier
+func (c *Chain) Name() string {
+	names := make([]string, 0, len(c.Classifiers))
+	for _, classifier := range c.Classifiers {
+		names = append(names, classifier.Name())
+	}
+	return strings.Join(names, ">")
+}
+
+// Classify implements Classifier
+func (c *Chain) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	if len(c.Classifiers) == 0 {
+		return nil, errors.New("no sentiment classifiers configured")
+	}
+
+	var errs []error
+	for _, classifier := range c.Classifiers {
+		analysis, err := c.classifyWithTimeout(ctx, classifier, message, opts)
+		if err == nil {
+			return analysis, nil
+		}
+		log.Printf("⚠️ Sentiment classifier %s failed: %v", classifier.Name(), err)
+		errs = append(errs, fmt.Errorf("%s: %w", classifier.Name(), err))
+
+		if ctx.Err() != nil {
+			break // The caller gave up, don't try the rest
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (c *Chain) classifyWithTimeout(ctx context.Context, classifier Classifier, message string, opts Options) (*Analysis, error) {
+	if c.Timeout <= 0 {
+		return classifier.Classify(ctx, message, opts)
+	}
+	classifyCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	return classifier.Classify(classifyCtx, message, opts)
+}
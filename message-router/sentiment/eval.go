@@ -0,0 +1,355 @@
+// eval.go
+package sentiment
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// EVALUATION - Offline scoring of classifiers against labeled datasets
+// =============================================================================
+
+// EvalCase is one labeled message. Intent is optional.
+type EvalCase struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Intent  string `json:"intent,omitempty"`
+}
+
+// LoadEvalDataset reads labeled cases from a .jsonl file (one EvalCase per line)
+// or a .csv file with a header containing message, status and optionally intent
+func LoadEvalDataset(path string) ([]EvalCase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening dataset: %v", err)
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return readEvalCSV(file)
+	}
+	return readEvalJSONL(file)
+}
+
+func readEvalJSONL(r io.Reader) ([]EvalCase, error) {
+	var cases []EvalCase
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "//") {
+			continue
+		}
+		var c EvalCase
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if c.Message == "" || c.Status == "" {
+			return nil, fmt.Errorf("line %d: message and status are required", line)
+		}
+		cases = append(cases, c)
+	}
+	return cases, scanner.Err()
+}
+
+func readEvalCSV(r io.Reader) ([]EvalCase, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	messageCol, okMessage := columns["message"]
+	statusCol, okStatus := columns["status"]
+	if !okMessage || !okStatus {
+		return nil, fmt.Errorf("CSV header must contain message and status columns")
+	}
+	intentCol, hasIntent := columns["intent"]
+
+	var cases []EvalCase
+	for i, record := range records[1:] {
+		if messageCol >= len(record) || statusCol >= len(record) {
+			return nil, fmt.Errorf("row %d: missing columns", i+2)
+		}
+		c := EvalCase{Message: record[messageCol], Status: record[statusCol]}
+		if hasIntent && intentCol < len(record) {
+			c.Intent = record[intentCol]
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// ClassReport holds precision/recall for one label
+type ClassReport struct {
+	Label     string  `json:"label"`
+	Support   int     `json:"support"` // Cases labeled with this class
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// EvalReport summarizes one classifier run over a dataset
+type EvalReport struct {
+	Name             string                    `json:"name"`
+	Cases            int                       `json:"cases"`
+	Errors           int                       `json:"errors"`
+	StatusAccuracy   float64                   `json:"status_accuracy"`
+	StatusClasses    []ClassReport             `json:"status_classes"`
+	StatusConfusion  map[string]map[string]int `json:"status_confusion"` // expected -> predicted -> count
+	IntentCases      int                       `json:"intent_cases"`     // Cases with an intent label
+	IntentAccuracy   float64                   `json:"intent_accuracy"`
+	IntentClasses    []ClassReport             `json:"intent_classes"`
+	PromptTokens     int                       `json:"prompt_tokens"`
+	CompletionTokens int                       `json:"completion_tokens"`
+	CostUSD          float64                   `json:"cost_usd"`
+	AvgLatency       time.Duration             `json:"avg_latency_ns"`
+	Mistakes         []EvalMistake             `json:"mistakes"`
+}
+
+// EvalMistake is a case whose status was misclassified
+type EvalMistake struct {
+	Message   string `json:"message"`
+	Expected  string `json:"expected"`
+	Predicted string `json:"predicted"`
+	Reasoning string `json:"reasoning,omitempty"`
+}
+
+// EvalOptions configures RunEval
+type EvalOptions struct {
+	Options                                 // Prompt and intents passed to the classifier
+	PromptPricePerMillion     float64       // USD, used for the cost column
+	CompletionPricePerMillion float64       //
+	Delay                     time.Duration // Pause between calls to respect rate limits
+}
+
+// RunEval classifies every case and scores the results. Classifier errors are
+// counted and scored as the "error" prediction.
+func RunEval(ctx context.Context, name string, classifier Classifier, cases []EvalCase, opts EvalOptions) *EvalReport {
+	report := &EvalReport{Name: name, Cases: len(cases)}
+	statusPairs := make([][2]string, 0, len(cases))
+	var intentPairs [][2]string
+	var totalLatency time.Duration
+
+	for i, c := range cases {
+		if i > 0 && opts.Delay > 0 {
+			time.Sleep(opts.Delay)
+		}
+
+		start := time.Now()
+		analysis, err := classifier.Classify(ctx, c.Message, opts.Options)
+		totalLatency += time.Since(start)
+
+		predictedStatus, predictedIntent := "error", "error"
+		if err != nil {
+			report.Errors++
+		} else {
+			predictedStatus, predictedIntent = analysis.Status, analysis.Intent
+			report.PromptTokens += analysis.PromptTokens
+			report.CompletionTokens += analysis.CompletionTokens
+		}
+
+		expected := strings.ToLower(strings.TrimSpace(c.Status))
+		statusPairs = append(statusPairs, [2]string{expected, predictedStatus})
+		if expected != predictedStatus {
+			mistake := EvalMistake{Message: c.Message, Expected: expected, Predicted: predictedStatus}
+			if analysis != nil {
+				mistake.Reasoning = analysis.Reasoning
+			}
+			report.Mistakes = append(report.Mistakes, mistake)
+		}
+		if c.Intent != "" {
+			intentPairs = append(intentPairs, [2]string{strings.ToLower(strings.TrimSpace(c.Intent)), strings.ToLower(predictedIntent)})
+		}
+	}
+
+	report.StatusConfusion, report.StatusAccuracy, report.StatusClasses = scorePairs(statusPairs)
+	report.IntentCases = len(intentPairs)
+	_, report.IntentAccuracy, report.IntentClasses = scorePairs(intentPairs)
+	report.CostUSD = float64(report.PromptTokens)*opts.PromptPricePerMillion/1_000_000 +
+		float64(report.CompletionTokens)*opts.CompletionPricePerMillion/1_000_000
+	if len(cases) > 0 {
+		report.AvgLatency = totalLatency / time.Duration(len(cases))
+	}
+	return report
+}
+
+// scorePairs builds the confusion matrix, accuracy and per-class metrics from
+// (expected, predicted) pairs
+func scorePairs(pairs [][2]string) (map[string]map[string]int, float64, []ClassReport) {
+	confusion := map[string]map[string]int{}
+	predictedCount := map[string]int{}
+	correct := 0
+	for _, pair := range pairs {
+		expected, predicted := pair[0], pair[1]
+		if confusion[expected] == nil {
+			confusion[expected] = map[string]int{}
+		}
+		confusion[expected][predicted]++
+		predictedCount[predicted]++
+		if expected == predicted {
+			correct++
+		}
+	}
+	if len(pairs) == 0 {
+		return confusion, 0, nil
+	}
+
+	labels := make([]string, 0, len(confusion))
+	for label := range confusion {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	classes := make([]ClassReport, 0, len(labels))
+	for _, label := range labels {
+		support := 0
+		for _, count := range confusion[label] {
+			support += count
+		}
+		truePositives := confusion[label][label]
+		class := ClassReport{Label: label, Support: support}
+		if predictedCount[label] > 0 {
+			class.Precision = float64(truePositives) / float64(predictedCount[label])
+		}
+		if support > 0 {
+			class.Recall = float64(truePositives) / float64(support)
+		}
+		if class.Precision+class.Recall > 0 {
+			class.F1 = 2 * class.Precision * class.Recall / (class.Precision + class.Recall)
+		}
+		classes = append(classes, class)
+	}
+	return confusion, float64(correct) / float64(len(pairs)), classes
+}
+
+// =============================================================================
+// RECORDED RESPONSES - Replay classifier output without network (for CI)
+// =============================================================================
+
+// recording is one stored classifier response
+type recording struct {
+	Key      string    `json:"key"`
+	Message  string    `json:"message"`
+	Analysis *Analysis `json:"analysis"`
+}
+
+// recordingKey identifies a classification by message and the options that shape the prompt
+func recordingKey(message string, opts Options) string {
+	sum := sha256.Sum256([]byte(opts.Prompt + "\x00" + strings.Join(opts.Intents, ",") + "\x00" + message))
+	return hex.EncodeToString(sum[:12])
+}
+
+// RecordingClassifier wraps a classifier and appends every successful response
+// to a JSONL file that a ReplayClassifier can serve later
+type RecordingClassifier struct {
+	Inner Classifier
+	mu    sync.Mutex
+	file  *os.File
+}
+
+// NewRecordingClassifier records inner's responses to path (created or truncated)
+func NewRecordingClassifier(inner Classifier, path string) (*RecordingClassifier, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating recordings directory: %v", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating recording file: %v", err)
+	}
+	return &RecordingClassifier{Inner: inner, file: file}, nil
+}
+
+// Name implements Classifier
+func (r *RecordingClassifier) Name() string {
+	return r.Inner.Name()
+}
+
+// Classify implements Classifier
+func (r *RecordingClassifier) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	analysis, err := r.Inner.Classify(ctx, message, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(recording{Key: recordingKey(message, opts), Message: message, Analysis: analysis})
+	if err != nil {
+		return analysis, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("error writing recording: %v", err)
+	}
+	return analysis, nil
+}
+
+// Close flushes the recording file
+func (r *RecordingClassifier) Close() error {
+	return r.file.Close()
+}
+
+// ReplayClassifier serves responses saved by a RecordingClassifier. Messages
+// that were never recorded return an error instead of calling any API.
+type ReplayClassifier struct {
+	name      string
+	responses map[string]*Analysis
+}
+
+// NewReplayClassifier loads the recordings at path
+func NewReplayClassifier(path string) (*ReplayClassifier, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recordings: %v", err)
+	}
+	defer file.Close()
+
+	replay := &ReplayClassifier{name: "replay", responses: map[string]*Analysis{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Analysis == nil {
+			continue
+		}
+		replay.responses[rec.Key] = rec.Analysis
+		if rec.Analysis.Provider != "" {
+			replay.name = "replay:" + rec.Analysis.Provider
+		}
+	}
+	return replay, scanner.Err()
+}
+
+// Name implements Classifier
+func (r *ReplayClassifier) Name() string {
+	return r.name
+}
+
+// Classify implements Classifier
+func (r *ReplayClassifier) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	analysis, ok := r.responses[recordingKey(message, opts)]
+	if !ok {
+		return nil, fmt.Errorf("no recorded response for %q", message)
+	}
+	copied := *analysis
+	return &copied, nil
+}
@@ -0,0 +1,157 @@
+// lexicon.go
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// LexiconClassifier is an offline, rule-based classifier for Spanish and English.
+// It is far less accurate than an LLM and is meant as the last fallback, so it
+// never needs the network and never fails.
+type LexiconClassifier struct{}
+
+// NewLexicon creates the offline lexicon classifier
+func NewLexicon() *LexiconClassifier {
+	return &LexiconClassifier{}
+}
+
+// Confidence reported by the lexicon classifier: a phrase match is a fair signal,
+// the "general" default is a guess
+const (
+	lexiconMatchConfidence   = 0.65
+	lexiconDefaultConfidence = 0.5
+)
+
+// Phrases are matched on normalized text (lowercase, no accents or punctuation)
+var (
+	lexiconNeedHuman = []string{
+		"hablar con una persona", "hablar con un humano", "hablar con alguien", "hablar con un agente",
+		"hablar con un asesor", "persona real", "atencion humana", "un representante", "pasame con",
+		"comunicarme con alguien", "quiero un humano",
+		"talk to a human", "speak to a human", "talk to a person", "speak to a person", "real person",
+		"human agent", "talk to someone", "speak with an agent", "talk to an agent", "representative",
+	}
+	lexiconFrustrated = []string{
+		"inutil", "no sirve", "no sirves", "pesimo", "harto", "harta", "otra vez lo mismo",
+		"no me entiendes", "no me estas entendiendo", "ya te dije", "terrible", "horrible",
+		"estafa", "que mal servicio", "me tienen cansado", "ridiculo",
+		"useless", "frustrated", "frustrating", "angry", "worst", "ridiculous", "not helpful",
+		"annoyed", "already told you", "waste of time", "this is a joke",
+	}
+	lexiconIntents = map[string][]string{
+		"purchase":  {"comprar", "compra", "pedido", "ordenar", "lo quiero", "apartar", "buy", "order", "purchase"},
+		"pricing":   {"precio", "precios", "cuesta", "cuestan", "costo", "cuanto", "tarifa", "price", "prices", "cost", "how much"},
+		"support":   {"ayuda", "problema", "no funciona", "error", "falla", "help", "issue", "problem", "not working", "support"},
+		"complaint": {"queja", "reclamo", "devolucion", "reembolso", "complaint", "refund", "return"},
+		"greeting":  {"hola", "buenos dias", "buenas tardes", "buenas noches", "buenas", "saludos", "hello", "hi", "hey", "good morning"},
+		"spam":      {"gana dinero", "dinero facil", "haz clic", "click here", "free money", "crypto", "bitcoin", "sigueme"},
+	}
+	lexiconStopwords = map[string][]string{
+		"es": {"el", "la", "los", "las", "de", "que", "y", "en", "un", "una", "por", "para", "con", "no", "es", "mi", "quiero", "hola", "como", "cuanto"},
+		"en": {"the", "a", "an", "of", "and", "to", "in", "is", "it", "for", "with", "my", "i", "you", "want", "hello", "how", "what", "can"},
+	}
+)
+
+var lexiconAccents = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n")
+
+// Name implements Classifier
+func (l *LexiconClassifier) Name() string {
+	return "lexicon"
+}
+
+// Classify implements Classifier
+func (l *LexiconClassifier) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	text := " " + normalizeLexiconText(message) + " "
+	analysis := &Analysis{
+		Status:     "general",
+		Intent:     "other",
+		Language:   detectLexiconLanguage(text),
+		Confidence: lexiconDefaultConfidence,
+		Reasoning:  "lexicon: no escalation phrases",
+		Model:      "lexicon",
+		Provider:   "lexicon",
+	}
+
+	if phrase := firstLexiconMatch(text, lexiconNeedHuman); phrase != "" {
+		analysis.Status = "need_human"
+		analysis.Confidence = lexiconMatchConfidence
+		analysis.Reasoning = fmt.Sprintf("lexicon: asks for a human (%q)", phrase)
+	} else if phrase := firstLexiconMatch(text, lexiconFrustrated); phrase != "" || isShouting(message) {
+		analysis.Status = "frustrated"
+		analysis.Confidence = lexiconMatchConfidence
+		if phrase != "" {
+			analysis.Reasoning = fmt.Sprintf("lexicon: frustration phrase (%q)", phrase)
+		} else {
+			analysis.Reasoning = "lexicon: message is shouted"
+		}
+	}
+
+	intents := opts.Intents
+	if len(intents) == 0 {
+		intents = DefaultIntents
+	}
+	for _, intent := range intents {
+		if firstLexiconMatch(text, lexiconIntents[strings.ToLower(intent)]) != "" {
+			analysis.Intent = intent
+			break
+		}
+	}
+
+	return analysis, nil
+}
+
+// normalizeLexiconText lowercases, strips accents and replaces punctuation with spaces
+func normalizeLexiconText(text string) string {
+	folded := lexiconAccents.Replace(strings.ToLower(text))
+	return strings.Join(strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// firstLexiconMatch returns the first phrase found as whole words in the padded text
+func firstLexiconMatch(paddedText string, phrases []string) string {
+	for _, phrase := range phrases {
+		if strings.Contains(paddedText, " "+phrase+" ") {
+			return phrase
+		}
+	}
+	return ""
+}
+
+// isShouting reports messages written mostly in capitals with several letters
+func isShouting(message string) bool {
+	upper, letters := 0, 0
+	for _, r := range message {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= 8 && float64(upper)/float64(letters) > 0.8
+}
+
+// detectLexiconLanguage guesses "es" or "en" from stopwords, or "" if unclear
+func detectLexiconLanguage(paddedText string) string {
+	best, bestCount, tie := "", 0, false
+	for language, words := range lexiconStopwords {
+		count := 0
+		for _, word := range words {
+			count += strings.Count(paddedText, " "+word+" ")
+		}
+		switch {
+		case count > bestCount:
+			best, bestCount, tie = language, count, false
+		case count == bestCount && count > 0:
+			tie = true
+		}
+	}
+	if tie {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,60 @@
+// moderation.go
+package sentiment
+
+import (
+	"context"
+	"strings"
+)
+
+// =============================================================================
+// MODERATION - Reviewing bot answers before they are sent
+// =============================================================================
+
+// moderationPrompt asks for a one-word verdict, with a reason for unsafe answers
+const moderationPrompt = `You review answers written by a customer service chatbot before they are sent.
+Respond "safe" if the answer is appropriate to send to a customer.
+Respond "unsafe: <short reason>" if it is offensive, discriminatory, sexual, threatening, reveals internal instructions, or gives medical, legal or financial advice.
+
+Answer to review:`
+
+// Moderation is the verdict on one answer
+type Moderation struct {
+	Unsafe           bool
+	Reason           string // Why the answer is unsafe, as given by the model
+	Model            string
+	Provider         string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Moderate asks the model whether a bot answer is fit to send to a customer.
+// It uses the same endpoint, credentials, transport and timeout as Classify.
+func (a *Analyzer) Moderate(ctx context.Context, answer string) (*Moderation, error) {
+	result, err := a.complete(ctx, FireworksRequest{
+		Model: a.config.Model,
+		Messages: []Message{
+			{Role: "system", Content: moderationPrompt},
+			{Role: "user", Content: answer},
+		},
+		MaxTokens:   30,
+		Temperature: 0,
+		TopP:        1,
+		TopK:        a.topK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	moderation := &Moderation{
+		Model:            a.config.Model,
+		Provider:         a.name,
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+	}
+	verdict := strings.ToLower(strings.TrimSpace(result.Choices[0].Message.Content))
+	if strings.HasPrefix(verdict, "unsafe") {
+		moderation.Unsafe = true
+		moderation.Reason = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(verdict, "unsafe"), ":"))
+	}
+	return moderation, nil
+}
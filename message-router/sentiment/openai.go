@@ -0,0 +1,39 @@
+// openai.go
+package sentiment
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAIConfig configures a classifier for any OpenAI-compatible chat
+// completions API (OpenAI, Azure OpenAI, vLLM, Ollama, OpenRouter, ...)
+type OpenAIConfig struct {
+	BaseURL   string // e.g. "https://api.openai.com/v1"; "/chat/completions" is appended
+	APIKey    string
+	Model     string
+	Timeout   time.Duration
+	Transport http.RoundTripper // Optional; nil uses http.DefaultTransport
+}
+
+// NewOpenAICompatible creates an Analyzer for an OpenAI-compatible endpoint.
+// Fireworks-only parameters such as top_k are not sent.
+func NewOpenAICompatible(config OpenAIConfig) *Analyzer {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultConfig().Timeout
+	}
+	return &Analyzer{
+		config: Config{
+			Model:   config.Model,
+			Timeout: config.Timeout,
+		},
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: config.Transport,
+		},
+		name:     "openai",
+		endpoint: strings.TrimRight(config.BaseURL, "/") + "/chat/completions",
+		apiKey:   config.APIKey,
+	}
+}
@@ -8,57 +8,170 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
+
+	"logging"
 )
 
 type Analysis struct {
-	Status     string  `json:"status"`      // "general", "need_human", or "frustrated"
-	Confidence float64 `json:"confidence"`  // 0.0 to 1.0
-	TokensUsed int     `json:"tokens_used"` // Total tokens used in request + response
+	Status           string  `json:"status"`            // "general", "need_human", or "frustrated"
+	Intent           string  `json:"intent"`            // One of the configured intents, or "other"
+	Language         string  `json:"language"`          // ISO 639-1 code of the message language, e.g. "es"
+	Reasoning        string  `json:"reasoning"`         // Short explanation from the model
+	Confidence       float64 `json:"confidence"`        // 0.0 to 1.0, from the status token logprobs (0 if unavailable)
+	TokensUsed       int     `json:"tokens_used"`       // Total tokens used in request + response
+	PromptTokens     int     `json:"prompt_tokens"`     // Tokens in the request (for cost accounting)
+	CompletionTokens int     `json:"completion_tokens"` // Tokens in the response (for cost accounting)
+	Model            string  `json:"model"`             // Model that produced the analysis
+	Provider         string  `json:"provider"`          // Classifier that produced the analysis, e.g. "fireworks" or "lexicon"
+	Cached           bool    `json:"cached,omitempty"`  // Served from the cache (token counts are 0, nothing was billed)
+}
+
+// DefaultIntents are the intent categories used when Options.Intents is empty
+var DefaultIntents = []string{"purchase", "support", "complaint", "pricing", "greeting", "spam"}
+
+// DefaultPrompt explains the status classification. The JSON response format,
+// including the intent categories, is always appended to it.
+const DefaultPrompt = `You're a sentiment analysis agent for a customer service chatbot.
+
+Status must be one of:
+
+"general" - for normal questions, requests, complaints, or any messages that don't EXPLICITLY ask for human help
+
+"need_human" - ONLY if they EXPLICITLY and DIRECTLY ask to speak to a human, agent, representative, or person. Examples: "I want to talk to a human", "Can I speak to a person?", "Transfer me to an agent", "I need human help"
+
+"frustrated" - ONLY if they explicitly express anger, frustration, or complaints
+
+IMPORTANT: Be very conservative with "need_human". Most complaints, problems, or even expressions of frustration should be "general" or "frustrated", NOT "need_human" unless they specifically ask to talk to a person.
+
+Most of the time, the message will be "general". If you are not sure, use "general".`
+
+// Options customizes one analysis, e.g. with a page's own prompt and intents
+type Options struct {
+	Prompt  string   // Replaces DefaultPrompt when set
+	Intents []string // Replaces DefaultIntents when set
+	History []Turn   // Earlier turns of the conversation, oldest first (context only)
+}
+
+// Turn is one earlier message of the conversation
+type Turn struct {
+	Role    string // "user", "bot" or "human" (a human agent)
+	Content string
 }
 
+// formatHistory renders earlier turns for the prompt, or "" without history
+func formatHistory(history []Turn) string {
+	if len(history) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nRecent conversation, oldest first. Use it only as context to understand the latest message - classify the latest message, not the earlier ones:\n")
+	for _, turn := range history {
+		content := strings.Join(strings.Fields(turn.Content), " ")
+		if runes := []rune(content); len(runes) > 300 {
+			content = string(runes[:300]) + "..."
+		}
+		fmt.Fprintf(&b, "[%s] %s\n", turn.Role, content)
+	}
+	return b.String()
+}
+
+// DefaultModel is the Fireworks model used when Config.Model is empty
+const DefaultModel = "accounts/fireworks/models/llama4-maverick-instruct-basic"
+
 type Config struct {
 	FireworksKey string
+	Model        string
 	Timeout      time.Duration
+	Transport    http.RoundTripper // Optional; nil uses http.DefaultTransport
+	Endpoint     string            // Optional; defaults to FireworksURL
 }
 
 // DefaultConfig returns a default configuration
 func DefaultConfig() Config {
 	return Config{
+		Model:   DefaultModel,
 		Timeout: 15 * time.Second,
 	}
 }
 
+// FireworksURL is the chat completions endpoint used by New
+const FireworksURL = "https://api.fireworks.ai/inference/v1/chat/completions"
+
+// Analyzer classifies messages with an LLM behind an OpenAI-style chat
+// completions API. New creates the Fireworks classifier, NewOpenAICompatible
+// one for any other compatible endpoint.
 type Analyzer struct {
-	config Config
-	client *http.Client
+	config   Config
+	client   *http.Client
+	name     string // Provider name reported in Analysis.Provider
+	endpoint string // Chat completions URL
+	apiKey   string //
+	topK     int    // Fireworks-only sampling parameter, omitted when 0
 }
 
 func New(config Config) *Analyzer {
+	if config.Model == "" {
+		config.Model = DefaultModel
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = FireworksURL
+	}
 	return &Analyzer{
 		config: config,
 		client: &http.Client{
-			Timeout: config.Timeout,
+			Timeout:   config.Timeout,
+			Transport: config.Transport,
 		},
+		name:     "fireworks",
+		endpoint: endpoint,
+		apiKey:   config.FireworksKey,
+		topK:     40,
 	}
 }
 
+// Name implements Classifier
+func (a *Analyzer) Name() string {
+	return a.name
+}
+
+// Classify implements Classifier
+func (a *Analyzer) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	return a.AnalyzeWithOptions(ctx, message, opts)
+}
+
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
 type FireworksRequest struct {
-	Model            string    `json:"model"`
-	Messages         []Message `json:"messages"`
-	MaxTokens        int       `json:"max_tokens"`
-	Temperature      float64   `json:"temperature"`
-	TopP             float64   `json:"top_p"`
-	TopK             int       `json:"top_k"`
-	PresencePenalty  float64   `json:"presence_penalty"`
-	FrequencyPenalty float64   `json:"frequency_penalty"`
+	Model            string          `json:"model"`
+	Messages         []Message       `json:"messages"`
+	MaxTokens        int             `json:"max_tokens"`
+	Temperature      float64         `json:"temperature"`
+	TopP             float64         `json:"top_p"`
+	TopK             int             `json:"top_k,omitempty"`
+	PresencePenalty  float64         `json:"presence_penalty"`
+	FrequencyPenalty float64         `json:"frequency_penalty"`
+	Logprobs         bool            `json:"logprobs,omitempty"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat asks the model for a specific output format, e.g. {"type": "json_object"}
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// TokenLogprob is the log probability of one generated token
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
 }
 
 type FireworksResponse struct {
@@ -66,6 +179,9 @@ type FireworksResponse struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
+		Logprobs *struct {
+			Content []TokenLogprob `json:"content"`
+		} `json:"logprobs"`
 	} `json:"choices"`
 	Usage struct {
 		PromptTokens     int `json:"prompt_tokens"`
@@ -74,27 +190,36 @@ type FireworksResponse struct {
 	} `json:"usage"`
 }
 
-// Analyze performs sentiment analysis on a message
+// Analyze performs sentiment analysis on a message with the default prompt and intents
 func (a *Analyzer) Analyze(ctx context.Context, message string) (*Analysis, error) {
-	systemPrompt := `You're a sentiment analysis agent. Respond with exactly one word:
-
-"general" - for normal questions, requests, complaints, or any messages that don't EXPLICITLY ask for human help
+	return a.AnalyzeWithOptions(ctx, message, Options{})
+}
 
-"need_human" - ONLY if they EXPLICITLY and DIRECTLY ask to speak to a human, agent, representative, or person. Examples: "I want to talk to a human", "Can I speak to a person?", "Transfer me to an agent", "I need human help"
+// AnalyzeWithOptions performs sentiment, intent and language analysis on a message
+func (a *Analyzer) AnalyzeWithOptions(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	prompt := opts.Prompt
+	if prompt == "" {
+		prompt = DefaultPrompt
+	}
+	intents := opts.Intents
+	if len(intents) == 0 {
+		intents = DefaultIntents
+	}
 
-"frustrated" - ONLY if they explicitly express anger, frustration, or complaints
+	systemPrompt := prompt + `
 
-IMPORTANT: Be very conservative with "need_human". Most complaints, problems, or even expressions of frustration should be "general" or "frustrated", NOT "need_human" unless they specifically ask to talk to a person.
+Also classify the intent of the message as exactly one of: ` + strings.Join(intents, ", ") + `.
 
-Most of the time, the message will be "general". If you are not sure, respond with "general".
+Respond ONLY with a JSON object in this format:
+{"status": "general", "intent": "` + intents[0] + `", "language": "es", "reasoning": "one short sentence"}
 
-Remember, only respond with one of these three words: general, need_human, frustrated
+"language" is the ISO 639-1 code of the language the message is written in.` + formatHistory(opts.History) + `
 
 Message to analyse:`
 
 	// Prepare the request
 	req := FireworksRequest{
-		Model: "accounts/fireworks/models/llama4-maverick-instruct-basic",
+		Model: a.config.Model,
 		Messages: []Message{
 			{
 				Role:    "system",
@@ -105,30 +230,59 @@ Message to analyse:`
 				Content: message,
 			},
 		},
-		MaxTokens:        10,  // We only need a single word
+		MaxTokens:        150, // Enough for the JSON object and a short reasoning
 		Temperature:      0.1, // Low temperature for consistency
 		TopP:             1,
-		TopK:             40,
+		TopK:             a.topK,
 		PresencePenalty:  0,
 		FrequencyPenalty: 0,
+		Logprobs:         true, // Used to derive the confidence of the status
+		ResponseFormat:   &ResponseFormat{Type: "json_object"},
 	}
 
+	result, err := a.complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	content := strings.TrimSpace(result.Choices[0].Message.Content)
+	log.Printf("Raw LLM response: %s (Tokens used - Prompt: %d, Completion: %d, Total: %d)",
+		logging.Text(content), result.Usage.PromptTokens, result.Usage.CompletionTokens, result.Usage.TotalTokens)
+
+	var logprobs []TokenLogprob
+	if result.Choices[0].Logprobs != nil {
+		logprobs = result.Choices[0].Logprobs.Content
+	}
+
+	analysis := parseAnalysis(result.Choices[0].Message.Content, logprobs, intents)
+	analysis.TokensUsed = result.Usage.TotalTokens
+	analysis.PromptTokens = result.Usage.PromptTokens
+	analysis.CompletionTokens = result.Usage.CompletionTokens
+	analysis.Model = a.config.Model
+	analysis.Provider = a.name
+
+	log.Printf("Normalized analysis: status=%s intent=%s language=%s confidence=%.2f",
+		analysis.Status, analysis.Intent, analysis.Language, analysis.Confidence)
+	return analysis, nil
+}
+
+// complete sends a chat completions request and returns the parsed response,
+// with an error for transport failures, non-200 statuses and empty choices
+func (a *Analyzer) complete(ctx context.Context, req FireworksRequest) (*FireworksResponse, error) {
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling request: %v", err)
 	}
 
 	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST",
-		"https://api.fireworks.ai/inference/v1/chat/completions",
-		bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.endpoint, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
 	httpReq.Header.Set("Accept", "application/json")
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+a.config.FireworksKey)
+	httpReq.Header.Set("Authorization", "Bearer "+a.apiKey)
 
 	// Send request
 	resp, err := a.client.Do(httpReq)
@@ -157,37 +311,98 @@ Message to analyse:`
 		return nil, fmt.Errorf("no choices in response: %s", string(respBody))
 	}
 
-	// Clean and validate the response
-	rawStatus := strings.TrimSpace(result.Choices[0].Message.Content)
-	log.Printf("Raw LLM response: %q (Tokens used - Prompt: %d, Completion: %d, Total: %d)",
-		rawStatus, result.Usage.PromptTokens, result.Usage.CompletionTokens, result.Usage.TotalTokens)
+	return &result, nil
+}
+
+// statusValuePattern locates the status value in the model's JSON output
+var statusValuePattern = regexp.MustCompile(`"status"\s*:\s*"([^"]*)"`)
+
+// parseAnalysis turns the model output into an Analysis. Output that isn't the
+// requested JSON is treated as a bare status word, the format older prompts used.
+func parseAnalysis(content string, logprobs []TokenLogprob, intents []string) *Analysis {
+	var raw struct {
+		Status    string `json:"status"`
+		Intent    string `json:"intent"`
+		Language  string `json:"language"`
+		Reasoning string `json:"reasoning"`
+	}
+
+	jsonStart, jsonEnd := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if jsonStart < 0 || jsonEnd < jsonStart || json.Unmarshal([]byte(content[jsonStart:jsonEnd+1]), &raw) != nil {
+		return &Analysis{
+			Status:     normalizeStatus(content),
+			Intent:     "other",
+			Confidence: spanConfidence(logprobs, 0, len(content)),
+		}
+	}
+
+	analysis := &Analysis{
+		Status:    normalizeStatus(raw.Status),
+		Intent:    normalizeIntent(raw.Intent, intents),
+		Language:  strings.ToLower(strings.TrimSpace(raw.Language)),
+		Reasoning: strings.TrimSpace(raw.Reasoning),
+	}
+	if match := statusValuePattern.FindStringSubmatchIndex(content); match != nil {
+		analysis.Confidence = spanConfidence(logprobs, match[2], match[3])
+	}
+	return analysis
+}
 
+// spanConfidence is the joint probability of the tokens that produced the
+// characters [start, end) of the output. It returns 0 without logprobs.
+func spanConfidence(logprobs []TokenLogprob, start, end int) float64 {
+	if end <= start {
+		return 0
+	}
+	pos, sum, found := 0, 0.0, false
+	for _, token := range logprobs {
+		tokenEnd := pos + len(token.Token)
+		if tokenEnd > start && pos < end {
+			sum += token.Logprob
+			found = true
+		}
+		if tokenEnd >= end {
+			break
+		}
+		pos = tokenEnd
+	}
+	if !found {
+		return 0
+	}
+	return math.Exp(sum)
+}
+
+// normalizeStatus maps the model's wording to general, need_human or frustrated
+func normalizeStatus(rawStatus string) string {
 	// Normalize the status - convert to lowercase and clean up
-	status := strings.ToLower(rawStatus)
+	status := strings.ToLower(strings.TrimSpace(rawStatus))
 	status = strings.ReplaceAll(status, "\"", "") // Remove quotes
 	status = strings.ReplaceAll(status, "'", "")  // Remove single quotes
 	status = strings.ReplaceAll(status, ".", "")  // Remove periods
 	status = strings.Split(status, " ")[0]        // Take only the first word
 	status = strings.Split(status, "\n")[0]       // Take only the first line
 
-	log.Printf("Normalized status: %q", status)
-
 	// Map variations to standard values (all lowercase now)
 	switch status {
 	case "general", "normal", "neutral", "regular":
-		status = "general"
+		return "general"
 	case "need_human", "needhuman", "human", "agent", "need-human", "need_human_help":
-		status = "need_human"
+		return "need_human"
 	case "frustrated", "angry", "upset", "mad", "annoyed", "irritated":
-		status = "frustrated"
+		return "frustrated"
 	default:
-		log.Printf("⚠️ Unexpected status received: %q (raw: %q), defaulting to 'general'", status, rawStatus)
-		status = "general" // Default to general instead of erroring out
+		log.Printf("⚠️ Unexpected status received: %s (raw: %s), defaulting to 'general'", logging.Text(status), logging.Text(rawStatus))
+		return "general" // Default to general instead of erroring out
 	}
+}
 
-	return &Analysis{
-		Status:     status,
-		Confidence: 0.95, // Fixed confidence since we use very low temperature
-		TokensUsed: result.Usage.TotalTokens,
-	}, nil
+// normalizeIntent returns the configured intent the model chose, or "other"
+func normalizeIntent(rawIntent string, intents []string) string {
+	intent := strings.ToLower(strings.TrimSpace(rawIntent))
+	for _, candidate := range intents {
+		if intent == strings.ToLower(candidate) {
+			return candidate
+		}
+	}
+	return "other"
 }
@@ -3,6 +3,10 @@ package sentiment
 
 import (
 	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -555,3 +559,249 @@ func TestNeedHumanCategorization(t *testing.T) {
 		t.Errorf("Accuracy %.2f%% is below acceptable threshold of 85%%", accuracy)
 	}
 }
+
+// TestParseAnalysis checks JSON parsing and logprob confidence without calling the API
+func TestParseAnalysis(t *testing.T) {
+	content := `{"status": "need_human", "intent": "Support", "language": "ES", "reasoning": "Asks for a person."}`
+	logprobs := []TokenLogprob{
+		{Token: `{"status": "`, Logprob: 0},
+		{Token: "need", Logprob: math.Log(0.9)},
+		{Token: "_human", Logprob: math.Log(0.8)},
+		{Token: `", "intent": "Support", "language": "ES", "reasoning": "Asks for a person."}`, Logprob: -0.1},
+	}
+
+	analysis := parseAnalysis(content, logprobs, DefaultIntents)
+	if analysis.Status != "need_human" || analysis.Intent != "support" || analysis.Language != "es" {
+		t.Errorf("parseAnalysis() = %+v", analysis)
+	}
+	if math.Abs(analysis.Confidence-0.72) > 0.001 {
+		t.Errorf("parseAnalysis() confidence = %.3f, want 0.72", analysis.Confidence)
+	}
+
+	// Legacy one-word output and unknown intents still parse
+	analysis = parseAnalysis("Frustrated.", nil, DefaultIntents)
+	if analysis.Status != "frustrated" || analysis.Intent != "other" || analysis.Confidence != 0 {
+		t.Errorf("parseAnalysis() legacy = %+v", analysis)
+	}
+	if intent := normalizeIntent("refund", []string{"refund", "support"}); intent != "refund" {
+		t.Errorf("normalizeIntent() = %q, want refund", intent)
+	}
+}
+
+// TestLexiconClassifier checks the offline fallback classifier
+func TestLexiconClassifier(t *testing.T) {
+	classifier := NewLexicon()
+
+	tests := []struct {
+		message  string
+		status   string
+		intent   string
+		language string
+	}{
+		{"Necesito hablar con una persona por favor", "need_human", "other", "es"},
+		{"Otra vez lo mismo, no me estás entendiendo", "frustrated", "other", "es"},
+		{"¿Cuánto cuesta la suscripción?", "general", "pricing", "es"},
+		{"Hola, buenos días", "general", "greeting", "es"},
+		{"I want to talk to a human", "need_human", "other", "en"},
+		{"THIS IS NOT WHAT I ASKED FOR", "frustrated", "other", "en"},
+	}
+
+	for _, tt := range tests {
+		analysis, err := classifier.Classify(context.Background(), tt.message, Options{})
+		if err != nil {
+			t.Fatalf("Classify(%q) error = %v", tt.message, err)
+		}
+		if analysis.Status != tt.status || analysis.Intent != tt.intent || analysis.Language != tt.language {
+			t.Errorf("Classify(%q) = %s/%s/%s, want %s/%s/%s", tt.message,
+				analysis.Status, analysis.Intent, analysis.Language, tt.status, tt.intent, tt.language)
+		}
+	}
+}
+
+// slowClassifier blocks until its context is cancelled
+type slowClassifier struct{}
+
+func (slowClassifier) Name() string { return "slow" }
+
+func (slowClassifier) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// TestChainFallsBack checks that a slow primary times out and the fallback answers
+func TestChainFallsBack(t *testing.T) {
+	chain := NewChain(50*time.Millisecond, slowClassifier{}, NewLexicon())
+
+	start := time.Now()
+	analysis, err := chain.Classify(context.Background(), "Quiero hablar con un agente", Options{})
+	if err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	if analysis.Provider != "lexicon" || analysis.Status != "need_human" {
+		t.Errorf("Classify() = %s from %s, want need_human from lexicon", analysis.Status, analysis.Provider)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Classify() took %v, the primary timeout was not applied", elapsed)
+	}
+}
+
+// TestRunEval scores the lexicon classifier on the bundled dataset and checks
+// that recorded responses replay identically without the original classifier
+func TestRunEval(t *testing.T) {
+	cases, err := LoadEvalDataset(filepath.Join("testdata", "eval.jsonl"))
+	if err != nil {
+		t.Fatalf("LoadEvalDataset() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "lexicon.jsonl")
+	recorder, err := NewRecordingClassifier(NewLexicon(), path)
+	if err != nil {
+		t.Fatalf("NewRecordingClassifier() error = %v", err)
+	}
+	live := RunEval(context.Background(), "live", recorder, cases, EvalOptions{})
+	recorder.Close()
+
+	if live.Cases != len(cases) || live.Errors != 0 {
+		t.Fatalf("RunEval() cases=%d errors=%d, want %d/0", live.Cases, live.Errors, len(cases))
+	}
+	if live.StatusAccuracy < 0.8 {
+		t.Errorf("lexicon status accuracy = %.2f, want >= 0.8", live.StatusAccuracy)
+	}
+
+	replay, err := NewReplayClassifier(path)
+	if err != nil {
+		t.Fatalf("NewReplayClassifier() error = %v", err)
+	}
+	replayed := RunEval(context.Background(), "replay", replay, cases, EvalOptions{})
+	if replayed.Errors != 0 || replayed.StatusAccuracy != live.StatusAccuracy || replayed.IntentAccuracy != live.IntentAccuracy {
+		t.Errorf("replay = %.2f/%.2f (%d errors), want %.2f/%.2f", replayed.StatusAccuracy,
+			replayed.IntentAccuracy, replayed.Errors, live.StatusAccuracy, live.IntentAccuracy)
+	}
+}
+
+// TestScorePairs checks precision and recall on a small confusion matrix
+func TestScorePairs(t *testing.T) {
+	confusion, accuracy, classes := scorePairs([][2]string{
+		{"general", "general"}, {"general", "frustrated"}, {"frustrated", "frustrated"}, {"need_human", "general"},
+	})
+	if confusion["general"]["frustrated"] != 1 || accuracy != 0.5 {
+		t.Fatalf("scorePairs() confusion=%v accuracy=%.2f", confusion, accuracy)
+	}
+	for _, class := range classes {
+		if class.Label == "frustrated" && (class.Precision != 0.5 || class.Recall != 1) {
+			t.Errorf("frustrated precision/recall = %.2f/%.2f, want 0.50/1.00", class.Precision, class.Recall)
+		}
+	}
+}
+
+// countingClassifier answers "general" from a fake paid provider and counts calls
+type countingClassifier struct{ calls int }
+
+func (c *countingClassifier) Name() string { return "counting" }
+
+func (c *countingClassifier) Classify(ctx context.Context, message string, opts Options) (*Analysis, error) {
+	c.calls++
+	return &Analysis{Status: "general", Intent: "greeting", Provider: "fireworks", PromptTokens: 100, CompletionTokens: 20, TokensUsed: 120}, nil
+}
+
+// TestCachedClassifier checks normalized hits, zeroed token counts, the length
+// limit, LRU eviction, prompt-sensitive keys, keys on the last turn and Purge
+func TestCachedClassifier(t *testing.T) {
+	inner := &countingClassifier{}
+	cache := NewCachedClassifier(inner, CacheConfig{Size: 2, TTL: time.Hour, MaxMessageLength: 20}, nil)
+	ctx := context.Background()
+
+	first, _ := cache.Classify(ctx, "Hola", Options{})
+	second, _ := cache.Classify(ctx, "¡hola!", Options{})
+	if inner.calls != 1 {
+		t.Fatalf("inner called %d times, want 1 for equivalent messages", inner.calls)
+	}
+	if first.Cached || first.PromptTokens != 100 {
+		t.Errorf("first call = %+v, want an uncached billed analysis", first)
+	}
+	if !second.Cached || second.TokensUsed != 0 || second.PromptTokens != 0 {
+		t.Errorf("second call = %+v, want a cached analysis without tokens", second)
+	}
+
+	cache.Classify(ctx, "hola", Options{Prompt: "another prompt"})
+	if inner.calls != 2 {
+		t.Errorf("a different prompt reused the cached answer")
+	}
+
+	cache.Classify(ctx, "este mensaje es demasiado largo para el cache", Options{})
+	cache.Classify(ctx, "este mensaje es demasiado largo para el cache", Options{})
+	if inner.calls != 4 {
+		t.Errorf("long messages were cached (inner calls = %d, want 4)", inner.calls)
+	}
+
+	history := Options{History: []Turn{{Role: "bot", Content: "¿Quieres que te llame un agente?"}}}
+	if analysis, _ := cache.Classify(ctx, "¡hola!", history); analysis.Cached || inner.calls != 5 {
+		t.Errorf("a message with history reused the answer without history (inner calls = %d, want 5)", inner.calls)
+	}
+	if analysis, _ := cache.Classify(ctx, "hola", history); !analysis.Cached || inner.calls != 5 {
+		t.Errorf("a message after the same last turn was not served from the cache (inner calls = %d, want 5)", inner.calls)
+	}
+	other := Options{History: []Turn{{Role: "bot", Content: "¿Algo más?"}}}
+	if analysis, _ := cache.Classify(ctx, "hola", other); analysis.Cached || inner.calls != 6 {
+		t.Errorf("a message after another last turn was served from the cache (inner calls = %d, want 6)", inner.calls)
+	}
+
+	cache.Classify(ctx, "gracias", Options{}) // Evicts the oldest entry
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 5 || stats.Skipped != 2 || stats.Evictions != 3 || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	if _, err := cache.Purge(ctx); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	cache.Classify(ctx, "gracias", Options{})
+	if inner.calls != 8 {
+		t.Errorf("Purge() kept entries (inner calls = %d, want 8)", inner.calls)
+	}
+	// The counters are cumulative, the purge is counted on its own
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 6 || stats.Purges != 1 || stats.Entries != 1 {
+		t.Errorf("Stats() after Purge() = %+v", stats)
+	}
+}
+
+// TestModerate checks the verdict parsing and that moderation goes to the
+// configured endpoint with the configured model
+func TestModerate(t *testing.T) {
+	var verdict string
+	var gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req FireworksRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotModel = req.Model
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": verdict}}},
+			"usage":   map[string]int{"prompt_tokens": 80, "completion_tokens": 4},
+		})
+	}))
+	defer server.Close()
+
+	moderator := New(Config{FireworksKey: "test", Model: "moderation-model", Endpoint: server.URL, Timeout: time.Second})
+	tests := []struct {
+		verdict string
+		unsafe  bool
+		reason  string
+	}{
+		{"safe", false, ""},
+		{"Unsafe: insults the customer", true, "insults the customer"},
+		{"unsafe", true, ""},
+	}
+	for _, tt := range tests {
+		verdict = tt.verdict
+		moderation, err := moderator.Moderate(context.Background(), "Gracias por escribirnos")
+		if err != nil {
+			t.Fatalf("Moderate() with verdict %q error = %v", tt.verdict, err)
+		}
+		if moderation.Unsafe != tt.unsafe || moderation.Reason != tt.reason {
+			t.Errorf("Moderate() with verdict %q = %+v, want unsafe=%v reason=%q", tt.verdict, moderation, tt.unsafe, tt.reason)
+		}
+		if moderation.PromptTokens != 80 || moderation.Model != "moderation-model" || gotModel != "moderation-model" {
+			t.Errorf("Moderate() = %+v (request model %q), want the configured model and token counts", moderation, gotModel)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// sentiment_cache.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"message-router/sentiment"
+)
+
+// =============================================================================
+// SENTIMENT CACHE - Postgres persistence and stats/invalidation endpoint
+// =============================================================================
+
+// postgresCacheStore keeps cached analyses in sentiment_cache so they survive
+// restarts and are shared between instances
+type postgresCacheStore struct {
+	db *sql.DB
+}
+
+// Get implements sentiment.CacheStore
+func (s *postgresCacheStore) Get(ctx context.Context, key string) (*sentiment.Analysis, bool, error) {
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT analysis FROM sentiment_cache
+		WHERE cache_key = $1 AND expires_at > NOW()
+	`, key).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading sentiment cache: %v", err)
+	}
+
+	var analysis sentiment.Analysis
+	if err := json.Unmarshal(raw, &analysis); err != nil {
+		return nil, false, fmt.Errorf("error decoding cached analysis: %v", err)
+	}
+	return &analysis, true, nil
+}
+
+// Set implements sentiment.CacheStore
+func (s *postgresCacheStore) Set(ctx context.Context, key string, analysis *sentiment.Analysis, ttl time.Duration) error {
+	raw, err := json.Marshal(analysis)
+	if err != nil {
+		return fmt.Errorf("error encoding analysis: %v", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO sentiment_cache (cache_key, analysis, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		ON CONFLICT (cache_key)
+		DO UPDATE SET analysis = EXCLUDED.analysis, expires_at = EXCLUDED.expires_at
+	`, key, raw, ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("error writing sentiment cache: %v", err)
+	}
+	return nil
+}
+
+// Purge implements sentiment.CacheStore
+func (s *postgresCacheStore) Purge(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sentiment_cache`)
+	if err != nil {
+		return 0, fmt.Errorf("error purging sentiment cache: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// pruneExpired removes expired rows; called once at startup
+func (s *postgresCacheStore) pruneExpired(ctx context.Context) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sentiment_cache WHERE expires_at <= NOW()`)
+	if err != nil {
+		LogWarn("Could not prune sentiment cache: %v", err)
+		return
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		LogInfo("🧹 Pruned %d expired sentiment cache entries", removed)
+	}
+}
+
+// handleSentimentCache returns the cache counters (GET) or drops every cached
+// analysis (DELETE), e.g. after editing the prompt or switching models
+func handleSentimentCache(w http.ResponseWriter, r *http.Request) {
+	if sentimentCache == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"enabled": false})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		stats := sentimentCache.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":    true,
+			"persistent": sentimentCache.Store != nil,
+			"hit_ratio":  stats.HitRatio(),
+			"stats":      stats,
+		})
+	case http.MethodDelete:
+		removed, err := sentimentCache.Purge(r.Context())
+		if err != nil {
+			LogError("Error purging sentiment cache: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		LogInfo("🧹 Sentiment cache purged (%d stored entries removed)", removed)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"purged": true, "stored_entries_removed": removed})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
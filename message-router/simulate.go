@@ -0,0 +1,224 @@
+// simulate.go
+//
+// `message-router simulate` replays recorded webhook payloads, or generates one
+// for a page, sender and text, signs them with the app secret and either posts
+// them to a running router or runs them in-process against fake Graph, Dify and
+// Fireworks servers. In-process runs print what the router decided for every
+// messaging event: echo classification, sentiment, route and outcome, the bot
+// state of the conversation and the messages sent through the Send API.
+// The in-process harness and its fakes live in simulate_inprocess.go and are
+// only compiled with -tags simulate; -target and -print work in any build.
+//
+//	go run -tags simulate . simulate -text "Hola, ¿a qué hora abren?"
+//	go run -tags simulate . simulate -echo -text "Hola, soy Marta"              # human agent reply from the page inbox
+//	go run -tags simulate . simulate -echo -app-id 1195277397801905 -text "..."  # echo of a bot reply
+//	go run -tags simulate . simulate testdata/webhooks/facebook_human_echo.json
+//	go run . simulate -target http://localhost:8080/webhook recorded.json
+//	go run -tags simulate . simulate -text "Hola" -print > testdata/webhooks/new_case.json
+//
+// A payload file holds one webhook body, or several in a row that are delivered
+// in order against the same state. Files in testdata/webhooks are regression
+// fixtures: TestWebhookFixtures compares their report with the .golden file
+// next to them (go test -tags simulate -run WebhookFixtures -update rewrites it).
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"logging"
+
+	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// =============================================================================
+// COMMAND LINE - go run . simulate [flags] [payload.json ...]
+// =============================================================================
+
+// runSimulate runs the simulate command and returns its exit status
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	object := fs.String("object", "page", "Webhook object of generated payloads: page (Messenger) or instagram")
+	pageID := fs.String("page", "100000000000001", "Page or Instagram account ID of generated payloads")
+	senderID := fs.String("sender", "200000000000002", "User ID of generated payloads")
+	text := fs.String("text", "", "Generate a payload with this message text")
+	echo := fs.Bool("echo", false, "Generate an echo: a message sent by the page to -sender")
+	appID := fs.Int64("app-id", 0, "app_id of a generated echo (the bot's app ID marks a bot echo)")
+	target := fs.String("target", "", "Webhook URL of a running router to post to instead of simulating in-process")
+	secret := fs.String("secret", "", "App secret to sign payloads with (default FACEBOOK_APP_SECRET)")
+	printOnly := fs.Bool("print", false, "Print the payloads and their signatures instead of delivering them")
+	sentimentAnswer := fs.String("sentiment", "", "Fake Fireworks answer, status or status/intent (default: the offline lexicon's)")
+	difyAnswer := fs.String("dify-answer", "Respuesta simulada de Dify.", "Answer of the fake Dify app")
+	comments := fs.Bool("comments", false, "Enable comment automation, as COMMENT_AUTOMATION_ENABLED=true")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: message-router simulate [flags] [payload.json ...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	_ = godotenv.Load() // FACEBOOK_APP_SECRET and the routing settings, as for the service
+	logConfig := logging.ConfigFromEnv("message-router")
+	if os.Getenv("LOG_LEVEL") == "" {
+		logConfig.Level = slog.LevelError // The database is always down here; LOG_LEVEL=INFO shows the pipeline
+	}
+	if logConfig.Format == "" {
+		logConfig.Format = "text"
+	}
+	logging.Setup(logConfig)
+
+	payloads, err := readPayloads(fs.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	if *text != "" {
+		payloads = append(payloads, generatePayload(*object, *pageID, *senderID, *text, *echo, *appID))
+	}
+	if len(payloads) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if *secret == "" {
+		*secret = getEnvOrDefault("FACEBOOK_APP_SECRET", "simulated-app-secret")
+	}
+
+	if *printOnly {
+		for _, payload := range payloads {
+			fmt.Fprintf(os.Stderr, "X-Hub-Signature-256: %s\n", signPayload(payload, *secret))
+			fmt.Println(string(payload))
+		}
+		return 0
+	}
+
+	if *target != "" {
+		return postPayloads(*target, *secret, payloads)
+	}
+
+	return runInProcess(simulationOptions{
+		Secret:     *secret,
+		DifyAnswer: *difyAnswer,
+		Sentiment:  *sentimentAnswer,
+		Comments:   *comments,
+	}, payloads)
+}
+
+// readPayloads reads the webhook bodies in each file ("-" is stdin)
+func readPayloads(paths ...string) ([][]byte, error) {
+	var payloads [][]byte
+	for _, path := range paths {
+		var input io.Reader = os.Stdin
+		if path != "-" {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+			input = file
+		}
+
+		decoder := json.NewDecoder(input)
+		for {
+			var payload json.RawMessage
+			err := decoder.Decode(&payload)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads, nil
+}
+
+// generatePayload builds a webhook with one text message from senderID to the
+// page, or with echo from the page to senderID
+func generatePayload(object, pageID, senderID, text string, echo bool, appID int64) []byte {
+	now := time.Now()
+	msg := MessagingEntry{Message: &MessageData{
+		Mid:    fmt.Sprintf("m_simulated_%d", now.UnixNano()),
+		Text:   text,
+		IsEcho: echo,
+		AppId:  appID,
+	}}
+	msg.Sender.ID, msg.Recipient.ID = senderID, pageID
+	if echo {
+		msg.Sender.ID, msg.Recipient.ID = pageID, senderID
+	}
+
+	payload, _ := json.Marshal(FacebookEvent{
+		Object: object,
+		Entry:  []EntryData{{ID: pageID, Time: now.UnixMilli(), Messaging: []MessagingEntry{msg}}},
+	})
+	return payload
+}
+
+// signPayload returns the X-Hub-Signature-256 header Facebook would send
+func signPayload(payload []byte, secret string) string {
+	return "sha256=" + generateFacebookSignature(payload, []byte(secret))
+}
+
+// postPayloads delivers the payloads to a running router. Its decisions are in
+// that router's logs (request_id) and traces (trace_id).
+func postPayloads(target, secret string, payloads [][]byte) int {
+	client := &http.Client{Timeout: 15 * time.Second}
+	status := 0
+	for i, payload := range payloads {
+		traceID, traceparent := newTraceparent()
+		requestID := "sim_" + traceID.String()[:16]
+
+		req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Hub-Signature-256", signPayload(payload, secret))
+		req.Header.Set("X-Request-ID", requestID)
+		req.Header.Set("traceparent", traceparent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Delivery %d: %v\n", i+1, err)
+			return 1
+		}
+		resp.Body.Close()
+
+		fmt.Printf("delivery %d → HTTP %d (request_id %s, trace_id %s)\n", i+1, resp.StatusCode, requestID, traceID)
+		if resp.StatusCode != http.StatusOK {
+			status = 1
+		}
+	}
+	return status
+}
+
+// newTraceparent returns a random trace ID and a W3C traceparent header that
+// starts a sampled trace with it
+func newTraceparent() (trace.TraceID, string) {
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	rand.Read(traceID[:])
+	rand.Read(spanID[:])
+	return traceID, fmt.Sprintf("00-%s-%s-01", traceID, spanID)
+}
+
+// simulationOptions configures the fake upstreams
+type simulationOptions struct {
+	Secret     string // App secret the payloads are signed with
+	DifyAnswer string // Answer of the fake Dify app
+	Sentiment  string // Fake Fireworks answer, "status" or "status/intent"; empty uses the lexicon's
+	Comments   bool   // Enable comment automation on top of the config
+}
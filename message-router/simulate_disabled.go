@@ -0,0 +1,17 @@
+//go:build !simulate
+
+// simulate_disabled.go
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runInProcess is only built with -tags simulate, which compiles the fake
+// upstreams in; production binaries can still post to a running router
+func runInProcess(opts simulationOptions, payloads [][]byte) int {
+	fmt.Fprintln(os.Stderr, "❌ In-process simulation is not built into this binary.")
+	fmt.Fprintln(os.Stderr, "   Use go run -tags simulate . simulate ..., or -target to post to a running router.")
+	return 2
+}
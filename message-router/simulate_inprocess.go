@@ -0,0 +1,594 @@
+//go:build simulate
+
+// simulate_inprocess.go
+//
+// The in-process simulator: the real router against a memory store and fake
+// Graph, Dify and Fireworks servers. It uses test
+// fakes (graphtest, tracetest), so it is only built with -tags simulate:
+//
+//	go run -tags simulate . simulate -text "Hola"
+//	go test -tags simulate -run WebhookFixtures .
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"graph/graphtest"
+	"message-router/sentiment"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// =============================================================================
+// IN-PROCESS SIMULATION - The real pipeline against fake upstreams
+// =============================================================================
+
+// Placeholders for the settings loadConfig requires; a simulation never
+// connects to the database or the real APIs
+var simulationPlaceholderEnv = []string{
+	"DATABASE_URL", "FACEBOOK_APP_ID", "VERIFY_TOKEN", "FIREWORKS_API_KEY",
+	"INSTAGRAM_APP_ID", "INSTAGRAM_APP_SECRET_KEY",
+}
+
+// runInProcess delivers the payloads to an in-process router and prints the
+// report of each delivery
+func runInProcess(opts simulationOptions, payloads [][]byte) int {
+	for _, key := range simulationPlaceholderEnv {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, "simulated")
+		}
+	}
+	os.Setenv("FACEBOOK_APP_SECRET", opts.Secret)
+	loadConfig()
+
+	sim, err := newSimulation(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer sim.Close()
+
+	for i, payload := range payloads {
+		delivery, err := sim.Run(payload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Delivery %d: %v\n", i+1, err)
+			return 1
+		}
+		delivery.Write(os.Stdout, i+1)
+	}
+	return 0
+}
+
+var (
+	simulationSpans     *tracetest.SpanRecorder
+	simulationSpansOnce sync.Once
+)
+
+// recordSimulationSpans installs a tracer provider that keeps every span in
+// memory; simulations read the routing decisions from them
+func recordSimulationSpans() *tracetest.SpanRecorder {
+	simulationSpansOnce.Do(func() {
+		simulationSpans = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(simulationSpans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return simulationSpans
+}
+
+// simulation runs webhooks through the router's handler with a memory store and
+// fake Graph, Dify and Fireworks servers. It replaces
+// the package globals until Close.
+type simulation struct {
+	opts      simulationOptions
+	spans     *tracetest.SpanRecorder
+	memory    *MemoryStore
+	graph     *graphtest.Server
+	dify      *httptest.Server
+	fireworks *httptest.Server
+	handler   http.Handler
+	restore   func()
+
+	mu    sync.Mutex
+	pages map[string]bool
+}
+
+// newSimulation installs the fakes on top of the current config
+func newSimulation(opts simulationOptions) (*simulation, error) {
+	oldConfig, oldGraphClient := config, graphClient
+	oldClassifier, oldModerator, oldBreakers := sentimentClassifier, answerModerator, difyBreakers
+
+	s := &simulation{
+		opts:   opts,
+		spans:  recordSimulationSpans(),
+		memory: NewMemoryStore(),
+		graph:  graphtest.NewServer(),
+		pages:  make(map[string]bool),
+	}
+	s.dify = httptest.NewServer(http.HandlerFunc(s.serveDify))
+	s.fireworks = httptest.NewServer(http.HandlerFunc(s.serveFireworks))
+
+	graphClient = s.graph.Client()
+	config.DifyBaseURL = s.dify.URL
+	config.FacebookAppSecret = opts.Secret
+	config.WebhookSecrets = []WebhookSecret{{Name: "facebook", Secret: opts.Secret}}
+	if opts.Comments {
+		config.Comments.Enabled = true
+	}
+	sentimentClassifier = sentiment.NewChain(5*time.Second, sentiment.New(sentiment.Config{
+		FireworksKey: "simulated",
+		Endpoint:     s.fireworks.URL + "/inference/v1/chat/completions",
+		Transport:    newTracingTransport(nil),
+	}))
+	answerModerator = sentiment.New(sentiment.Config{
+		FireworksKey: "simulated",
+		Endpoint:     s.fireworks.URL + "/inference/v1/chat/completions",
+		Transport:    newTracingTransport(nil),
+	})
+	breakerThreshold, breakerCooldown := config.DifyBreakerThreshold, config.DifyBreakerCooldown
+	if breakerThreshold <= 0 {
+		breakerThreshold, breakerCooldown = 5, time.Minute
+	}
+	difyBreakers = newCircuitBreakerRegistry(breakerThreshold, breakerCooldown)
+	s.handler = traceMiddleware(setupRouter(s.memory.Stores()))
+
+	s.restore = func() {
+		config, graphClient = oldConfig, oldGraphClient
+		sentimentClassifier, answerModerator, difyBreakers = oldClassifier, oldModerator, oldBreakers
+	}
+	return s, nil
+}
+
+// Close stops the fake servers and restores the globals
+func (s *simulation) Close() {
+	s.graph.Close()
+	s.dify.Close()
+	s.fireworks.Close()
+	s.restore()
+}
+
+// serveDify answers chat-messages with the configured answer
+func (s *simulation) serveDify(w http.ResponseWriter, r *http.Request) {
+	var req DifyRequest
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat-messages") ||
+		json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, `{"code":"not_found","message":"not found"}`, http.StatusNotFound)
+		return
+	}
+
+	conversationID := req.ConversationId
+	if conversationID == "" {
+		conversationID = "sim-" + req.User
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DifyResponse{
+		Answer:         s.opts.DifyAnswer,
+		ConversationId: conversationID,
+		MessageId:      "sim-message",
+	})
+}
+
+// serveFireworks answers chat completions like the sentiment model: with the
+// configured status and intent, or what the offline lexicon makes of the message
+func (s *simulation) serveFireworks(w http.ResponseWriter, r *http.Request) {
+	var req sentiment.FireworksRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Messages) == 0 {
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	message := req.Messages[len(req.Messages)-1].Content
+	analysis, _ := sentiment.NewLexicon().Classify(r.Context(), message, sentiment.Options{})
+	reasoning := "simulated (lexicon)"
+	if s.opts.Sentiment != "" {
+		status, intent, _ := strings.Cut(s.opts.Sentiment, "/")
+		analysis.Status, analysis.Intent = status, intent
+		if intent == "" {
+			analysis.Intent = "other"
+		}
+		reasoning = "simulated (-sentiment)"
+	}
+
+	content, _ := json.Marshal(map[string]string{
+		"status":    analysis.Status,
+		"intent":    analysis.Intent,
+		"language":  analysis.Language,
+		"reasoning": reasoning,
+	})
+	var resp sentiment.FireworksResponse
+	resp.Choices = make([]struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Logprobs *struct {
+			Content []sentiment.TokenLogprob `json:"content"`
+		} `json:"logprobs"`
+	}, 1)
+	resp.Choices[0].Message.Content = string(content)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// addParticipants registers unknown pages in the memory store and gives their
+// users a profile on the fake Graph server
+func (s *simulation) addParticipants(event FacebookEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	platform := event.Object
+	if platform == "page" {
+		platform = "facebook"
+	}
+	for _, entry := range event.Entry {
+		if !s.pages[entry.ID] {
+			s.pages[entry.ID] = true
+			s.memory.AddPage(MemoryPage{
+				PageID:      entry.ID,
+				Platform:    platform,
+				ClientID:    "simulated-client",
+				AccessToken: "simulated-page-token",
+				DifyAPIKey:  "app-simulated",
+			})
+		}
+		for _, msg := range entry.Messaging {
+			for _, id := range []string{msg.Sender.ID, msg.Recipient.ID} {
+				if _, known := s.graph.Object(id); id != "" && id != entry.ID && !known {
+					s.graph.SetObject(id, map[string]interface{}{"name": "Simulated User", "username": "simulated_user"})
+				}
+			}
+		}
+		// Comments must exist on the fake Graph server to be hidden
+		for _, change := range entry.Changes {
+			var value struct {
+				ID        string `json:"id"`
+				CommentID string `json:"comment_id"`
+			}
+			json.Unmarshal(change.Value, &value)
+			for _, id := range []string{value.ID, value.CommentID} {
+				if _, known := s.graph.Object(id); id != "" && !known {
+					s.graph.SetObject(id, map[string]interface{}{})
+				}
+			}
+		}
+	}
+}
+
+// Run delivers one signed payload to the webhook endpoint, waits for the
+// background processing and reports what happened
+func (s *simulation) Run(payload []byte) (*simulatedDelivery, error) {
+	var event FacebookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	foldMessageChanges(&event)
+	s.addParticipants(event)
+
+	traceID, traceparent := newTraceparent()
+	sentBefore, requestsBefore := len(s.graph.SentMessages()), len(s.graph.Requests())
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature-256", signPayload(payload, s.opts.Secret))
+	req.Header.Set("traceparent", traceparent)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	if !waitForAsyncWork(30 * time.Second) {
+		return nil, errors.New("webhook processing did not finish within 30s")
+	}
+
+	delivery := &simulatedDelivery{Status: rec.Code, Event: event}
+	delivery.collect(s.spans.Ended(), traceID)
+	delivery.Sent = s.graph.SentMessages()[sentBefore:]
+	for _, req := range s.graph.Requests()[requestsBefore:] {
+		if req.Method != http.MethodGet && !strings.HasSuffix(req.Path, "/messages") {
+			delivery.GraphWrites = append(delivery.GraphWrites, req)
+		}
+	}
+	for _, threadID := range delivery.threads() {
+		if conv, ok := s.memory.Conversation(threadID); ok {
+			delivery.BotEnabled = append(delivery.BotEnabled, threadState{threadID, conv.BotEnabled})
+		}
+	}
+	return delivery, nil
+}
+
+// =============================================================================
+// REPORT - What the router decided for one delivery
+// =============================================================================
+
+// simulatedDelivery is the outcome of one webhook delivery
+type simulatedDelivery struct {
+	Status      int
+	Event       FacebookEvent
+	Steps       []simulatedStep // Changes, echoes and user messages in processing order
+	Sent        []graphtest.SentMessage
+	GraphWrites []graphtest.Request // Graph calls other than sends, e.g. comment replies and hides
+	BotEnabled  []threadState
+}
+
+// simulatedStep is one change, echo or user message the pipeline processed
+type simulatedStep struct {
+	at        time.Time
+	Change    bool
+	Echo      bool
+	Mid       string
+	Sender    string
+	Recipient string
+
+	EchoClass string // bot, human_agent or unknown
+
+	Field   string // Change field (comments, mentions, ...)
+	Result  string // handled, ignored, unhandled or invalid
+	Action  string // What the bot did, e.g. the comment action
+	Rule    string // Moderation rule that hid the comment
+	MediaID string
+
+	Sentiment  string // status/intent
+	Provider   string
+	Confidence float64
+	Route      string
+	Outcome    string
+	DifyCalls  int
+}
+
+type threadState struct {
+	ThreadID   string
+	BotEnabled bool
+}
+
+// collect turns the spans of the delivery's trace into steps
+func (d *simulatedDelivery) collect(spans []sdktrace.ReadOnlySpan, traceID trace.TraceID) {
+	byID := make(map[trace.SpanID]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		if span.SpanContext().TraceID() == traceID {
+			byID[span.SpanContext().SpanID()] = span
+		}
+	}
+
+	// messageSpan returns the message.process span a span belongs to
+	messageSpan := func(span sdktrace.ReadOnlySpan) (trace.SpanID, bool) {
+		for span != nil {
+			if span.Name() == "message.process" {
+				return span.SpanContext().SpanID(), true
+			}
+			span = byID[span.Parent().SpanID()]
+		}
+		return trace.SpanID{}, false
+	}
+
+	steps := make(map[trace.SpanID]*simulatedStep)
+	for id, span := range byID {
+		if span.Name() != "message.process" {
+			continue
+		}
+		attrs := span.Attributes()
+		steps[id] = &simulatedStep{
+			at:        span.StartTime(),
+			Mid:       attrString(attrs, "message.mid"),
+			Sender:    attrString(attrs, "thread.id"),
+			Recipient: attrString(attrs, "page.id"),
+			Route:     attrString(attrs, "message.route"),
+			Outcome:   attrString(attrs, "message.outcome"),
+		}
+	}
+
+	for _, span := range byID {
+		switch span.Name() {
+		case "webhook.process":
+			for _, event := range span.Events() {
+				if event.Name == "change" {
+					d.Steps = append(d.Steps, simulatedStep{
+						at:      event.Time,
+						Change:  true,
+						Mid:     attrString(event.Attributes, "change.id"),
+						Sender:  attrString(event.Attributes, "change.from"),
+						Field:   attrString(event.Attributes, "change.field"),
+						Result:  attrString(event.Attributes, "change.result"),
+						Action:  attrString(event.Attributes, "change.action"),
+						Rule:    attrString(event.Attributes, "change.rule"),
+						MediaID: attrString(event.Attributes, "change.media"),
+					})
+					continue
+				}
+				if event.Name != "echo" {
+					continue
+				}
+				d.Steps = append(d.Steps, simulatedStep{
+					at:        event.Time,
+					Echo:      true,
+					Mid:       attrString(event.Attributes, "message.mid"),
+					Sender:    attrString(event.Attributes, "echo.sender"),
+					Recipient: attrString(event.Attributes, "echo.recipient"),
+					EchoClass: attrString(event.Attributes, "echo.classification"),
+				})
+			}
+		case "sentiment.classify":
+			if id, ok := messageSpan(span); ok {
+				attrs := span.Attributes()
+				steps[id].Sentiment = attrString(attrs, "sentiment.status") + "/" + attrString(attrs, "sentiment.intent")
+				steps[id].Provider = attrString(attrs, "sentiment.provider")
+				steps[id].Confidence = attrValue(attrs, "sentiment.confidence").AsFloat64()
+			}
+		case "dify.forward":
+			if id, ok := messageSpan(span); ok {
+				steps[id].DifyCalls++
+			}
+		}
+	}
+
+	for _, step := range steps {
+		d.Steps = append(d.Steps, *step)
+	}
+	sort.SliceStable(d.Steps, func(i, j int) bool { return d.Steps[i].at.Before(d.Steps[j].at) })
+}
+
+// threads lists the conversations the delivery touched, in order
+func (d *simulatedDelivery) threads() []string {
+	var threads []string
+	seen := make(map[string]bool)
+	for _, step := range d.Steps {
+		if step.Change {
+			continue
+		}
+		threadID := step.Sender
+		if step.Echo {
+			threadID = step.Recipient
+		}
+		if !seen[threadID] {
+			seen[threadID] = true
+			threads = append(threads, threadID)
+		}
+	}
+	return threads
+}
+
+// messagingEvents counts the messaging events in the payload
+func (d *simulatedDelivery) messagingEvents() int {
+	total := 0
+	for _, entry := range d.Event.Entry {
+		total += len(entry.Messaging)
+	}
+	return total
+}
+
+// changes counts the changes in the payload that were not folded into messaging
+func (d *simulatedDelivery) changes() int {
+	total := 0
+	for _, entry := range d.Event.Entry {
+		total += len(entry.Changes)
+	}
+	return total
+}
+
+// text returns the text of the payload message with the given mid
+func (d *simulatedDelivery) text(mid string) string {
+	for _, entry := range d.Event.Entry {
+		for _, msg := range entry.Messaging {
+			if msg.Message != nil && msg.Message.Mid == mid {
+				return msg.Message.Text
+			}
+		}
+	}
+	return ""
+}
+
+// Write prints the report of delivery number n
+func (d *simulatedDelivery) Write(w io.Writer, n int) {
+	events, changes := d.messagingEvents(), d.changes()
+	counts := plural(events, "messaging event", "messaging events")
+	if changes > 0 {
+		counts += ", " + plural(changes, "change", "changes")
+	}
+	fmt.Fprintf(w, "delivery %d: %s, %s, %s → HTTP %d\n",
+		n, d.Event.Object, plural(len(d.Event.Entry), "entry", "entries"), counts, d.Status)
+
+	for _, step := range d.Steps {
+		if step.Change {
+			fmt.Fprintf(w, "  change   %s", step.Field)
+			if step.Mid != "" {
+				fmt.Fprintf(w, " %s", step.Mid)
+			}
+			if step.Sender != "" {
+				fmt.Fprintf(w, " from %s", step.Sender)
+			}
+			if step.MediaID != "" {
+				fmt.Fprintf(w, " on media %s", step.MediaID)
+			}
+			fmt.Fprintf(w, " → %s", step.Result)
+			if step.Action != "" && step.Rule != "" {
+				fmt.Fprintf(w, " (%s by %s)", step.Action, step.Rule)
+			} else if step.Action != "" {
+				fmt.Fprintf(w, " (%s)", step.Action)
+			}
+			fmt.Fprintln(w)
+			continue
+		}
+		if step.Echo {
+			fmt.Fprintf(w, "  echo     %s → %s %q\n", step.Sender, step.Recipient, d.text(step.Mid))
+			fmt.Fprintf(w, "           classified %s\n", step.EchoClass)
+			continue
+		}
+
+		fmt.Fprintf(w, "  message  %s → %s %q\n", step.Sender, step.Recipient, d.text(step.Mid))
+		if step.Sentiment != "" {
+			confidence := ""
+			if step.Confidence > 0 {
+				confidence = fmt.Sprintf(", confidence %.2f", step.Confidence)
+			}
+			fmt.Fprintf(w, "           sentiment %s (%s%s)\n", step.Sentiment, step.Provider, confidence)
+		}
+		route := "none recorded"
+		if step.Route != "" {
+			route = step.Route + " → " + step.Outcome
+		}
+		fmt.Fprintf(w, "           route %s, %s\n", route, plural(step.DifyCalls, "Dify call", "Dify calls"))
+	}
+
+	if filtered := events - len(d.Steps) + changes; filtered > 0 {
+		fmt.Fprintf(w, "  filtered %s (delivery receipts, empty or non-user messages)\n", plural(filtered, "event", "events"))
+	}
+	for _, sent := range d.Sent {
+		recipient := sent.RecipientID
+		if sent.CommentID != "" {
+			recipient = "comment " + sent.CommentID
+		}
+		fmt.Fprintf(w, "  sent     %s → %s %q\n", sent.PageID, recipient, sent.Text)
+	}
+	for _, req := range d.GraphWrites {
+		fmt.Fprintf(w, "  graph    %s %s", req.Method, req.Path)
+		names := make([]string, 0, len(req.Form))
+		for name := range req.Form {
+			if name != "access_token" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, " %s=%q", name, req.Form.Get(name))
+		}
+		fmt.Fprintln(w)
+	}
+	for _, state := range d.BotEnabled {
+		botState := "disabled"
+		if state.BotEnabled {
+			botState = "enabled"
+		}
+		fmt.Fprintf(w, "  state    %s: bot %s\n", state.ThreadID, botState)
+	}
+}
+
+func plural(n int, singular, pluralForm string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, pluralForm)
+}
+
+func attrValue(attrs []attribute.KeyValue, key attribute.Key) attribute.Value {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func attrString(attrs []attribute.KeyValue, key attribute.Key) string {
+	return attrValue(attrs, key).AsString()
+}
@@ -0,0 +1,89 @@
+//go:build simulate
+
+// simulate_test.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateFixtures = flag.Bool("update", false, "Rewrite the .golden reports of testdata/webhooks")
+
+// TestWebhookFixtures replays every payload file in testdata/webhooks through
+// the simulator and compares the report with the .golden file next to it
+func TestWebhookFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "webhooks", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			oldConfig := config
+			t.Cleanup(func() { config = oldConfig })
+			config = Config{
+				DifyMaxRetries:         1,
+				FAQMatchThreshold:      1,
+				FAQFallbackThreshold:   1,
+				SentimentMinConfidence: 0.6,
+				SentimentHistoryTurns:  4,
+				FrustrationSmoothing:   0.5,
+				FrustrationThreshold:   0.75,
+				Comments: CommentPolicy{
+					Enabled:          true,
+					GeneralAction:    CommentActionReply,
+					FrustratedAction: CommentActionPrivateReply,
+					NeedHumanAction:  CommentActionFlag,
+					SpamAction:       CommentActionHide,
+					PrivateReply:     "Te escribimos por privado.",
+				},
+				Moderation: ModerationRules{
+					Enabled:         true,
+					Keywords:        []string{"sorteo falso"},
+					HideLinks:       true,
+					HideProfanity:   true,
+					SentimentLabels: []string{"spam"},
+				},
+			}
+
+			sim, err := newSimulation(simulationOptions{Secret: "fixture-secret", DifyAnswer: "Respuesta simulada de Dify."})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sim.Close()
+
+			payloads, err := readPayloads(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report bytes.Buffer
+			for i, payload := range payloads {
+				delivery, err := sim.Run(payload)
+				if err != nil {
+					t.Fatalf("delivery %d: %v", i+1, err)
+				}
+				delivery.Write(&report, i+1)
+			}
+
+			golden := strings.TrimSuffix(path, ".json") + ".golden"
+			if *updateFixtures {
+				if err := os.WriteFile(golden, report.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -run WebhookFixtures -update to create it)", err)
+			}
+			if got := report.String(); got != string(want) {
+				t.Errorf("report differs from %s:\n--- got\n%s--- want\n%s", golden, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// stores.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"message-router/oauth"
+	"message-router/sentiment"
+)
+
+// =============================================================================
+// DATA STORES - Pipeline data behind interfaces
+// =============================================================================
+//
+// Everything the router keeps in the database is reached through these
+// interfaces. PostgresStore is the production implementation; MemoryStore runs
+// the same pipeline in tests and the simulator without a database. main builds
+// the stores once and passes them to NewMessageProcessor and to the constructors
+// of the HTTP handlers.
+
+// ErrNotFound is returned by stores when the requested row doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrAmbiguousPage is returned when a page ID matches pages on more than one
+// platform and the caller didn't say which
+var ErrAmbiguousPage = errors.New("page ID matches more than one platform")
+
+// ConversationStore keeps per-thread conversation and bot control state
+type ConversationStore interface {
+	// GetOrCreate returns a thread's conversation on a page, creating it with the
+	// bot enabled on first contact
+	GetOrCreate(ctx context.Context, pageID, threadID, platform string) (*ConversationState, error)
+	// BotEnabled reports whether the bot answers a thread; ErrNotFound for new threads
+	BotEnabled(ctx context.Context, threadID string) (bool, error)
+	// SetBotEnabled turns the bot on or off for a thread and counts the message
+	SetBotEnabled(ctx context.Context, threadID string, enabled bool) error
+	// RecordHumanMessage disables the bot after a human agent replied in the thread
+	RecordHumanMessage(ctx context.Context, pageID, threadID, platform string) error
+	// SetUserName stores the user's profile name
+	SetUserName(ctx context.Context, threadID, userName string) error
+	// SetDifyConversationID stores the Dify conversation of a thread ("" clears it)
+	SetDifyConversationID(ctx context.Context, threadID, difyConversationID string) error
+	// ClientDifyConversationID returns the stored Dify conversation of a thread on
+	// one of the client's pages; ErrNotFound when the thread isn't the client's
+	ClientDifyConversationID(ctx context.Context, threadID, clientID string) (string, error)
+	// UpdateFrustrationScore folds one result into the running score and returns it
+	UpdateFrustrationScore(ctx context.Context, threadID string, frustration, smoothing float64) (float64, error)
+	// ResetFrustrationScore sets the running score back to 0
+	ResetFrustrationScore(ctx context.Context, threadID string) error
+	// ReenableDisabledBots applies the 12-hour rule and returns how many threads it re-enabled
+	ReenableDisabledBots(ctx context.Context) (int, error)
+}
+
+// PageStore reads the configuration of connected pages
+type PageStore interface {
+	// GetPageInfo returns an active page's platform and access token; ErrNotFound otherwise
+	GetPageInfo(ctx context.Context, pageID, platform string) (*PageInfo, error)
+	// GetDifyKeys returns an active page's Dify app keys; ErrNotFound otherwise
+	GetDifyKeys(ctx context.Context, pageID, platform string) (PageDifyKeys, error)
+	// ClientPages returns a client's active pages ordered by platform and name
+	ClientPages(ctx context.Context, clientID string) ([]Page, error)
+	// ClientPage returns the platform and access token of one of the client's
+	// active pages; ErrNotFound when the client has no such page
+	ClientPage(ctx context.Context, pageID, clientID string) (*PageInfo, error)
+}
+
+// PageDifyKeys are the Dify apps configured for a page
+type PageDifyKeys struct {
+	APIKey          string // Primary app key, may be empty
+	FallbackAPIKey  string // Secondary app key, empty when not configured
+	FallbackBaseURL string // Secondary app base URL, empty for DIFY_BASE_URL
+}
+
+// MessageStore keeps the messages of each thread
+type MessageStore interface {
+	// StoreMessage records a user message or a human agent reply; source is
+	// "user" or "human"
+	StoreMessage(ctx context.Context, pageID, platform, threadID, source, content string) error
+	// StoreBotMessage records a reply the bot sent (a Dify or FAQ answer) and
+	// bumps the conversation's bot activity timestamps
+	StoreBotMessage(ctx context.Context, pageID, platform, threadID, content string) error
+	// RecentMessages returns up to limit user, bot and human messages of a
+	// thread, newest first
+	RecentMessages(ctx context.Context, pageID, platform, threadID string, limit int) ([]sentiment.Turn, error)
+}
+
+// FAQStore keeps the canned answers of each page
+type FAQStore interface {
+	// EnabledFAQEntries returns the enabled FAQ entries of a page
+	EnabledFAQEntries(ctx context.Context, pageID, platform string) ([]FAQEntry, error)
+	// ClientPageUUID resolves one of the client's active pages to its internal
+	// ID. An empty platform matches any platform. ErrNotFound when the client
+	// has no such page, ErrAmbiguousPage when it has one on each platform.
+	ClientPageUUID(ctx context.Context, pageID, platform, clientID string) (string, error)
+	// ListFAQEntries returns every entry of a page, oldest first
+	ListFAQEntries(ctx context.Context, pageUUID string) ([]FAQEntry, error)
+	// CreateFAQEntry adds an entry to a page and returns its ID
+	CreateFAQEntry(ctx context.Context, pageUUID string, entry FAQEntry) (string, error)
+	// UpdateFAQEntry replaces an entry's question, triggers and answer, and its
+	// enabled flag when given; ErrNotFound when the page has no such entry
+	UpdateFAQEntry(ctx context.Context, pageUUID, entryID string, req FAQEntryRequest) error
+	// DeleteFAQEntry removes an entry; ErrNotFound when the page has no such entry
+	DeleteFAQEntry(ctx context.Context, pageUUID, entryID string) error
+}
+
+// QuotaStore reads quota limits and keeps the windowed counters shared by
+// quotas and the message guard
+type QuotaStore interface {
+	// QuotaLimits applies the page's page_quotas and client_quotas overrides to
+	// defaults and returns them with the page's internal ID and client ID
+	QuotaLimits(ctx context.Context, pageID, platform string, defaults QuotaPolicy) (policy QuotaPolicy, pageUUID, clientID string, err error)
+	// IncrementCounter bumps a counter for a window and returns the new value
+	IncrementCounter(ctx context.Context, scope, key string, window time.Time) (int64, error)
+	// DecrementCounter takes back one increment, never going below 0
+	DecrementCounter(ctx context.Context, scope, key string, window time.Time) error
+	// PruneCounters removes windows that can no longer affect a decision
+	PruneCounters(ctx context.Context, now time.Time) error
+	// ClientTokensSince returns the LLM tokens a client used since a time
+	ClientTokensSince(ctx context.Context, clientID string, since time.Time) (int64, error)
+}
+
+// GuardStore holds the message guard's blocklists, page settings and flags
+type GuardStore interface {
+	// SenderBlocked reports whether a sender is on the page's blocklist
+	SenderBlocked(ctx context.Context, pageID, platform, senderID string) (bool, error)
+	// GuardSettings returns the page's page_guard_settings row; a zero value
+	// (or no row at all) means the configured defaults
+	GuardSettings(ctx context.Context, pageID, platform string) (PageGuardSettings, error)
+	// FlagMessage records a message in message_flags for review
+	FlagMessage(ctx context.Context, flag MessageFlag) error
+}
+
+// PageGuardSettings override the message guard defaults for one page. Nil and
+// empty fields use the configured defaults.
+type PageGuardSettings struct {
+	Enabled *bool
+	Action  string
+	Reply   string
+}
+
+// PolicyStore reads the per-page policies that override the configured
+// defaults. Pages without a row of their own get the defaults back.
+type PolicyStore interface {
+	// SafetyPolicy applies the page's page_safety_policies row to defaults
+	SafetyPolicy(ctx context.Context, pageID, platform string, defaults SafetyPolicy) (SafetyPolicy, error)
+	// CommentPolicy applies the page's page_comment_policies row to defaults
+	CommentPolicy(ctx context.Context, pageID, platform string, defaults CommentPolicy) (CommentPolicy, error)
+	// ModerationRules applies the page's page_moderation_rules row to defaults
+	ModerationRules(ctx context.Context, pageID, platform string, defaults ModerationRules) (ModerationRules, error)
+	// SentimentOptions returns the page's custom prompt and intents from
+	// page_sentiment_settings; empty options mean the package defaults
+	SentimentOptions(ctx context.Context, pageID, platform string) (sentiment.Options, error)
+}
+
+// UsageStore keeps the LLM usage ledger
+type UsageStore interface {
+	// RecordLLMUsage stores one LLM call with its cost and the request that made it
+	RecordLLMUsage(ctx context.Context, usage LLMUsage, costUSD float64, requestID string) error
+	// UsageReport sums a client's usage from from (inclusive) to to (exclusive) per
+	// period ("day" or "month"), provider, model and backend, oldest first
+	UsageReport(ctx context.Context, clientID, period string, from, to time.Time) ([]UsageReportRow, error)
+}
+
+// ReplyTierStore keeps which fallback tier answered each message
+type ReplyTierStore interface {
+	// RecordReplyTier stores the tier that answered a thread's message
+	RecordReplyTier(ctx context.Context, pageID, platform, threadID string, tier ReplyTier, errorMessage string) error
+	// ReplyTierCounts counts a client's answers per page and tier since a time
+	ReplyTierCounts(ctx context.Context, clientID string, since time.Time) ([]ReplyTierCount, error)
+}
+
+// ModerationLogStore keeps the audit log of hidden and unhidden comments
+type ModerationLogStore interface {
+	// RecordModeration stores a hide or unhide
+	RecordModeration(ctx context.Context, entry ModerationEntry) error
+	// ModerationLog returns up to limit of a client's entries, newest first.
+	// Empty pageID and commentID don't filter.
+	ModerationLog(ctx context.Context, clientID, pageID, commentID string, limit int) ([]ModerationEntry, error)
+}
+
+// HealthStore reports on the database behind the stores for /readyz
+type HealthStore interface {
+	// Ping checks the database and returns its connection pool usage
+	Ping(ctx context.Context) (sql.DBStats, error)
+	// ReenableFunctionExists reports whether the reenable_disabled_bots()
+	// function that ReenableDisabledBots relies on is installed
+	ReenableFunctionExists(ctx context.Context) (bool, error)
+}
+
+// MessageFlag is a message, answer or comment stored for review
+type MessageFlag struct {
+	PageID     string // Platform page ID
+	Platform   string
+	ThreadID   string // Conversation, or the media of a comment
+	SenderID   string
+	MessageMid string // Message mid or comment ID
+	Content    string
+	Check      string // e.g. "url_spam", "answer_forbidden_term", "comment_need_human"
+	Reason     string
+	Action     string
+}
+
+// Stores groups every store of the router
+type Stores struct {
+	Conversations ConversationStore
+	Pages         PageStore
+	Messages      MessageStore
+	FAQ           FAQStore
+	Quotas        QuotaStore
+	Guard         GuardStore
+	Policies      PolicyStore
+	Usage         UsageStore
+	ReplyTiers    ReplyTierStore
+	ModerationLog ModerationLogStore
+	Clients       oauth.ClientStore
+	Health        HealthStore
+}
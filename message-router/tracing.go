@@ -0,0 +1,188 @@
+// tracing.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"logging"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// =============================================================================
+// OPENTELEMETRY TRACING - One trace per message, webhook to Send API
+// =============================================================================
+
+// tracer creates every span of the message router. It is a no-op until
+// setupTracing installs an exporting provider.
+var tracer = otel.Tracer("message-router")
+
+// tracingShutdown flushes and stops the exporter; nil when tracing is off
+var tracingShutdown func(context.Context) error
+
+// setupTracing installs the tracer provider selected by OTEL_TRACES_EXPORTER:
+// "none" (default), "otlp" or "stdout". The OTLP exporter reads the standard
+// OTEL_EXPORTER_OTLP_* variables and the sampler OTEL_TRACES_SAMPLER(_ARG).
+func setupTracing() {
+	// W3C trace context is propagated even when spans are not exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporterName := strings.ToLower(getEnvOrDefault("OTEL_TRACES_EXPORTER", "none"))
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case "none", "":
+		log.Printf("🔭 Tracing disabled (OTEL_TRACES_EXPORTER=none)")
+		return
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "stdout", "console":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		err = fmt.Errorf("unknown exporter %q", exporterName)
+	}
+	if err != nil {
+		log.Printf("⚠️ Tracing disabled: %v", err)
+		return
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("message-router")),
+		resource.WithFromEnv(), // OTEL_SERVICE_NAME, OTEL_RESOURCE_ATTRIBUTES
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		log.Printf("⚠️ Tracing resource incomplete: %v", err)
+	}
+
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if exporterName == "otlp" {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	} else {
+		options = append(options, sdktrace.WithSyncer(exporter)) // Print spans as they end
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	tracingShutdown = provider.Shutdown
+
+	// Correlate log lines with their trace
+	logging.AddContextAttrs(func(ctx context.Context) []slog.Attr {
+		spanContext := trace.SpanContextFromContext(ctx)
+		if !spanContext.IsValid() {
+			return nil
+		}
+		return []slog.Attr{
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		}
+	})
+
+	log.Printf("🔭 Tracing enabled (%s exporter)", exporterName)
+}
+
+// startSpan starts an internal span as a child of the span in ctx
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, logging.ScrubSecrets(err.Error()))
+	}
+	span.End()
+}
+
+// =============================================================================
+// HTTP INSTRUMENTATION - Inbound server spans and outbound client spans
+// =============================================================================
+
+// statusRecorder captures the response status for the server span
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// traceMiddleware starts a server span for every request, continuing the
+// caller's trace when it sends a traceparent header. Only the path is recorded:
+// queries carry verify tokens and OAuth codes.
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// tracingTransport starts a client span for every outbound request (Fireworks,
+// Dify, Graph API) and propagates the trace context in its headers
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// newTracingTransport wraps base, or http.DefaultTransport when nil
+func newTracingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{base: base}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), req.Method+" "+req.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", req.URL.Path), // Graph API tokens are in the query
+		))
+
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+	return resp, nil
+}
@@ -2,9 +2,13 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"sync"
 	"time"
+
+	"logging"
 )
 
 // FacebookEvent represents the incoming webhook event from Facebook
@@ -19,6 +23,8 @@ type EntryData struct {
 	Time int64  `json:"time"`
 	// Handle messaging events
 	Messaging []MessagingEntry `json:"messaging"`
+	// Handle subscription field changes (comments, mentions, ...), see changes.go
+	Changes []ChangeEntry `json:"changes"`
 }
 
 // MessagingEntry represents a message in the Facebook webhook
@@ -101,11 +107,10 @@ type InstagramUser struct {
 	Username string `json:"username,omitempty"`
 }
 
-type InstagramChanges struct {
-	Field string `json:"field"`
-	Value struct {
-		Messages []InstagramMessage `json:"messages"`
-	} `json:"value"`
+// ChangeEntry is one entry[].changes[] item; Value depends on Field
+type ChangeEntry struct {
+	Field string          `json:"field"`
+	Value json.RawMessage `json:"value"`
 }
 
 type ConversationState struct {
@@ -130,12 +135,42 @@ type Config struct {
 	// Instagram OAuth credentials
 	InstagramAppID        string // Added for Instagram OAuth
 	InstagramAppSecretKey string // Added for Instagram OAuth
+	// Secrets accepted on webhook signatures, current ones first
+	WebhookSecrets []WebhookSecret
 	// Facebook App IDs for echo message detection
 	FacebookBotAppID       int64 // Your bot's Facebook App ID (1195277397801905) - used for echo detection
 	FacebookPageInboxAppID int64 // Facebook Page Inbox App ID (263902037430900) - unused
 	// Botpress integration (legacy - will be removed after migration)
 	BotpressToken string // Botpress token (temporary during migration)
 	// Note: Dify API keys are now stored per-page in database (multi-tenant)
+	DifyBaseURL string // Dify API base URL for the primary app (self-hosted installs can override it)
+	// Dify resilience settings
+	DifyMaxRetries       int           // Attempts per message for retryable Dify errors
+	DifyBreakerThreshold int           // Consecutive failures before a Dify API key's circuit opens
+	DifyBreakerCooldown  time.Duration // How long an open circuit rejects calls
+	DifyDegradedReply    string        // Sent to users while the Dify circuit for their page is open
+	// Usage accounting
+	LLMPrices map[string]ModelPrice // Keyed by "provider/model", "provider/*" applies to any model of a provider
+	// FAQ responder settings
+	FAQMatchThreshold    float64 // Minimum score to answer from the FAQ before sentiment/Dify
+	FAQFallbackThreshold float64 // Lower score accepted when the FAQ is used as a fallback tier
+	// Quotas (page_quotas / client_quotas rows override these per page and client)
+	QuotaDefaults QuotaPolicy
+	// Spam, abuse and prompt-injection guard (page_guard_settings rows override the action/reply)
+	Guard GuardConfig
+	// Sentiment routing
+	SentimentMinConfidence float64 // Below this, need_human and spam labels are not acted on
+	SentimentHistoryTurns  int     // Earlier messages sent as context (0 = analyze messages alone)
+	FrustrationSmoothing   float64 // Weight of the newest message in the running frustration score
+	FrustrationThreshold   float64 // Score at which the conversation escalates to a human (0 = never)
+	// Outbound answer safety filter (page_safety_policies rows override it per page)
+	Safety SafetyConfig
+	// Comment automation (page_comment_policies rows override it per page)
+	Comments CommentPolicy
+	// Comment moderation rules (page_moderation_rules rows override them per page)
+	Moderation ModerationRules
+	// Readiness checks served at /readyz
+	Health HealthConfig
 }
 
 // PageInfo represents essential page information retrieved from the database
@@ -222,7 +257,7 @@ func (c *UserCache) Set(userID, name string) {
 		name:      name,
 		expiresAt: expiresAt,
 	}
-	log.Printf("💾 Cached user %s name as %s (expires at %v)", userID, name, expiresAt)
+	log.Printf("💾 Cached user %s name as %s (expires at %v)", userID, logging.Name(name), expiresAt)
 }
 
 // =============================================================================
@@ -247,9 +282,11 @@ type DifyResponse struct {
 	Mode           string `json:"mode"`            // Response mode used
 	Metadata       struct {
 		Usage struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			TotalTokens      int `json:"total_tokens"`
+			PromptTokens     int    `json:"prompt_tokens"`
+			CompletionTokens int    `json:"completion_tokens"`
+			TotalTokens      int    `json:"total_tokens"`
+			TotalPrice       string `json:"total_price"` // Price Dify computed for the call, as a decimal string
+			Currency         string `json:"currency"`
 		} `json:"usage"`
 		RetrieverResources []interface{} `json:"retriever_resources"`
 	} `json:"metadata"`
@@ -263,6 +300,30 @@ type DifyErrorResponse struct {
 	Status  int    `json:"status"`
 }
 
+// DifyBackend identifies one Dify app a page can be answered by
+type DifyBackend struct {
+	Name    string // "primary" or "secondary", used for logs and reply tier tracking
+	APIKey  string // Dify app API key (app-xxxxx...)
+	BaseURL string // Dify API base URL, e.g. https://api.dify.ai/v1
+}
+
+// DifyAPIError is returned by sendToDify when Dify answers with a non-200 status.
+// Keeping the status and Dify error code lets callers react to specific failures
+// (e.g. an expired conversation) instead of treating every error the same way.
+type DifyAPIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RetryAfter time.Duration // Parsed from the Retry-After header (429/503), zero if absent
+}
+
+func (e *DifyAPIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("dify error: %s (code: %s)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("unexpected status code from Dify: %d - %s", e.StatusCode, e.Message)
+}
+
 // DifyConversationState represents conversation state for Dify integration
 type DifyConversationState struct {
 	ConversationId string    // Dify's conversation ID
@@ -0,0 +1,244 @@
+// usage_tracking.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"logging"
+)
+
+// =============================================================================
+// LLM USAGE & COST ACCOUNTING - Per-client token usage for billing
+// =============================================================================
+
+// ModelPrice is the USD price of a model per million tokens
+type ModelPrice struct {
+	PromptPerMillion     float64 `json:"prompt_per_million"`
+	CompletionPerMillion float64 `json:"completion_per_million"`
+}
+
+// defaultLLMPrices are used unless LLM_PRICES overrides them. The Fireworks price
+// matches the estimate the router has always logged ($0.20 per 1M tokens).
+var defaultLLMPrices = map[string]ModelPrice{
+	"fireworks/*": {PromptPerMillion: 0.20, CompletionPerMillion: 0.20},
+}
+
+// LLMUsage describes one LLM call to be recorded
+type LLMUsage struct {
+	PageID           string // Platform page ID (not the internal UUID)
+	Platform         string
+	ThreadID         string
+	Provider         string // "fireworks" or "dify"
+	Model            string // Dify doesn't report the model behind an app; its calls use difyModel
+	Backend          string // Dify backend ("primary" or "secondary"); empty for direct model calls
+	Purpose          string // "sentiment" or "chat"
+	PromptTokens     int
+	CompletionTokens int
+	ReportedCostUSD  *float64 // Cost reported by the provider, used when no price is configured
+}
+
+// loadLLMPrices returns the default price table merged with the JSON in LLM_PRICES, e.g.
+// {"fireworks/*": {"prompt_per_million": 0.22, "completion_per_million": 0.88}}
+func loadLLMPrices(raw string) map[string]ModelPrice {
+	prices := make(map[string]ModelPrice, len(defaultLLMPrices))
+	for key, price := range defaultLLMPrices {
+		prices[key] = price
+	}
+	if raw == "" {
+		return prices
+	}
+
+	var overrides map[string]ModelPrice
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		LogWarn("Invalid LLM_PRICES, using default prices: %v", err)
+		return prices
+	}
+	for key, price := range overrides {
+		prices[key] = price
+	}
+	return prices
+}
+
+// difyModel is the model recorded for Dify calls
+const difyModel = "app"
+
+// lookupModelPrice finds the price for provider/model, then provider/backend
+// (the Dify app decides the model), falling back to provider/*
+func lookupModelPrice(provider, model, backend string) (ModelPrice, bool) {
+	if price, ok := config.LLMPrices[provider+"/"+model]; ok {
+		return price, true
+	}
+	if backend != "" {
+		if price, ok := config.LLMPrices[provider+"/"+backend]; ok {
+			return price, true
+		}
+	}
+	price, ok := config.LLMPrices[provider+"/*"]
+	return price, ok
+}
+
+// unpricedModels remembers the provider/model pairs already warned about
+var unpricedModels sync.Map
+
+// estimateCostUSD prices a call with the configured table, falling back to the
+// cost the provider reported. Unknown prices cost 0 and are logged once.
+func estimateCostUSD(usage LLMUsage) float64 {
+	if price, ok := lookupModelPrice(usage.Provider, usage.Model, usage.Backend); ok {
+		return float64(usage.PromptTokens)*price.PromptPerMillion/1_000_000 +
+			float64(usage.CompletionTokens)*price.CompletionPerMillion/1_000_000
+	}
+	if usage.ReportedCostUSD != nil {
+		return *usage.ReportedCostUSD
+	}
+	key := usage.Provider + "/" + usage.Model
+	if usage.Backend != "" {
+		key = usage.Provider + "/" + usage.Backend
+	}
+	if _, warned := unpricedModels.LoadOrStore(key, true); !warned {
+		LogWarn("No price configured for %s and none reported - recording its cost as 0; add it to LLM_PRICES", key)
+	}
+	return 0
+}
+
+// recordLLMUsage stores one LLM call with its tokens, cost and the request ID
+// from ctx. The cost is frozen at record time so later price changes don't
+// rewrite past invoices.
+// Failures are logged, never returned - accounting must not break replies.
+func (p *MessageProcessor) recordLLMUsage(ctx context.Context, usage LLMUsage) {
+	cost := estimateCostUSD(usage)
+	llmTokens.WithLabelValues(usage.Provider, usage.Model, usage.Purpose, "prompt").Add(float64(usage.PromptTokens))
+	llmTokens.WithLabelValues(usage.Provider, usage.Model, usage.Purpose, "completion").Add(float64(usage.CompletionTokens))
+
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := p.stores.Usage.RecordLLMUsage(queryCtx, usage, cost, logging.RequestID(ctx)); err != nil {
+		LogWarnCtx(ctx, "Could not record %s usage: %v", usage.Provider, err)
+		return
+	}
+
+	LogDebugCtx(ctx, "💰 %s/%s: %d+%d tokens, $%.6f", usage.Provider, usage.Model,
+		usage.PromptTokens, usage.CompletionTokens, cost)
+}
+
+// difyUsage converts the usage metadata of a Dify response into an LLMUsage
+func difyUsage(pageID, platform, threadID string, backend DifyBackend, response *DifyResponse) LLMUsage {
+	usage := LLMUsage{
+		PageID:           pageID,
+		Platform:         platform,
+		ThreadID:         threadID,
+		Provider:         "dify",
+		Model:            difyModel,
+		Backend:          backend.Name,
+		Purpose:          "chat",
+		PromptTokens:     response.Metadata.Usage.PromptTokens,
+		CompletionTokens: response.Metadata.Usage.CompletionTokens,
+	}
+	if strings.EqualFold(response.Metadata.Usage.Currency, "USD") {
+		if price, err := strconv.ParseFloat(response.Metadata.Usage.TotalPrice, 64); err == nil {
+			usage.ReportedCostUSD = &price
+		}
+	}
+	return usage
+}
+
+// UsageReportRow is the usage of one provider, model and backend in one period
+type UsageReportRow struct {
+	Period           string  `json:"period"` // 2006-01-02 or 2006-01
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Backend          string  `json:"backend,omitempty"`
+	Calls            int     `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// formatUsagePeriod formats the start of a report period
+func formatUsagePeriod(bucket time.Time, period string) string {
+	if period == "month" {
+		return bucket.Format("2006-01")
+	}
+	return bucket.Format("2006-01-02")
+}
+
+// handleUsageReport aggregates LLM usage for the authenticated client.
+// GET /api/usage?period=day|month&from=2025-01-01&to=2025-01-31
+//
+// `from` defaults to the start of the current month and `to` (inclusive) to today.
+func handleUsageReport(usage UsageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		clientID := r.Header.Get("X-Client-ID")
+		if clientID == "" {
+			http.Error(w, "Client ID required", http.StatusUnauthorized)
+			return
+		}
+
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			period = "day"
+		}
+		if period != "day" && period != "month" {
+			http.Error(w, "period must be 'day' or 'month'", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().UTC()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		var err error
+		if value := r.URL.Query().Get("from"); value != "" {
+			if from, err = time.Parse("2006-01-02", value); err != nil {
+				http.Error(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("to"); value != "" {
+			if to, err = time.Parse("2006-01-02", value); err != nil {
+				http.Error(w, "to must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		if to.Before(from) {
+			http.Error(w, "to must not be before from", http.StatusBadRequest)
+			return
+		}
+
+		report, err := usage.UsageReport(r.Context(), clientID, period, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			LogError("Error querying usage report: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		var totalCost float64
+		var totalTokens int64
+		for _, row := range report {
+			totalCost += row.CostUSD
+			totalTokens += row.PromptTokens + row.CompletionTokens
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"client_id":      clientID,
+			"period":         period,
+			"from":           from.Format("2006-01-02"),
+			"to":             to.Format("2006-01-02"),
+			"usage":          report,
+			"total_tokens":   totalTokens,
+			"total_cost_usd": fmt.Sprintf("%.6f", totalCost),
+		})
+	}
+}
@@ -0,0 +1,32 @@
+// usage_tracking_test.go
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestEstimateCostUSD checks the price lookup order for model, Dify backend,
+// provider wildcard and the provider-reported cost
+func TestEstimateCostUSD(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+	config.LLMPrices = loadLLMPrices(`{"dify/primary":{"prompt_per_million":1,"completion_per_million":2}}`)
+
+	reported := 0.5
+	tests := []struct {
+		name  string
+		usage LLMUsage
+		want  float64
+	}{
+		{"fireworks wildcard", LLMUsage{Provider: "fireworks", Model: "any-model", PromptTokens: 1_000_000}, 0.20},
+		{"dify backend price", LLMUsage{Provider: "dify", Model: difyModel, Backend: "primary", PromptTokens: 1_000_000, CompletionTokens: 1_000_000}, 3},
+		{"dify reported cost", LLMUsage{Provider: "dify", Model: difyModel, Backend: "secondary", ReportedCostUSD: &reported}, 0.5},
+		{"unpriced", LLMUsage{Provider: "dify", Model: difyModel, Backend: "secondary", PromptTokens: 100}, 0},
+	}
+	for _, tt := range tests {
+		if got := estimateCostUSD(tt.usage); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: estimateCostUSD() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
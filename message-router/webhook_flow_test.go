@@ -0,0 +1,538 @@
+// webhook_flow_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"graph"
+	"graph/graphtest"
+	"message-router/sentiment"
+)
+
+// The webhook flow tests run the whole pipeline against a MemoryStore.
+
+const (
+	testPageID   = "100000000000001"
+	testUserID   = "200000000000002"
+	testDifyKey  = "app-testkey0001"
+	testDifyBase = "https://dify.test/v1"
+)
+
+// fakeUpstreams answers the Dify calls made through httpClient; Graph API
+// calls go to a graphtest server
+type fakeUpstreams struct {
+	graph *graphtest.Server
+
+	mu       sync.Mutex
+	difyReqs []DifyRequest
+}
+
+func (f *fakeUpstreams) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if req.URL.Host == "dify.test" && req.URL.Path == "/v1/chat-messages" {
+		var payload DifyRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			return nil, err
+		}
+		f.difyReqs = append(f.difyReqs, payload)
+		return jsonResponse(http.StatusOK, `{"answer":"Abrimos de 9 a 18 h.","conversation_id":"dify-conv-1","message_id":"msg-1"}`), nil
+	}
+	return jsonResponse(http.StatusNotFound, `{}`), nil
+}
+
+func (f *fakeUpstreams) counts() (difyCalls, sent int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.difyReqs), len(f.graph.SentMessages())
+}
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// setupFlowTest installs a memory store with one Facebook page, fake Dify and Graph,
+// the lexicon classifier and a minimal config, and restores the globals afterwards
+func setupFlowTest(t *testing.T) (*MemoryStore, *fakeUpstreams) {
+	t.Helper()
+
+	oldConfig := config
+	oldTransport, oldClassifier, oldBreakers := httpClient.Transport, sentimentClassifier, difyBreakers
+	oldGraphClient := graphClient
+	t.Cleanup(func() {
+		config = oldConfig
+		httpClient.Transport, sentimentClassifier, difyBreakers = oldTransport, oldClassifier, oldBreakers
+		graphClient = oldGraphClient
+	})
+
+	memory := NewMemoryStore()
+	memory.AddPage(MemoryPage{
+		PageID:      testPageID,
+		Platform:    "facebook",
+		ClientID:    "client-1",
+		AccessToken: "page-token",
+		DifyAPIKey:  testDifyKey,
+	})
+
+	fakeGraph := graphtest.NewServer()
+	t.Cleanup(fakeGraph.Close)
+	fakeGraph.SetObject(testUserID, map[string]interface{}{"name": "Ana"})
+	graphClient = fakeGraph.Client()
+
+	upstreams := &fakeUpstreams{graph: fakeGraph}
+	httpClient.Transport = upstreams
+	sentimentClassifier = sentiment.NewLexicon()
+	difyBreakers = newCircuitBreakerRegistry(5, time.Minute)
+	config = Config{
+		DifyBaseURL:           testDifyBase,
+		DifyMaxRetries:        1,
+		FAQMatchThreshold:     1,
+		FAQFallbackThreshold:  1,
+		SentimentHistoryTurns: 4,
+		FrustrationSmoothing:  0.5,
+	}
+	return memory, upstreams
+}
+
+// postWebhook sends a Messenger webhook to handleWebhook and waits until the
+// asynchronous processing it started has finished
+func postWebhook(t *testing.T, memory *MemoryStore, messaging MessagingEntry) {
+	t.Helper()
+	postEntry(t, memory, EntryData{ID: testPageID, Time: time.Now().UnixMilli(), Messaging: []MessagingEntry{messaging}})
+}
+
+// postChange sends a page subscription change (e.g. a feed comment) the same way
+func postChange(t *testing.T, memory *MemoryStore, field string, value interface{}) {
+	t.Helper()
+	raw, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postEntry(t, memory, EntryData{ID: testPageID, Time: time.Now().UnixMilli(), Changes: []ChangeEntry{{Field: field, Value: raw}}})
+}
+
+func postEntry(t *testing.T, memory *MemoryStore, entry EntryData) {
+	t.Helper()
+
+	body, err := json.Marshal(FacebookEvent{Object: "page", Entry: []EntryData{entry}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	NewMessageProcessor(memory.Stores()).handleWebhook(rec, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("webhook status = %d, want 200", rec.Code)
+	}
+
+	if !waitForAsyncWork(5 * time.Second) {
+		t.Fatal("webhook processing did not finish")
+	}
+}
+
+func userMessage(text string) MessagingEntry {
+	msg := MessagingEntry{Message: &MessageData{Mid: "mid-" + text, Text: text}}
+	msg.Sender.ID = testUserID
+	msg.Recipient.ID = testPageID
+	return msg
+}
+
+func TestWebhookFlowAnswersWithDify(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+
+	postWebhook(t, memory, userMessage("Hola, ¿a qué hora abren?"))
+
+	difyCalls, sent := upstreams.counts()
+	if difyCalls != 1 || sent != 1 {
+		t.Fatalf("got %d Dify calls and %d sent messages, want 1 and 1", difyCalls, sent)
+	}
+	if got := upstreams.graph.SentMessages()[0]; got.Text != "Abrimos de 9 a 18 h." || got.RecipientID != testUserID || got.Token != "page-token" {
+		t.Errorf("sent %+v, want the Dify answer to the user with the page token", got)
+	}
+	if got := upstreams.difyReqs[0].User; got != testPageID+"-"+testUserID {
+		t.Errorf("Dify user = %q, want %q", got, testPageID+"-"+testUserID)
+	}
+
+	conv, ok := memory.Conversation(testUserID)
+	if !ok {
+		t.Fatal("conversation was not created")
+	}
+	if !conv.BotEnabled {
+		t.Error("bot should stay enabled after answering")
+	}
+	if conv.DifyConversationID != "dify-conv-1" {
+		t.Errorf("DifyConversationID = %q, want dify-conv-1", conv.DifyConversationID)
+	}
+
+	// The next message continues the same Dify conversation
+	postWebhook(t, memory, userMessage("¿Y los domingos?"))
+	if difyCalls, _ := upstreams.counts(); difyCalls != 2 {
+		t.Fatalf("got %d Dify calls, want 2", difyCalls)
+	}
+	if got := upstreams.difyReqs[1].ConversationId; got != "dify-conv-1" {
+		t.Errorf("second Dify request conversation_id = %q, want dify-conv-1", got)
+	}
+}
+
+func TestWebhookFlowHumanAgentDisablesBot(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+
+	postWebhook(t, memory, userMessage("Hola"))
+
+	// A reply typed by a human in the page inbox arrives as an echo from the page
+	echo := MessagingEntry{Message: &MessageData{Mid: "mid-echo", Text: "Hola, soy Marta", IsEcho: true}}
+	echo.Sender.ID = testPageID
+	echo.Recipient.ID = testUserID
+	postWebhook(t, memory, echo)
+
+	if conv, _ := memory.Conversation(testUserID); conv.BotEnabled {
+		t.Fatal("bot should be disabled after a human agent replied")
+	}
+
+	postWebhook(t, memory, userMessage("Gracias Marta"))
+	if difyCalls, sent := upstreams.counts(); difyCalls != 1 || sent != 1 {
+		t.Errorf("got %d Dify calls and %d sent messages, want only the first message answered", difyCalls, sent)
+	}
+}
+
+// historyRecorder wraps a classifier and keeps the history of every call
+type historyRecorder struct {
+	sentiment.Classifier
+
+	mu        sync.Mutex
+	histories [][]sentiment.Turn
+}
+
+func (r *historyRecorder) Classify(ctx context.Context, message string, opts sentiment.Options) (*sentiment.Analysis, error) {
+	r.mu.Lock()
+	r.histories = append(r.histories, opts.History)
+	r.mu.Unlock()
+	return r.Classifier.Classify(ctx, message, opts)
+}
+
+func TestWebhookFlowStoresTurnsForClassifierHistory(t *testing.T) {
+	memory, _ := setupFlowTest(t)
+	recorder := &historyRecorder{Classifier: sentimentClassifier}
+	sentimentClassifier = recorder
+
+	postWebhook(t, memory, userMessage("Hola, ¿a qué hora abren?"))
+	postWebhook(t, memory, userMessage("¿Y los domingos?"))
+
+	if len(recorder.histories) != 2 {
+		t.Fatalf("classifier called %d times, want 2", len(recorder.histories))
+	}
+	if len(recorder.histories[0]) != 0 {
+		t.Errorf("first message history = %+v, want none", recorder.histories[0])
+	}
+	want := []sentiment.Turn{
+		{Role: "user", Content: "Hola, ¿a qué hora abren?"},
+		{Role: "bot", Content: "Abrimos de 9 a 18 h."},
+	}
+	if got := recorder.histories[1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("second message history = %+v, want %+v", got, want)
+	}
+
+	// Human agent replies are part of the history too
+	echo := MessagingEntry{Message: &MessageData{Mid: "mid-echo", Text: "Los domingos cerramos", IsEcho: true}}
+	echo.Sender.ID = testPageID
+	echo.Recipient.ID = testUserID
+	postWebhook(t, memory, echo)
+
+	turns, err := memory.RecentMessages(context.Background(), testPageID, "facebook", testUserID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(turns) != 1 || turns[0] != (sentiment.Turn{Role: "human", Content: "Los domingos cerramos"}) {
+		t.Errorf("newest stored turn = %+v, want the human agent reply", turns)
+	}
+}
+
+func TestWebhookFlowAnswersFromFAQ(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+	memory.AddPage(MemoryPage{
+		PageID:      testPageID,
+		Platform:    "facebook",
+		ClientID:    "client-1",
+		AccessToken: "page-token",
+		DifyAPIKey:  testDifyKey,
+		FAQ: []FAQEntry{
+			{ID: "faq-1", Triggers: []string{"horario"}, Answer: "Abrimos de lunes a sábado.", Enabled: true},
+			{ID: "faq-2", Triggers: []string{"envios"}, Answer: "Enviamos a todo el país.", Enabled: false},
+		},
+	})
+
+	postWebhook(t, memory, userMessage("¿Cuál es el horario?"))
+	difyCalls, sent := upstreams.counts()
+	if difyCalls != 0 || sent != 1 {
+		t.Fatalf("got %d Dify calls and %d sent messages, want 0 and 1", difyCalls, sent)
+	}
+	if got := upstreams.graph.SentMessages()[0].Text; got != "Abrimos de lunes a sábado." {
+		t.Errorf("sent %q, want the FAQ answer", got)
+	}
+
+	// Disabled entries are not used
+	postWebhook(t, memory, userMessage("¿Hacen envios?"))
+	if difyCalls, _ := upstreams.counts(); difyCalls != 1 {
+		t.Errorf("got %d Dify calls, want the disabled FAQ entry to fall through to Dify", difyCalls)
+	}
+}
+
+func TestWebhookFlowDailyReplyQuota(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+	config.QuotaDefaults = QuotaPolicy{
+		PageDailyReplies: 1,
+		PageDailyAction:  QuotaActionTemplate,
+		TemplateMessage:  "Volvemos mañana.",
+	}
+
+	postWebhook(t, memory, userMessage("Hola, ¿a qué hora abren?"))
+	postWebhook(t, memory, userMessage("¿Y los domingos?"))
+	postWebhook(t, memory, userMessage("¿Hola?"))
+
+	difyCalls, sent := upstreams.counts()
+	if difyCalls != 1 {
+		t.Errorf("got %d Dify calls, want only the first message answered", difyCalls)
+	}
+	var texts []string
+	for _, msg := range upstreams.graph.SentMessages()[:sent] {
+		texts = append(texts, msg.Text)
+	}
+	if want := []string{"Abrimos de 9 a 18 h.", "Volvemos mañana."}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("sent %q, want %q (the template only once)", texts, want)
+	}
+}
+
+func TestWebhookFlowDailyReplyQuotaReleasedWithoutReply(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+	config.QuotaDefaults = QuotaPolicy{PageDailyReplies: 1, PageDailyAction: QuotaActionDrop}
+	config.SentimentMinConfidence = 0.7
+
+	// A handoff is not a bot reply, so it gives back its reservation
+	sentimentClassifier = staticClassifier{sentiment.Analysis{Status: "need_human", Intent: "support", Provider: "static"}}
+	other := userMessage("Quiero hablar con alguien")
+	other.Sender.ID = "300000000000003"
+	postWebhook(t, memory, other)
+
+	sentimentClassifier = staticClassifier{sentiment.Analysis{Status: "general", Intent: "other", Provider: "static"}}
+	postWebhook(t, memory, userMessage("Hola, ¿a qué hora abren?"))
+	postWebhook(t, memory, userMessage("¿Y los domingos?"))
+
+	if difyCalls, _ := upstreams.counts(); difyCalls != 1 {
+		t.Errorf("got %d Dify calls, want the first general message answered and the second over the limit", difyCalls)
+	}
+}
+
+func TestWebhookFlowGuardFlagsMessages(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+	config.Guard = GuardConfig{MaxURLs: 1, RepeatLimit: 1, RepeatWindow: time.Minute, RepeatMinLength: 15,
+		DefaultAction: GuardActionIgnore}
+	enabled := true
+	memory.AddPage(MemoryPage{
+		PageID:         testPageID,
+		Platform:       "facebook",
+		ClientID:       "client-1",
+		AccessToken:    "page-token",
+		DifyAPIKey:     testDifyKey,
+		BlockedSenders: []string{"300000000000003"},
+		Guard:          PageGuardSettings{Enabled: &enabled}, // Opted in while GUARD_ENABLED is off
+	})
+
+	spam := userMessage("Mira http://a.example y http://b.example")
+	postWebhook(t, memory, spam)
+	blocked := userMessage("Hola")
+	blocked.Sender.ID = "300000000000003"
+	postWebhook(t, memory, blocked)
+
+	if difyCalls, sent := upstreams.counts(); difyCalls != 0 || sent != 0 {
+		t.Errorf("got %d Dify calls and %d sent messages, want none", difyCalls, sent)
+	}
+
+	// Short replies are never repeats
+	postWebhook(t, memory, userMessage("Gracias"))
+	postWebhook(t, memory, userMessage("gracias!"))
+	if difyCalls, _ := upstreams.counts(); difyCalls != 2 {
+		t.Errorf("got %d Dify calls, want both short replies answered", difyCalls)
+	}
+	var checks []string
+	for _, flag := range memory.Flags() {
+		checks = append(checks, flag.Check)
+	}
+	if want := []string{"url_spam", "blocked_sender"}; !reflect.DeepEqual(checks, want) {
+		t.Errorf("flagged %q, want %q", checks, want)
+	}
+}
+
+func TestWebhookFlowRecordsUsageAndReplyTier(t *testing.T) {
+	memory, _ := setupFlowTest(t)
+
+	postWebhook(t, memory, userMessage("Hola, ¿a qué hora abren?"))
+
+	tiers := memory.ReplyTiers()
+	if want := []ReplyTier{ReplyTierPrimary}; !reflect.DeepEqual(tiers, want) {
+		t.Errorf("reply tiers = %q, want %q", tiers, want)
+	}
+	var chat []LLMUsage
+	for _, usage := range memory.Usage() {
+		if usage.Purpose == "chat" {
+			chat = append(chat, usage)
+		}
+	}
+	if len(chat) != 1 || chat[0].Provider != "dify" || chat[0].Backend != "primary" || chat[0].ThreadID != testUserID {
+		t.Errorf("chat usage = %+v, want one primary Dify call for the thread", chat)
+	}
+}
+
+func TestWebhookFlowFallbackOnlyWhenNothingSent(t *testing.T) {
+	tests := []struct {
+		name     string
+		failure  graph.GraphError
+		wantTier ReplyTier
+		wantSent int // Send API calls, including the failed one
+	}{
+		// Meta rejected the message: the human fallback is sent instead
+		{"rejected", graph.GraphError{Message: "User unavailable", Type: "OAuthException", Code: 551}, ReplyTierHuman, 2},
+		// A bare 5xx may come after the message went out: nothing else is sent
+		{"ambiguous", graph.GraphError{StatusCode: http.StatusBadGateway, Message: "Bad Gateway"}, ReplyTierPrimary, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory, upstreams := setupFlowTest(t)
+			upstreams.graph.Fail(http.MethodPost, testPageID+"/messages", tt.failure)
+
+			postWebhook(t, memory, userMessage("Hola, ¿a qué hora abren?"))
+
+			sent := 0
+			for _, req := range upstreams.graph.Requests() {
+				if req.Method == http.MethodPost && req.Path == testPageID+"/messages" {
+					sent++
+				}
+			}
+			if sent != tt.wantSent {
+				t.Errorf("got %d Send API calls, want %d", sent, tt.wantSent)
+			}
+			if tiers := memory.ReplyTiers(); !reflect.DeepEqual(tiers, []ReplyTier{tt.wantTier}) {
+				t.Errorf("reply tiers = %q, want %q", tiers, tt.wantTier)
+			}
+		})
+	}
+}
+
+func TestWebhookFlowSafetyPolicyReplacesAnswer(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+	memory.AddPage(MemoryPage{
+		PageID:      testPageID,
+		Platform:    "facebook",
+		ClientID:    "client-1",
+		AccessToken: "page-token",
+		DifyAPIKey:  testDifyKey,
+		Safety: &SafetyPolicy{
+			Enabled:        true,
+			ForbiddenTerms: []string{"abrimos"},
+			OnFail:         SafetyActionTemplate,
+			SafeTemplate:   "Un agente te responderá pronto.",
+		},
+	})
+
+	postWebhook(t, memory, userMessage("Hola, ¿a qué hora abren?"))
+
+	if difyCalls, sent := upstreams.counts(); difyCalls != 1 || sent != 1 {
+		t.Fatalf("got %d Dify calls and %d sent messages, want 1 and 1", difyCalls, sent)
+	}
+	if got := upstreams.graph.SentMessages()[0].Text; got != "Un agente te responderá pronto." {
+		t.Errorf("sent %q, want the safe template", got)
+	}
+	flags := memory.Flags()
+	if len(flags) != 1 || flags[0].Check != "answer_forbidden_term" || flags[0].Content != "Abrimos de 9 a 18 h." {
+		t.Errorf("flags = %+v, want the withheld answer flagged", flags)
+	}
+
+	// The withheld answer stays in the Dify conversation, so the next message starts a new one
+	if conv, _ := memory.Conversation(testUserID); conv.DifyConversationID != "" {
+		t.Errorf("DifyConversationID = %q, want it reset", conv.DifyConversationID)
+	}
+	postWebhook(t, memory, userMessage("¿Y los domingos?"))
+	if got := upstreams.difyReqs[1].ConversationId; got != "" {
+		t.Errorf("second Dify request conversation_id = %q, want a new conversation", got)
+	}
+}
+
+func TestWebhookFlowModerationHidesComment(t *testing.T) {
+	memory, upstreams := setupFlowTest(t)
+	memory.AddPage(MemoryPage{
+		PageID:      testPageID,
+		Platform:    "facebook",
+		ClientID:    "client-1",
+		AccessToken: "page-token",
+		DifyAPIKey:  testDifyKey,
+		Moderation:  &ModerationRules{Enabled: true, Keywords: []string{"estafa"}},
+	})
+	const commentID = "400000000000004_500000000000005"
+	upstreams.graph.SetObject(commentID, map[string]interface{}{"message": "Esto es una estafa"})
+
+	postChange(t, memory, "feed", map[string]interface{}{
+		"item": "comment", "verb": "add", "comment_id": commentID,
+		"post_id": "400000000000004", "parent_id": "400000000000004",
+		"message": "Esto es una estafa", "from": map[string]string{"id": testUserID, "name": "Ana"},
+	})
+
+	if object, _ := upstreams.graph.Object(commentID); object["is_hidden"] != "true" {
+		t.Errorf("comment = %+v, want it hidden", object)
+	}
+	entries := memory.Moderations()
+	if len(entries) != 1 || entries[0].Action != "hide" || entries[0].Rule != ModerationRuleKeyword || entries[0].CommentID != commentID {
+		t.Errorf("moderation log = %+v, want the hidden comment", entries)
+	}
+}
+
+func TestWebhookFlowSpamIntent(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name     string
+		provider string
+		guard    *bool
+		dropped  bool
+	}{
+		{"guard off", "fireworks", nil, false},
+		{"guard on", "fireworks", &enabled, true},
+		{"lexicon verdict", "lexicon", &enabled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory, upstreams := setupFlowTest(t)
+			memory.AddPage(MemoryPage{
+				PageID:      testPageID,
+				Platform:    "facebook",
+				ClientID:    "client-1",
+				AccessToken: "page-token",
+				DifyAPIKey:  testDifyKey,
+				Guard:       PageGuardSettings{Enabled: tt.guard},
+			})
+			sentimentClassifier = staticClassifier{sentiment.Analysis{Status: "general", Intent: "spam", Provider: tt.provider}}
+
+			postWebhook(t, memory, userMessage("Gana dinero desde casa"))
+
+			difyCalls, sent := upstreams.counts()
+			if dropped := difyCalls == 0 && sent == 0; dropped != tt.dropped {
+				t.Errorf("got %d Dify calls and %d sent messages, want dropped = %v", difyCalls, sent, tt.dropped)
+			}
+			if flagged := len(memory.Flags()) == 1 && memory.Flags()[0].Check == "intent_spam"; flagged != tt.dropped {
+				t.Errorf("flags = %+v, want flagged = %v", memory.Flags(), tt.dropped)
+			}
+		})
+	}
+}
@@ -8,6 +8,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+
+	"logging"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // handleWebhook processes incoming webhook requests from Facebook and Instagram.
@@ -30,8 +35,10 @@ import (
 //
 // Security:
 //
-// All POST requests must include a valid X-Hub-Signature-256 header that matches
-// the computed HMAC signature of the request body using the Facebook App Secret.
+// All POST requests must include a valid X-Hub-Signature-256 (or legacy sha1
+// X-Hub-Signature) header that matches the HMAC signature of the request body
+// under one of the active app secrets (Facebook, Instagram, or a previous one
+// during rotation).
 // This ensures requests originate from Facebook and haven't been tampered with.
 //
 // The function delegates signature validation to the validateFacebookRequest
@@ -48,14 +55,14 @@ import (
 //
 // The function ensures Facebook receives timely responses while processing
 // message data asynchronously to handle complex operations without timeouts.
-func handleWebhook(w http.ResponseWriter, r *http.Request) {
+func (p *MessageProcessor) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		handleGetRequest(w, r)
 		return
 	}
 
 	if r.Method == "POST" {
-		handlePostRequest(w, r)
+		p.handlePostRequest(w, r)
 		return
 	}
 
@@ -86,7 +93,7 @@ func handleGetRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 // handlePostRequest handles incoming webhook data (POST requests)
-func handlePostRequest(w http.ResponseWriter, r *http.Request) {
+func (p *MessageProcessor) handlePostRequest(w http.ResponseWriter, r *http.Request) {
 	// Read request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -98,13 +105,18 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	// Process the webhook data asynchronously
-	handlePlatformMessage(body)
+	p.handlePlatformMessage(r.Context(), body)
 }
 
 // handlePlatformMessage processes Facebook/Instagram webhook messages
-func handlePlatformMessage(body []byte) {
-	// Generate request ID for log correlation
-	requestID := generateRequestID()
+func (p *MessageProcessor) handlePlatformMessage(ctx context.Context, body []byte) {
+	// Reuse the HTTP request ID for log correlation, or generate one
+	requestID := logging.RequestID(ctx)
+	if requestID == "" {
+		requestID = generateRequestID()
+	}
+	ctx, span := startSpan(ctx, "webhook.receive", attribute.Int("webhook.bytes", len(body)))
+	defer span.End()
 
 	// Log webhook reception (optimized)
 	LogDebug("[%s] 📥 Raw webhook payload: %d bytes", requestID, len(body))
@@ -113,37 +125,55 @@ func handlePlatformMessage(body []byte) {
 	var event FacebookEvent
 	if err := json.Unmarshal(body, &event); err != nil {
 		LogError("[%s] Error parsing webhook JSON: %v", requestID, err)
+		span.SetStatus(codes.Error, "invalid JSON")
+		webhooksReceived.WithLabelValues("invalid").Inc()
 		return
 	}
+	foldMessageChanges(&event)
 
-	// Count total messages across all entries
-	totalMessages := 0
+	// Count total messages and changes across all entries
+	totalMessages, totalChanges := 0, 0
 	for _, entry := range event.Entry {
 		totalMessages += len(entry.Messaging)
+		totalChanges += len(entry.Changes)
 	}
 
 	// Validate webhook object type
 	if !isValidFacebookObject(event.Object) {
 		LogError("[%s] Unsupported webhook object: %s", requestID, event.Object)
+		webhooksReceived.WithLabelValues("invalid").Inc()
 		return
 	}
+	webhooksReceived.WithLabelValues(event.Object).Inc()
+	span.SetAttributes(
+		attribute.String("webhook.object", event.Object),
+		attribute.Int("webhook.entries", len(event.Entry)),
+		attribute.Int("webhook.messages", totalMessages),
+		attribute.Int("webhook.changes", totalChanges),
+	)
 
 	// Single consolidated log for webhook details
-	LogInfo("[%s] 📝 Webhook: %s, %d entries, %d messages",
-		requestID, event.Object, len(event.Entry), totalMessages)
+	LogInfo("[%s] 📝 Webhook: %s, %d entries, %d messages, %d changes",
+		requestID, event.Object, len(event.Entry), totalMessages, totalChanges)
 
 	// Additional debug logging for entries
 	for i, entry := range event.Entry {
-		LogInfo("[%s] 📋 Entry %d: id=%s, messages=%d", requestID, i, entry.ID, len(entry.Messaging))
+		LogInfo("[%s] 📋 Entry %d: id=%s, messages=%d, changes=%d", requestID, i, entry.ID, len(entry.Messaging), len(entry.Changes))
 	}
 
-	// Skip processing if no messages
-	if totalMessages == 0 {
-		LogDebug("[%s] No messages to process", requestID)
+	// Skip processing if no messages or changes
+	if totalMessages == 0 && totalChanges == 0 {
+		LogDebug("[%s] No messages or changes to process", requestID)
 		return
 	}
 
-	// Process messages asynchronously to avoid blocking webhook response
-	ctx := context.Background()
-	go processMessagesAsync(ctx, event, requestID)
+	// Process messages asynchronously to avoid blocking webhook response. The
+	// goroutine outlives the request, so it keeps the trace and log fields of
+	// ctx but not its cancellation.
+	ctx = logging.WithRequestID(context.WithoutCancel(ctx), requestID)
+	beginAsyncWork()
+	go func() {
+		defer endAsyncWork()
+		p.processMessagesAsync(ctx, event, requestID)
+	}()
 }